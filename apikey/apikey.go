@@ -0,0 +1,64 @@
+// Package apikey generates and checks the API keys bots and services send
+// in the X-API-Key header. A key reads ucb_<prefix>_<secret>. The prefix is
+// stored in the clear so the key can be found, and shown in listings so
+// users can tell their keys apart; of the whole key only a SHA-256 hash is
+// stored, so the database never holds a usable key. The secret carries 256
+// random bits, which is why a fast hash is enough.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Marker starts every key, so leaked keys are easy to spot and scan for
+const Marker = "ucb_"
+
+const (
+	prefixBytes = 6
+	secretBytes = 32
+)
+
+// New returns a fresh key with its prefix and hash. The key is shown to
+// its creator once; only the prefix and hash are stored.
+func New() (key, prefix, hash string, err error) {
+	random := make([]byte, prefixBytes+secretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", "", fmt.Errorf("generating API key: %w", err)
+	}
+	prefix = hex.EncodeToString(random[:prefixBytes])
+	key = Marker + prefix + "_" + base64.RawURLEncoding.EncodeToString(random[prefixBytes:])
+	return key, prefix, Hash(key), nil
+}
+
+// Prefix returns the prefix of a well-formed key
+func Prefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, Marker)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 2*prefixBytes || secret == "" {
+		return "", false
+	}
+	if _, err := hex.DecodeString(prefix); err != nil {
+		return "", false
+	}
+	return prefix, true
+}
+
+// Hash returns the hex SHA-256 of key
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify reports whether key hashes to hash, in constant time
+func Verify(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(Hash(key)), []byte(hash)) == 1
+}
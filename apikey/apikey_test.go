@@ -0,0 +1,53 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	key, prefix, hash, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, Marker+prefix+"_") {
+		t.Errorf("key %q does not start with its prefix %q", key, prefix)
+	}
+	if got, ok := Prefix(key); !ok || got != prefix {
+		t.Errorf("Prefix(%q) = %q, %v, want %q", key, got, ok, prefix)
+	}
+	if !Verify(key, hash) {
+		t.Error("the key does not verify against its own hash")
+	}
+	if strings.Contains(hash, key) || strings.Contains(hash, strings.TrimPrefix(key, Marker+prefix+"_")) {
+		t.Error("the hash contains the key")
+	}
+
+	other, otherPrefix, _, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key || otherPrefix == prefix {
+		t.Errorf("two keys share a value: %q, %q", key, other)
+	}
+	if Verify(other, hash) {
+		t.Error("another key verifies against the hash")
+	}
+}
+
+func TestPrefixRejectsMalformedKeys(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"Bearer abc",
+		"ucb_",
+		"ucb_0123456789ab",
+		"ucb_0123456789ab_",
+		"ucb_0123_secret",
+		"ucb_zzzzzzzzzzzz_secret",
+		"xyz_0123456789ab_secret",
+	} {
+		if prefix, ok := Prefix(key); ok {
+			t.Errorf("Prefix(%q) = %q, want a rejection", key, prefix)
+		}
+	}
+}
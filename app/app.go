@@ -0,0 +1,142 @@
+// Package app assembles the HTTP server from its dependencies. main wires
+// the production dependencies; tests wire in-memory or fake ones through
+// the same function so they exercise exactly what ships.
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net"
+
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/health"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/logging"
+	"ultra-chat-backend/metrics"
+	"ultra-chat-backend/ratelimit"
+	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/routes"
+	"ultra-chat-backend/tracing"
+	"ultra-chat-backend/validation"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Dependencies are the collaborators the server needs
+type Dependencies struct {
+	Users     repositories.UserRepository
+	Summaries repositories.SummaryRepository
+	// Identities are the identities linked from providers other than Discord
+	Identities repositories.IdentityRepository
+	// APIKeys are the keys bots and services call the summary API with
+	APIKeys repositories.APIKeyRepository
+	// RequireAPIKeys rejects summary requests without an API key
+	RequireAPIKeys bool
+	// TrustNamedUser lets summary requests without an API key or a bearer
+	// token act as the user they name. It is insecure and only meant for
+	// migrating old clients.
+	TrustNamedUser bool
+	// Audit is the audit log of logins and changes to accounts and summaries
+	Audit repositories.AuditRepository
+	// Maintenance runs the tasks operators start from the admin routes
+	Maintenance repositories.Maintenance
+	// AdminIDs are the Discord IDs of the users allowed on the admin routes
+	AdminIDs []string
+	Discord  *discord.Client
+	// Providers are the identity providers users can link besides Discord
+	Providers []identity.Provider
+	// Limiter may be nil to disable rate limiting
+	Limiter *ratelimit.Limiter
+	// TrustedProxies are the networks of the reverse proxies whose
+	// X-Forwarded-For is believed. Without any, the client IP is the peer
+	// address and forwarding headers are ignored.
+	TrustedProxies []*net.IPNet
+	// Health checks back /readyz; nil reports ready with no checks
+	Health *health.Checker
+	// Metrics collects HTTP, repository and business metrics served on
+	// /metrics; nil uses a fresh registry
+	Metrics *metrics.Registry
+	// MetricsToken, if set, must be sent as a bearer token to scrape /metrics
+	MetricsToken string
+	// Logger writes access and error logs; nil uses slog.Default
+	Logger *slog.Logger
+	// MaxBodyBytes caps JSON request bodies; zero uses the validation default
+	MaxBodyBytes int64
+}
+
+// New returns a configured Echo instance with every route registered
+func New(deps Dependencies) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Binder = validation.NewBinder(deps.MaxBodyBytes)
+	e.Validator = validation.New()
+	e.IPExtractor = ipExtractor(deps.TrustedProxies)
+
+	reg := deps.Metrics
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+	users := repositories.InstrumentUsers(deps.Users, reg)
+	summaries := repositories.InstrumentSummaries(deps.Summaries, reg)
+	identities := repositories.InstrumentIdentities(deps.Identities, reg)
+	keys := repositories.InstrumentAPIKeys(deps.APIKeys, reg)
+	audit := repositories.InstrumentAudit(deps.Audit, reg)
+	maintenance := repositories.InstrumentMaintenance(deps.Maintenance, reg)
+	providers := identity.NewRegistry(append([]identity.Provider{identity.Discord(deps.Discord)}, deps.Providers...)...)
+	if deps.Users != nil {
+		reg.GaugeFunc("users", "Users who have logged in with Discord.", func() (float64, error) {
+			count, err := deps.Users.CountUsers(context.Background())
+			return float64(count), err
+		})
+	}
+
+	logger := deps.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	// Tracing comes first so the span covers everything and its IDs can be
+	// logged; logging follows so the request ID is set before anything can
+	// fail. All three sit outside Recover so panics are seen as 500s.
+	e.Use(tracing.Middleware())
+	e.Use(logging.Middleware(logger, routes.ProbePaths...))
+	e.Use(metrics.Middleware(reg))
+	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
+		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+			logging.FromContext(c.Request().Context()).Error("panic recovered", "error", err, "stack", string(stack))
+			return err
+		},
+	}))
+
+	routes.Register(e, routes.Handlers{
+		Auth:      handlers.NewAuthHandler(users, identities, audit, deps.Discord, providers),
+		Account:   handlers.NewAccountHandler(users, identities, keys, summaries, audit, deps.Discord, providers),
+		Summaries: handlers.NewSummaryHandler(summaries, users, identities, keys, audit, deps.Discord, providers, deps.RequireAPIKeys, deps.TrustNamedUser),
+		Admin:     handlers.NewAdminHandler(users, summaries, identities, keys, audit, maintenance, deps.Discord, providers, deps.AdminIDs),
+		Health:    handlers.NewHealthHandler(deps.Health),
+		Metrics:   metrics.Handler(reg, deps.MetricsToken),
+		Identify:  handlers.Identify(users, keys),
+	}, deps.Limiter)
+
+	return e
+}
+
+// ipExtractor returns how the client IP, which rate limits and the audit
+// log go by, is found. X-Forwarded-For is only read from the trusted
+// proxies, walking it back from the peer past every trusted hop; echo's
+// default trust in loopback and private addresses is dropped, so a client
+// on the same network cannot pick its own IP either.
+func ipExtractor(proxies []*net.IPNet) echo.IPExtractor {
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range proxies {
+		options = append(options, echo.TrustIPRange(proxy))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
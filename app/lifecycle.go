@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Lifecycle releases the resources the server holds. Stop hooks run in the
+// reverse order they were added, so something added after the database
+// (a worker that writes to it, the HTTP server) is stopped before it.
+type Lifecycle struct {
+	mu    sync.Mutex
+	hooks []stopHook
+}
+
+type stopHook struct {
+	name string
+	stop func(context.Context) error
+}
+
+// OnStop registers fn to run during Stop
+func (l *Lifecycle) OnStop(name string, fn func(context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, stopHook{name: name, stop: fn})
+}
+
+// Stop runs every hook, newest first, and returns their joined errors. A
+// failing hook does not prevent the rest from running; hooks are expected
+// to give up when ctx expires.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	hooks := l.hooks
+	l.hooks = nil
+	l.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		if err := hook.stop(ctx); err != nil {
+			slog.Error("stopping failed", "component", hook.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", hook.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Serve runs e on addr until it fails or ctx is cancelled, typically by
+// SIGINT or SIGTERM. It then stops accepting connections, lets in-flight
+// requests finish and runs the lifecycle's stop hooks, all within timeout.
+func Serve(ctx context.Context, e *echo.Echo, addr string, lc *Lifecycle, timeout time.Duration) error {
+	lc.OnStop("http server", e.Shutdown)
+
+	slog.Info("listening", "addr", addr)
+	served := make(chan error, 1)
+	go func() { served <- e.Start(addr) }()
+
+	var serveErr error
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr = err
+		}
+	case <-ctx.Done():
+		slog.Info("shutting down, draining in-flight requests", "timeout", timeout.String())
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return errors.Join(serveErr, lc.Stop(stopCtx))
+}
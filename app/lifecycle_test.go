@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestLifecycleStopsInReverseOrder(t *testing.T) {
+	var lc Lifecycle
+	var order []string
+	boom := errors.New("boom")
+
+	lc.OnStop("mongo", func(context.Context) error { order = append(order, "mongo"); return nil })
+	lc.OnStop("worker", func(context.Context) error { order = append(order, "worker"); return boom })
+	lc.OnStop("http", func(context.Context) error { order = append(order, "http"); return nil })
+
+	err := lc.Stop(context.Background())
+	if !errors.Is(err, boom) {
+		t.Errorf("err = %v, want the worker's error", err)
+	}
+	if want := []string{"http", "worker", "mongo"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("stop order = %v, want %v", order, want)
+	}
+
+	// Hooks run once
+	if err := lc.Stop(context.Background()); err != nil || len(order) != 3 {
+		t.Errorf("second Stop ran hooks again: %v, %v", order, err)
+	}
+}
+
+func TestServeDrainsRequestsBeforeStopping(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handled := make(chan struct{})
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.GET("/slow", func(c echo.Context) error {
+		defer close(handled)
+		close(started)
+		<-release
+		return c.String(http.StatusOK, "done")
+	})
+
+	var lc Lifecycle
+	dbClosedAfterDrain := make(chan bool, 1)
+	lc.OnStop("mongo", func(context.Context) error {
+		select {
+		case <-handled:
+			dbClosedAfterDrain <- true
+		default:
+			dbClosedAfterDrain <- false
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, e, addr, &lc, 5*time.Second) }()
+
+	body := make(chan string, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get("http://" + addr + "/slow"); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		body <- string(data)
+	}()
+
+	<-started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if got := <-body; got != "done" {
+		t.Errorf("in-flight request got %q, want it to complete", got)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve returned %v", err)
+	}
+	if !<-dbClosedAfterDrain {
+		t.Error("mongo was closed before the in-flight request finished")
+	}
+}
@@ -0,0 +1,130 @@
+// Package apperror defines the error model returned by the API. Every
+// non-2xx response is an RFC 7807 problem document carrying a stable,
+// machine-readable code; internal causes are logged but never serialized.
+package apperror
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Code is a stable machine-readable error identifier. Clients may switch on
+// it, so existing values must never change meaning.
+type Code string
+
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeInvalidState     Code = "invalid_state"
+	CodeInvalidBody      Code = "invalid_body"
+	CodeValidation       Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeAccountDisabled  Code = "account_disabled"
+	CodeNotFound         Code = "not_found"
+	CodeSummaryNotFound  Code = "summary_not_found"
+	CodeUserNotFound     Code = "user_not_found"
+	CodeProviderNotFound Code = "provider_not_found"
+	CodeIdentityNotFound Code = "identity_not_found"
+	CodeIdentityLinked   Code = "identity_linked"
+	CodeAPIKeyNotFound   Code = "api_key_not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodePayloadTooLarge  Code = "payload_too_large"
+	CodeRateLimited      Code = "rate_limited"
+	CodeUpstream         Code = "upstream_error"
+	CodeUnavailable      Code = "service_unavailable"
+	CodeTimeout          Code = "timeout"
+	CodeCanceled         Code = "request_canceled"
+	CodeInternal         Code = "internal_error"
+)
+
+// StatusClientClosedRequest is the non-standard status, borrowed from nginx,
+// recorded for requests the client abandoned before a response was ready
+const StatusClientClosedRequest = 499
+
+// StatusText is http.StatusText, extended with the non-standard statuses
+// this package uses
+func StatusText(status int) string {
+	if status == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field" doc:"JSON name of the offending field"`
+	Code    string `json:"code" doc:"Machine-readable reason, e.g. required or max"`
+	Message string `json:"message"`
+}
+
+// Error is an error that knows how it should be presented to clients
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Fields  []FieldError
+	// Err is the internal cause. It is logged, never returned to clients.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap attaches an internal cause to the error
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// New creates an error with the given status, code and client-facing message
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func BadRequest(code Code, message string) *Error {
+	return New(http.StatusBadRequest, code, message)
+}
+
+func Unauthorized(message string) *Error {
+	return New(http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func Forbidden(message string) *Error {
+	return New(http.StatusForbidden, CodeForbidden, message)
+}
+
+func NotFound(code Code, message string) *Error {
+	return New(http.StatusNotFound, code, message)
+}
+
+func Conflict(code Code, message string) *Error {
+	return New(http.StatusConflict, code, message)
+}
+
+// Validation reports one or more rejected request fields
+func Validation(fields ...FieldError) *Error {
+	return &Error{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    CodeValidation,
+		Message: "Request validation failed",
+		Fields:  fields,
+	}
+}
+
+// Upstream reports a failure of a service we depend on, such as Discord
+func Upstream(message string, err error) *Error {
+	return &Error{Status: http.StatusBadGateway, Code: CodeUpstream, Message: message, Err: err}
+}
+
+// Internal hides err behind a generic message
+func Internal(err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "Internal server error", Err: err}
+}
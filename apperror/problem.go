@@ -0,0 +1,33 @@
+package apperror
+
+// ContentType is the media type of problem documents (RFC 7807)
+const ContentType = "application/problem+json"
+
+// typePrefix namespaces problem types; the code is appended
+const typePrefix = "urn:ultra-chat:problem:"
+
+// Problem is the RFC 7807 body sent for every error response
+type Problem struct {
+	Type      string       `json:"type" doc:"URI identifying the problem type" example:"urn:ultra-chat:problem:summary_not_found"`
+	Title     string       `json:"title" doc:"Short summary of the HTTP status"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty" doc:"Human readable explanation"`
+	Instance  string       `json:"instance,omitempty" doc:"Request path the problem occurred on"`
+	Code      Code         `json:"code" doc:"Stable machine-readable error code" example:"summary_not_found"`
+	RequestID string       `json:"request_id,omitempty" doc:"Correlates the response with server logs"`
+	Errors    []FieldError `json:"errors,omitempty" doc:"Per-field validation failures"`
+}
+
+// Problem renders the error as a problem document
+func (e *Error) Problem(instance, requestID string) Problem {
+	return Problem{
+		Type:      typePrefix + string(e.Code),
+		Title:     StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Message,
+		Instance:  instance,
+		Code:      e.Code,
+		RequestID: requestID,
+		Errors:    e.Fields,
+	}
+}
@@ -0,0 +1,513 @@
+// Package apptest runs the full application in-process for end-to-end
+// tests. Each Harness serves the real Echo app built by app.New against a
+// fake Discord, a fake OpenID Connect issuer linked as the "oidc" provider
+// and one of the storage backends:
+//
+//   - mongo: a throwaway database on a locally spawned mongod (or on
+//     APPTEST_MONGO_URI), used when mongod is on PATH or APPTEST_MONGOD is set
+//   - memory: the in-memory repositories, used otherwise
+//   - sqlite: a database file in the test's temporary directory
+//   - postgres: a throwaway schema on APPTEST_POSTGRES_DSN
+//
+// APPTEST_BACKEND forces a backend; sqlite and postgres are only used when
+// asked for. Packages using the harness
+// should call Main from TestMain so the shared mongod is stopped and route
+// coverage is enforced.
+package apptest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ultra-chat-backend/app"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/discord/discordtest"
+	"ultra-chat-backend/health"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/identity/oidctest"
+	"ultra-chat-backend/metrics"
+	"ultra-chat-backend/migrations"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/ratelimit"
+	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/repositories/memory"
+	"ultra-chat-backend/repositories/sqldb"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Credentials the app and the fake Discord are configured with
+const (
+	ClientID     = "1100000000000000001"
+	ClientSecret = "apptest-secret"
+	RedirectURI  = "http://localhost:5001/api/v1/auth/callback"
+)
+
+// The OIDC provider's name and the credentials the app and the fake issuer
+// are configured with
+const (
+	OIDCProvider     = "oidc"
+	OIDCClientID     = "apptest-oidc"
+	OIDCClientSecret = "apptest-oidc-secret"
+	OIDCRedirectURI  = "http://localhost:5001/api/v1/auth/oidc/callback"
+)
+
+type Backend string
+
+const (
+	BackendMemory   Backend = "memory"
+	BackendMongo    Backend = "mongo"
+	BackendSQLite   Backend = "sqlite"
+	BackendPostgres Backend = "postgres"
+)
+
+// Options customises a Harness
+type Options struct {
+	Limiter *ratelimit.Limiter
+	Health  *health.Checker
+	// DiscordTimeout bounds each Discord call; zero uses the client default
+	DiscordTimeout time.Duration
+	// RequireAPIKeys rejects summary requests without an API key
+	RequireAPIKeys bool
+	// TrustNamedUser lets summary requests without a key or a bearer token
+	// act as the user they name
+	TrustNamedUser bool
+	// AdminIDs are the Discord IDs allowed on the admin routes
+	AdminIDs []string
+	// TrustedProxies are the proxies whose X-Forwarded-For is believed
+	TrustedProxies []*net.IPNet
+	// WrapAudit, if set, wraps the audit log the app records to, e.g. to
+	// make it fail. Harness.Audit stays the unwrapped log.
+	WrapAudit func(repositories.AuditRepository) repositories.AuditRepository
+}
+
+// Harness is a running instance of the application
+type Harness struct {
+	t          *testing.T
+	Backend    Backend
+	Discord    *discordtest.Server
+	OIDC       *oidctest.Server
+	Users      repositories.UserRepository
+	Identities repositories.IdentityRepository
+	APIKeys    repositories.APIKeyRepository
+	Summaries  repositories.SummaryRepository
+	Audit      repositories.AuditRepository
+	// Maintenance runs the admin maintenance tasks on the same storage
+	Maintenance repositories.Maintenance
+	Metrics     *metrics.Registry
+	Echo        *echo.Echo
+	Server      *httptest.Server
+
+	// client keeps the cookies the app sets, like a browser
+	client *http.Client
+}
+
+// New starts the application with default options
+func New(t *testing.T) *Harness {
+	return NewWithOptions(t, Options{})
+}
+
+// NewWithOptions starts the application. Everything is torn down when the
+// test ends.
+func NewWithOptions(t *testing.T, opts Options) *Harness {
+	t.Helper()
+
+	h := &Harness{t: t, Backend: selectBackend(t), client: &http.Client{Jar: newJar()}}
+
+	h.Discord = discordtest.NewServer(ClientID, ClientSecret)
+	t.Cleanup(h.Discord.Close)
+	h.OIDC = oidctest.NewServer(OIDCClientID, OIDCClientSecret)
+	t.Cleanup(h.OIDC.Close)
+
+	switch h.Backend {
+	case BackendMongo:
+		h.useMongo(t)
+	case BackendSQLite:
+		h.useSQL(t, sqldb.SQLite)
+	case BackendPostgres:
+		h.useSQL(t, sqldb.Postgres)
+	default:
+		h.Users = memory.NewUserRepository()
+		h.Identities = memory.NewIdentityRepository()
+		h.APIKeys = memory.NewAPIKeyRepository()
+		h.Summaries = memory.NewSummaryRepository(h.Users)
+		h.Audit = memory.NewAuditRepository()
+		h.Maintenance = memory.NewMaintenance(h.Users, h.Summaries, h.Identities, h.APIKeys)
+	}
+
+	audit := h.Audit
+	if opts.WrapAudit != nil {
+		audit = opts.WrapAudit(audit)
+	}
+
+	h.Metrics = metrics.NewRegistry()
+	h.Echo = app.New(app.Dependencies{
+		Users:          h.Users,
+		Identities:     h.Identities,
+		APIKeys:        h.APIKeys,
+		RequireAPIKeys: opts.RequireAPIKeys,
+		TrustNamedUser: opts.TrustNamedUser,
+		Summaries:      h.Summaries,
+		Audit:          audit,
+		Maintenance:    h.Maintenance,
+		AdminIDs:       opts.AdminIDs,
+		TrustedProxies: opts.TrustedProxies,
+		Discord: discord.NewClient(discord.Config{
+			BaseURL:      h.Discord.URL,
+			ClientID:     ClientID,
+			ClientSecret: ClientSecret,
+			RedirectURI:  RedirectURI,
+			Scope:        "identify guilds",
+			Timeout:      opts.DiscordTimeout,
+			Metrics:      h.Metrics,
+		}),
+		Providers: []identity.Provider{identity.NewOIDC(identity.OIDCConfig{
+			Name:         OIDCProvider,
+			Issuer:       h.OIDC.URL,
+			ClientID:     OIDCClientID,
+			ClientSecret: OIDCClientSecret,
+			RedirectURI:  OIDCRedirectURI,
+		})},
+		Limiter: opts.Limiter,
+		Health:  opts.Health,
+		Metrics: h.Metrics,
+	})
+	h.Echo.Use(recordCoverage)
+
+	h.Server = httptest.NewServer(h.Echo)
+	t.Cleanup(h.Server.Close)
+
+	return h
+}
+
+func selectBackend(t *testing.T) Backend {
+	switch Backend(os.Getenv("APPTEST_BACKEND")) {
+	case BackendMemory, BackendMongo, BackendSQLite, BackendPostgres:
+		return Backend(os.Getenv("APPTEST_BACKEND"))
+	case "":
+		if os.Getenv("APPTEST_MONGO_URI") != "" {
+			return BackendMongo
+		}
+		if _, ok := mongodBinary(); ok {
+			return BackendMongo
+		}
+		return BackendMemory
+	default:
+		t.Fatalf("APPTEST_BACKEND must be %q, %q, %q or %q", BackendMemory, BackendMongo, BackendSQLite, BackendPostgres)
+		return ""
+	}
+}
+
+// useMongo stores the harness' data in a fresh, migrated MongoDB database
+func (h *Harness) useMongo(t *testing.T) {
+	t.Helper()
+
+	db := newMongoDatabase(t)
+	if _, err := migrations.New(db, migrations.All).Up(context.Background()); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	h.Users = repositories.NewUserRepository(db, repositories.Timeouts{})
+	h.Identities = repositories.NewIdentityRepository(db, repositories.Timeouts{})
+	h.APIKeys = repositories.NewAPIKeyRepository(db, repositories.Timeouts{})
+	h.Summaries = repositories.NewMongoSummaryRepository(db, repositories.Timeouts{})
+	h.Audit = repositories.NewAuditRepository(db, repositories.Timeouts{})
+	h.Maintenance = repositories.NewMongoMaintenance(db, repositories.Timeouts{})
+}
+
+// MongoDatabase returns an empty database, dropped when t ends, on the
+// same MongoDB the mongo backend uses. The test is skipped when no MongoDB
+// is available, unless APPTEST_BACKEND=mongo demands one.
+func MongoDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	if Backend(os.Getenv("APPTEST_BACKEND")) != BackendMongo && os.Getenv("APPTEST_MONGO_URI") == "" {
+		if _, ok := mongodBinary(); !ok {
+			t.Skip("no MongoDB available; install mongod or set APPTEST_MONGO_URI")
+		}
+	}
+	return newMongoDatabase(t)
+}
+
+func newMongoDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	client, err := sharedMongo()
+	if err != nil {
+		t.Fatalf("mongo backend unavailable: %v", err)
+	}
+
+	db := client.Database("apptest_" + randomHex(6))
+	t.Cleanup(func() { _ = db.Drop(context.Background()) })
+	return db
+}
+
+// SeedUser stores a user that has completed the OAuth flow and returns a
+// bearer token the fake Discord accepts for them
+func (h *Harness) SeedUser(user discord.User) string {
+	h.t.Helper()
+
+	h.Discord.AddUser(user)
+	token := models.Token{AccessToken: h.Discord.IssueToken(user.ID), TokenType: "Bearer"}
+	h.storeUser(user, token)
+	return token.AccessToken
+}
+
+// SeedLinkedUser is SeedUser with a refresh token stored as well, and
+// returns the whole stored token
+func (h *Harness) SeedLinkedUser(user discord.User) models.Token {
+	h.t.Helper()
+
+	h.Discord.AddUser(user)
+	access, refresh := h.Discord.IssueTokenPair(user.ID)
+	token := models.Token{AccessToken: access, TokenType: "Bearer", RefreshToken: refresh, Scope: "identify"}
+	h.storeUser(user, token)
+	return token
+}
+
+func (h *Harness) storeUser(user discord.User, token models.Token) {
+	h.t.Helper()
+
+	err := h.Users.CreateUser(context.Background(), &models.User{
+		ID:            user.ID,
+		UUID:          uuid.New().String(),
+		Token:         token,
+		Username:      user.Username,
+		Discriminator: user.Discriminator,
+	})
+	if err != nil {
+		h.t.Fatalf("seeding user %s: %v", user.ID, err)
+	}
+}
+
+// SeedSummary stores a summary and returns its ID
+func (h *Harness) SeedSummary(userID, serverID string, isPrivate bool, content string) string {
+	h.t.Helper()
+
+	summary := &models.Summary{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		ServerID:  serverID,
+		IsPrivate: isPrivate,
+		Content:   content,
+	}
+	if err := h.Summaries.AddSummary(context.Background(), summary); err != nil {
+		h.t.Fatalf("seeding summary: %v", err)
+	}
+	return summary.ID
+}
+
+// Request describes a call to the API
+type Request struct {
+	Method string
+	Path   string
+	// Body is sent as JSON; a string is sent verbatim
+	Body interface{}
+	// Bearer is sent as the Authorization header
+	Bearer string
+	// UserID is sent as the ID header
+	UserID  string
+	Headers map[string]string
+}
+
+// Response is a buffered API response
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Do sends req to the running app
+func (h *Harness) Do(req Request) *Response {
+	h.t.Helper()
+
+	var body io.Reader
+	switch b := req.Body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(b)
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	httpReq, err := http.NewRequest(req.Method, h.Server.URL+req.Path, body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if body != nil {
+		httpReq.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if req.Bearer != "" {
+		httpReq.Header.Set(echo.HeaderAuthorization, "Bearer "+req.Bearer)
+	}
+	if req.UserID != "" {
+		httpReq.Header.Set("ID", req.UserID)
+	}
+	for key, value := range req.Headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}
+}
+
+// JSON decodes the response body into out
+func (r *Response) JSON(t *testing.T, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.Body, out); err != nil {
+		t.Fatalf("decoding %q: %v", r.Body, err)
+	}
+}
+
+// ClearCookies forgets the cookies the app has set, so that later requests
+// come from a different client
+func (h *Harness) ClearCookies() {
+	h.client.Jar = newJar()
+}
+
+func newJar() http.CookieJar {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		panic(err)
+	}
+	return jar
+}
+
+// Authorize follows a login URL through the consent page of the fake
+// Discord or the fake OIDC issuer and returns the query it redirects back
+// to the callback with: the authorization code and the login's state
+func (h *Harness) Authorize(loginURL string) string {
+	h.t.Helper()
+
+	var redirectURI string
+	switch {
+	case strings.HasPrefix(loginURL, h.Discord.URL):
+		redirectURI = RedirectURI
+	case strings.HasPrefix(loginURL, h.OIDC.URL):
+		redirectURI = OIDCRedirectURI
+	default:
+		h.t.Fatalf("login URL %s points at neither the configured Discord nor the OIDC issuer", loginURL)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(loginURL)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		h.t.Fatalf("authorize returned %d", resp.StatusCode)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), redirectURI) {
+		h.t.Fatalf("authorize redirected to %s, want %s", location, redirectURI)
+	}
+	return location.RawQuery
+}
+
+// Callback returns the callback query for a code a fake issued directly,
+// carrying the state of the login started at loginURL
+func Callback(loginURL, code string) string {
+	parsed, err := url.Parse(loginURL)
+	if err != nil {
+		panic(err)
+	}
+	return url.Values{"code": {code}, "state": {parsed.Query().Get("state")}}.Encode()
+}
+
+var (
+	coverageMu sync.Mutex
+	covered    = map[string]bool{}
+)
+
+// recordCoverage notes which registered routes the tests reached
+func recordCoverage(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		coverageMu.Lock()
+		covered[c.Request().Method+" "+c.Path()] = true
+		coverageMu.Unlock()
+		return next(c)
+	}
+}
+
+// Main runs the tests, stops the shared mongod and, when the whole suite
+// ran, fails if any route registered by app.New was never requested
+func Main(m *testing.M) {
+	code := m.Run()
+
+	if code == 0 && flag.Lookup("test.run").Value.String() == "" {
+		if missing := uncoveredRoutes(); len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "apptest: routes never exercised end-to-end:\n  %s\n", strings.Join(missing, "\n  "))
+			code = 1
+		}
+	}
+
+	if mongod != nil {
+		mongod.stop()
+	}
+	os.Exit(code)
+}
+
+func uncoveredRoutes() []string {
+	e := app.New(app.Dependencies{Discord: discord.NewClient(discord.Config{})})
+
+	coverageMu.Lock()
+	defer coverageMu.Unlock()
+
+	var missing []string
+	for _, route := range e.Routes() {
+		// Groups with middleware register these to answer 404 themselves
+		if route.Method == echo.RouteNotFound {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		if !covered[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
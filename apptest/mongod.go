@@ -0,0 +1,130 @@
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongodServer is a throwaway mongod shared by every harness in a test
+// binary. Each harness gets its own database on it.
+type mongodServer struct {
+	cmd    *exec.Cmd
+	dir    string
+	client *mongo.Client
+}
+
+var (
+	mongodOnce sync.Once
+	mongod     *mongodServer
+	mongodErr  error
+)
+
+// mongodBinary returns the mongod to spawn, if one is available
+func mongodBinary() (string, bool) {
+	if path := os.Getenv("APPTEST_MONGOD"); path != "" {
+		return path, true
+	}
+	path, err := exec.LookPath("mongod")
+	return path, err == nil
+}
+
+// sharedMongo connects to APPTEST_MONGO_URI or spawns mongod on first use
+func sharedMongo() (*mongo.Client, error) {
+	mongodOnce.Do(func() {
+		if uri := os.Getenv("APPTEST_MONGO_URI"); uri != "" {
+			mongod = &mongodServer{}
+			mongod.client, mongodErr = connect(uri, 10*time.Second)
+			return
+		}
+		mongod, mongodErr = startMongod()
+	})
+	if mongodErr != nil {
+		return nil, mongodErr
+	}
+	return mongod.client, nil
+}
+
+func startMongod() (*mongodServer, error) {
+	binary, ok := mongodBinary()
+	if !ok {
+		return nil, fmt.Errorf("mongod not found; install it or set APPTEST_MONGOD")
+	}
+
+	dir, err := os.MkdirTemp("", "apptest-mongod-")
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	cmd := exec.Command(binary, "--dbpath", dir, "--port", fmt.Sprint(port), "--bind_ip", "127.0.0.1", "--quiet")
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("starting mongod: %w", err)
+	}
+
+	server := &mongodServer{cmd: cmd, dir: dir}
+	server.client, err = connect(fmt.Sprintf("mongodb://127.0.0.1:%d", port), 30*time.Second)
+	if err != nil {
+		server.stop()
+		return nil, err
+	}
+	return server, nil
+}
+
+// connect retries until the server answers a ping or the timeout expires
+func connect(uri string, timeout time.Duration) (*mongo.Client, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err = client.Ping(ctx, nil)
+		cancel()
+		if err == nil {
+			return client, nil
+		}
+		if time.Now().After(deadline) {
+			_ = client.Disconnect(context.Background())
+			return nil, fmt.Errorf("mongo at %s did not come up: %w", uri, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *mongodServer) stop() {
+	if s.client != nil {
+		_ = s.client.Disconnect(context.Background())
+	}
+	if s.cmd != nil && s.cmd.Process != nil {
+		_ = s.cmd.Process.Kill()
+		_, _ = s.cmd.Process.Wait()
+	}
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+	}
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,88 @@
+package apptest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/repositories/sqldb"
+)
+
+// useSQL stores the harness' data in a fresh, migrated database of the
+// dialect
+func (h *Harness) useSQL(t *testing.T, dialect sqldb.Dialect) {
+	t.Helper()
+
+	db := SQLDatabase(t, dialect)
+	if _, err := sqldb.NewMigrator(db, sqldb.All).Up(context.Background()); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	h.Users = sqldb.NewUserRepository(db, repositories.Timeouts{})
+	h.Identities = sqldb.NewIdentityRepository(db, repositories.Timeouts{})
+	h.APIKeys = sqldb.NewAPIKeyRepository(db, repositories.Timeouts{})
+	h.Summaries = sqldb.NewSummaryRepository(db, repositories.Timeouts{})
+	h.Audit = sqldb.NewAuditRepository(db, repositories.Timeouts{})
+	h.Maintenance = sqldb.NewMaintenance(db, repositories.Timeouts{})
+}
+
+// SQLDatabase returns an empty, unmigrated database closed when t ends. A
+// SQLite database is a file in the test's temporary directory. A
+// PostgreSQL database is a schema of its own on APPTEST_POSTGRES_DSN,
+// dropped when t ends; the test is skipped when that is unset, unless
+// APPTEST_BACKEND=postgres demands it.
+func SQLDatabase(t *testing.T, dialect sqldb.Dialect) *sqldb.DB {
+	t.Helper()
+	ctx := context.Background()
+
+	if dialect == sqldb.SQLite {
+		db, err := sqldb.Open(ctx, sqldb.SQLite, filepath.Join(t.TempDir(), "apptest.db"))
+		if err != nil {
+			t.Fatalf("opening SQLite: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close(ctx) })
+		return db
+	}
+
+	dsn := os.Getenv("APPTEST_POSTGRES_DSN")
+	if dsn == "" {
+		if Backend(os.Getenv("APPTEST_BACKEND")) == BackendPostgres {
+			t.Fatal("APPTEST_BACKEND=postgres needs APPTEST_POSTGRES_DSN")
+		}
+		t.Skip("no PostgreSQL available; set APPTEST_POSTGRES_DSN")
+	}
+
+	admin, err := sqldb.Open(ctx, sqldb.Postgres, dsn)
+	if err != nil {
+		t.Fatalf("postgres backend unavailable: %v", err)
+	}
+	schema := "apptest_" + randomHex(6)
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		_ = admin.Close(ctx)
+	})
+
+	db, err := sqldb.Open(ctx, sqldb.Postgres, withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatalf("connecting to schema %s: %v", schema, err)
+	}
+	t.Cleanup(func() { _ = db.Close(ctx) })
+	return db
+}
+
+// withSearchPath points every connection of dsn, a URL or a key=value
+// string, at schema
+func withSearchPath(dsn, schema string) string {
+	if strings.Contains(dsn, "://") {
+		if strings.Contains(dsn, "?") {
+			return dsn + "&search_path=" + schema
+		}
+		return dsn + "?search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
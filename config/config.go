@@ -0,0 +1,400 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/ratelimit"
+	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/validation"
+)
+
+// Config is every setting the server reads at startup. Each field can be
+// set, from lowest to highest precedence, by its default, the config file
+// key (yaml/toml tag), the environment variable (env tag) and the command
+// line flag (the env name in lower-kebab-case, e.g. -mongo-uri).
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Storage   Storage   `yaml:"storage" toml:"storage"`
+	Mongo     Mongo     `yaml:"mongo" toml:"mongo"`
+	SQL       SQL       `yaml:"sql" toml:"sql"`
+	Discord   Discord   `yaml:"discord" toml:"discord"`
+	OIDC      OIDC      `yaml:"oidc" toml:"oidc"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Log       Log       `yaml:"log" toml:"log"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+}
+
+type Server struct {
+	Port            int           `yaml:"port" toml:"port" env:"PORT" doc:"HTTP listen port"`
+	MaxBodyBytes    int64         `yaml:"max_body_bytes" toml:"max_body_bytes" env:"MAX_BODY_BYTES" doc:"Largest accepted request body in bytes"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" doc:"How long to drain requests and release resources on SIGINT/SIGTERM"`
+	MetricsToken    string        `yaml:"metrics_token" toml:"metrics_token" env:"METRICS_TOKEN" secret:"true" doc:"Bearer token required to scrape /metrics; empty leaves it open"`
+	RequireAPIKeys  bool          `yaml:"require_api_keys" toml:"require_api_keys" env:"API_KEYS_REQUIRED" doc:"Reject summary requests without an API key in X-API-Key"`
+	TrustNamedUser  bool          `yaml:"insecure_trust_named_user" toml:"insecure_trust_named_user" env:"INSECURE_TRUST_NAMED_USER" doc:"Let summary requests without an API key or bearer token act as the user named in the ID header or user_id; anyone can then act for anyone, so only set it while migrating old clients"`
+	AdminIDs        DiscordIDs    `yaml:"admin_ids" toml:"admin_ids" env:"ADMIN_IDS" doc:"Comma separated Discord IDs of the operators allowed on the admin routes"`
+	TrustedProxies  Networks      `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES" doc:"Comma separated IPs or CIDR networks of the reverse proxies whose X-Forwarded-For is believed; empty uses the peer address"`
+}
+
+// DiscordIDs is a list of Discord user IDs, written comma separated
+type DiscordIDs []string
+
+// MarshalText renders the IDs comma separated
+func (ids DiscordIDs) MarshalText() ([]byte, error) {
+	return []byte(strings.Join(ids, ",")), nil
+}
+
+// UnmarshalText parses comma separated IDs, ignoring blanks
+func (ids *DiscordIDs) UnmarshalText(text []byte) error {
+	*ids = nil
+	for _, id := range strings.Split(string(text), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			*ids = append(*ids, id)
+		}
+	}
+	return nil
+}
+
+// Networks is a list of IP networks in CIDR notation or single IPs,
+// written comma separated
+type Networks []string
+
+// MarshalText renders the networks comma separated
+func (n Networks) MarshalText() ([]byte, error) {
+	return []byte(strings.Join(n, ",")), nil
+}
+
+// UnmarshalText parses comma separated networks, ignoring blanks. They are
+// checked by Validate.
+func (n *Networks) UnmarshalText(text []byte) error {
+	*n = nil
+	for _, network := range strings.Split(string(text), ",") {
+		if network = strings.TrimSpace(network); network != "" {
+			*n = append(*n, network)
+		}
+	}
+	return nil
+}
+
+// Parse returns the networks, a single IP as a network of its own
+func (n Networks) Parse() ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(n))
+	for _, s := range n {
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP nor a CIDR network", s)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Storage backends for users and summaries
+const (
+	BackendMongo    = "mongo"
+	BackendSQLite   = "sqlite"
+	BackendPostgres = "postgres"
+)
+
+type Storage struct {
+	Backend string `yaml:"backend" toml:"backend" env:"STORAGE_BACKEND" doc:"Where users and summaries are stored: mongo, sqlite or postgres"`
+}
+
+// Mongo configures MongoDB, used when it is the storage backend or holds
+// the rate limit counters
+type Mongo struct {
+	URI      string                `yaml:"uri" toml:"uri" env:"MONGO_URI" secret:"true" doc:"MongoDB connection string; required when MongoDB is used"`
+	Database string                `yaml:"database" toml:"database" env:"MONGO_DATABASE" doc:"MongoDB database name"`
+	Timeouts repositories.Timeouts `yaml:"timeouts" toml:"timeouts" env:"MONGO_TIMEOUTS" doc:"Deadline per database operation as <default>[,<repository>.<method>=<duration>...], e.g. 10s,summaries.GetSummaries=3s"`
+}
+
+// SQL configures the sqlite and postgres storage backends
+type SQL struct {
+	DSN      string                `yaml:"dsn" toml:"dsn" env:"SQL_DSN" secret:"true" doc:"SQLite database file, or PostgreSQL connection URL; required with those backends"`
+	Timeouts repositories.Timeouts `yaml:"timeouts" toml:"timeouts" env:"SQL_TIMEOUTS" doc:"Deadline per database operation, in the format of MONGO_TIMEOUTS"`
+}
+
+type Discord struct {
+	APIBaseURL   string        `yaml:"api_base_url" toml:"api_base_url" env:"DISCORD_API_BASE_URL" doc:"Discord API root"`
+	ClientID     string        `yaml:"client_id" toml:"client_id" env:"CLIENT_ID" required:"true" doc:"Discord OAuth2 client ID"`
+	ClientSecret string        `yaml:"client_secret" toml:"client_secret" env:"CLIENT_SECRET" required:"true" secret:"true" doc:"Discord OAuth2 client secret"`
+	RedirectURI  string        `yaml:"redirect_uri" toml:"redirect_uri" env:"REDIRECT_URI" required:"true" doc:"OAuth2 redirect URI registered with Discord"`
+	Scope        string        `yaml:"scope" toml:"scope" env:"SCOPE" doc:"Space separated OAuth2 scopes"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout" env:"DISCORD_TIMEOUT" doc:"Deadline for each Discord API request"`
+}
+
+// OIDC configures an OpenID Connect provider users can link to their
+// account and log in with, alongside Discord. It is off unless an issuer
+// is set.
+type OIDC struct {
+	Name         string        `yaml:"name" toml:"name" env:"OIDC_PROVIDER_NAME" doc:"Provider name in routes, e.g. /api/v1/auth/<name>/login"`
+	Issuer       string        `yaml:"issuer" toml:"issuer" env:"OIDC_ISSUER" doc:"Issuer URL serving /.well-known/openid-configuration; empty disables the provider"`
+	ClientID     string        `yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID" doc:"OIDC client ID; required with an issuer"`
+	ClientSecret string        `yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true" doc:"OIDC client secret"`
+	RedirectURI  string        `yaml:"redirect_uri" toml:"redirect_uri" env:"OIDC_REDIRECT_URI" doc:"Redirect URI registered with the issuer; required with an issuer"`
+	Scope        string        `yaml:"scope" toml:"scope" env:"OIDC_SCOPE" doc:"Space separated OAuth2 scopes"`
+	Timeout      time.Duration `yaml:"timeout" toml:"timeout" env:"OIDC_TIMEOUT" doc:"Deadline for each request to the issuer"`
+}
+
+// Enabled reports whether an OIDC provider is configured
+func (o OIDC) Enabled() bool {
+	return o.Issuer != ""
+}
+
+type RateLimit struct {
+	Auth      ratelimit.Limit `yaml:"auth" toml:"auth" env:"RATE_LIMIT_AUTH" doc:"Auth route limit as <requests>/<s|m|h>[:<burst>]"`
+	Summaries ratelimit.Limit `yaml:"summaries" toml:"summaries" env:"RATE_LIMIT_SUMMARIES" doc:"Summary route limit as <requests>/<s|m|h>[:<burst>]"`
+	Store     string          `yaml:"store" toml:"store" env:"RATE_LIMIT_STORE" doc:"Where rate limit counters live: memory or mongo"`
+}
+
+type Log struct {
+	Level  slog.Level `yaml:"level" toml:"level" env:"LOG_LEVEL" doc:"Lowest level logged: debug, info, warn or error"`
+	Format string     `yaml:"format" toml:"format" env:"LOG_FORMAT" doc:"Log output: json or text"`
+}
+
+type Tracing struct {
+	Exporter     string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" doc:"Where spans are sent: none, otlp or stdout"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" doc:"OTLP/HTTP collector URL, e.g. http://localhost:4318"`
+	ServiceName  string  `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME" doc:"service.name reported with every span"`
+	SampleRatio  float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" doc:"Fraction of new traces recorded, from 0 to 1; sampled parents are always followed"`
+}
+
+// Default returns the configuration used for anything not set explicitly
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port:            5001,
+			MaxBodyBytes:    validation.DefaultMaxBodyBytes,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Storage: Storage{
+			Backend: BackendMongo,
+		},
+		Mongo: Mongo{
+			Database: "discord_oauth",
+			Timeouts: repositories.Timeouts{Default: repositories.DefaultTimeout},
+		},
+		SQL: SQL{
+			Timeouts: repositories.Timeouts{Default: repositories.DefaultTimeout},
+		},
+		Discord: Discord{
+			APIBaseURL: discord.DefaultBaseURL,
+			Scope:      "identify email",
+			Timeout:    discord.DefaultTimeout,
+		},
+		OIDC: OIDC{
+			Name:    "oidc",
+			Scope:   identity.DefaultOIDCScope,
+			Timeout: identity.DefaultOIDCTimeout,
+		},
+		RateLimit: RateLimit{
+			Auth:      ratelimit.Limit{Requests: 30, Per: time.Minute, Burst: 10},
+			Summaries: ratelimit.Limit{Requests: 120, Per: time.Minute, Burst: 30},
+			Store:     "memory",
+		},
+		Log: Log{
+			Level:  slog.LevelInfo,
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "ultra-chat-backend",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Missing []string
+	Invalid []string
+}
+
+func (e *ValidationError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		parts = append(parts, "missing required settings: "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		parts = append(parts, "invalid settings: "+strings.Join(e.Invalid, "; "))
+	}
+	return "config: " + strings.Join(parts, "; ")
+}
+
+// providerName is what an identity provider name may look like, since it
+// appears in URL paths
+var providerName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Validate reports every missing or invalid setting at once
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+
+	walk(reflect.ValueOf(c).Elem(), "", func(f field) {
+		if f.required && f.value.IsZero() {
+			verr.Missing = append(verr.Missing, fmt.Sprintf("%s (%s)", f.env, f.key))
+		}
+	})
+
+	// Checked here rather than with a tag: which database settings are
+	// required depends on the backend
+	switch c.Storage.Backend {
+	case BackendMongo:
+	case BackendSQLite, BackendPostgres:
+		if c.SQL.DSN == "" {
+			verr.Missing = append([]string{"SQL_DSN (sql.dsn)"}, verr.Missing...)
+		}
+	default:
+		verr.Invalid = append(verr.Invalid, fmt.Sprintf("STORAGE_BACKEND: %q must be mongo, sqlite or postgres", c.Storage.Backend))
+	}
+	if c.UsesMongo() && c.Mongo.URI == "" {
+		verr.Missing = append([]string{"MONGO_URI (mongo.uri)"}, verr.Missing...)
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		verr.Invalid = append(verr.Invalid, fmt.Sprintf("PORT: %d is not a valid port", c.Server.Port))
+	}
+	if c.Server.MaxBodyBytes <= 0 {
+		verr.Invalid = append(verr.Invalid, "MAX_BODY_BYTES: must be positive")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		verr.Invalid = append(verr.Invalid, "SHUTDOWN_TIMEOUT: must be positive")
+	}
+	for _, id := range c.Server.AdminIDs {
+		if !validation.IsSnowflake(id) {
+			verr.Invalid = append(verr.Invalid, fmt.Sprintf("ADMIN_IDS: %q is not a Discord ID", id))
+		}
+	}
+	if c.Server.RequireAPIKeys && c.Server.TrustNamedUser {
+		verr.Invalid = append(verr.Invalid, "INSECURE_TRUST_NAMED_USER: cannot be set with API_KEYS_REQUIRED")
+	}
+	if _, err := c.Server.TrustedProxies.Parse(); err != nil {
+		verr.Invalid = append(verr.Invalid, "TRUSTED_PROXIES: "+err.Error())
+	}
+	if c.Discord.Timeout <= 0 {
+		verr.Invalid = append(verr.Invalid, "DISCORD_TIMEOUT: must be positive")
+	}
+	if c.Discord.RedirectURI != "" {
+		if u, err := url.Parse(c.Discord.RedirectURI); err != nil || !u.IsAbs() {
+			verr.Invalid = append(verr.Invalid, "REDIRECT_URI: must be an absolute URL")
+		}
+	}
+	if u, err := url.Parse(c.Discord.APIBaseURL); err != nil || !u.IsAbs() {
+		verr.Invalid = append(verr.Invalid, "DISCORD_API_BASE_URL: must be an absolute URL")
+	}
+	if c.OIDC.Enabled() {
+		if c.OIDC.ClientID == "" {
+			verr.Missing = append(verr.Missing, "OIDC_CLIENT_ID (oidc.client_id)")
+		}
+		if c.OIDC.RedirectURI == "" {
+			verr.Missing = append(verr.Missing, "OIDC_REDIRECT_URI (oidc.redirect_uri)")
+		} else if u, err := url.Parse(c.OIDC.RedirectURI); err != nil || !u.IsAbs() {
+			verr.Invalid = append(verr.Invalid, "OIDC_REDIRECT_URI: must be an absolute URL")
+		}
+		if u, err := url.Parse(c.OIDC.Issuer); err != nil || !u.IsAbs() {
+			verr.Invalid = append(verr.Invalid, "OIDC_ISSUER: must be an absolute URL")
+		}
+		if !providerName.MatchString(c.OIDC.Name) || c.OIDC.Name == identity.DiscordName {
+			verr.Invalid = append(verr.Invalid, fmt.Sprintf("OIDC_PROVIDER_NAME: %q must be lowercase letters, digits and dashes, and not %s", c.OIDC.Name, identity.DiscordName))
+		}
+		if c.OIDC.Timeout <= 0 {
+			verr.Invalid = append(verr.Invalid, "OIDC_TIMEOUT: must be positive")
+		}
+	}
+	if c.RateLimit.Store != "memory" && c.RateLimit.Store != "mongo" {
+		verr.Invalid = append(verr.Invalid, fmt.Sprintf("RATE_LIMIT_STORE: %q must be memory or mongo", c.RateLimit.Store))
+	}
+
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		verr.Invalid = append(verr.Invalid, fmt.Sprintf("LOG_FORMAT: %q must be json or text", c.Log.Format))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.OTLPEndpoint != "" {
+			if u, err := url.Parse(c.Tracing.OTLPEndpoint); err != nil || !u.IsAbs() {
+				verr.Invalid = append(verr.Invalid, "OTEL_EXPORTER_OTLP_ENDPOINT: must be an absolute URL")
+			}
+		}
+	default:
+		verr.Invalid = append(verr.Invalid, fmt.Sprintf("TRACING_EXPORTER: %q must be none, otlp or stdout", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		verr.Invalid = append(verr.Invalid, "TRACING_SAMPLE_RATIO: must be between 0 and 1")
+	}
+
+	if len(verr.Missing) > 0 || len(verr.Invalid) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// UsesMongo reports whether the server connects to MongoDB, for storage
+// or for shared rate limit counters
+func (c *Config) UsesMongo() bool {
+	return c.Storage.Backend == BackendMongo || c.RateLimit.Store == "mongo"
+}
+
+// String renders the configuration one setting per line with secrets
+// redacted, so it is safe to log
+func (c Config) String() string {
+	var b strings.Builder
+	walk(reflect.ValueOf(&c).Elem(), "", func(f field) {
+		value := format(f.value)
+		if f.secret {
+			value = redact(value)
+		}
+		fmt.Fprintf(&b, "%s = %s\n", f.key, value)
+	})
+	return b.String()
+}
+
+// LogValue logs the configuration as a group of settings with secrets
+// redacted
+func (c Config) LogValue() slog.Value {
+	var attrs []slog.Attr
+	walk(reflect.ValueOf(&c).Elem(), "", func(f field) {
+		value := format(f.value)
+		if f.secret {
+			value = redact(value)
+		}
+		attrs = append(attrs, slog.String(f.key, value))
+	})
+	return slog.GroupValue(attrs...)
+}
+
+// redact hides a secret. URLs keep their scheme and host so it is still
+// clear which server is configured.
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		redacted := u.Scheme + "://"
+		if u.User != nil {
+			redacted += "REDACTED@"
+		}
+		redacted += u.Host + u.Path
+		if u.RawQuery != "" {
+			redacted += "?REDACTED"
+		}
+		return redacted
+	}
+	return "REDACTED"
+}
@@ -3,58 +3,76 @@ package config
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"ultra-chat-backend/repositories/sqldb"
 )
 
-var db *mongo.Database
-var mongoClient *mongo.Client
-
-func ConnectDB() *mongo.Database {
-	uri := os.Getenv("MONGO_URI")
-	fmt.Println("MongoDB URI:", uri)
+// DB owns the MongoDB client for the lifetime of the process. The embedded
+// Database is what repositories use; Close disconnects the client.
+type DB struct {
+	*mongo.Database
+	client *mongo.Client
+}
 
-	if uri == "" {
-		log.Fatal("MONGO_URI not set in environment")
-	}
+// ConnectDB connects and pings MongoDB, giving up after 30 seconds or when
+// ctx is cancelled
+func ConnectDB(ctx context.Context, cfg Mongo) (*DB, error) {
+	slog.Info("connecting to MongoDB", "uri", redact(cfg.URI), "database", cfg.Database)
 
-	clientOpts := options.Client().ApplyURI(uri).SetMaxPoolSize(100)
-	fmt.Println("Connecting to MongoDB...")
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
-	client, err := mongo.NewClient(clientOpts)
+	clientOpts := options.Client().ApplyURI(cfg.URI).SetMaxPoolSize(100)
+	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
-		log.Fatalf("Failed to create MongoDB client: %v", err)
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	slog.Info("connected to MongoDB")
+	return &DB{Database: client.Database(cfg.Database), client: client}, nil
+}
+
+// Ping checks that the primary is reachable
+func (db *DB) Ping(ctx context.Context) error {
+	return db.client.Ping(ctx, readpref.Primary())
+}
 
-	if err := client.Connect(ctx); err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+// Close disconnects from MongoDB, waiting for in-use connections until ctx
+// expires
+func (db *DB) Close(ctx context.Context) error {
+	if err := db.client.Disconnect(ctx); err != nil {
+		return fmt.Errorf("failed to disconnect MongoDB: %w", err)
 	}
+	slog.Info("disconnected from MongoDB")
+	return nil
+}
 
-	fmt.Println("Pinging MongoDB...")
-	if err := client.Ping(ctx, nil); err != nil {
-		log.Fatalf("Failed to ping MongoDB: %v", err)
+// ConnectSQL opens the sqlite or postgres storage backend, giving up after
+// 30 seconds or when ctx is cancelled
+func ConnectSQL(ctx context.Context, backend string, cfg SQL) (*sqldb.DB, error) {
+	dsn := cfg.DSN
+	if backend == BackendPostgres {
+		dsn = redact(dsn)
 	}
+	slog.Info("connecting to "+backend, "dsn", dsn)
 
-	fmt.Println("Connected to MongoDB successfully")
-	return client.Database("discord_oauth")
-}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
-// DisconnectDB gracefully disconnects MongoDB
-func DisconnectDB() {
-	if mongoClient != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := mongoClient.Disconnect(ctx); err != nil {
-			log.Println("Failed to disconnect MongoDB: ", err)
-		} else {
-			log.Println("Disconnected from MongoDB")
-		}
+	db, err := sqldb.Open(ctx, sqldb.Dialect(backend), cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", backend, err)
 	}
+	slog.Info("connected to " + backend)
+	return db, nil
 }
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable that points at a config file; the
+// -config flag takes precedence over it
+const FileEnv = "CONFIG_FILE"
+
+// Load builds the configuration from defaults, an optional YAML or TOML
+// file, the environment and the command line, then validates it. getenv is
+// usually os.LookupEnv. If args contain -h, the usage is written to output
+// and flag.ErrHelp returned.
+func Load(args []string, getenv func(string) (string, bool), output io.Writer) (*Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("ultra-chat-backend", flag.ContinueOnError)
+	flags.SetOutput(output)
+	file := flags.String("config", "", "Path to a YAML or TOML config file (env "+FileEnv+")")
+
+	// Flags are parsed first so -config can locate the file, but applied
+	// last so they win over the file and the environment
+	type flagValue struct{ name, value string }
+	var flagValues []flagValue
+	setters := map[string]func(string) error{}
+	walk(reflect.ValueOf(&cfg).Elem(), "", func(f field) {
+		name := flagName(f.env)
+		setters[name] = f.set
+		flags.Func(name, fmt.Sprintf("%s (env %s, default %q)", f.doc, f.env, format(f.value)), func(value string) error {
+			flagValues = append(flagValues, flagValue{name, value})
+			return nil
+		})
+	})
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *file == "" {
+		*file, _ = getenv(FileEnv)
+	}
+	if *file != "" {
+		if err := loadFile(*file, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	var invalid []string
+	walk(reflect.ValueOf(&cfg).Elem(), "", func(f field) {
+		if value, ok := getenv(f.env); ok {
+			if err := f.set(value); err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s: %v", f.env, err))
+			}
+		}
+	})
+
+	for _, fl := range flagValues {
+		if err := setters[fl.name](fl.value); err != nil {
+			invalid = append(invalid, fmt.Sprintf("-%s: %v", fl.name, err))
+		}
+	}
+
+	err := cfg.Validate()
+	if len(invalid) > 0 {
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			verr = &ValidationError{}
+		}
+		verr.Invalid = append(invalid, verr.Invalid...)
+		return nil, verr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile decodes a config file over cfg, rejecting unknown keys
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: reading %s: %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config: parsing %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("config: parsing %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config: parsing %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("config: %s: unsupported file type, use .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// field is a single setting found by walk
+type field struct {
+	key      string
+	env      string
+	doc      string
+	required bool
+	secret   bool
+	value    reflect.Value
+}
+
+// walk calls fn for every tagged setting in v, depth first in declaration order
+func walk(v reflect.Value, prefix string, fn func(field)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := prefix + strings.Split(sf.Tag.Get("yaml"), ",")[0]
+
+		env := sf.Tag.Get("env")
+		if env == "" {
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), key+".", fn)
+			}
+			continue
+		}
+
+		fn(field{
+			key:      key,
+			env:      env,
+			doc:      sf.Tag.Get("doc"),
+			required: sf.Tag.Get("required") == "true",
+			secret:   sf.Tag.Get("secret") == "true",
+			value:    v.Field(i),
+		})
+	}
+}
+
+// set parses s into the setting
+func (f field) set(s string) error {
+	if u, ok := f.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch f.value.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		f.value.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		f.value.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		f.value.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+	return nil
+}
+
+// format renders a setting the way set parses it
+func format(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		text, _ := m.MarshalText()
+		return string(text)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// flagName turns an env name into a flag name, e.g. MONGO_URI to mongo-uri
+func flagName(env string) string {
+	return strings.ReplaceAll(strings.ToLower(env), "_", "-")
+}
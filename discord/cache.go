@@ -0,0 +1,81 @@
+package discord
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type cachedUser struct {
+	user    *User
+	expires time.Time
+}
+
+// userCache maps access tokens to the user they belong to. Tokens are
+// stored hashed so a heap dump does not leak them.
+type userCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedUser
+	writes  int
+}
+
+// sweepEvery is how many writes happen between sweeps of expired entries
+const sweepEvery = 256
+
+func newUserCache() *userCache {
+	return &userCache{entries: map[string]cachedUser{}}
+}
+
+func (c *userCache) get(token string, now time.Time) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[tokenKey(token)]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+	user := *entry.user
+	return &user, true
+}
+
+func (c *userCache) set(token string, user *User, now time.Time, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Periodically drop expired entries so the map stays bounded by live tokens
+	c.writes++
+	if c.writes%sweepEvery == 0 {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+	}
+
+	stored := *user
+	c.entries[tokenKey(token)] = cachedUser{user: &stored, expires: now.Add(ttl)}
+}
+
+func (c *userCache) delete(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, tokenKey(token))
+}
+
+// deleteUser drops every entry for the user with the given ID
+func (c *userCache) deleteUser(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if entry.user.ID == userID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// tokenKey hashes a token for use as a map key
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
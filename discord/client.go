@@ -0,0 +1,272 @@
+// Package discord is a small client for the Discord OAuth2 and REST APIs.
+// It tracks Discord's per-route rate limit buckets, honours Retry-After on
+// 429s, applies timeouts and briefly caches /users/@me per access token so
+// that repeated authentication checks do not each cost a Discord call.
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"ultra-chat-backend/metrics"
+)
+
+var tracer = otel.Tracer("ultra-chat-backend/discord")
+
+const (
+	// DefaultBaseURL is Discord's versioned API root
+	DefaultBaseURL = "https://discord.com/api/v10"
+
+	DefaultTimeout      = 10 * time.Second
+	DefaultUserCacheTTL = 30 * time.Second
+	DefaultMaxRetries   = 2
+	DefaultMaxWait      = 5 * time.Second
+)
+
+// Config configures a Client. Zero values fall back to the defaults above.
+type Config struct {
+	// BaseURL lets tests point the client at a fake Discord
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scope        string
+
+	Timeout time.Duration
+	// UserCacheTTL is how long /users/@me is cached per token; negative
+	// disables the cache
+	UserCacheTTL time.Duration
+	// MaxRetries is how many times a rate limited request is retried;
+	// negative disables retries
+	MaxRetries int
+	// MaxWait is the longest the client sleeps for a bucket to reset before
+	// giving up with a 429 Error instead
+	MaxWait time.Duration
+
+	HTTPClient *http.Client
+
+	// Metrics, if set, receives request counts, latencies and rate limits
+	// per route
+	Metrics *metrics.Registry
+}
+
+// Client talks to Discord. It is safe for concurrent use.
+type Client struct {
+	cfg     Config
+	http    *http.Client
+	limits  *rateLimits
+	users   *userCache
+	now     func() time.Time
+	sleepFn func(ctx context.Context, d time.Duration) error
+
+	requests    *metrics.CounterVec
+	latency     *metrics.HistogramVec
+	rateLimited *metrics.CounterVec
+}
+
+func NewClient(cfg Config) *Client {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.UserCacheTTL == 0 {
+		cfg.UserCacheTTL = DefaultUserCacheTTL
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = DefaultMaxWait
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	return &Client{
+		cfg:     cfg,
+		http:    httpClient,
+		limits:  newRateLimits(),
+		users:   newUserCache(),
+		now:     time.Now,
+		sleepFn: sleep,
+
+		requests:    cfg.Metrics.Counter("discord_requests_total", "Discord API requests per route and status; status is \"error\" when no response arrived.", "route", "status"),
+		latency:     cfg.Metrics.Histogram("discord_request_duration_seconds", "Discord API request latency in seconds.", nil, "route"),
+		rateLimited: cfg.Metrics.Counter("discord_rate_limited_total", "Discord rate limits hit per route. scope is global or bucket for 429 responses, and local when the client gave up before sending.", "route", "scope"),
+	}
+}
+
+// BaseURL returns the API root the client sends requests to
+func (c *Client) BaseURL() string {
+	return c.cfg.BaseURL
+}
+
+// AuthorizeURL returns the URL users open to grant the application access.
+// state is passed back on the redirect and challenge is the S256 PKCE code
+// challenge; either may be empty.
+func (c *Client) AuthorizeURL(state, challenge string) string {
+	query := url.Values{}
+	query.Set("client_id", c.cfg.ClientID)
+	query.Set("redirect_uri", c.cfg.RedirectURI)
+	query.Set("response_type", "code")
+	query.Set("scope", c.cfg.Scope)
+	if state != "" {
+		query.Set("state", state)
+	}
+	if challenge != "" {
+		query.Set("code_challenge", challenge)
+		query.Set("code_challenge_method", "S256")
+	}
+	return c.cfg.BaseURL + "/oauth2/authorize?" + query.Encode()
+}
+
+// request describes a single API call so it can be rebuilt for retries
+type request struct {
+	method string
+	path   string
+	// route identifies the rate limit bucket, e.g. "GET /users/@me"
+	route  string
+	form   url.Values
+	bearer string
+}
+
+// do sends req, waiting for exhausted buckets and retrying 429s, and
+// decodes a 200 response into out. The call, including waits and retries,
+// is traced as one client span. Trace context is not propagated to Discord.
+func (c *Client) do(ctx context.Context, req request, out interface{}) (err error) {
+	ctx, span := tracer.Start(ctx, "discord "+req.route,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.method),
+			attribute.String("discord.route", req.route),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	key := req.route
+	if req.bearer != "" {
+		// User routes are limited per token
+		key += " " + tokenKey(req.bearer)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if wait := c.limits.wait(key, c.now()); wait > 0 {
+			if wait > c.cfg.MaxWait {
+				c.rateLimited.With(req.route, "local").Inc()
+				return &Error{Route: req.route, StatusCode: http.StatusTooManyRequests, RetryAfter: wait}
+			}
+			span.AddEvent("waiting for rate limit bucket", trace.WithAttributes(attribute.Float64("wait_seconds", wait.Seconds())))
+			if err := c.sleepFn(ctx, wait); err != nil {
+				return err
+			}
+		}
+
+		start := time.Now()
+		resp, err := c.send(ctx, req)
+		c.latency.With(req.route).Observe(time.Since(start).Seconds())
+		if err != nil {
+			c.requests.With(req.route, "error").Inc()
+			return fmt.Errorf("discord %s: %w", req.route, err)
+		}
+		c.requests.With(req.route, strconv.Itoa(resp.StatusCode)).Inc()
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", resp.StatusCode),
+			attribute.Int("http.request.resend_count", attempt),
+		)
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("discord %s: reading response: %w", req.route, err)
+		}
+
+		now := c.now()
+		c.limits.update(key, resp.Header, now)
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			retryAfter, global := parseTooManyRequests(resp.Header, body)
+			scope := "bucket"
+			if global {
+				scope = "global"
+			}
+			c.rateLimited.With(req.route, scope).Inc()
+			span.AddEvent("rate limited", trace.WithAttributes(
+				attribute.String("scope", scope),
+				attribute.Float64("retry_after_seconds", retryAfter.Seconds()),
+			))
+			c.limits.limited(key, retryAfter, global, now)
+			if attempt < c.cfg.MaxRetries && retryAfter <= c.cfg.MaxWait {
+				continue
+			}
+			return &Error{Route: req.route, StatusCode: resp.StatusCode, Body: string(body), RetryAfter: retryAfter}
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return &Error{Route: req.route, StatusCode: resp.StatusCode, Body: string(body)}
+		}
+
+		if out == nil {
+			return nil
+		}
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("discord %s: decoding response: %w", req.route, err)
+		}
+		return nil
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var body io.Reader
+	if req.form != nil {
+		body = strings.NewReader(req.form.Encode())
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, c.cfg.BaseURL+req.path, body)
+	if err != nil {
+		return nil, err
+	}
+	if req.form != nil {
+		httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if req.bearer != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.bearer)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+
+	return c.http.Do(httpReq)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,166 @@
+package discord
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient points a client at handler and records requested sleeps
+// instead of sleeping
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *[]time.Duration) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client := NewClient(Config{BaseURL: server.URL, ClientID: "id", RedirectURI: "http://localhost/cb", Scope: "identify"})
+	now := time.Unix(1700000000, 0)
+	client.now = func() time.Time { return now }
+	var slept []time.Duration
+	client.sleepFn = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	return client, &slept
+}
+
+func TestCurrentUserIsCachedPerToken(t *testing.T) {
+	var calls int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path != "/users/@me" || r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message": "401: Unauthorized", "code": 0}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": "80351110224678912", "username": "nelly"}`))
+	})
+
+	for i := 0; i < 3; i++ {
+		user, err := client.CurrentUser(context.Background(), "good")
+		if err != nil || user.ID != "80351110224678912" {
+			t.Fatalf("CurrentUser = %+v, %v", user, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Discord was called %d times, want 1", calls)
+	}
+
+	_, err := client.CurrentUser(context.Background(), "bad")
+	if !IsUnauthorized(err) {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+
+	client.now = func() time.Time { return time.Unix(1700000000, 0).Add(time.Hour) }
+	if _, err := client.CurrentUser(context.Background(), "good"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expired cache entry was not refreshed (calls = %d)", calls)
+	}
+
+	client.ForgetUser("80351110224678912")
+	if _, err := client.CurrentUser(context.Background(), "good"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 {
+		t.Errorf("ForgetUser left the lookup cached (calls = %d)", calls)
+	}
+}
+
+func TestTooManyRequestsIsRetried(t *testing.T) {
+	var calls int32
+	client, slept := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.25, "global": false}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "abc", "token_type": "Bearer", "expires_in": 604800}`))
+	})
+
+	token, err := client.ExchangeCode(context.Background(), "code", "")
+	if err != nil || token.AccessToken != "abc" {
+		t.Fatalf("ExchangeCode = %+v, %v", token, err)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 250*time.Millisecond {
+		t.Errorf("slept %v, want one 250ms wait", *slept)
+	}
+}
+
+func TestLongRateLimitFailsFast(t *testing.T) {
+	client, slept := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"retry_after": 60, "global": true}`))
+	})
+
+	_, err := client.ExchangeCode(context.Background(), "code", "")
+	if !IsRateLimited(err) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if len(*slept) != 0 {
+		t.Errorf("client slept %v for a limit above MaxWait", *slept)
+	}
+
+	// The global limit now applies to every route without calling Discord
+	_, err = client.CurrentUser(context.Background(), "token")
+	if !IsRateLimited(err) {
+		t.Errorf("global rate limit was not applied to other routes: %v", err)
+	}
+}
+
+func TestExhaustedBucketWaitsForReset(t *testing.T) {
+	client, slept := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Bucket", "abcd1234")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset-After", "2")
+		_, _ = w.Write([]byte(`{"access_token": "abc"}`))
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ExchangeCode(context.Background(), "code", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(*slept) != 1 || (*slept)[0] != 2*time.Second {
+		t.Errorf("slept %v, want one 2s wait before the second call", *slept)
+	}
+}
+
+func TestAuthorizeURL(t *testing.T) {
+	client := NewClient(Config{BaseURL: "http://fake/api/", ClientID: "123", RedirectURI: "http://localhost:5001/callback", Scope: "identify email"})
+
+	want := "http://fake/api/oauth2/authorize?client_id=123&redirect_uri=http%3A%2F%2Flocalhost%3A5001%2Fcallback&response_type=code&scope=identify+email"
+	if got := client.AuthorizeURL("", ""); got != want {
+		t.Errorf("AuthorizeURL() = %s, want %s", got, want)
+	}
+
+	want = "http://fake/api/oauth2/authorize?client_id=123&code_challenge=abc&code_challenge_method=S256&redirect_uri=http%3A%2F%2Flocalhost%3A5001%2Fcallback&response_type=code&scope=identify+email&state=xyz"
+	if got := client.AuthorizeURL("xyz", "abc"); got != want {
+		t.Errorf("AuthorizeURL(xyz, abc) = %s, want %s", got, want)
+	}
+}
+
+func TestGuildCanManage(t *testing.T) {
+	tests := []struct {
+		guild Guild
+		want  bool
+	}{
+		{Guild{Owner: true, Permissions: "0"}, true},
+		{Guild{Permissions: "8"}, true},
+		{Guild{Permissions: "32"}, true},
+		{Guild{Permissions: "2147483648"}, false},
+		{Guild{Permissions: "104324673"}, false},
+		{Guild{}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.guild.CanManage(); got != tt.want {
+			t.Errorf("%+v.CanManage() = %v, want %v", tt.guild, got, tt.want)
+		}
+	}
+}
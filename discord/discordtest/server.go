@@ -0,0 +1,436 @@
+// Package discordtest provides an in-process fake of the Discord OAuth2 and
+// user APIs for tests. Point discord.Config.BaseURL at Server.URL.
+//
+// The fake implements /oauth2/authorize, /oauth2/token (authorization_code
+// and refresh_token grants), /oauth2/token/revoke, /users/@me and
+// /users/@me/guilds. Tests script
+// it by adding users, choosing who "consents" on the authorize page and
+// queueing failures per route. Codes issued for an authorize request with
+// a PKCE challenge are only exchanged with the matching verifier.
+package discordtest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ultra-chat-backend/discord"
+)
+
+// Routes accepted by Fail and Requests
+const (
+	RouteAuthorize = "GET /oauth2/authorize"
+	RouteToken     = "POST /oauth2/token"
+	RouteRevoke    = "POST /oauth2/token/revoke"
+	RouteMe        = "GET /users/@me"
+	RouteGuilds    = "GET /users/@me/guilds"
+	RouteGateway   = "GET /gateway"
+)
+
+const tokenLifetime = 604800
+
+// Failure is a scripted response served instead of the real one
+type Failure struct {
+	Status int
+	Body   string
+	Header http.Header
+	// Delay holds the response back, or until the client gives up. With a
+	// zero Status the route then responds normally.
+	Delay time.Duration
+}
+
+type account struct {
+	user   discord.User
+	guilds []discord.Guild
+}
+
+// Server is a fake Discord. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	mu            sync.Mutex
+	accounts      map[string]*account
+	consenting    string
+	codes         map[string]grant
+	accessTokens  map[string]string
+	refreshTokens map[string]string
+	// partners pairs the access and refresh token of each grant, so that
+	// revoking one revokes both
+	partners map[string]string
+	failures map[string][]Failure
+	requests map[string]int
+}
+
+type grant struct {
+	userID      string
+	redirectURI string
+	scope       string
+	// challenge is the S256 PKCE challenge of the authorize request
+	challenge string
+}
+
+// NewServer starts a fake Discord accepting the given client credentials
+func NewServer(clientID, clientSecret string) *Server {
+	s := &Server{
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		accounts:      map[string]*account{},
+		codes:         map[string]grant{},
+		accessTokens:  map[string]string{},
+		refreshTokens: map[string]string{},
+		partners:      map[string]string{},
+		failures:      map[string][]Failure{},
+		requests:      map[string]int{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/authorize", s.route(RouteAuthorize, s.authorize))
+	mux.HandleFunc("/oauth2/token", s.route(RouteToken, s.token))
+	mux.HandleFunc("/oauth2/token/revoke", s.route(RouteRevoke, s.revoke))
+	mux.HandleFunc("/users/@me", s.route(RouteMe, s.me))
+	mux.HandleFunc("/users/@me/guilds", s.route(RouteGuilds, s.guilds))
+	mux.HandleFunc("/gateway", s.route(RouteGateway, s.gateway))
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddUser registers a Discord account. The first user added consents on
+// the authorize page until SetConsentingUser picks another.
+func (s *Server) AddUser(user discord.User, guilds ...discord.Guild) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts[user.ID] = &account{user: user, guilds: guilds}
+	if s.consenting == "" {
+		s.consenting = user.ID
+	}
+}
+
+// SetConsentingUser chooses the account that approves the next authorize request
+func (s *Server) SetConsentingUser(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consenting = userID
+}
+
+// IssueCode returns an authorization code for userID, skipping the
+// authorize redirect
+func (s *Server) IssueCode(userID, redirectURI string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := randomString()
+	s.codes[code] = grant{userID: userID, redirectURI: redirectURI, scope: "identify"}
+	return code
+}
+
+// IssueToken returns a valid access token for userID
+func (s *Server) IssueToken(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := randomString()
+	s.accessTokens[token] = userID
+	return token
+}
+
+// IssueTokenPair returns a valid access token and its refresh token for
+// userID, as a completed OAuth flow would
+func (s *Server) IssueTokenPair(userID string) (access, refresh string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	access, refresh = randomString(), randomString()
+	s.accessTokens[access] = userID
+	s.refreshTokens[refresh] = userID
+	s.partners[access] = refresh
+	s.partners[refresh] = access
+	return access, refresh
+}
+
+// ExpireToken invalidates an access token while leaving its refresh token
+// usable, as when it reaches the end of its lifetime
+func (s *Server) ExpireToken(access string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.accessTokens, access)
+}
+
+// RevokeUser invalidates every token of userID, as when the user removes
+// the application from their Discord settings
+func (s *Server) RevokeUser(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, owner := range s.accessTokens {
+		if owner == userID {
+			delete(s.accessTokens, token)
+		}
+	}
+	for token, owner := range s.refreshTokens {
+		if owner == userID {
+			delete(s.refreshTokens, token)
+		}
+	}
+}
+
+// Fail queues failures for route; each request to route consumes one
+func (s *Server) Fail(route string, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[route] = append(s.failures[route], failures...)
+}
+
+// RateLimit queues a 429 for route asking clients to retry after seconds
+func (s *Server) RateLimit(route string, seconds float64, global bool) {
+	s.Fail(route, Failure{
+		Status: http.StatusTooManyRequests,
+		Body:   fmt.Sprintf(`{"message": "You are being rate limited.", "retry_after": %g, "global": %t}`, seconds, global),
+		Header: http.Header{"Retry-After": {fmt.Sprintf("%g", seconds)}},
+	})
+}
+
+// Requests returns how many requests route has received
+func (s *Server) Requests(route string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[route]
+}
+
+// Valid reports whether token is a live access or refresh token
+func (s *Server) Valid(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, access := s.accessTokens[token]
+	_, refresh := s.refreshTokens[token]
+	return access || refresh
+}
+
+// route counts requests, enforces the method and serves queued failures
+func (s *Server) route(name string, next http.HandlerFunc) http.HandlerFunc {
+	method, _, _ := strings.Cut(name, " ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, "405: Method Not Allowed")
+			return
+		}
+
+		s.mu.Lock()
+		s.requests[name]++
+		var failure *Failure
+		if queued := s.failures[name]; len(queued) > 0 {
+			failure = &queued[0]
+			s.failures[name] = queued[1:]
+		}
+		s.mu.Unlock()
+
+		if failure != nil && failure.Delay > 0 {
+			select {
+			case <-time.After(failure.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if failure != nil && failure.Status != 0 {
+			for key, values := range failure.Header {
+				w.Header()[key] = values
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(failure.Status)
+			_, _ = w.Write([]byte(failure.Body))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		writeError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	s.mu.Lock()
+	userID := s.consenting
+	code := randomString()
+	s.codes[code] = grant{userID: userID, redirectURI: query.Get("redirect_uri"), scope: query.Get("scope"), challenge: query.Get("code_challenge")}
+	s.mu.Unlock()
+
+	params := redirect.Query()
+	if userID == "" {
+		params.Set("error", "access_denied")
+	} else {
+		params.Set("code", code)
+	}
+	if state := query.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("client_id") != s.ClientID || r.PostForm.Get("client_secret") != s.ClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var userID, scope string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		g, ok := s.codes[r.PostForm.Get("code")]
+		if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") || !verifies(g.challenge, r.PostForm.Get("code_verifier")) {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		delete(s.codes, r.PostForm.Get("code"))
+		userID, scope = g.userID, g.scope
+	case "refresh_token":
+		owner, ok := s.refreshTokens[r.PostForm.Get("refresh_token")]
+		if !ok {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		delete(s.refreshTokens, r.PostForm.Get("refresh_token"))
+		userID, scope = owner, "identify"
+	default:
+		writeOAuthError(w, "unsupported_grant_type")
+		return
+	}
+
+	access, refresh := randomString(), randomString()
+	s.accessTokens[access] = userID
+	s.refreshTokens[refresh] = userID
+	s.partners[access] = refresh
+	s.partners[refresh] = access
+
+	writeJSON(w, discord.Token{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokenLifetime,
+		RefreshToken: refresh,
+		Scope:        scope,
+	})
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("client_id") != s.ClientID || r.PostForm.Get("client_secret") != s.ClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := r.PostForm.Get("token")
+	for _, t := range []string{token, s.partners[token]} {
+		delete(s.accessTokens, t)
+		delete(s.refreshTokens, t)
+		delete(s.partners, t)
+	}
+	writeJSON(w, map[string]string{})
+}
+
+// authenticated returns the account a bearer token belongs to
+func (s *Server) authenticated(w http.ResponseWriter, r *http.Request) (*account, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ok {
+		if acc, found := s.accounts[s.accessTokens[token]]; found {
+			return acc, true
+		}
+	}
+	writeError(w, http.StatusUnauthorized, "401: Unauthorized")
+	return nil, false
+}
+
+func (s *Server) me(w http.ResponseWriter, r *http.Request) {
+	if acc, ok := s.authenticated(w, r); ok {
+		writeJSON(w, acc.user)
+	}
+}
+
+func (s *Server) guilds(w http.ResponseWriter, r *http.Request) {
+	if acc, ok := s.authenticated(w, r); ok {
+		guilds := acc.guilds
+		if guilds == nil {
+			guilds = []discord.Guild{}
+		}
+		writeJSON(w, guilds)
+	}
+}
+
+func (s *Server) gateway(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"url": "wss://gateway.discord.gg"})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"message": message, "code": 0})
+}
+
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// verifies reports whether verifier matches an S256 PKCE challenge. Codes
+// issued without a challenge need no verifier.
+func verifies(challenge, verifier string) bool {
+	if challenge == "" {
+		return true
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,44 @@
+package discord
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Error is returned when Discord answers with a non-200 status. Body is
+// kept for logging and must not be forwarded to API clients.
+type Error struct {
+	Route      string
+	StatusCode int
+	Body       string
+	// RetryAfter is set on 429s
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == http.StatusTooManyRequests {
+		return fmt.Sprintf("discord %s: rate limited, retry after %s", e.Route, e.RetryAfter)
+	}
+	return fmt.Sprintf("discord %s: status %d: %s", e.Route, e.StatusCode, e.Body)
+}
+
+// StatusCode returns the Discord status carried by err, or 0
+func StatusCode(err error) int {
+	var discordErr *Error
+	if errors.As(err, &discordErr) {
+		return discordErr.StatusCode
+	}
+	return 0
+}
+
+// IsUnauthorized reports whether Discord rejected the access token
+func IsUnauthorized(err error) bool {
+	return StatusCode(err) == http.StatusUnauthorized
+}
+
+// IsRateLimited reports whether the call failed because of a rate limit
+func IsRateLimited(err error) bool {
+	return StatusCode(err) == http.StatusTooManyRequests
+}
@@ -0,0 +1,205 @@
+package discord
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Token is an OAuth2 token response
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// User is Discord's user object as returned by /users/@me
+type User struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	Discriminator string `json:"discriminator"`
+	GlobalName    string `json:"global_name,omitempty"`
+	Avatar        string `json:"avatar,omitempty"`
+	Banner        string `json:"banner,omitempty"`
+	AccentColor   int    `json:"accent_color,omitempty"`
+	Locale        string `json:"locale,omitempty"`
+	Email         string `json:"email,omitempty"`
+	Verified      bool   `json:"verified,omitempty"`
+	MFAEnabled    bool   `json:"mfa_enabled,omitempty"`
+	Flags         int    `json:"flags,omitempty"`
+	PremiumType   int    `json:"premium_type,omitempty"`
+	PublicFlags   int    `json:"public_flags,omitempty"`
+}
+
+// Guild is a partial guild as returned by /users/@me/guilds
+type Guild struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Icon        string `json:"icon,omitempty"`
+	Owner       bool   `json:"owner"`
+	Permissions string `json:"permissions"`
+}
+
+// Permission bits in Guild.Permissions
+const (
+	PermissionAdministrator = 1 << 3
+	PermissionManageGuild   = 1 << 5
+)
+
+// CanManage reports whether the user owns the guild or may manage it
+func (g Guild) CanManage() bool {
+	if g.Owner {
+		return true
+	}
+	permissions, err := strconv.ParseUint(g.Permissions, 10, 64)
+	return err == nil && permissions&(PermissionAdministrator|PermissionManageGuild) != 0
+}
+
+// ExchangeCode trades an authorization code for tokens. verifier is the
+// PKCE code verifier of the authorization, if it was started with a
+// challenge.
+func (c *Client) ExchangeCode(ctx context.Context, code, verifier string) (*Token, error) {
+	form := url.Values{}
+	form.Set("client_id", c.cfg.ClientID)
+	form.Set("client_secret", c.cfg.ClientSecret)
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.cfg.RedirectURI)
+	if verifier != "" {
+		form.Set("code_verifier", verifier)
+	}
+
+	var token Token
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/oauth2/token",
+		route:  "POST /oauth2/token",
+		form:   form,
+	}, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RefreshToken trades a refresh token for a new token pair
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*Token, error) {
+	form := url.Values{}
+	form.Set("client_id", c.cfg.ClientID)
+	form.Set("client_secret", c.cfg.ClientSecret)
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+
+	var token Token
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/oauth2/token",
+		route:  "POST /oauth2/token",
+		form:   form,
+	}, &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Token type hints for RevokeToken
+const (
+	HintAccessToken  = "access_token"
+	HintRefreshToken = "refresh_token"
+)
+
+// RevokeToken invalidates an access or refresh token; hint says which it
+// is. Discord ends the whole grant, so the other token of the pair stops
+// working too. Revoking a token Discord no longer knows succeeds.
+func (c *Client) RevokeToken(ctx context.Context, token, hint string) error {
+	form := url.Values{}
+	form.Set("client_id", c.cfg.ClientID)
+	form.Set("client_secret", c.cfg.ClientSecret)
+	form.Set("token", token)
+	if hint != "" {
+		form.Set("token_type_hint", hint)
+	}
+
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/oauth2/token/revoke",
+		route:  "POST /oauth2/token/revoke",
+		form:   form,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if hint != HintRefreshToken {
+		c.ForgetToken(token)
+	}
+	return nil
+}
+
+// CurrentUser returns the user an access token belongs to. Successful
+// lookups are cached for Config.UserCacheTTL; a 401 evicts the token.
+func (c *Client) CurrentUser(ctx context.Context, accessToken string) (*User, error) {
+	now := c.now()
+	if user, ok := c.users.get(accessToken, now); ok {
+		return user, nil
+	}
+
+	var user User
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/users/@me",
+		route:  "GET /users/@me",
+		bearer: accessToken,
+	}, &user)
+	if err != nil {
+		if IsUnauthorized(err) {
+			c.users.delete(accessToken)
+		}
+		return nil, err
+	}
+
+	if c.cfg.UserCacheTTL > 0 {
+		c.users.set(accessToken, &user, now, c.cfg.UserCacheTTL)
+	}
+	return &user, nil
+}
+
+// ForgetToken drops any cached lookup for accessToken
+func (c *Client) ForgetToken(accessToken string) {
+	c.users.delete(accessToken)
+}
+
+// ForgetUser drops every cached lookup that resolved to the user with the
+// given Discord ID, whichever token it was made with
+func (c *Client) ForgetUser(userID string) {
+	c.users.deleteUser(userID)
+}
+
+// CurrentUserGuilds lists the guilds the token's user is a member of. It
+// requires the guilds scope.
+func (c *Client) CurrentUserGuilds(ctx context.Context, accessToken string) ([]Guild, error) {
+	var guilds []Guild
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/users/@me/guilds",
+		route:  "GET /users/@me/guilds",
+		bearer: accessToken,
+	}, &guilds)
+	if err != nil {
+		return nil, err
+	}
+	return guilds, nil
+}
+
+// Ping checks that Discord's API is reachable with an unauthenticated
+// request for the gateway URL
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/gateway",
+		route:  "GET /gateway",
+	}, nil)
+}
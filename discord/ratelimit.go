@@ -0,0 +1,131 @@
+package discord
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type bucketState struct {
+	remaining int
+	reset     time.Time
+}
+
+// rateLimits mirrors Discord's rate limit state as reported by the
+// X-RateLimit-* headers. Routes are mapped to the bucket hash Discord
+// reports so that routes sharing a bucket share the countdown.
+type rateLimits struct {
+	mu          sync.Mutex
+	routes      map[string]string
+	buckets     map[string]*bucketState
+	globalReset time.Time
+}
+
+func newRateLimits() *rateLimits {
+	return &rateLimits{
+		routes:  map[string]string{},
+		buckets: map[string]*bucketState{},
+	}
+}
+
+// bucketKey returns the bucket for route, which is the route itself until
+// Discord has told us its hash
+func (r *rateLimits) bucketKey(route string) string {
+	if hash, ok := r.routes[route]; ok {
+		return hash
+	}
+	return route
+}
+
+// wait returns how long to hold a request on route before sending it
+func (r *rateLimits) wait(route string, now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var wait time.Duration
+	if now.Before(r.globalReset) {
+		wait = r.globalReset.Sub(now)
+	}
+
+	if b, ok := r.buckets[r.bucketKey(route)]; ok && b.remaining <= 0 && now.Before(b.reset) {
+		if d := b.reset.Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// update records the bucket state reported in a response
+func (r *rateLimits) update(route string, header http.Header, now time.Time) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	resetAfter, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset-After"), 64)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := route
+	if hash := header.Get("X-RateLimit-Bucket"); hash != "" {
+		// Route key is kept in the bucket key so per-token buckets stay apart
+		key = hash + " " + route
+		r.routes[route] = key
+	}
+
+	r.buckets[key] = &bucketState{
+		remaining: remaining,
+		reset:     now.Add(time.Duration(resetAfter * float64(time.Second))),
+	}
+	r.sweep(now)
+}
+
+// limited records a 429 so that following requests wait it out
+func (r *rateLimits) limited(route string, retryAfter time.Duration, global bool, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reset := now.Add(retryAfter)
+	if global {
+		r.globalReset = reset
+		return
+	}
+	r.buckets[r.bucketKey(route)] = &bucketState{remaining: 0, reset: reset}
+}
+
+// sweep forgets buckets that have reset; a missing bucket is not limited
+func (r *rateLimits) sweep(now time.Time) {
+	for key, b := range r.buckets {
+		if now.After(b.reset) {
+			delete(r.buckets, key)
+		}
+	}
+	for route, key := range r.routes {
+		if _, ok := r.buckets[key]; !ok {
+			delete(r.routes, route)
+		}
+	}
+}
+
+// parseTooManyRequests reads the wait time of a 429 from its body, falling
+// back to the Retry-After header
+func parseTooManyRequests(header http.Header, body []byte) (time.Duration, bool) {
+	var payload struct {
+		RetryAfter float64 `json:"retry_after"`
+		Global     bool    `json:"global"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.RetryAfter > 0 {
+		return time.Duration(payload.RetryAfter * float64(time.Second)), payload.Global
+	}
+
+	global := header.Get("X-RateLimit-Global") == "true"
+	if seconds, err := strconv.ParseFloat(header.Get("Retry-After"), 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), global
+	}
+	return time.Second, global
+}
@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"ultra-chat-backend/apikey"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+// AccountHandler serves the authenticated user's own account: its stored
+// profile and preferences, its linked identities, its API keys, a copy of
+// everything stored about them and its deletion
+type AccountHandler struct {
+	users      repositories.UserRepository
+	identities repositories.IdentityRepository
+	keys       repositories.APIKeyRepository
+	summaries  repositories.SummaryRepository
+	audit      repositories.AuditRepository
+	discord    *discord.Client
+	sessions   sessions
+}
+
+func NewAccountHandler(users repositories.UserRepository, identities repositories.IdentityRepository, keys repositories.APIKeyRepository, summaries repositories.SummaryRepository, audit repositories.AuditRepository, discordClient *discord.Client, providers *identity.Registry) *AccountHandler {
+	return &AccountHandler{
+		users:      users,
+		identities: identities,
+		keys:       keys,
+		summaries:  summaries,
+		audit:      audit,
+		discord:    discordClient,
+		sessions:   sessions{discord: discordClient, users: users, identities: identities, providers: providers},
+	}
+}
+
+// account returns the stored user the request's bearer token belongs to,
+// and the token if Discord issued it
+func (h *AccountHandler) account(c echo.Context) (*models.User, string, error) {
+	return h.sessions.user(c)
+}
+
+func (h *AccountHandler) GetAccount(c echo.Context) error {
+	user, _, err := h.account(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newAccount(user))
+}
+
+func (h *AccountHandler) PatchAccount(c echo.Context) error {
+	user, _, err := h.account(c)
+	if err != nil {
+		return err
+	}
+
+	var body PatchAccountRequest
+	if err := bind(c, &body); err != nil {
+		return err
+	}
+
+	patch := models.PreferencesPatch{
+		DefaultPrivate: body.Preferences.DefaultPrivate,
+		Timezone:       body.Preferences.Timezone,
+		Language:       body.Preferences.Language,
+	}
+	if patch.Empty() {
+		return apperror.BadRequest(apperror.CodeBadRequest, "No fields to update")
+	}
+
+	ctx := c.Request().Context()
+	if err := h.users.UpdatePreferences(ctx, user.ID, patch); err != nil {
+		return err
+	}
+	user, err = h.users.FindUserByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newAccount(user))
+}
+
+func (h *AccountHandler) ExportAccount(c echo.Context) error {
+	user, _, err := h.account(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	identities, err := h.accountIdentities(c, user)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	keys, err := h.keys.ListAPIKeys(ctx, user.UUID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	summaries, err := h.summaries.GetSummaries(ctx, user.ID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	events, err := h.accountEvents(c, user.UUID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+
+	export := AccountExport{
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Account:    newAccount(user),
+		Token: TokenInfo{
+			TokenType: user.Token.TokenType,
+			Scope:     user.Token.Scope,
+			ExpiresIn: user.Token.ExpiresIn,
+		},
+		Identities:  identities,
+		APIKeys:     make([]APIKey, 0, len(keys)),
+		Summaries:   make([]Summary, 0, len(summaries)),
+		AuditEvents: make([]AuditEvent, 0, len(events)),
+	}
+	for _, key := range keys {
+		export.APIKeys = append(export.APIKeys, newAPIKey(key))
+	}
+	for _, summary := range summaries {
+		export.Summaries = append(export.Summaries, newSummary(summary))
+	}
+	for _, event := range events {
+		export.AuditEvents = append(export.AuditEvents, newAuditEvent(event))
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="ultra-chat-export.json"`)
+	return c.JSON(http.StatusOK, export)
+}
+
+// ListIdentities lists the identities the user can log in with, Discord
+// first
+func (h *AccountHandler) ListIdentities(c echo.Context) error {
+	user, _, err := h.account(c)
+	if err != nil {
+		return err
+	}
+	identities, err := h.accountIdentities(c, user)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	return c.JSON(http.StatusOK, identities)
+}
+
+// UnlinkIdentity removes the user's identity at the provider named in the
+// path. Discord is the account itself, so it cannot be unlinked.
+func (h *AccountHandler) UnlinkIdentity(c echo.Context) error {
+	user, _, err := h.account(c)
+	if err != nil {
+		return err
+	}
+	provider := c.Param("provider")
+	if provider == identity.DiscordName {
+		return apperror.BadRequest(apperror.CodeBadRequest, "Discord cannot be unlinked, delete the account instead")
+	}
+	ctx := c.Request().Context()
+
+	if err := h.identities.UnlinkIdentity(ctx, user.UUID, provider); err != nil {
+		return err
+	}
+
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:   models.AuditUser(user.UUID),
+		Action:  models.AuditIdentityUnlinked,
+		Target:  models.AuditUser(user.UUID),
+		Details: map[string]string{"provider": provider},
+	})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListAPIKeys lists the user's API keys, newest first
+func (h *AccountHandler) ListAPIKeys(c echo.Context) error {
+	user, _, err := h.account(c)
+	if err != nil {
+		return err
+	}
+	keys, err := h.keys.ListAPIKeys(c.Request().Context(), user.UUID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	list := make([]APIKey, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, newAPIKey(key))
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// CreateAPIKey creates an API key for the user and returns it, the only
+// time it is shown. Server keys may only name servers the user manages.
+func (h *AccountHandler) CreateAPIKey(c echo.Context) error {
+	user, bearer, err := h.account(c)
+	if err != nil {
+		return err
+	}
+
+	var body CreateAPIKeyRequest
+	if err := bind(c, &body); err != nil {
+		return err
+	}
+	kind := body.Kind
+	if kind == "" {
+		kind = models.APIKeyUser
+	}
+	if kind == models.APIKeyServer {
+		if len(body.ServerIDs) == 0 {
+			return apperror.Validation(apperror.FieldError{Field: "server_ids", Code: "required", Message: "is required for server keys"})
+		}
+		if err := h.checkManaged(c, user, bearer, body.ServerIDs); err != nil {
+			return err
+		}
+	}
+
+	raw, prefix, hash, err := apikey.New()
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	key := &models.APIKey{
+		ID:        uuid.New().String(),
+		Prefix:    prefix,
+		Hash:      hash,
+		UserUUID:  user.UUID,
+		UserID:    user.ID,
+		Name:      body.Name,
+		Kind:      kind,
+		Scopes:    distinct(body.Scopes),
+		ServerIDs: distinct(body.ServerIDs),
+	}
+	ctx := c.Request().Context()
+	if err := h.keys.CreateAPIKey(ctx, key); err != nil {
+		return apperror.Internal(err)
+	}
+
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:   models.AuditUser(user.UUID),
+		Action:  models.AuditAPIKeyCreated,
+		Target:  models.AuditAPIKey(key.ID),
+		Details: map[string]string{"kind": key.Kind, "scopes": strings.Join(key.Scopes, " ")},
+	})
+	return c.JSON(http.StatusCreated, CreatedAPIKey{APIKey: newAPIKey(*key), Key: raw})
+}
+
+// RevokeAPIKey deletes the user's API key with the ID in the path
+func (h *AccountHandler) RevokeAPIKey(c echo.Context) error {
+	user, _, err := h.account(c)
+	if err != nil {
+		return err
+	}
+	var params APIKeyParams
+	if err := bind(c, &params); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	if err := h.keys.RevokeAPIKey(ctx, user.UUID, params.ID); err != nil {
+		return err
+	}
+
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:  models.AuditUser(user.UUID),
+		Action: models.AuditAPIKeyRevoked,
+		Target: models.AuditAPIKey(params.ID),
+	})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// checkManaged fails unless the user owns or may manage every one of the
+// servers, according to Discord. It asks with the request's bearer token
+// or, for a session of another provider, the stored one; either needs the
+// guilds scope.
+func (h *AccountHandler) checkManaged(c echo.Context, user *models.User, bearer string, serverIDs []string) error {
+	token := bearer
+	if token == "" {
+		token = user.Token.AccessToken
+	}
+	if token == "" {
+		return apperror.Forbidden("Log in with Discord again to create server keys")
+	}
+
+	guilds, err := h.discord.CurrentUserGuilds(c.Request().Context(), token)
+	if discord.StatusCode(err) == http.StatusForbidden {
+		return apperror.Forbidden("Creating server keys needs the guilds scope, log in with it").Wrap(err)
+	}
+	if err != nil {
+		return discordError(err, "Failed to list your Discord servers")
+	}
+	managed := make(map[string]bool, len(guilds))
+	for _, guild := range guilds {
+		managed[guild.ID] = guild.CanManage()
+	}
+	for _, id := range serverIDs {
+		if !managed[id] {
+			return apperror.Forbidden(fmt.Sprintf("You do not manage the server %s", id))
+		}
+	}
+	return nil
+}
+
+// distinct returns list sorted and without repeats
+func distinct(list []string) []string {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+	return slices.Compact(sorted)
+}
+
+// accountIdentities returns the user's Discord identity followed by the
+// linked ones
+func (h *AccountHandler) accountIdentities(c echo.Context, user *models.User) ([]Identity, error) {
+	linked, err := h.identities.ListIdentities(c.Request().Context(), user.UUID)
+	if err != nil {
+		return nil, err
+	}
+	identities := make([]Identity, 0, len(linked)+1)
+	identities = append(identities, discordIdentity(user))
+	for _, i := range linked {
+		identities = append(identities, newIdentity(i))
+	}
+	return identities, nil
+}
+
+// accountEvents returns the audit events the user with the given UUID
+// either did or was the target of, newest first
+func (h *AccountHandler) accountEvents(c echo.Context, userUUID string) ([]models.AuditEvent, error) {
+	ctx := c.Request().Context()
+	subject := models.AuditUser(userUUID)
+
+	byActor, err := h.audit.ListEvents(ctx, models.AuditFilter{Actor: subject})
+	if err != nil {
+		return nil, err
+	}
+	byTarget, err := h.audit.ListEvents(ctx, models.AuditFilter{Target: subject})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(byActor))
+	events := make([]models.AuditEvent, 0, len(byActor)+len(byTarget))
+	for _, event := range append(byActor, byTarget...) {
+		if !seen[event.ID] {
+			seen[event.ID] = true
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.After(events[j].Time)
+		}
+		return events[i].ID > events[j].ID
+	})
+	return events, nil
+}
+
+// DeleteAccount erases the user: their Discord grant and API keys are
+// revoked, their linked identities, summaries and account are deleted and
+// an audit event, which refers to them only by UUID, records that it
+// happened. The IP addresses and user agents of the events they or their
+// API keys caused are cleared; the events themselves are kept, as the
+// record of what happened to data other users may rely on. The deletion
+// event keeps the client it was requested from, as evidence of who asked
+// for the erasure should the account have been taken over. Revoking the
+// Discord grant is best effort; the data is deleted whether or not
+// Discord could be reached.
+func (h *AccountHandler) DeleteAccount(c echo.Context) error {
+	user, bearer, err := h.account(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	revokeErr := revokeGrant(c, h.discord, user.ID, user.Token, bearer)
+
+	// Cleared first, while a failure can still be retried by the user
+	keys, err := h.keys.ListAPIKeys(ctx, user.UUID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	actors := []string{models.AuditUser(user.UUID)}
+	for _, key := range keys {
+		actors = append(actors, models.AuditAPIKey(key.ID))
+	}
+	if _, err := h.audit.RedactClients(ctx, actors); err != nil {
+		return apperror.Internal(err)
+	}
+
+	revoked, err := h.keys.RevokeAPIKeys(ctx, user.UUID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	unlinked, err := h.identities.UnlinkIdentities(ctx, user.UUID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	deleted, err := h.summaries.DeleteSummaries(ctx, user.ID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	if err := h.users.DeleteUser(ctx, user.ID); err != nil {
+		return err
+	}
+
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:  models.AuditUser(user.UUID),
+		Action: models.AuditAccountDeleted,
+		Target: models.AuditUser(user.UUID),
+		Details: map[string]string{
+			"summaries_deleted":   strconv.FormatInt(deleted, 10),
+			"identities_unlinked": strconv.FormatInt(unlinked, 10),
+			"api_keys_revoked":    strconv.FormatInt(revoked, 10),
+			"discord_revoked":     strconv.FormatBool(revokeErr == nil),
+		},
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
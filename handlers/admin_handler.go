@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+// NDJSONContentType is the media type of newline-delimited JSON, one
+// value per line
+const NDJSONContentType = "application/x-ndjson"
+
+// defaultListLimit is how many audit events or users a listing returns
+// when the request sets no limit
+const defaultListLimit = 100
+
+// exportPageSize is how many audit events an export reads at a time
+const exportPageSize = 500
+
+// AdminHandler serves the operators' routes. Only users whose Discord ID
+// is one of the configured admin IDs may call them, which RequireAdmin
+// enforces for the whole route group.
+type AdminHandler struct {
+	users       repositories.UserRepository
+	summaries   repositories.SummaryRepository
+	keys        repositories.APIKeyRepository
+	audit       repositories.AuditRepository
+	maintenance repositories.Maintenance
+	discord     *discord.Client
+	admins      map[string]bool
+	sessions    sessions
+}
+
+func NewAdminHandler(users repositories.UserRepository, summaries repositories.SummaryRepository, identities repositories.IdentityRepository, keys repositories.APIKeyRepository, audit repositories.AuditRepository, maintenance repositories.Maintenance, discordClient *discord.Client, providers *identity.Registry, adminIDs []string) *AdminHandler {
+	admins := make(map[string]bool, len(adminIDs))
+	for _, id := range adminIDs {
+		admins[id] = true
+	}
+	return &AdminHandler{
+		users:       users,
+		summaries:   summaries,
+		keys:        keys,
+		audit:       audit,
+		maintenance: maintenance,
+		discord:     discordClient,
+		admins:      admins,
+		sessions:    sessions{discord: discordClient, users: users, identities: identities, providers: providers},
+	}
+}
+
+// adminKey is the echo context key holding the admin RequireAdmin let
+// through
+const adminKey = "admin"
+
+// RequireAdmin is middleware that lets through only requests whose bearer
+// token belongs to an admin. Every admin route is mounted behind it.
+func (h *AdminHandler) RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, _, err := h.sessions.user(c)
+		if err != nil {
+			return err
+		}
+		if !h.admins[user.ID] {
+			return apperror.Forbidden("Admin access required")
+		}
+		c.Set(adminKey, user)
+		return next(c)
+	}
+}
+
+// currentAdmin returns the admin RequireAdmin let through. It panics on a
+// route mounted without RequireAdmin, so such a route fails closed.
+func currentAdmin(c echo.Context) *models.User {
+	return c.Get(adminKey).(*models.User)
+}
+
+// ListAuditEvents returns the audit events matching the query, newest
+// first
+func (h *AdminHandler) ListAuditEvents(c echo.Context) error {
+	var query AuditQuery
+	if err := bind(c, &query); err != nil {
+		return err
+	}
+	filter := query.filter()
+	if filter.Limit == 0 {
+		filter.Limit = defaultListLimit
+	}
+
+	events, err := h.audit.ListEvents(c.Request().Context(), filter)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	list := make([]AuditEvent, 0, len(events))
+	for _, event := range events {
+		list = append(list, newAuditEvent(event))
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// ExportAuditEvents downloads the audit events matching the query as
+// NDJSON, newest first and by default all of them. The events are read a
+// page at a time, so the log is never held in memory at once. The export
+// itself is recorded in the audit log.
+func (h *AdminHandler) ExportAuditEvents(c echo.Context) error {
+	user := currentAdmin(c)
+	var query AuditQuery
+	if err := bind(c, &query); err != nil {
+		return err
+	}
+	filter := query.filter()
+	// Events recorded while the export runs, its own among them, are left
+	// out so that the count matches what is written
+	if now := time.Now(); filter.Until.IsZero() || filter.Until.After(now) {
+		filter.Until = now
+	}
+
+	ctx := c.Request().Context()
+	count, err := h.audit.CountEvents(ctx, filter)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	if filter.Limit > 0 && count > int64(filter.Limit) {
+		count = int64(filter.Limit)
+	}
+	err = recordEvent(c, h.audit, models.AuditEvent{
+		Actor:   models.AuditUser(user.UUID),
+		Action:  models.AuditLogExported,
+		Target:  models.AuditLog,
+		Details: query.details(int(count)),
+	})
+	if err != nil {
+		return err
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, NDJSONContentType)
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="ultra-chat-audit.ndjson"`)
+	res.WriteHeader(http.StatusOK)
+	// Encode adds the newline that ends each line
+	encoder := json.NewEncoder(res)
+	// The status is sent, so from here on the client can only see a cut
+	// short download
+	for remaining := count; remaining > 0; {
+		page := filter
+		page.Limit = exportPageSize
+		if remaining < exportPageSize {
+			page.Limit = int(remaining)
+		}
+		events, err := h.audit.ListEvents(ctx, page)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := encoder.Encode(newAuditEvent(event)); err != nil {
+				return err
+			}
+		}
+		res.Flush()
+		if len(events) < page.Limit {
+			return nil
+		}
+		remaining -= int64(len(events))
+		filter.After = models.CursorOf(events[len(events)-1])
+	}
+	return nil
+}
+
+// SearchUsers returns the users whose Discord ID or UUID is the query or
+// whose username contains it, ordered by username
+func (h *AdminHandler) SearchUsers(c echo.Context) error {
+	var query UserQuery
+	if err := bind(c, &query); err != nil {
+		return err
+	}
+	limit := query.Limit
+	if limit == 0 {
+		limit = defaultListLimit
+	}
+
+	users, err := h.users.SearchUsers(c.Request().Context(), query.Query, limit)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	list := make([]AdminUser, 0, len(users))
+	for i := range users {
+		list = append(list, newAdminUser(&users[i]))
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// GetUserSummaries returns every summary of the user, private ones
+// included. Summaries left behind by a user who no longer exists are
+// listed too.
+func (h *AdminHandler) GetUserSummaries(c echo.Context) error {
+	var params AdminUserParams
+	if err := bind(c, &params); err != nil {
+		return err
+	}
+
+	summaries, err := h.summaries.GetSummaries(c.Request().Context(), params.UserID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	list := make([]Summary, 0, len(summaries))
+	for _, summary := range summaries {
+		list = append(list, newSummary(summary))
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// DisableUser stops the user from logging in and from using the API,
+// API keys they created included, until EnableUser. Admins cannot be
+// disabled.
+func (h *AdminHandler) DisableUser(c echo.Context) error {
+	return h.setDisabled(c, true)
+}
+
+// EnableUser lifts DisableUser
+func (h *AdminHandler) EnableUser(c echo.Context) error {
+	return h.setDisabled(c, false)
+}
+
+func (h *AdminHandler) setDisabled(c echo.Context, disabled bool) error {
+	admin := currentAdmin(c)
+	var params AdminUserParams
+	if err := bind(c, &params); err != nil {
+		return err
+	}
+	if disabled && h.admins[params.UserID] {
+		return apperror.BadRequest(apperror.CodeBadRequest, "Admins cannot be disabled")
+	}
+	ctx := c.Request().Context()
+
+	user, err := h.users.FindUserByID(ctx, params.UserID)
+	if err != nil {
+		return err
+	}
+
+	action := models.AuditUserEnabled
+	if disabled {
+		action = models.AuditUserDisabled
+	}
+	err = recordEvent(c, h.audit, models.AuditEvent{
+		Actor:  models.AuditUser(admin.UUID),
+		Action: action,
+		Target: models.AuditUser(user.UUID),
+	})
+	if err != nil {
+		return err
+	}
+	if err := h.users.SetUserDisabled(ctx, user.ID, disabled); err != nil {
+		return err
+	}
+	user.Disabled = disabled
+	return c.JSON(http.StatusOK, newAdminUser(user))
+}
+
+// RevokeTokens revokes every API key of the user and the Discord grant
+// stored for them, which logs them out everywhere. The stored grant is
+// only cleared once Discord has revoked it, so a failure can be retried.
+func (h *AdminHandler) RevokeTokens(c echo.Context) error {
+	admin := currentAdmin(c)
+	var params AdminUserParams
+	if err := bind(c, &params); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	user, err := h.users.FindUserByID(ctx, params.UserID)
+	if err != nil {
+		return err
+	}
+	keys, err := h.keys.RevokeAPIKeys(ctx, user.UUID)
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	result := RevokedTokens{APIKeysRevoked: keys}
+
+	stored := user.Token
+	var discordErr error
+	if stored.AccessToken != "" || stored.RefreshToken != "" {
+		discordErr = revokeGrant(c, h.discord, user.ID, stored, "")
+		if discordErr == nil {
+			user.Token = models.Token{}
+			if err := h.users.UpdateUser(ctx, user); err != nil {
+				return apperror.Internal(err)
+			}
+			result.DiscordRevoked = true
+		}
+	}
+
+	// The API keys are gone even if Discord failed, so that is recorded
+	// either way
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:  models.AuditUser(admin.UUID),
+		Action: models.AuditTokensRevoked,
+		Target: models.AuditUser(user.UUID),
+		Details: map[string]string{
+			"api_keys": strconv.FormatInt(keys, 10),
+			"discord":  strconv.FormatBool(result.DiscordRevoked),
+		},
+	})
+	if discordErr != nil {
+		return discordError(discordErr, "Failed to revoke the Discord authorization")
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// ServerStats sums up the summaries of every server that has any, ordered
+// by server ID
+func (h *AdminHandler) ServerStats(c echo.Context) error {
+
+	stats, err := h.summaries.ServerStats(c.Request().Context())
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	list := make([]ServerStats, 0, len(stats))
+	for _, s := range stats {
+		list = append(list, newServerStats(s))
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// RunMaintenance runs a maintenance task to completion: reindex rebuilds
+// the indexes, purge-orphans deletes the records of users who no longer
+// exist
+func (h *AdminHandler) RunMaintenance(c echo.Context) error {
+	admin := currentAdmin(c)
+	var params MaintenanceParams
+	if err := bind(c, &params); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	result := MaintenanceResult{Task: params.Task}
+	var details map[string]string
+	var err error
+	switch params.Task {
+	case "reindex":
+		err = h.maintenance.Reindex(ctx)
+	case "purge-orphans":
+		var orphans models.Orphans
+		orphans, err = h.maintenance.PurgeOrphans(ctx)
+		result.Purged = &orphans
+		details = map[string]string{
+			"summaries":  strconv.FormatInt(orphans.Summaries, 10),
+			"identities": strconv.FormatInt(orphans.Identities, 10),
+			"api_keys":   strconv.FormatInt(orphans.APIKeys, 10),
+		}
+	}
+	if err != nil {
+		return apperror.Internal(err)
+	}
+
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:   models.AuditUser(admin.UUID),
+		Action:  models.AuditMaintenanceRun,
+		Target:  models.AuditMaintenance(params.Task),
+		Details: details,
+	})
+	return c.JSON(http.StatusOK, result)
+}
+
+// filter returns the audit filter the query selects
+func (q AuditQuery) filter() models.AuditFilter {
+	return models.AuditFilter{
+		Actor:  q.Actor,
+		Target: q.Target,
+		Action: q.Action,
+		Since:  q.Since,
+		Until:  q.Until,
+		Limit:  q.Limit,
+	}
+}
+
+// details describes the query and how many events it matched, for the
+// audit event of an export
+func (q AuditQuery) details(count int) map[string]string {
+	details := map[string]string{"events": strconv.Itoa(count)}
+	for key, value := range map[string]string{"actor": q.Actor, "target": q.Target, "action": q.Action} {
+		if value != "" {
+			details[key] = value
+		}
+	}
+	if !q.Since.IsZero() {
+		details["since"] = q.Since.UTC().Format(time.RFC3339)
+	}
+	if !q.Until.IsZero() {
+		details["until"] = q.Until.UTC().Format(time.RFC3339)
+	}
+	if q.Limit > 0 {
+		details["limit"] = strconv.Itoa(q.Limit)
+	}
+	return details
+}
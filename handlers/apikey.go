@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"ultra-chat-backend/apikey"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/logging"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+// Bots and services call the summary API with an API key in the
+// APIKeyHeader. A user key acts as the user who created it, in every
+// server unless it was limited to some. A server key acts as whichever
+// user the request names, but only in the servers it was created for,
+// all of which its creator manages. Either kind only does what its scopes
+// allow. Requests without a key act for the user whose bearer token they
+// carry, unless keys are required; see SummaryHandler.acting.
+
+// APIKeyHeader carries the API key of a bot or service
+const APIKeyHeader = "X-API-Key"
+
+// touchInterval is how stale a key's last use may get before a request
+// records it again, so busy keys do not write on every request
+const touchInterval = time.Minute
+
+// apiKeys checks the API keys requests carry
+type apiKeys struct {
+	repo repositories.APIKeyRepository
+	// required rejects requests without a key
+	required bool
+}
+
+// verify returns the key the request carries, or nil if it carries none
+// and keys are optional. The key must grant scope.
+func (k apiKeys) verify(c echo.Context, scope string) (*models.APIKey, error) {
+	raw := c.Request().Header.Get(APIKeyHeader)
+	if raw == "" {
+		if k.required {
+			return nil, apperror.Unauthorized("Missing API key")
+		}
+		return nil, nil
+	}
+	ctx := c.Request().Context()
+
+	prefix, ok := apikey.Prefix(raw)
+	if !ok {
+		return nil, apperror.Unauthorized("Invalid API key")
+	}
+	key, err := k.repo.FindAPIKeyByPrefix(ctx, prefix)
+	if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		return nil, apperror.Unauthorized("Invalid API key").Wrap(err)
+	}
+	if err != nil {
+		return nil, apperror.Internal(err)
+	}
+	if !apikey.Verify(raw, key.Hash) {
+		return nil, apperror.Unauthorized("Invalid API key")
+	}
+	if !key.HasScope(scope) {
+		return nil, apperror.Forbidden("The API key lacks the " + scope + " scope")
+	}
+
+	if now := time.Now().UTC(); now.Sub(key.LastUsedAt) >= touchInterval {
+		if err := k.repo.TouchAPIKey(ctx, key.ID, now); err != nil {
+			logging.FromContext(ctx).Warn("recording an API key use failed", "key_id", key.ID, "error", err)
+		}
+	}
+	return key, nil
+}
+
+// actingUser returns the Discord ID of the user a request acts for: the
+// one it names or, when a user key names nobody, the key's creator. A user
+// key cannot act for anyone else.
+func actingUser(key *models.APIKey, named string) (string, error) {
+	if key == nil || key.Kind != models.APIKeyUser {
+		return named, nil
+	}
+	if named == "" {
+		return key.UserID, nil
+	}
+	if named != key.UserID {
+		return "", apperror.Forbidden("The API key cannot act for another user")
+	}
+	return named, nil
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/logging"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+// maxUserAgent caps the user agent stored with an audit event, since
+// clients can send anything
+const maxUserAgent = 512
+
+// recordEvent appends event to the audit log, stamped with the IP address
+// and user agent of the request's client. The IP address is Echo's
+// RealIP, which goes through the server's IP extractor: the connecting
+// address, or the client a trusted proxy forwarded for in X-Forwarded-For.
+// The user agent is whatever the client sent. Event IDs are version 7
+// UUIDs, which grow with time, so events recorded within the same
+// millisecond still list in the order they happened.
+//
+// Handlers record a change before making it where the event can be told
+// in advance, so a change is never made without its event: if the event
+// cannot be recorded, the request fails and nothing changes. Should the
+// change fail after all, the event stays, and the next event on the
+// target shows by its before hash that nothing changed. Changes whose
+// event depends on their outcome are recorded afterwards with
+// recordCompleted.
+func recordEvent(c echo.Context, audit repositories.AuditRepository, event models.AuditEvent) error {
+	event.ID = uuid.Must(uuid.NewV7()).String()
+	event.IP = c.RealIP()
+	event.UserAgent = c.Request().UserAgent()
+	if len(event.UserAgent) > maxUserAgent {
+		event.UserAgent = strings.ToValidUTF8(event.UserAgent[:maxUserAgent], "")
+	}
+	if err := audit.RecordEvent(c.Request().Context(), &event); err != nil {
+		return apperror.Internal(err)
+	}
+	return nil
+}
+
+// recordCompleted records the event of a change that has been made. The
+// change stands whether or not the event is recorded, so a failure is
+// logged with the event instead of being reported to the client as if the
+// change had failed.
+func recordCompleted(c echo.Context, audit repositories.AuditRepository, event models.AuditEvent) {
+	if err := recordEvent(c, audit, event); err != nil {
+		logging.FromContext(c.Request().Context()).Error("recording an audit event failed",
+			"action", event.Action, "actor", event.Actor, "target", event.Target, "details", event.Details, "error", err)
+	}
+}
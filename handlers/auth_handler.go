@@ -1,96 +1,296 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"go.mongodb.org/mongo-driver/bson"
 	"net/http"
-	"os"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/identity"
 	"ultra-chat-backend/models"
 	"ultra-chat-backend/repositories"
-	"ultra-chat-backend/utils"
 )
 
 type AuthHandler struct {
-	repo repositories.UserRepository
+	repo       repositories.UserRepository
+	identities repositories.IdentityRepository
+	audit      repositories.AuditRepository
+	discord    *discord.Client
+	sessions   sessions
 }
 
-func NewAuthHandler(repo repositories.UserRepository) *AuthHandler {
-	return &AuthHandler{repo: repo}
+// NewAuthHandler serves logins through Discord and the other providers.
+// Discord must be one of providers.
+func NewAuthHandler(repo repositories.UserRepository, identities repositories.IdentityRepository, audit repositories.AuditRepository, discordClient *discord.Client, providers *identity.Registry) *AuthHandler {
+	return &AuthHandler{
+		repo:       repo,
+		identities: identities,
+		audit:      audit,
+		discord:    discordClient,
+		sessions:   sessions{discord: discordClient, users: repo, identities: identities, providers: providers},
+	}
 }
 
+// Login starts a Discord login: the client gets the authorize URL and a
+// cookie the callback checks the returned state against
 func (h *AuthHandler) Login(c echo.Context) error {
-	clientID := os.Getenv("CLIENT_ID")
-	redirectURI := os.Getenv("REDIRECT_URI")
-	scope := os.Getenv("SCOPE")
+	login, err := startLogin(c, identity.DiscordName)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, LoginResponse{URL: h.discord.AuthorizeURL(login.State, login.Challenge())})
+}
 
-	url := "https://discord.com/api/oauth2/authorize?client_id=" + clientID + "&redirect_uri=" + redirectURI + "&response_type=code&scope=" + scope
-	return c.JSON(http.StatusOK, map[string]string{
-		"url": url,
-	})
+// ProviderLogin returns the authorize URL of the provider named in the path
+func (h *AuthHandler) ProviderLogin(c echo.Context) error {
+	p, err := h.sessions.provider(c.Param("provider"))
+	if err != nil {
+		return err
+	}
+	if p.Name() == identity.DiscordName {
+		return h.Login(c)
+	}
+
+	login, err := startLogin(c, p.Name())
+	if err != nil {
+		return err
+	}
+	url, err := p.AuthorizeURL(c.Request().Context(), login)
+	if err != nil {
+		return providerError(p, err, "Failed to build the authorize URL")
+	}
+	return c.JSON(http.StatusOK, LoginResponse{URL: url})
 }
 
-func (h *AuthHandler) Callback(c echo.Context) error {
+// ProviderCallback finishes a login with the provider named in the path,
+// which must have been started from the same client. Discord logins create
+// or update the account as Callback does. With any other provider, a
+// caller that already has a session links the identity to their account;
+// anyone else logs in with an identity linked before and gets the
+// provider's token back as their session.
+func (h *AuthHandler) ProviderCallback(c echo.Context) error {
+	p, err := h.sessions.provider(c.Param("provider"))
+	if err != nil {
+		return err
+	}
+	if p.Name() == identity.DiscordName {
+		return h.Callback(c)
+	}
+
 	code := c.QueryParam("code")
 	if code == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No code provided"})
+		return apperror.BadRequest(apperror.CodeBadRequest, "No code provided")
 	}
+	login, err := finishLogin(c, p.Name())
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
 
-	tokens, err := utils.ExchangeCodeForTokens(code)
+	token, err := p.Exchange(ctx, code, login)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		if identity.StatusCode(err) == http.StatusBadRequest {
+			return apperror.BadRequest(apperror.CodeBadRequest, "Invalid or expired authorization code").Wrap(err)
+		}
+		return providerError(p, err, "Failed to exchange authorization code")
+	}
+	if token.AccessToken == "" {
+		return apperror.Upstream("The provider returned no access token", nil)
+	}
+
+	userInfo, err := p.UserInfo(ctx, token.AccessToken)
+	if err != nil {
+		return providerError(p, err, "Failed to fetch the provider's user")
+	}
+	if userInfo.Subject == "" {
+		return apperror.Upstream("The provider returned no subject", nil)
+	}
+	// The profile must belong to the user the ID token vouched for
+	if token.Subject != "" && userInfo.Subject != token.Subject {
+		return apperror.Upstream("The provider's user does not match its ID token", nil)
+	}
+
+	if c.Request().Header.Get(echo.HeaderAuthorization) != "" {
+		return h.link(c, p, token, userInfo)
+	}
+
+	linked, err := h.identities.FindIdentity(ctx, p.Name(), userInfo.Subject)
+	if err != nil && !errors.Is(err, repositories.ErrIdentityNotFound) {
+		return apperror.Internal(err)
+	}
+	var user *models.User
+	if err == nil {
+		user, err = h.repo.FindUserByUUID(ctx, linked.UserUUID)
+		if err != nil && !errors.Is(err, repositories.ErrUserNotFound) {
+			return apperror.Internal(err)
+		}
+	}
+	// An identity left behind by a removed account is as good as unlinked
+	if user == nil {
+		return apperror.NotFound(apperror.CodeIdentityNotFound, "The identity is not linked to an account, log in with Discord and link it first").Wrap(err)
+	}
+	if err := enabled(user); err != nil {
+		return err
+	}
+	updateIdentity(linked, token, userInfo)
+	if err := h.identities.UpdateIdentity(ctx, linked); err != nil {
+		return err
+	}
+
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:   models.AuditUser(linked.UserUUID),
+		Action:  models.AuditLogin,
+		Target:  models.AuditUser(linked.UserUUID),
+		Details: map[string]string{"provider": p.Name()},
+	})
+	return c.JSON(http.StatusOK, ProviderSession{
+		Provider:    p.Name(),
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		ExpiresIn:   token.ExpiresIn,
+		UserUUID:    linked.UserUUID,
+	})
+}
+
+// link attaches the identity a provider login returned to the caller's
+// account, or refreshes it if it is already theirs
+func (h *AuthHandler) link(c echo.Context, p identity.Provider, token *identity.Token, userInfo *identity.UserInfo) error {
+	user, _, err := h.sessions.user(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	linked, err := h.identities.FindIdentity(ctx, p.Name(), userInfo.Subject)
+	switch {
+	case err == nil && linked.UserUUID != user.UUID:
+		return apperror.Conflict(apperror.CodeIdentityLinked, "The identity is linked to another account")
+	case err == nil:
+		updateIdentity(linked, token, userInfo)
+		if err := h.identities.UpdateIdentity(ctx, linked); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, newIdentity(*linked))
+	case !errors.Is(err, repositories.ErrIdentityNotFound):
+		return apperror.Internal(err)
+	}
+
+	linked = &models.Identity{
+		ID:       uuid.New().String(),
+		Provider: p.Name(),
+		Subject:  userInfo.Subject,
+		UserUUID: user.UUID,
 	}
+	updateIdentity(linked, token, userInfo)
+	if err := h.identities.LinkIdentity(ctx, linked); err != nil {
+		return err
+	}
+
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:   models.AuditUser(user.UUID),
+		Action:  models.AuditIdentityLinked,
+		Target:  models.AuditUser(user.UUID),
+		Details: map[string]string{"provider": p.Name()},
+	})
+	return c.JSON(http.StatusCreated, newIdentity(*linked))
+}
 
-	accessToken, ok := tokens["access_token"].(string)
-	if !ok || accessToken == "" {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Invalid access token"})
+// updateIdentity copies what a provider login returned onto a stored identity
+func updateIdentity(linked *models.Identity, token *identity.Token, userInfo *identity.UserInfo) {
+	linked.Token = models.Token{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		ExpiresIn:    token.ExpiresIn,
+		RefreshToken: token.RefreshToken,
+		Scope:        token.Scope,
 	}
+	linked.Username = userInfo.Username
+	linked.Email = userInfo.Email
+}
 
-	userInfo, err := utils.FetchUserInfo(accessToken)
+// Callback finishes a Discord login started from the same client and
+// creates or updates the account
+func (h *AuthHandler) Callback(c echo.Context) error {
+	code := c.QueryParam("code")
+	if code == "" {
+		return apperror.BadRequest(apperror.CodeBadRequest, "No code provided")
+	}
+	login, err := finishLogin(c, identity.DiscordName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return err
 	}
 
-	userID, ok := userInfo["id"].(string)
-	if !ok || userID == "" {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Invalid user ID"})
+	ctx := c.Request().Context()
+
+	token, err := h.discord.ExchangeCode(ctx, code, login.Verifier)
+	if err != nil {
+		if discord.StatusCode(err) == http.StatusBadRequest {
+			return apperror.BadRequest(apperror.CodeBadRequest, "Invalid or expired authorization code").Wrap(err)
+		}
+		return discordError(err, "Failed to exchange authorization code")
+	}
+	if token.AccessToken == "" {
+		return apperror.Upstream("Discord returned no access token", nil)
+	}
+
+	userInfo, err := h.discord.CurrentUser(ctx, token.AccessToken)
+	if err != nil {
+		return discordError(err, "Failed to fetch Discord user")
+	}
+	if userInfo.ID == "" {
+		return apperror.Upstream("Discord returned no user ID", nil)
 	}
 
 	userUUID := uuid.New().String()
-	existingUser, _ := h.repo.FindUserByID(userID)
+	existingUser, err := h.repo.FindUserByID(ctx, userInfo.ID)
+	if err != nil && !errors.Is(err, repositories.ErrUserNotFound) {
+		return apperror.Internal(err)
+	}
 
+	details := map[string]string{"provider": identity.DiscordName}
+	if existingUser != nil && existingUser.Disabled {
+		// The new grant is not stored, so it is revoked rather than left
+		// working at Discord
+		_ = revokeGrant(c, h.discord, userInfo.ID, storedToken(token), "")
+		return enabled(existingUser)
+	}
 	if existingUser != nil {
-		update := bson.M{
-			"token":         tokens,
-			"username":      userInfo["username"],
-			"discriminator": userInfo["discriminator"],
-		}
-		if err := h.repo.UpdateUser(userID, update); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		userUUID = existingUser.UUID
+		existingUser.Token = storedToken(token)
+		existingUser.Username = userInfo.Username
+		existingUser.Discriminator = userInfo.Discriminator
+		if err := h.repo.UpdateUser(ctx, existingUser); err != nil {
+			return apperror.Internal(err)
 		}
 	} else {
 		newUser := &models.User{
-			ID:            userID,
+			ID:            userInfo.ID,
 			UUID:          userUUID,
-			Token:         tokens,
-			Username:      userInfo["username"].(string),
-			Discriminator: userInfo["discriminator"].(string),
+			Token:         storedToken(token),
+			Username:      userInfo.Username,
+			Discriminator: userInfo.Discriminator,
 		}
-		if err := h.repo.CreateUser(newUser); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		if err := h.repo.CreateUser(ctx, newUser); err != nil {
+			return apperror.Internal(err)
 		}
+		details["account_created"] = "true"
 	}
 
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:   models.AuditUser(userUUID),
+		Action:  models.AuditLogin,
+		Target:  models.AuditUser(userUUID),
+		Details: details,
+	})
 	return c.JSON(http.StatusOK, "Successfully Authenticated")
 }
 
 func (h *AuthHandler) Profile(c echo.Context) error {
 	// Get the token from the Authorization header
 	token := c.Request().Header.Get("Authorization")
-	fmt.Println("token", token)
 	if token == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+		return apperror.Unauthorized("Missing token")
 	}
 
 	// Remove "Bearer " prefix if present
@@ -99,11 +299,69 @@ func (h *AuthHandler) Profile(c echo.Context) error {
 	}
 
 	// Fetch user information using the token
-	userInfo, err := utils.FetchUserInfo(token)
+	userInfo, err := currentUser(c, h.discord, h.repo, token, "Invalid or expired token")
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return err
+	}
+	if err := checkEnabled(c, h.repo, userInfo.ID); err != nil {
+		return err
 	}
 
 	// Respond with the user information
-	return c.JSON(http.StatusOK, userInfo)
+	return c.JSON(http.StatusOK, newDiscordUser(userInfo))
+}
+
+// Logout ends the caller's session: the Discord grant behind the bearer
+// token is revoked and the stored token cleared, which also unlinks
+// Discord until the next login. The account and its summaries are kept.
+func (h *AuthHandler) Logout(c echo.Context) error {
+	token, err := bearerToken(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+
+	userInfo, err := currentUser(c, h.discord, h.repo, token, "Invalid or expired token")
+	if err != nil {
+		return err
+	}
+
+	user, err := h.repo.FindUserByID(ctx, userInfo.ID)
+	if errors.Is(err, repositories.ErrUserNotFound) {
+		// Nothing is stored, so only the bearer token is left to revoke
+		if err := revokeGrant(c, h.discord, userInfo.ID, models.Token{}, token); err != nil {
+			return discordError(err, "Failed to revoke the Discord authorization")
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+	if err != nil {
+		return apperror.Internal(err)
+	}
+
+	// The stored token is only cleared once Discord has revoked it, so a
+	// failed logout can be retried
+	if err := revokeGrant(c, h.discord, user.ID, user.Token, token); err != nil {
+		return discordError(err, "Failed to revoke the Discord authorization")
+	}
+	user.Token = models.Token{}
+	if err := h.repo.UpdateUser(ctx, user); err != nil {
+		return apperror.Internal(err)
+	}
+
+	recordCompleted(c, h.audit, models.AuditEvent{
+		Actor:  models.AuditUser(user.UUID),
+		Action: models.AuditLogout,
+		Target: models.AuditUser(user.UUID),
+	})
+	return c.NoContent(http.StatusNoContent)
+}
+
+func storedToken(token *discord.Token) models.Token {
+	return models.Token{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		ExpiresIn:    token.ExpiresIn,
+		RefreshToken: token.RefreshToken,
+		Scope:        token.Scope,
+	}
 }
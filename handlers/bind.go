@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"strings"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+// bind decodes the request into body and validates it. Both steps already
+// return apperror values, so callers can return the error unchanged.
+func bind(c echo.Context, body interface{}) error {
+	if err := c.Bind(body); err != nil {
+		return err
+	}
+	return c.Validate(body)
+}
+
+// headerUserID returns the Discord ID the caller passed in the ID header
+func headerUserID(c echo.Context) (string, error) {
+	userID := c.Request().Header.Get("ID")
+	if userID == "" {
+		return "", apperror.Unauthorized("Missing ID header")
+	}
+	if !validation.IsSnowflake(userID) {
+		return "", apperror.Validation(apperror.FieldError{
+			Field:   "ID",
+			Code:    "snowflake",
+			Message: "must be a Discord ID",
+		})
+	}
+	return userID, nil
+}
+
+// bearerToken returns the access token from the Authorization header
+func bearerToken(c echo.Context) (string, error) {
+	header := c.Request().Header.Get("Authorization")
+	if header == "" {
+		return "", apperror.Unauthorized("Missing token")
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return "", apperror.Unauthorized("Invalid token format")
+	}
+	return token, nil
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"github.com/labstack/echo/v4"
+	"ultra-chat-backend/apikey"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+// CallerKey is the echo context key holding who a request comes from, as
+// an audit actor such as user:<uuid> or api_key:<id>, once Identify has
+// recognised its credentials
+const CallerKey = "caller"
+
+// Identify returns middleware that recognises the caller of a request
+// before the rate limiter counts it. An API key is recognised when it
+// verifies against its stored hash, and a Discord bearer token when it is
+// the token stored for a user. Nothing else is trusted, and Discord is
+// never asked, so unrecognised credentials cost one indexed lookup and
+// leave the request to be counted by IP. Handlers still authenticate and
+// authorize the request themselves.
+func Identify(users repositories.UserRepository, keys repositories.APIKeyRepository) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if caller := identify(c, users, keys); caller != "" {
+				c.Set(CallerKey, caller)
+			}
+			return next(c)
+		}
+	}
+}
+
+// identify returns the caller the request's credentials belong to, or ""
+func identify(c echo.Context, users repositories.UserRepository, keys repositories.APIKeyRepository) string {
+	ctx := c.Request().Context()
+
+	if raw := c.Request().Header.Get(APIKeyHeader); raw != "" {
+		prefix, ok := apikey.Prefix(raw)
+		if !ok {
+			return ""
+		}
+		key, err := keys.FindAPIKeyByPrefix(ctx, prefix)
+		if err != nil || !apikey.Verify(raw, key.Hash) {
+			return ""
+		}
+		return models.AuditAPIKey(key.ID)
+	}
+
+	// Tokens of linked providers can only be resolved by asking the
+	// provider, so they are counted by IP
+	if name := c.Request().Header.Get(AuthProviderHeader); name != "" && name != identity.DiscordName {
+		return ""
+	}
+	token, err := bearerToken(c)
+	if err != nil {
+		return ""
+	}
+	user, err := users.FindUserByAccessToken(ctx, token)
+	if err != nil {
+		return ""
+	}
+	return models.AuditUser(user.UUID)
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/logging"
+	"ultra-chat-backend/repositories"
+
+	"github.com/labstack/echo/v4"
+)
+
+// statusCodes maps statuses produced by Echo itself (routing, body limits,
+// middleware) to error codes
+var statusCodes = map[int]apperror.Code{
+	http.StatusBadRequest:            apperror.CodeBadRequest,
+	http.StatusUnauthorized:          apperror.CodeUnauthorized,
+	http.StatusForbidden:             apperror.CodeForbidden,
+	http.StatusNotFound:              apperror.CodeNotFound,
+	http.StatusMethodNotAllowed:      apperror.CodeMethodNotAllowed,
+	http.StatusRequestEntityTooLarge: apperror.CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  apperror.CodeInvalidBody,
+	http.StatusTooManyRequests:       apperror.CodeRateLimited,
+	http.StatusServiceUnavailable:    apperror.CodeUnavailable,
+}
+
+// HTTPErrorHandler renders every error returned by a handler or middleware
+// as an RFC 7807 problem document. Server-side failures are logged with their
+// cause; clients only ever see the code and a generic message.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	logger := logging.FromContext(c.Request().Context())
+
+	appErr := toAppError(err)
+	if appErr.Status >= http.StatusInternalServerError {
+		logger.Error("request failed", "method", c.Request().Method, "path", c.Request().URL.Path, "code", appErr.Code, "error", err)
+	}
+
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+	problem := appErr.Problem(c.Request().URL.Path, requestID)
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(appErr.Status)
+	} else {
+		var body []byte
+		if body, err = json.Marshal(problem); err == nil {
+			err = c.Blob(appErr.Status, apperror.ContentType, body)
+		}
+	}
+	if err != nil {
+		logger.Error("failed to write error response", "error", err)
+	}
+}
+
+// toAppError maps domain and framework errors to their client-facing form
+func toAppError(err error) *apperror.Error {
+	var appErr *apperror.Error
+	if errors.As(err, &appErr) && appErr.Status < http.StatusInternalServerError {
+		return appErr
+	}
+
+	// A server-side failure caused by a deadline or a disconnected client is
+	// reported as such, whatever the handler wrapped it in
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return apperror.New(http.StatusGatewayTimeout, apperror.CodeTimeout, "The request timed out, retry later").Wrap(err)
+	case errors.Is(err, context.Canceled):
+		return apperror.New(apperror.StatusClientClosedRequest, apperror.CodeCanceled, "The request was canceled").Wrap(err)
+	}
+	if appErr != nil {
+		return appErr
+	}
+
+	switch {
+	case errors.Is(err, repositories.ErrSummaryNotFound):
+		return apperror.NotFound(apperror.CodeSummaryNotFound, "Summary not found").Wrap(err)
+	case errors.Is(err, repositories.ErrUserNotFound):
+		return apperror.NotFound(apperror.CodeUserNotFound, "User not found").Wrap(err)
+	case errors.Is(err, repositories.ErrIdentityNotFound):
+		return apperror.NotFound(apperror.CodeIdentityNotFound, "Identity not found").Wrap(err)
+	case errors.Is(err, repositories.ErrAPIKeyNotFound):
+		return apperror.NotFound(apperror.CodeAPIKeyNotFound, "API key not found").Wrap(err)
+	case errors.Is(err, repositories.ErrIdentityLinked):
+		return apperror.Conflict(apperror.CodeIdentityLinked, "The identity is linked to another account, or the account already has one at this provider").Wrap(err)
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		code, ok := statusCodes[httpErr.Code]
+		if !ok {
+			if httpErr.Code < http.StatusInternalServerError {
+				code = apperror.CodeBadRequest
+			} else {
+				code = apperror.CodeInternal
+			}
+		}
+		// Echo messages can embed decoder internals, so only the status text is exposed
+		return apperror.New(httpErr.Code, code, apperror.StatusText(httpErr.Code)).Wrap(err)
+	}
+
+	return apperror.Internal(err)
+}
+
+// discordError maps a failed Discord call. A 401 from Discord means the
+// token the client gave us is no good; a 429 that outlasted the client's
+// retries means we are temporarily unable to serve the request.
+func discordError(err error, message string) *apperror.Error {
+	switch {
+	case discord.IsUnauthorized(err):
+		return apperror.Unauthorized(message).Wrap(err)
+	case discord.IsRateLimited(err):
+		return apperror.New(http.StatusServiceUnavailable, apperror.CodeUnavailable, "Discord is rate limiting requests, retry later").Wrap(err)
+	}
+	return apperror.Upstream("Discord request failed", err)
+}
+
+// providerError maps a failed call to an identity provider other than
+// Discord the way discordError maps Discord's
+func providerError(p identity.Provider, err error, message string) *apperror.Error {
+	if identity.IsUnauthorized(err) {
+		return apperror.Unauthorized(message).Wrap(err)
+	}
+	return apperror.Upstream(fmt.Sprintf("%s request failed", p.Name()), err)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/repositories"
+
+	"github.com/labstack/echo/v4"
+)
+
+func serveError(t *testing.T, err error) (*httptest.ResponseRecorder, apperror.Problem) {
+	t.Helper()
+
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.GET("/fail", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderXRequestID, "req-123")
+		return err
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	var problem apperror.Problem
+	if decodeErr := json.Unmarshal(rec.Body.Bytes(), &problem); decodeErr != nil {
+		t.Fatalf("response is not a problem document: %v: %s", decodeErr, rec.Body.String())
+	}
+	return rec, problem
+}
+
+func TestHTTPErrorHandlerMapsDomainErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   apperror.Code
+	}{
+		{"summary not found", fmt.Errorf("delete: %w", repositories.ErrSummaryNotFound), http.StatusNotFound, apperror.CodeSummaryNotFound},
+		{"user not found", repositories.ErrUserNotFound, http.StatusNotFound, apperror.CodeUserNotFound},
+		{"identity not found", repositories.ErrIdentityNotFound, http.StatusNotFound, apperror.CodeIdentityNotFound},
+		{"API key not found", repositories.ErrAPIKeyNotFound, http.StatusNotFound, apperror.CodeAPIKeyNotFound},
+		{"identity linked", fmt.Errorf("link: %w", repositories.ErrIdentityLinked), http.StatusConflict, apperror.CodeIdentityLinked},
+		{"app error", apperror.Unauthorized("Missing token"), http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"echo error", echo.NewHTTPError(http.StatusRequestEntityTooLarge, "body too big"), http.StatusRequestEntityTooLarge, apperror.CodePayloadTooLarge},
+		{"unknown error", errors.New("connection reset"), http.StatusInternalServerError, apperror.CodeInternal},
+		{"deadline", apperror.Internal(fmt.Errorf("find: %w", context.DeadlineExceeded)), http.StatusGatewayTimeout, apperror.CodeTimeout},
+		{"canceled", apperror.Upstream("Discord request failed", context.Canceled), apperror.StatusClientClosedRequest, apperror.CodeCanceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, problem := serveError(t, tt.err)
+
+			if rec.Code != tt.status || problem.Status != tt.status {
+				t.Errorf("status = %d (body %d), want %d", rec.Code, problem.Status, tt.status)
+			}
+			if problem.Code != tt.code {
+				t.Errorf("code = %q, want %q", problem.Code, tt.code)
+			}
+			if problem.Title == "" {
+				t.Error("title is empty")
+			}
+			if problem.RequestID != "req-123" {
+				t.Errorf("request_id = %q, want req-123", problem.RequestID)
+			}
+			if got := rec.Header().Get(echo.HeaderContentType); got != apperror.ContentType {
+				t.Errorf("content type = %q, want %q", got, apperror.ContentType)
+			}
+		})
+	}
+}
+
+func TestHTTPErrorHandlerHidesInternalDetails(t *testing.T) {
+	secret := `{"error": "invalid_client", "client_secret": "s3cr3t"}`
+	errs := []error{
+		apperror.Upstream("Discord request failed", &discord.Error{Route: "GET /users/@me", StatusCode: http.StatusBadGateway, Body: secret}),
+		apperror.Internal(errors.New(secret)),
+		errors.New(secret),
+	}
+
+	for _, err := range errs {
+		rec, _ := serveError(t, err)
+		if strings.Contains(rec.Body.String(), "s3cr3t") {
+			t.Errorf("response leaks internal error: %s", rec.Body.String())
+		}
+	}
+}
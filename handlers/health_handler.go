@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"ultra-chat-backend/health"
+)
+
+type HealthHandler struct {
+	checker *health.Checker
+}
+
+// NewHealthHandler serves liveness and readiness; a nil checker reports
+// ready with no checks
+func NewHealthHandler(checker *health.Checker) *HealthHandler {
+	if checker == nil {
+		checker = health.NewChecker()
+	}
+	return &HealthHandler{checker: checker}
+}
+
+// Liveness reports that the process is serving requests. It checks no
+// dependencies so an outage elsewhere does not get the process restarted.
+func (h *HealthHandler) Liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, LivenessResponse{Status: health.StatusOK})
+}
+
+// Readiness runs the dependency checks and answers 503 while a required
+// one is failing so the instance is taken out of rotation. Unlike other
+// errors the 503 body is the health.Report, so probes see which check failed.
+func (h *HealthHandler) Readiness(c echo.Context) error {
+	report := h.checker.Run(c.Request().Context())
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(status, report)
+}
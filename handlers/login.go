@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/identity"
+)
+
+// loginTTL bounds how long a started login can be finished
+const loginTTL = 10 * time.Minute
+
+// loginCookie names the cookie holding the login started with provider.
+// One login per provider can be in flight; starting another replaces it.
+func loginCookie(provider string) string {
+	return "login_" + provider
+}
+
+// startLogin generates the secrets of a new login with provider and gives
+// them to the client in an HttpOnly cookie, which only this client sends
+// back to the callback. The cookie is not signed: it holds nothing but the
+// client's own random values, and a client altering it only breaks its own
+// login.
+func startLogin(c echo.Context, provider string) (identity.Login, error) {
+	login, err := identity.NewLogin()
+	if err != nil {
+		return identity.Login{}, apperror.Internal(err)
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     loginCookie(provider),
+		Value:    strings.Join([]string{login.State, login.Verifier, login.Nonce}, "."),
+		Path:     "/",
+		MaxAge:   int(loginTTL.Seconds()),
+		Secure:   c.Scheme() == "https",
+		HttpOnly: true,
+		// Lax, so that the provider's top-level redirect to the callback
+		// still carries it
+		SameSite: http.SameSiteLaxMode,
+	})
+	return login, nil
+}
+
+// finishLogin returns the login the client started with provider, provided
+// the state the provider redirected back with matches it. A callback
+// without one is refused: its code may have been obtained by someone else
+// and planted in this client. The cookie is cleared either way, so a login
+// is finished at most once.
+func finishLogin(c echo.Context, provider string) (identity.Login, error) {
+	cookie, err := c.Cookie(loginCookie(provider))
+	c.SetCookie(&http.Cookie{
+		Name:     loginCookie(provider),
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   c.Scheme() == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	if err != nil {
+		return identity.Login{}, apperror.BadRequest(apperror.CodeInvalidState, "No login was started from this client, or it expired; start the login again")
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	state := c.QueryParam("state")
+	if len(parts) != 3 || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(parts[0])) != 1 {
+		return identity.Login{}, apperror.BadRequest(apperror.CodeInvalidState, "The state does not match the login started from this client; start the login again")
+	}
+	return identity.Login{State: parts[0], Verifier: parts[1], Nonce: parts[2]}, nil
+}
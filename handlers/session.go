@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/logging"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+// A session is a Discord access token used as a bearer token. Ending one
+// means revoking the Discord grant it belongs to, forgetting the stored
+// copy and dropping cached lookups, so the token stops working everywhere.
+// An access token of a linked provider works as a session too when the
+// AuthProviderHeader names the provider; it is resolved to the account
+// through the identity it belongs to. Sessions of a disabled user are
+// refused until an admin enables them again.
+
+// AuthProviderHeader names the linked provider that issued the bearer
+// token. Without it the token is taken to be Discord's.
+const AuthProviderHeader = "X-Auth-Provider"
+
+// sessions resolves bearer tokens to stored users
+type sessions struct {
+	discord    *discord.Client
+	users      repositories.UserRepository
+	identities repositories.IdentityRepository
+	providers  *identity.Registry
+}
+
+// user returns the stored user the request's bearer token belongs to. The
+// token is returned as well when Discord issued it, so it can be revoked.
+func (s sessions) user(c echo.Context) (*models.User, string, error) {
+	token, err := bearerToken(c)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx := c.Request().Context()
+
+	name := c.Request().Header.Get(AuthProviderHeader)
+	if name == "" || name == identity.DiscordName {
+		userInfo, err := currentUser(c, s.discord, s.users, token, "Invalid or expired token")
+		if err != nil {
+			return nil, "", err
+		}
+		user, err := s.users.FindUserByID(ctx, userInfo.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := enabled(user); err != nil {
+			return nil, "", err
+		}
+		return user, token, nil
+	}
+
+	p, err := s.provider(name)
+	if err != nil {
+		return nil, "", err
+	}
+	userInfo, err := p.UserInfo(ctx, token)
+	if err != nil {
+		return nil, "", providerError(p, err, "Invalid or expired token")
+	}
+	linked, err := s.identities.FindIdentity(ctx, p.Name(), userInfo.Subject)
+	if errors.Is(err, repositories.ErrIdentityNotFound) {
+		return nil, "", apperror.Unauthorized("The identity is not linked to an account, log in with Discord and link it first").Wrap(err)
+	}
+	if err != nil {
+		return nil, "", apperror.Internal(err)
+	}
+	user, err := s.users.FindUserByUUID(ctx, linked.UserUUID)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := enabled(user); err != nil {
+		return nil, "", err
+	}
+	return user, "", nil
+}
+
+// enabled fails for a disabled user
+func enabled(user *models.User) error {
+	if user.Disabled {
+		return apperror.New(http.StatusForbidden, apperror.CodeAccountDisabled, "The account is disabled")
+	}
+	return nil
+}
+
+// checkEnabled fails if the user with the given Discord ID is disabled.
+// Users who never logged in have nothing stored that could disable them.
+func checkEnabled(c echo.Context, users repositories.UserRepository, userID string) error {
+	user, err := users.FindUserByID(c.Request().Context(), userID)
+	if errors.Is(err, repositories.ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return apperror.Internal(err)
+	}
+	return enabled(user)
+}
+
+// provider returns the configured provider with the given name
+func (s sessions) provider(name string) (identity.Provider, error) {
+	p, ok := s.providers.Get(name)
+	if !ok {
+		return nil, apperror.NotFound(apperror.CodeProviderNotFound, "Unknown identity provider")
+	}
+	return p, nil
+}
+
+// currentUser returns the Discord user the bearer token belongs to. When
+// Discord rejects a token that is stored for a user, the user may have
+// removed the app in their Discord settings; see deauthorized.
+func currentUser(c echo.Context, client *discord.Client, users repositories.UserRepository, token, message string) (*discord.User, error) {
+	userInfo, err := client.CurrentUser(c.Request().Context(), token)
+	if err != nil {
+		if discord.IsUnauthorized(err) && deauthorized(c, client, users, token) {
+			return nil, apperror.Unauthorized("Discord authorization was revoked, log in again").Wrap(err)
+		}
+		return nil, discordError(err, message)
+	}
+	if userInfo.ID == "" {
+		return nil, apperror.Upstream("Discord returned no user ID", nil)
+	}
+	return userInfo, nil
+}
+
+// deauthorized handles Discord rejecting accessToken. If a user has it
+// stored, their refresh token tells an expired token from a revoked grant:
+// an expired one is renewed and stored, a revoked one is cleared. It
+// reports whether the grant was revoked. Failures are only logged, since
+// the request fails with a 401 either way.
+func deauthorized(c echo.Context, client *discord.Client, users repositories.UserRepository, accessToken string) bool {
+	ctx := c.Request().Context()
+	logger := logging.FromContext(ctx)
+
+	user, err := users.FindUserByAccessToken(ctx, accessToken)
+	if err != nil {
+		if !errors.Is(err, repositories.ErrUserNotFound) {
+			logger.Warn("looking up the owner of a rejected token failed", "error", err)
+		}
+		return false
+	}
+
+	if user.Token.RefreshToken != "" {
+		renewed, err := client.RefreshToken(ctx, user.Token.RefreshToken)
+		if err == nil {
+			user.Token = storedToken(renewed)
+			if err := users.UpdateUser(ctx, user); err != nil {
+				logger.Warn("storing a renewed Discord token failed", "error", err)
+			}
+			return false
+		}
+		if status := discord.StatusCode(err); status != http.StatusBadRequest && status != http.StatusUnauthorized {
+			logger.Warn("checking a rejected Discord grant failed", "error", err)
+			return false
+		}
+	}
+
+	user.Token = models.Token{}
+	if err := users.UpdateUser(ctx, user); err != nil {
+		logger.Warn("clearing a revoked Discord grant failed", "error", err)
+	}
+	client.ForgetUser(user.ID)
+	logger.Info("Discord authorization was revoked, cleared the stored token", "user_uuid", user.UUID)
+	return true
+}
+
+// revokeGrant asks Discord to revoke the stored tokens of the user with
+// the given Discord ID and the request's bearer token, then drops every
+// cached lookup for the user. Discord ends a grant when either of its
+// tokens is revoked, so the stored grant counts as revoked if one of its
+// tokens was; a bearer token from another grant must be revoked itself.
+func revokeGrant(c echo.Context, client *discord.Client, userID string, stored models.Token, bearer string) error {
+	ctx := c.Request().Context()
+	defer client.ForgetUser(userID)
+
+	revoke := func(token, hint string) error {
+		if token == "" {
+			return nil
+		}
+		err := client.RevokeToken(ctx, token, hint)
+		if err != nil {
+			logging.FromContext(ctx).Warn("revoking a Discord token failed", "hint", hint, "error", err)
+		}
+		return err
+	}
+
+	refreshErr := revoke(stored.RefreshToken, discord.HintRefreshToken)
+	accessErr := revoke(stored.AccessToken, discord.HintAccessToken)
+	if refreshErr != nil && (accessErr != nil || stored.AccessToken == "") {
+		return refreshErr
+	}
+	if accessErr != nil && stored.RefreshToken == "" {
+		return accessErr
+	}
+	if bearer != stored.AccessToken {
+		return revoke(bearer, discord.HintAccessToken)
+	}
+	return nil
+}
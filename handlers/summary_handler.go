@@ -1,159 +1,430 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"go.mongodb.org/mongo-driver/bson"
 	"net/http"
-	"time"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/models"
 	"ultra-chat-backend/repositories"
-	"ultra-chat-backend/utils"
 )
 
 type SummaryHandler struct {
-	repo *repositories.MongoSummaryRepository // Use pointer to MongoSummaryRepository
+	repo     repositories.SummaryRepository
+	users    repositories.UserRepository
+	keys     apiKeys
+	sessions sessions
+	audit    repositories.AuditRepository
+	discord  *discord.Client
+	// trustNamed lets requests without a key or a session act as the user
+	// they name
+	trustNamed bool
 }
 
-func NewSummaryHandler(summaryRepo *repositories.MongoSummaryRepository) *SummaryHandler {
-	return &SummaryHandler{repo: summaryRepo} // Initialize with summaryRepo
+// NewSummaryHandler serves summaries, recording every change to one in
+// the audit log. A request acts for a user with an API key or with the
+// user's bearer token. With requireKeys set, every summary request must
+// carry an API key. With trustNamed set, requests carrying neither act
+// as the user they name, as before keys and sessions were checked; that
+// lets anyone act for anyone and only suits migrating old clients.
+func NewSummaryHandler(summaryRepo repositories.SummaryRepository, userRepo repositories.UserRepository, identities repositories.IdentityRepository, keyRepo repositories.APIKeyRepository, audit repositories.AuditRepository, discordClient *discord.Client, providers *identity.Registry, requireKeys, trustNamed bool) *SummaryHandler {
+	return &SummaryHandler{
+		repo:       summaryRepo,
+		users:      userRepo,
+		keys:       apiKeys{repo: keyRepo, required: requireKeys},
+		sessions:   sessions{discord: discordClient, users: userRepo, identities: identities, providers: providers},
+		audit:      audit,
+		discord:    discordClient,
+		trustNamed: trustNamed,
+	}
 }
 
-func (h *SummaryHandler) CreateSummary(c echo.Context) error {
-	type RequestBody struct {
-		Content   string `json:"content"`
-		ServerID  string `json:"server_id"`
-		IsPrivate bool   `json:"is_private"`
-		UserID    string `json:"user_id"`
+// caller returns the Discord ID of the user a request acts for, named in
+// the ID header, and the API key it carries, if any. A user key or a
+// session may leave the header out.
+func (h *SummaryHandler) caller(c echo.Context, scope string) (string, *models.APIKey, error) {
+	named := c.Request().Header.Get("ID")
+	if named != "" {
+		if _, err := headerUserID(c); err != nil {
+			return "", nil, err
+		}
 	}
+	return h.acting(c, scope, named, apperror.Unauthorized("Missing ID header"))
+}
 
-	var body RequestBody
-	if err := c.Bind(&body); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+// acting returns the Discord ID of the user a request acts for and the
+// API key it carries, if any. named is the user the request names, and
+// missing the error for naming nobody where someone must be named. A key
+// acts as actingUser allows, and a session only as its own user. Without
+// either, the named user is only trusted when trustNamed is set.
+func (h *SummaryHandler) acting(c echo.Context, scope, named string, missing error) (string, *models.APIKey, error) {
+	key, err := h.keys.verify(c, scope)
+	if err != nil {
+		return "", nil, err
 	}
 
-	if body.Content == "" || body.ServerID == "" || body.UserID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	var userID string
+	switch {
+	case key != nil:
+		if userID, err = actingUser(key, named); err != nil {
+			return "", nil, err
+		}
+	case c.Request().Header.Get("Authorization") != "":
+		user, _, err := h.sessions.user(c)
+		if err != nil {
+			return "", nil, err
+		}
+		if named != "" && named != user.ID {
+			return "", nil, apperror.Forbidden("The session cannot act for another user")
+		}
+		userID = user.ID
+	case h.trustNamed:
+		userID = named
+	default:
+		return "", nil, apperror.Unauthorized("Missing API key or bearer token")
+	}
+	if userID == "" {
+		return "", nil, missing
 	}
+	return userID, key, nil
+}
 
-	exists, dbErr := h.repo.CheckUserExists(body.UserID)
-	if dbErr != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Internal server error"})
+// enabled fails if the user a request acts for, or the creator of the API
+// key it carries, is disabled. Handlers call it once the request is
+// validated, before touching any summary.
+func (h *SummaryHandler) enabled(c echo.Context, key *models.APIKey, userID string) error {
+	if key != nil && key.UserID != userID {
+		if err := checkEnabled(c, h.users, key.UserID); err != nil {
+			return err
+		}
 	}
-	if !exists {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized: User not found"})
+	return checkEnabled(c, h.users, userID)
+}
+
+// visible returns the user's summary with the given ID, as not found when
+// key may not see it
+func (h *SummaryHandler) visible(c echo.Context, key *models.APIKey, userID, summaryID string) (*models.Summary, error) {
+	summary, err := h.repo.GetSummary(c.Request().Context(), userID, summaryID)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil && !key.AllowsSummary(summary) {
+		return nil, repositories.ErrSummaryNotFound
+	}
+	return summary, nil
+}
+
+// recordChange records a change to a summary in the audit log before it
+// is made, with before and after nil where the summary does not exist.
+// The actor is the API key the request carried or else the user it acts
+// for; with a key, the summary's author is recorded in the details. The
+// author of an orphaned summary has no UUID and is recorded by Discord ID.
+func (h *SummaryHandler) recordChange(c echo.Context, key *models.APIKey, action string, before, after *models.Summary) error {
+	summary := after
+	if summary == nil {
+		summary = before
+	}
+	author := models.AuditDiscordUser(summary.UserID)
+	user, err := h.users.FindUserByID(c.Request().Context(), summary.UserID)
+	switch {
+	case err == nil:
+		author = models.AuditUser(user.UUID)
+	case !errors.Is(err, repositories.ErrUserNotFound):
+		return apperror.Internal(err)
 	}
 
-	summaryID := uuid.New().String()
+	event := models.AuditEvent{
+		Actor:   author,
+		Action:  action,
+		Target:  models.AuditSummary(summary.ID),
+		Details: map[string]string{"server_id": summary.ServerID},
+	}
+	if user == nil {
+		event.Details["user_id"] = summary.UserID
+	}
+	if key != nil {
+		event.Actor = models.AuditAPIKey(key.ID)
+		event.Details["user"] = author
+	}
+	if before != nil {
+		event.BeforeHash = before.AuditHash()
+	}
+	if after != nil {
+		event.AfterHash = after.AuditHash()
+	}
+	return recordEvent(c, h.audit, event)
+}
 
-	createdAt := time.Now().Format(time.RFC3339)
+// serverForbidden is returned when an API key is used in a server outside
+// its own
+func serverForbidden() error {
+	return apperror.Forbidden("The API key cannot act in this server")
+}
 
-	err := h.repo.AddSummary(summaryID, body.UserID, body.ServerID, body.IsPrivate, body.Content, createdAt)
+func (h *SummaryHandler) CreateSummary(c echo.Context) error {
+	var body CreateSummaryRequest
+	if err := bind(c, &body); err != nil {
+		return err
+	}
+	userID, key, err := h.acting(c, models.ScopeSummariesWrite, body.UserID,
+		apperror.Validation(apperror.FieldError{Field: "user_id", Code: "required", Message: "is required"}))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create summary"})
+		return err
+	}
+	if key != nil && !key.AllowsServer(body.ServerID) {
+		return serverForbidden()
+	}
+	if err := h.enabled(c, key, userID); err != nil {
+		return err
+	}
+
+	exists, dbErr := h.repo.CheckUserExists(c.Request().Context(), userID)
+	if dbErr != nil {
+		return apperror.Internal(dbErr)
+	}
+	if !exists {
+		return apperror.Unauthorized("User not found")
 	}
 
-	return c.JSON(http.StatusCreated, map[string]string{
-		"message":    "Summary created successfully",
-		"summary_id": summaryID,
+	// Without an explicit visibility the user's preference applies
+	var isPrivate bool
+	if body.IsPrivate != nil {
+		isPrivate = *body.IsPrivate
+	} else {
+		user, err := h.users.FindUserByID(c.Request().Context(), userID)
+		if errors.Is(err, repositories.ErrUserNotFound) {
+			return apperror.Unauthorized("User not found").Wrap(err)
+		}
+		if err != nil {
+			return apperror.Internal(err)
+		}
+		isPrivate = user.Preferences.DefaultPrivate
+	}
+
+	summary := &models.Summary{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		ServerID:  body.ServerID,
+		IsPrivate: isPrivate,
+		Content:   body.Content,
+	}
+	if err := h.recordChange(c, key, models.AuditSummaryCreated, nil, summary); err != nil {
+		return err
+	}
+	if err := h.repo.AddSummary(c.Request().Context(), summary); err != nil {
+		return apperror.Internal(err)
+	}
+
+	return c.JSON(http.StatusCreated, CreateSummaryResponse{
+		Message:   "Summary created successfully",
+		SummaryID: summary.ID,
 	})
 }
 
 func (h *SummaryHandler) GetSummaries(c echo.Context) error {
-	userID := c.Request().Header.Get("ID")
-	if userID == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+	userID, key, err := h.caller(c, models.ScopeSummariesRead)
+	if err != nil {
+		return err
+	}
+	if err := h.enabled(c, key, userID); err != nil {
+		return err
 	}
 
-	filter := bson.M{"user_id": userID}
-	summaries, err := h.repo.GetSummaries(filter)
+	summaries, err := h.repo.GetSummaries(c.Request().Context(), userID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve summaries"})
+		return apperror.Internal(err)
 	}
 
-	return c.JSON(http.StatusOK, summaries)
+	list := make([]Summary, 0, len(summaries))
+	for _, summary := range summaries {
+		if key == nil || key.AllowsSummary(&summary) {
+			list = append(list, newSummary(summary))
+		}
+	}
+	return c.JSON(http.StatusOK, list)
 }
 
 func (h *SummaryHandler) UpdateSummary(c echo.Context) error {
-	type RequestBody struct {
-		SummaryID string `json:"summary_id"`
-		ServerID  string `json:"server_id"`
-		IsPrivate bool   `json:"is_private"`
-		Content   string `json:"content"`
+	var body UpdateSummaryRequest
+	if err := bind(c, &body); err != nil {
+		return err
+	}
+	userID, key, err := h.caller(c, models.ScopeSummariesWrite)
+	if err != nil {
+		return err
+	}
+	if key != nil && !key.AllowsServer(body.ServerID) {
+		return serverForbidden()
+	}
+	if err := h.enabled(c, key, userID); err != nil {
+		return err
+	}
+	before, err := h.visible(c, key, userID, body.SummaryID)
+	if err != nil {
+		return err
+	}
+	// The route used to update whichever summary matched the server and
+	// visibility, so a summary elsewhere is not the one the client means
+	if before.ServerID != body.ServerID || before.IsPrivate != body.IsPrivate {
+		return repositories.ErrSummaryNotFound
 	}
 
-	var body RequestBody
-	if err := c.Bind(&body); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	patch := models.SummaryPatch{Content: &body.Content}
+	after := *before
+	patch.Apply(&after)
+	if err := h.recordChange(c, key, models.AuditSummaryUpdated, before, &after); err != nil {
+		return err
+	}
+	if err := h.repo.PatchSummary(c.Request().Context(), userID, body.SummaryID, patch); err != nil {
+		return err
 	}
 
-	userID := c.Request().Header.Get("ID")
-	if userID == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+	return c.JSON(http.StatusOK, MessageResponse{Message: "Summary updated successfully"})
+}
+
+func (h *SummaryHandler) DeleteSummary(c echo.Context) error {
+	var body DeleteSummaryRequest
+	if err := bind(c, &body); err != nil {
+		return err
+	}
+	userID, key, err := h.caller(c, models.ScopeSummariesWrite)
+	if err != nil {
+		return err
+	}
+	if err := h.enabled(c, key, userID); err != nil {
+		return err
+	}
+	before, err := h.visible(c, key, userID, body.SummaryID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.recordChange(c, key, models.AuditSummaryDeleted, before, nil); err != nil {
+		return err
+	}
+	if err := h.repo.DeleteSummary(c.Request().Context(), userID, body.SummaryID); err != nil {
+		return err
 	}
 
-	if body.SummaryID == "" || body.ServerID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Missing required fields"})
+	return c.JSON(http.StatusOK, MessageResponse{Message: "Summary deleted successfully"})
+}
+
+func (h *SummaryHandler) GetSummary(c echo.Context) error {
+	var params SummaryParams
+	if err := bind(c, &params); err != nil {
+		return err
+	}
+	userID, key, err := h.caller(c, models.ScopeSummariesRead)
+	if err != nil {
+		return err
+	}
+	if err := h.enabled(c, key, userID); err != nil {
+		return err
 	}
 
-	err := h.repo.UpdateSummary(userID, body.ServerID, body.IsPrivate, body.Content)
+	summary, err := h.visible(c, key, userID, params.ID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update summary"})
+		return err
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Summary updated successfully"})
+	return c.JSON(http.StatusOK, newSummary(*summary))
 }
 
-func (h *SummaryHandler) DeleteSummary(c echo.Context) error {
-	type RequestBody struct {
-		SummaryID string `json:"summary_id"`
+func (h *SummaryHandler) PatchSummary(c echo.Context) error {
+	var body PatchSummaryRequest
+	if err := bind(c, &body); err != nil {
+		return err
 	}
-	var body RequestBody
-	if err := c.Bind(&body); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	userID, key, err := h.caller(c, models.ScopeSummariesWrite)
+	if err != nil {
+		return err
 	}
 
-	userID := c.Request().Header.Get("ID")
-	if userID == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+	patch := models.SummaryPatch{
+		ServerID:  body.ServerID,
+		IsPrivate: body.IsPrivate,
+		Content:   body.Content,
+	}
+	if patch.Empty() {
+		return apperror.BadRequest(apperror.CodeBadRequest, "No fields to update")
+	}
+	if err := h.enabled(c, key, userID); err != nil {
+		return err
+	}
+	before, err := h.visible(c, key, userID, body.ID)
+	if err != nil {
+		return err
+	}
+	if key != nil && patch.ServerID != nil && !key.AllowsServer(*patch.ServerID) {
+		return serverForbidden()
 	}
 
-	if err := h.repo.DeleteSummary(userID, body.SummaryID); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete summary"})
+	after := *before
+	patch.Apply(&after)
+	if err := h.recordChange(c, key, models.AuditSummaryUpdated, before, &after); err != nil {
+		return err
+	}
+	if err := h.repo.PatchSummary(c.Request().Context(), userID, body.ID, patch); err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Summary deleted successfully"})
+	return c.JSON(http.StatusOK, MessageResponse{Message: "Summary updated successfully"})
+}
+
+func (h *SummaryHandler) DeleteSummaryByID(c echo.Context) error {
+	var params SummaryParams
+	if err := bind(c, &params); err != nil {
+		return err
+	}
+	userID, key, err := h.caller(c, models.ScopeSummariesWrite)
+	if err != nil {
+		return err
+	}
+	if err := h.enabled(c, key, userID); err != nil {
+		return err
+	}
+	before, err := h.visible(c, key, userID, params.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.recordChange(c, key, models.AuditSummaryDeleted, before, nil); err != nil {
+		return err
+	}
+	if err := h.repo.DeleteSummary(c.Request().Context(), userID, params.ID); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
 }
 
 func (h *SummaryHandler) IsAuthenticated(c echo.Context) error {
 	authHeader := c.Request().Header.Get("Authorization")
-	fmt.Println(authHeader)
 	if authHeader == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized: Missing token"})
+		return apperror.Unauthorized("Missing token")
 	}
 
 	accessToken := ""
 	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
 		accessToken = authHeader[7:]
 	} else {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized: Invalid token format"})
+		return apperror.Unauthorized("Invalid token format")
 	}
 
-	userInfo, err := utils.FetchUserInfo(accessToken)
+	userInfo, err := currentUser(c, h.discord, h.users, accessToken, "Failed to validate token")
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized: Failed to validate token"})
+		return err
 	}
-
-	userID, ok := userInfo["id"].(string)
-	if !ok || userID == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Unauthorized: Invalid user data"})
+	if err := checkEnabled(c, h.users, userInfo.ID); err != nil {
+		return err
 	}
 
-	c.Set("userID", userID)
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"message":   "Authenticated",
-		"user_id":   userID,
-		"user_info": userInfo,
+	return c.JSON(http.StatusOK, AuthStatusResponse{
+		Message:  "Authenticated",
+		UserID:   userInfo.ID,
+		UserInfo: newDiscordUser(userInfo),
 	})
 }
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"time"
+
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/health"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/models"
+)
+
+// Request and response bodies exchanged by the handlers. These types are
+// also the source of the OpenAPI document, so keep the json tags and doc
+// strings accurate. Errors are always apperror.Problem documents.
+//
+// Request types are checked by validation.Validator using their `validate`
+// tags; the limits here must stay in sync with validation.MaxSummaryLength.
+
+// MessageResponse acknowledges a successful mutation
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// LivenessResponse is returned by /healthz while the process is up
+type LivenessResponse struct {
+	Status health.Status `json:"status" example:"ok"`
+}
+
+// LoginResponse carries the authorize URL the client should open
+type LoginResponse struct {
+	URL string `json:"url" doc:"OAuth2 authorize URL of Discord or the requested provider"`
+}
+
+// ProviderSession is returned by a login with a linked provider. The
+// access token is a session as long as the provider accepts it.
+type ProviderSession struct {
+	Provider    string `json:"provider" example:"oidc"`
+	AccessToken string `json:"access_token" doc:"Send as the bearer token along with the X-Auth-Provider header"`
+	TokenType   string `json:"token_type" example:"Bearer"`
+	ExpiresIn   int    `json:"expires_in" doc:"Lifetime in seconds when it was issued"`
+	UserUUID    string `json:"user_uuid" doc:"UUID of the account the identity is linked to"`
+}
+
+// Identity is an account at an identity provider the user can log in with
+type Identity struct {
+	Provider string `json:"provider" example:"discord"`
+	Subject  string `json:"subject" doc:"The user's ID at the provider; the Discord ID for Discord"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	LinkedAt string `json:"linked_at" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+}
+
+func newIdentity(i models.Identity) Identity {
+	return Identity{
+		Provider: i.Provider,
+		Subject:  i.Subject,
+		Username: i.Username,
+		Email:    i.Email,
+		LinkedAt: i.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// discordIdentity presents the user's Discord account, which every account
+// has, as an Identity
+func discordIdentity(u *models.User) Identity {
+	return Identity{
+		Provider: identity.DiscordName,
+		Subject:  u.ID,
+		Username: u.Username,
+		LinkedAt: u.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// APIKey describes an API key without revealing it
+type APIKey struct {
+	KeyID      string   `json:"key_id" doc:"API key UUID"`
+	Prefix     string   `json:"prefix" doc:"Public part of the key, which reads ucb_<prefix>_<secret>" example:"3f9a0c5e71d2"`
+	Name       string   `json:"name"`
+	Kind       string   `json:"kind" doc:"user keys act as their creator; server keys act as any user, in their servers only" example:"user"`
+	Scopes     []string `json:"scopes" example:"summaries:read"`
+	ServerIDs  []string `json:"server_ids" doc:"Discord servers the key may act in; empty for every server"`
+	CreatedAt  string   `json:"created_at" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+	LastUsedAt *string  `json:"last_used_at" doc:"RFC 3339 timestamp in UTC, to the minute; null until the key is used"`
+}
+
+func newAPIKey(k models.APIKey) APIKey {
+	key := APIKey{
+		KeyID:     k.ID,
+		Prefix:    k.Prefix,
+		Name:      k.Name,
+		Kind:      k.Kind,
+		Scopes:    k.Scopes,
+		ServerIDs: k.ServerIDs,
+		CreatedAt: k.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if key.Scopes == nil {
+		key.Scopes = []string{}
+	}
+	if key.ServerIDs == nil {
+		key.ServerIDs = []string{}
+	}
+	if !k.LastUsedAt.IsZero() {
+		lastUsed := k.LastUsedAt.UTC().Format(time.RFC3339)
+		key.LastUsedAt = &lastUsed
+	}
+	return key
+}
+
+// CreatedAPIKey is a new API key. It is the only response that includes
+// the key itself.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key" doc:"Send in the X-API-Key header; it cannot be shown again"`
+}
+
+// CreateAPIKeyRequest is the body of POST /api/v1/me/api-keys
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name" validate:"required,max=100"`
+	Kind      string   `json:"kind,omitempty" validate:"omitempty,oneof=user server" doc:"Defaults to user"`
+	Scopes    []string `json:"scopes" validate:"required,min=1,dive,oneof=summaries:read summaries:write"`
+	ServerIDs []string `json:"server_ids,omitempty" validate:"max=100,dive,snowflake" doc:"Servers the key may act in. Required for server keys, all of which you must manage; a user key without any acts in every server."`
+}
+
+// APIKeyParams are the path parameters of /api/v1/me/api-keys/:id
+type APIKeyParams struct {
+	ID string `param:"id" json:"-" validate:"required,uuid"`
+}
+
+// DiscordUser is Discord's user object as the API passes it through
+type DiscordUser struct {
+	ID            string `json:"id" doc:"Discord snowflake ID" example:"80351110224678912"`
+	Username      string `json:"username"`
+	Discriminator string `json:"discriminator"`
+	GlobalName    string `json:"global_name,omitempty"`
+	Avatar        string `json:"avatar,omitempty" doc:"Avatar hash"`
+	Banner        string `json:"banner,omitempty" doc:"Banner hash"`
+	AccentColor   int    `json:"accent_color,omitempty"`
+	Locale        string `json:"locale,omitempty"`
+	Email         string `json:"email,omitempty" doc:"Only with the email scope"`
+	Verified      bool   `json:"verified,omitempty"`
+	MFAEnabled    bool   `json:"mfa_enabled,omitempty"`
+	Flags         int    `json:"flags,omitempty"`
+	PremiumType   int    `json:"premium_type,omitempty"`
+	PublicFlags   int    `json:"public_flags,omitempty"`
+}
+
+func newDiscordUser(u *discord.User) DiscordUser {
+	return DiscordUser{
+		ID:            u.ID,
+		Username:      u.Username,
+		Discriminator: u.Discriminator,
+		GlobalName:    u.GlobalName,
+		Avatar:        u.Avatar,
+		Banner:        u.Banner,
+		AccentColor:   u.AccentColor,
+		Locale:        u.Locale,
+		Email:         u.Email,
+		Verified:      u.Verified,
+		MFAEnabled:    u.MFAEnabled,
+		Flags:         u.Flags,
+		PremiumType:   u.PremiumType,
+		PublicFlags:   u.PublicFlags,
+	}
+}
+
+// AuthStatusResponse confirms a bearer token is valid
+type AuthStatusResponse struct {
+	Message  string      `json:"message"`
+	UserID   string      `json:"user_id"`
+	UserInfo DiscordUser `json:"user_info"`
+}
+
+// Account is the authenticated user's stored profile
+type Account struct {
+	ID            string      `json:"id" doc:"Discord snowflake ID" example:"80351110224678912"`
+	UUID          string      `json:"uuid" doc:"Account UUID"`
+	Username      string      `json:"username" doc:"Discord username at the last login"`
+	Discriminator string      `json:"discriminator"`
+	Preferences   Preferences `json:"preferences"`
+	CreatedAt     string      `json:"created_at" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+	UpdatedAt     string      `json:"updated_at" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+}
+
+// Preferences are the user's account settings, with defaults filled in
+type Preferences struct {
+	DefaultPrivate bool   `json:"default_private" doc:"Visibility of summaries created without is_private"`
+	Timezone       string `json:"timezone" doc:"IANA time zone name" example:"Europe/Berlin"`
+	Language       string `json:"language" doc:"BCP 47 tag of the language summaries are written in" example:"en"`
+}
+
+func newAccount(u *models.User) Account {
+	prefs := Preferences{
+		DefaultPrivate: u.Preferences.DefaultPrivate,
+		Timezone:       u.Preferences.Timezone,
+		Language:       u.Preferences.Language,
+	}
+	if prefs.Timezone == "" {
+		prefs.Timezone = models.DefaultTimezone
+	}
+	if prefs.Language == "" {
+		prefs.Language = models.DefaultLanguage
+	}
+	return Account{
+		ID:            u.ID,
+		UUID:          u.UUID,
+		Username:      u.Username,
+		Discriminator: u.Discriminator,
+		Preferences:   prefs,
+		CreatedAt:     u.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:     u.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// PatchAccountRequest is the body of PATCH /api/v1/me. Omitted fields are
+// left unchanged.
+type PatchAccountRequest struct {
+	Preferences PreferencesPatch `json:"preferences"`
+}
+
+// PreferencesPatch lists the preferences to change
+type PreferencesPatch struct {
+	DefaultPrivate *bool   `json:"default_private,omitempty"`
+	Timezone       *string `json:"timezone,omitempty" validate:"omitnil,timezone" doc:"IANA time zone name" example:"Europe/Berlin"`
+	Language       *string `json:"language,omitempty" validate:"omitnil,bcp47_language_tag" doc:"BCP 47 language tag" example:"pt-BR"`
+}
+
+// AccountExport is everything stored about the authenticated user, as
+// returned by GET /api/v1/me/export
+type AccountExport struct {
+	ExportedAt  string       `json:"exported_at" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+	Account     Account      `json:"account"`
+	Token       TokenInfo    `json:"discord_token" doc:"The stored Discord grant, without the token values"`
+	Identities  []Identity   `json:"identities" doc:"Discord and the linked identities"`
+	APIKeys     []APIKey     `json:"api_keys"`
+	Summaries   []Summary    `json:"summaries"`
+	AuditEvents []AuditEvent `json:"audit_events" doc:"Events about the account, newest first"`
+}
+
+// TokenInfo describes a stored Discord token without revealing it
+type TokenInfo struct {
+	TokenType string `json:"token_type" example:"Bearer"`
+	Scope     string `json:"scope" example:"identify guilds"`
+	ExpiresIn int    `json:"expires_in" doc:"Lifetime in seconds when it was issued"`
+}
+
+// AuditEvent is an entry of the audit log
+type AuditEvent struct {
+	EventID    string            `json:"event_id"`
+	Time       string            `json:"time" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+	Actor      string            `json:"actor" example:"user:0f8fad5b-d9cb-469f-a165-70867728950e"`
+	Action     string            `json:"action" example:"account.deleted"`
+	Target     string            `json:"target" example:"user:0f8fad5b-d9cb-469f-a165-70867728950e"`
+	IP         string            `json:"ip,omitempty" doc:"IP address of the client" example:"203.0.113.7"`
+	UserAgent  string            `json:"user_agent,omitempty" doc:"User agent of the client"`
+	BeforeHash string            `json:"before_hash,omitempty" doc:"SHA-256 of the target before the action, if it existed"`
+	AfterHash  string            `json:"after_hash,omitempty" doc:"SHA-256 of the target after the action, if it still exists"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+func newAuditEvent(e models.AuditEvent) AuditEvent {
+	return AuditEvent{
+		EventID:    e.ID,
+		Time:       e.Time.UTC().Format(time.RFC3339),
+		Actor:      e.Actor,
+		Action:     e.Action,
+		Target:     e.Target,
+		IP:         e.IP,
+		UserAgent:  e.UserAgent,
+		BeforeHash: e.BeforeHash,
+		AfterHash:  e.AfterHash,
+		Details:    e.Details,
+	}
+}
+
+// AuditQuery are the query parameters selecting audit events on the admin
+// routes
+type AuditQuery struct {
+	Actor  string    `query:"actor" json:"-"`
+	Target string    `query:"target" json:"-"`
+	Action string    `query:"action" json:"-"`
+	Since  time.Time `query:"since" json:"-"`
+	Until  time.Time `query:"until" json:"-"`
+	Limit  int       `query:"limit" json:"-" validate:"min=0,max=1000"`
+}
+
+// UserQuery are the query parameters of GET /api/v1/admin/users
+type UserQuery struct {
+	Query string `query:"q" json:"-"`
+	Limit int    `query:"limit" json:"-" validate:"min=0,max=1000"`
+}
+
+// AdminUserParams are the path parameters of /api/v1/admin/users/:user_id
+type AdminUserParams struct {
+	UserID string `param:"user_id" json:"-" validate:"required,snowflake"`
+}
+
+// AdminUser is a user's account as operators see it
+type AdminUser struct {
+	Account
+	Disabled bool `json:"disabled" doc:"Disabled users are rejected until enabled again"`
+	LoggedIn bool `json:"logged_in" doc:"Whether a Discord grant is stored for the user"`
+}
+
+func newAdminUser(u *models.User) AdminUser {
+	return AdminUser{
+		Account:  newAccount(u),
+		Disabled: u.Disabled,
+		LoggedIn: u.Token.AccessToken != "" || u.Token.RefreshToken != "",
+	}
+}
+
+// RevokedTokens reports what POST /api/v1/admin/users/:user_id/revoke-tokens
+// revoked
+type RevokedTokens struct {
+	APIKeysRevoked int64 `json:"api_keys_revoked"`
+	DiscordRevoked bool  `json:"discord_revoked" doc:"Whether a stored Discord grant was revoked"`
+}
+
+// ServerStats sums up the summaries written in a Discord server
+type ServerStats struct {
+	ServerID      string `json:"server_id" doc:"Discord snowflake ID" example:"80351110224678912"`
+	Summaries     int64  `json:"summaries"`
+	Private       int64  `json:"private" doc:"How many of the summaries are private"`
+	Authors       int64  `json:"authors" doc:"Distinct users who wrote them"`
+	LastUpdatedAt string `json:"last_updated_at" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+}
+
+func newServerStats(s models.ServerStats) ServerStats {
+	return ServerStats{
+		ServerID:      s.ServerID,
+		Summaries:     s.Summaries,
+		Private:       s.Private,
+		Authors:       s.Authors,
+		LastUpdatedAt: s.LastUpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// MaintenanceParams are the path parameters of
+// /api/v1/admin/maintenance/:task
+type MaintenanceParams struct {
+	Task string `param:"task" json:"-" validate:"required,oneof=reindex purge-orphans"`
+}
+
+// MaintenanceResult reports a maintenance task that ran to completion
+type MaintenanceResult struct {
+	Task   string          `json:"task" example:"purge-orphans"`
+	Purged *models.Orphans `json:"purged,omitempty" doc:"Records of users who no longer exist, deleted by purge-orphans"`
+}
+
+// Summary is a stored chat summary
+type Summary struct {
+	SummaryID string `json:"summary_id" doc:"Summary UUID"`
+	UserID    string `json:"user_id" doc:"Discord ID of the author"`
+	ServerID  string `json:"server_id" doc:"Discord ID of the server the chat was summarized in"`
+	IsPrivate bool   `json:"is_private"`
+	Content   string `json:"summary"`
+	CreatedAt string `json:"created_at" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+	UpdatedAt string `json:"updated_at" doc:"RFC 3339 timestamp in UTC" example:"2024-05-01T09:30:00Z"`
+}
+
+func newSummary(s models.Summary) Summary {
+	return Summary{
+		SummaryID: s.ID,
+		UserID:    s.UserID,
+		ServerID:  s.ServerID,
+		IsPrivate: s.IsPrivate,
+		Content:   s.Content,
+		CreatedAt: s.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt: s.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// CreateSummaryRequest is the body of POST /api/v1/summaries
+type CreateSummaryRequest struct {
+	Content   string `json:"content" validate:"required,max=16000"`
+	ServerID  string `json:"server_id" validate:"required,snowflake"`
+	IsPrivate *bool  `json:"is_private,omitempty" doc:"Defaults to the user's default_private preference"`
+	UserID    string `json:"user_id,omitempty" validate:"omitempty,snowflake" doc:"Author; required with a server API key, and may be left out with a user API key or a bearer token, which act as their own user"`
+}
+
+// CreateSummaryResponse identifies the created summary
+type CreateSummaryResponse struct {
+	Message   string `json:"message"`
+	SummaryID string `json:"summary_id"`
+}
+
+// PatchSummaryRequest is the body of PATCH /api/v1/summaries/:id. Omitted
+// fields are left unchanged.
+type PatchSummaryRequest struct {
+	SummaryParams
+	ServerID  *string `json:"server_id,omitempty" validate:"omitnil,snowflake"`
+	IsPrivate *bool   `json:"is_private,omitempty"`
+	Content   *string `json:"content,omitempty" validate:"omitnil,min=1,max=16000"`
+}
+
+// SummaryParams are the path parameters of /api/v1/summaries/:id
+type SummaryParams struct {
+	ID string `param:"id" json:"-" validate:"required,uuid"`
+}
+
+// UpdateSummaryRequest is the body of the deprecated PUT /update-summary
+type UpdateSummaryRequest struct {
+	SummaryID string `json:"summary_id" validate:"required,uuid"`
+	ServerID  string `json:"server_id" validate:"required,snowflake"`
+	IsPrivate bool   `json:"is_private"`
+	Content   string `json:"content" validate:"max=16000"`
+}
+
+// DeleteSummaryRequest is the body of the deprecated DELETE /delete-summary
+type DeleteSummaryRequest struct {
+	SummaryID string `json:"summary_id" validate:"required,uuid"`
+}
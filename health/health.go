@@ -0,0 +1,181 @@
+// Package health reports whether the service and its dependencies are
+// usable. A Checker runs a set of Checks concurrently; required checks
+// decide readiness, optional ones only mark the report degraded.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const DefaultTimeout = 2 * time.Second
+
+type Status string
+
+const (
+	StatusOK          Status = "ok"
+	StatusDegraded    Status = "degraded"
+	StatusUnavailable Status = "unavailable"
+)
+
+// Check probes one dependency
+type Check struct {
+	Name string
+	// Probe returns nil when the dependency is usable
+	Probe func(ctx context.Context) error
+	// Optional checks mark the report degraded instead of unavailable
+	Optional bool
+	// CacheFor reuses the last result for this long, for dependencies that
+	// should not be probed on every readiness request
+	CacheFor time.Duration
+	// Timeout bounds a probe; zero uses DefaultTimeout
+	Timeout time.Duration
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status    Status    `json:"status"`
+	LatencyMS float64   `json:"latency_ms" doc:"How long the probe took"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Cached    bool      `json:"cached,omitempty" doc:"The result is from an earlier probe"`
+}
+
+// Report is the outcome of every check
+type Report struct {
+	Status Status            `json:"status" doc:"unavailable if a required check failed, degraded if only optional checks failed"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Ready reports whether every required check passed
+func (r Report) Ready() bool {
+	return r.Status != StatusUnavailable
+}
+
+type check struct {
+	Check
+
+	mu   sync.Mutex
+	last *Result
+}
+
+// Checker runs checks. It is safe for concurrent use.
+type Checker struct {
+	checks []*check
+	now    func() time.Time
+}
+
+func NewChecker(checks ...Check) *Checker {
+	c := &Checker{now: time.Now}
+	for _, ch := range checks {
+		if ch.Timeout <= 0 {
+			ch.Timeout = DefaultTimeout
+		}
+		c.checks = append(c.checks, &check{Check: ch})
+	}
+	return c
+}
+
+// Run probes every check concurrently and summarises the results
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, ch := range c.checks {
+		wg.Add(1)
+		go func(i int, ch *check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, ch)
+		}(i, ch)
+	}
+	wg.Wait()
+
+	for i, ch := range c.checks {
+		result := results[i]
+		report.Checks[ch.Name] = result
+		if result.Status == StatusOK {
+			continue
+		}
+		if !ch.Optional {
+			report.Status = StatusUnavailable
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, ch *check) Result {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	now := c.now()
+	if ch.last != nil && ch.CacheFor > 0 && now.Sub(ch.last.CheckedAt) < ch.CacheFor {
+		cached := *ch.last
+		cached.Cached = true
+		return cached
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ch.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := ch.Probe(ctx)
+	result := Result{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: now,
+	}
+	if err != nil {
+		result.Status = StatusUnavailable
+		if ch.Optional {
+			result.Status = StatusDegraded
+		}
+		result.Error = err.Error()
+	}
+
+	ch.last = &result
+	return result
+}
+
+// ErrStarting is reported by a Startup gate that has not finished
+var ErrStarting = errors.New("starting up")
+
+// Startup gates readiness on a startup task such as creating indexes. Its
+// Probe fails until Done is called.
+type Startup struct {
+	mu   sync.Mutex
+	done bool
+	err  error
+}
+
+// Probe is a Check.Probe returning the last startup error until Done
+func (s *Startup) Probe(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return ErrStarting
+}
+
+// Failed records why the latest startup attempt failed
+func (s *Startup) Failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Done marks startup complete
+func (s *Startup) Done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.err = nil
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckerStatus(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("down") }
+
+	tests := []struct {
+		name   string
+		checks []Check
+		want   Status
+	}{
+		{"no checks", nil, StatusOK},
+		{"all pass", []Check{{Name: "a", Probe: ok}, {Name: "b", Probe: ok, Optional: true}}, StatusOK},
+		{"optional fails", []Check{{Name: "a", Probe: ok}, {Name: "b", Probe: fail, Optional: true}}, StatusDegraded},
+		{"required fails", []Check{{Name: "a", Probe: fail}, {Name: "b", Probe: fail, Optional: true}}, StatusUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewChecker(tt.checks...).Run(context.Background())
+			if report.Status != tt.want {
+				t.Errorf("status = %s, want %s (%+v)", report.Status, tt.want, report.Checks)
+			}
+			if report.Ready() != (tt.want != StatusUnavailable) {
+				t.Errorf("Ready() = %v for %s", report.Ready(), report.Status)
+			}
+			for _, check := range tt.checks {
+				if _, ok := report.Checks[check.Name]; !ok {
+					t.Errorf("report is missing %s", check.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	report := NewChecker(Check{Name: "slow", Probe: hang, Timeout: 10 * time.Millisecond}).Run(context.Background())
+
+	result := report.Checks["slow"]
+	if result.Status != StatusUnavailable || result.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("result = %+v", result)
+	}
+	if result.LatencyMS < 10 {
+		t.Errorf("latency = %vms, want at least the timeout", result.LatencyMS)
+	}
+}
+
+func TestCheckCache(t *testing.T) {
+	calls := 0
+	probe := func(context.Context) error {
+		calls++
+		return errors.New("unreachable")
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	checker := NewChecker(Check{Name: "discord", Probe: probe, Optional: true, CacheFor: 30 * time.Second})
+	checker.now = func() time.Time { return now }
+
+	first := checker.Run(context.Background()).Checks["discord"]
+	now = now.Add(10 * time.Second)
+	second := checker.Run(context.Background()).Checks["discord"]
+
+	if calls != 1 || first.Cached || !second.Cached || second.Error != "unreachable" {
+		t.Errorf("calls = %d, first = %+v, second = %+v", calls, first, second)
+	}
+
+	now = now.Add(30 * time.Second)
+	if checker.Run(context.Background()).Checks["discord"].Cached || calls != 2 {
+		t.Errorf("expired result was reused (%d calls)", calls)
+	}
+}
+
+func TestStartup(t *testing.T) {
+	var s Startup
+	if err := s.Probe(context.Background()); !errors.Is(err, ErrStarting) {
+		t.Errorf("before any attempt: %v", err)
+	}
+	s.Failed(errors.New("no primary"))
+	if err := s.Probe(context.Background()); err == nil || err.Error() != "no primary" {
+		t.Errorf("after a failed attempt: %v", err)
+	}
+	s.Done()
+	if err := s.Probe(context.Background()); err != nil {
+		t.Errorf("after Done: %v", err)
+	}
+}
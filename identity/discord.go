@@ -0,0 +1,68 @@
+package identity
+
+import (
+	"context"
+
+	"ultra-chat-backend/discord"
+)
+
+// DiscordName is the name of the Discord provider
+const DiscordName = "discord"
+
+type discordProvider struct {
+	client *discord.Client
+}
+
+// Discord adapts a Discord client to Provider. Its errors are the
+// client's, so discord.IsUnauthorized and friends still apply.
+func Discord(client *discord.Client) Provider {
+	return &discordProvider{client: client}
+}
+
+func (p *discordProvider) Name() string {
+	return DiscordName
+}
+
+func (p *discordProvider) AuthorizeURL(_ context.Context, login Login) (string, error) {
+	return p.client.AuthorizeURL(login.State, login.Challenge()), nil
+}
+
+// Exchange ignores the login's nonce: Discord issues no ID tokens
+func (p *discordProvider) Exchange(ctx context.Context, code string, login Login) (*Token, error) {
+	token, err := p.client.ExchangeCode(ctx, code, login.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	return fromDiscord(token), nil
+}
+
+func (p *discordProvider) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	token, err := p.client.RefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return fromDiscord(token), nil
+}
+
+func (p *discordProvider) UserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	user, err := p.client.CurrentUser(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	return &UserInfo{
+		Subject:  user.ID,
+		Username: user.Username,
+		Name:     user.GlobalName,
+		Email:    user.Email,
+	}, nil
+}
+
+func fromDiscord(token *discord.Token) *Token {
+	return &Token{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		ExpiresIn:    token.ExpiresIn,
+		RefreshToken: token.RefreshToken,
+		Scope:        token.Scope,
+	}
+}
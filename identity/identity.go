@@ -0,0 +1,117 @@
+// Package identity abstracts the OAuth2 providers users can sign in with.
+// Discord is the primary one: accounts are created by logging in with
+// Discord, and the bot keys everything by Discord ID. Other providers, such
+// as any OpenID Connect issuer, hold identities linked to an existing
+// account. Every provider is driven through the same four steps: send the
+// user to the authorize URL, exchange the code it redirects back with,
+// look up who the token belongs to and renew the token when it expires.
+// The first two are bound together by a Login the client keeps in between.
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"ultra-chat-backend/discord"
+)
+
+// Provider is an OAuth2 identity provider
+type Provider interface {
+	// Name identifies the provider in routes and stored identities
+	Name() string
+	// AuthorizeURL returns the URL users open to grant access, carrying
+	// the state, PKCE challenge and, where supported, nonce of login
+	AuthorizeURL(ctx context.Context, login Login) (string, error)
+	// Exchange trades an authorization code for tokens, proving with the
+	// login's PKCE verifier that the caller started the authorization
+	Exchange(ctx context.Context, code string, login Login) (*Token, error)
+	// Refresh trades a refresh token for a new token
+	Refresh(ctx context.Context, refreshToken string) (*Token, error)
+	// UserInfo returns the user an access token belongs to
+	UserInfo(ctx context.Context, accessToken string) (*UserInfo, error)
+}
+
+// Token is an OAuth2 token response
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	// Subject is the user a verified ID token names, for providers that
+	// issue them. UserInfo must return the same subject.
+	Subject string `json:"-"`
+}
+
+// UserInfo is who a token belongs to
+type UserInfo struct {
+	// Subject is the user's stable ID at the provider
+	Subject  string
+	Username string
+	Name     string
+	Email    string
+}
+
+// Error is returned when a provider answers with a non-200 status. Body is
+// kept for logging and must not be forwarded to API clients.
+type Error struct {
+	Provider   string
+	Endpoint   string
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: status %d: %s", e.Provider, e.Endpoint, e.StatusCode, e.Body)
+}
+
+// StatusCode returns the provider status carried by err, or 0. Errors of
+// the Discord provider are discord errors and are recognised too.
+func StatusCode(err error) int {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.StatusCode
+	}
+	return discord.StatusCode(err)
+}
+
+// IsUnauthorized reports whether the provider rejected the token
+func IsUnauthorized(err error) bool {
+	return StatusCode(err) == http.StatusUnauthorized
+}
+
+// Registry holds the configured providers by name
+type Registry struct {
+	providers map[string]Provider
+}
+
+// NewRegistry returns a registry of providers. Names must be unique.
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: make(map[string]Provider, len(providers))}
+	for _, p := range providers {
+		if _, dup := r.providers[p.Name()]; dup {
+			panic(fmt.Sprintf("identity: provider %q is registered twice", p.Name()))
+		}
+		r.providers[p.Name()] = p
+	}
+	return r
+}
+
+// Get returns the provider with the given name
+func (r *Registry) Get(name string) (Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Names lists the registered providers in alphabetical order
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
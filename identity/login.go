@@ -0,0 +1,41 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// Login holds the secrets of one authorization, generated when the user is
+// sent to the authorize URL and kept by the client that started it until
+// the provider redirects back. State is passed back on the redirect and
+// must match the client's copy, so a code obtained by someone else cannot
+// be completed in the user's session. Verifier is the PKCE code verifier
+// (RFC 7636): only its S256 challenge is sent with the authorization, so
+// an intercepted code is useless without it. Nonce is echoed in OIDC ID
+// tokens and ties them to this authorization.
+type Login struct {
+	State    string
+	Verifier string
+	Nonce    string
+}
+
+// NewLogin returns a login with fresh random secrets
+func NewLogin() (Login, error) {
+	var secrets [3]string
+	for i := range secrets {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Login{}, fmt.Errorf("generating login secrets: %w", err)
+		}
+		secrets[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return Login{State: secrets[0], Verifier: secrets[1], Nonce: secrets[2]}, nil
+}
+
+// Challenge returns the S256 PKCE code challenge of the verifier
+func (l Login) Challenge() string {
+	sum := sha256.Sum256([]byte(l.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,277 @@
+package identity
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("ultra-chat-backend/identity")
+
+const (
+	DefaultOIDCScope   = "openid profile email"
+	DefaultOIDCTimeout = 10 * time.Second
+)
+
+// OIDCConfig configures an OIDC provider. Zero values fall back to the
+// defaults above.
+type OIDCConfig struct {
+	// Name identifies the provider, e.g. google
+	Name string
+	// Issuer is the issuer URL; the endpoints are discovered from its
+	// /.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scope        string
+	Timeout      time.Duration
+	HTTPClient   *http.Client
+}
+
+// OIDC is a generic OpenID Connect provider using the authorization code
+// flow with PKCE. The ID token of every exchange is verified against the
+// issuer's published keys, its audience and the login's nonce before its
+// subject is trusted; userinfo only supplies the profile and must name the
+// same subject. It is safe for concurrent use.
+type OIDC struct {
+	cfg  OIDCConfig
+	http *http.Client
+
+	mu        sync.Mutex
+	endpoints *endpoints
+	// keys are the issuer's signing keys by key ID, refetched when a token
+	// names a key not seen before
+	keys map[string]*rsa.PublicKey
+}
+
+// endpoints are the parts of the discovery document the provider uses
+type endpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDC returns a provider for cfg. Nothing is fetched until the first
+// call that needs the endpoints.
+func NewOIDC(cfg OIDCConfig) *OIDC {
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	if cfg.Scope == "" {
+		cfg.Scope = DefaultOIDCScope
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultOIDCTimeout
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: cfg.Timeout}
+	}
+	return &OIDC{cfg: cfg, http: httpClient}
+}
+
+func (p *OIDC) Name() string {
+	return p.cfg.Name
+}
+
+func (p *OIDC) AuthorizeURL(ctx context.Context, login Login) (string, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authorize, err := url.Parse(ep.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid authorization_endpoint: %w", p.cfg.Name, err)
+	}
+	query := authorize.Query()
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURI)
+	query.Set("response_type", "code")
+	query.Set("scope", p.cfg.Scope)
+	query.Set("state", login.State)
+	query.Set("nonce", login.Nonce)
+	query.Set("code_challenge", login.Challenge())
+	query.Set("code_challenge_method", "S256")
+	authorize.RawQuery = query.Encode()
+	return authorize.String(), nil
+}
+
+// Exchange fails unless the token response carries an ID token issued by
+// the issuer, for this client and for login
+func (p *OIDC) Exchange(ctx context.Context, code string, login Login) (*Token, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURI)
+	form.Set("code_verifier", login.Verifier)
+	resp, err := p.token(ctx, ep, form)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.IDToken == "" {
+		return nil, fmt.Errorf("%s token: no id_token in the response", p.cfg.Name)
+	}
+	resp.Subject, err = p.verifyIDToken(ctx, ep, resp.IDToken, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Token, nil
+}
+
+func (p *OIDC) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	resp, err := p.token(ctx, ep, form)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Token, nil
+}
+
+// tokenResponse is a token endpoint response
+type tokenResponse struct {
+	Token
+	IDToken string `json:"id_token"`
+}
+
+func (p *OIDC) token(ctx context.Context, ep *endpoints, form url.Values) (*tokenResponse, error) {
+
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("client_secret", p.cfg.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var resp tokenResponse
+	if err := p.do(req, "token", &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (p *OIDC) UserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var claims struct {
+		Subject           string `json:"sub"`
+		PreferredUsername string `json:"preferred_username"`
+		Name              string `json:"name"`
+		Email             string `json:"email"`
+	}
+	if err := p.do(req, "userinfo", &claims); err != nil {
+		return nil, err
+	}
+
+	info := &UserInfo{Subject: claims.Subject, Username: claims.PreferredUsername, Name: claims.Name, Email: claims.Email}
+	if info.Username == "" {
+		info.Username = claims.Email
+	}
+	return info, nil
+}
+
+// discover fetches the discovery document once. Failures are not cached,
+// so an issuer that was down is retried on the next call.
+func (p *OIDC) discover(ctx context.Context) (*endpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var ep endpoints
+	if err := p.do(req, "discovery", &ep); err != nil {
+		return nil, err
+	}
+
+	// The issuer must vouch for itself, or anyone able to answer for the
+	// URL could hand out endpoints
+	if strings.TrimRight(ep.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("%s discovery: issuer %q does not match %q", p.cfg.Name, ep.Issuer, p.cfg.Issuer)
+	}
+	if ep.AuthorizationEndpoint == "" || ep.TokenEndpoint == "" || ep.UserinfoEndpoint == "" || ep.JWKSURI == "" {
+		return nil, fmt.Errorf("%s discovery: authorization, token and userinfo endpoints and jwks_uri are required", p.cfg.Name)
+	}
+	p.endpoints = &ep
+	return p.endpoints, nil
+}
+
+// do sends req and decodes a 200 response into out, traced as one client
+// span
+func (p *OIDC) do(req *http.Request, endpoint string, out interface{}) (err error) {
+	ctx, span := tracer.Start(req.Context(), p.cfg.Name+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("identity.provider", p.cfg.Name),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", p.cfg.Name, endpoint, err)
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%s %s: reading response: %w", p.cfg.Name, endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &Error{Provider: p.cfg.Name, Endpoint: endpoint, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("%s %s: decoding response: %w", p.cfg.Name, endpoint, err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package identity_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/identity/oidctest"
+)
+
+const redirectURI = "http://localhost/api/v1/auth/acme/callback"
+
+func newProvider(t *testing.T) (*identity.OIDC, *oidctest.Server) {
+	t.Helper()
+
+	issuer := oidctest.NewServer("client", "secret")
+	t.Cleanup(issuer.Close)
+	provider := identity.NewOIDC(identity.OIDCConfig{
+		Name:         "acme",
+		Issuer:       issuer.URL + "/",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURI:  redirectURI,
+	})
+	return provider, issuer
+}
+
+// authorize follows an authorize URL through the issuer's consent page
+// and returns the code it redirects back with
+func authorize(t *testing.T, authorizeURL string) string {
+	t.Helper()
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(authorizeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code")
+}
+
+func newLogin(t *testing.T) identity.Login {
+	t.Helper()
+	login, err := identity.NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return login
+}
+
+func TestOIDCFlow(t *testing.T) {
+	ctx := context.Background()
+	provider, issuer := newProvider(t)
+	issuer.AddUser(oidctest.User{Subject: "248289761001", Name: "Jane Doe", Email: "jane@example.com"})
+
+	login := newLogin(t)
+	authorizeURL, err := provider.AuthorizeURL(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(authorizeURL)
+	query := parsed.Query()
+	if !strings.HasPrefix(authorizeURL, issuer.URL+"/authorize?") || query.Get("client_id") != "client" ||
+		query.Get("scope") != identity.DefaultOIDCScope || query.Get("redirect_uri") != redirectURI || query.Get("state") != login.State ||
+		query.Get("nonce") != login.Nonce || query.Get("code_challenge") != login.Challenge() || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("AuthorizeURL = %s", authorizeURL)
+	}
+
+	token, err := provider.Exchange(ctx, authorize(t, authorizeURL), login)
+	if err != nil || token.AccessToken == "" || token.RefreshToken == "" || token.Subject != "248289761001" {
+		t.Fatalf("Exchange = %+v, %v", token, err)
+	}
+	info, err := provider.UserInfo(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a preferred_username the email stands in
+	want := identity.UserInfo{Subject: "248289761001", Username: "jane@example.com", Name: "Jane Doe", Email: "jane@example.com"}
+	if *info != want {
+		t.Errorf("UserInfo = %+v, want %+v", *info, want)
+	}
+
+	issuer.ExpireToken(token.AccessToken)
+	if _, err := provider.UserInfo(ctx, token.AccessToken); !identity.IsUnauthorized(err) {
+		t.Errorf("UserInfo with an expired token: err = %v, want a 401", err)
+	}
+	renewed, err := provider.Refresh(ctx, token.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.UserInfo(ctx, renewed.AccessToken); err != nil {
+		t.Errorf("UserInfo with the renewed token: %v", err)
+	}
+
+	if _, err := provider.Exchange(ctx, "used-up", login); identity.StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("Exchange of an unknown code: err = %v, want a 400", err)
+	}
+	if n := issuer.Requests(oidctest.RouteDiscovery); n != 1 {
+		t.Errorf("discovery was fetched %d times, want 1", n)
+	}
+	if n := issuer.Requests(oidctest.RouteJWKS); n != 1 {
+		t.Errorf("the signing keys were fetched %d times, want 1", n)
+	}
+}
+
+func TestOIDCVerifiesTheIDToken(t *testing.T) {
+	ctx := context.Background()
+	provider, issuer := newProvider(t)
+	issuer.AddUser(oidctest.User{Subject: "248289761001"})
+
+	tests := []struct {
+		name   string
+		script func()
+		want   string
+	}{
+		{"another audience", func() {
+			issuer.TamperIDToken(func(claims map[string]interface{}) { claims["aud"] = "someone-else" })
+		}, "not issued for this client"},
+		{"several audiences without this client as authorized party", func() {
+			issuer.TamperIDToken(func(claims map[string]interface{}) { claims["aud"] = []string{"client", "someone-else"} })
+		}, "authorized party"},
+		{"another issuer", func() {
+			issuer.TamperIDToken(func(claims map[string]interface{}) { claims["iss"] = "https://elsewhere.example" })
+		}, "issuer"},
+		{"expired", func() {
+			issuer.TamperIDToken(func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() })
+		}, "expired"},
+		{"another login's nonce", func() {
+			issuer.TamperIDToken(func(claims map[string]interface{}) { claims["nonce"] = "replayed" })
+		}, "nonce"},
+		{"signed with an unpublished key", func() {
+			issuer.ForgeIDToken()
+		}, "invalid signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := newLogin(t)
+			tt.script()
+			authorizeURL, err := provider.AuthorizeURL(ctx, login)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := provider.Exchange(ctx, authorize(t, authorizeURL), login); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Exchange: err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+
+	// A code issued without the login's nonce is not accepted either
+	if _, err := provider.Exchange(ctx, issuer.IssueCode("248289761001", redirectURI), newLogin(t)); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("Exchange without a nonce: err = %v", err)
+	}
+
+	// Nor is a code exchanged without the verifier of its PKCE challenge
+	login := newLogin(t)
+	authorizeURL, _ := provider.AuthorizeURL(ctx, login)
+	code := authorize(t, authorizeURL)
+	login.Verifier = newLogin(t).Verifier
+	if _, err := provider.Exchange(ctx, code, login); identity.StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("Exchange with another verifier: err = %v, want a 400", err)
+	}
+}
+
+func TestOIDCDiscoveryFailuresAreRetried(t *testing.T) {
+	provider, issuer := newProvider(t)
+	issuer.Fail(oidctest.RouteDiscovery, http.StatusServiceUnavailable)
+
+	if _, err := provider.AuthorizeURL(context.Background(), identity.Login{}); identity.StatusCode(err) != http.StatusServiceUnavailable {
+		t.Fatalf("AuthorizeURL while the issuer is down: err = %v", err)
+	}
+	if _, err := provider.AuthorizeURL(context.Background(), identity.Login{}); err != nil {
+		t.Errorf("AuthorizeURL once the issuer is back: %v", err)
+	}
+}
+
+func TestOIDCRejectsAMismatchedIssuer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"issuer": "https://elsewhere.example", "authorization_endpoint": "https://elsewhere.example/a",
+			"token_endpoint": "https://elsewhere.example/t", "userinfo_endpoint": "https://elsewhere.example/u"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	provider := identity.NewOIDC(identity.OIDCConfig{Name: "acme", Issuer: server.URL})
+	if _, err := provider.AuthorizeURL(context.Background(), identity.Login{}); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("err = %v, want an issuer mismatch", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	acme := identity.NewOIDC(identity.OIDCConfig{Name: "acme"})
+	other := identity.NewOIDC(identity.OIDCConfig{Name: "other"})
+	registry := identity.NewRegistry(other, acme)
+
+	if p, ok := registry.Get("acme"); !ok || p != identity.Provider(acme) {
+		t.Errorf("Get(acme) = %v, %t", p, ok)
+	}
+	if _, ok := registry.Get("discord"); ok {
+		t.Error("Get found an unregistered provider")
+	}
+	if names := registry.Names(); len(names) != 2 || names[0] != "acme" || names[1] != "other" {
+		t.Errorf("Names = %v", names)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	identity.NewRegistry(acme, acme)
+}
@@ -0,0 +1,419 @@
+// Package oidctest provides an in-process fake OpenID Connect issuer for
+// tests. Point identity.OIDCConfig.Issuer at Server.URL.
+//
+// The fake serves discovery, the authorize page, the token endpoint
+// (authorization_code and refresh_token grants), its signing keys and
+// userinfo. Authorization codes are exchanged for an RS256 ID token
+// carrying the nonce of the authorize request, and only with the verifier
+// of its PKCE challenge. Tests script it by adding users, choosing who
+// consents and queueing failures per route, like discordtest.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Routes accepted by Fail and Requests
+const (
+	RouteDiscovery = "GET /.well-known/openid-configuration"
+	RouteAuthorize = "GET /authorize"
+	RouteToken     = "POST /token"
+	RouteUserinfo  = "GET /userinfo"
+	RouteJWKS      = "GET /jwks"
+)
+
+const (
+	tokenLifetime = 3600
+	keyID         = "oidctest"
+)
+
+// User is an account at the issuer, with the claims userinfo returns
+type User struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Name              string `json:"name,omitempty"`
+	Email             string `json:"email,omitempty"`
+}
+
+// Server is a fake OIDC issuer. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	mu            sync.Mutex
+	users         map[string]User
+	consenting    string
+	codes         map[string]grant
+	accessTokens  map[string]string
+	refreshTokens map[string]string
+	failures      map[string][]int
+	requests      map[string]int
+
+	key    *rsa.PrivateKey
+	tamper []func(claims map[string]interface{})
+	forge  int
+}
+
+type grant struct {
+	subject     string
+	redirectURI string
+	nonce       string
+	// challenge is the S256 PKCE challenge of the authorize request
+	challenge string
+}
+
+// NewServer starts a fake issuer accepting the given client credentials
+func NewServer(clientID, clientSecret string) *Server {
+	s := &Server{
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		users:         map[string]User{},
+		codes:         map[string]grant{},
+		accessTokens:  map[string]string{},
+		refreshTokens: map[string]string{},
+		failures:      map[string][]int{},
+		requests:      map[string]int{},
+		key:           signingKey(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.route(RouteDiscovery, s.discovery))
+	mux.HandleFunc("/authorize", s.route(RouteAuthorize, s.authorize))
+	mux.HandleFunc("/token", s.route(RouteToken, s.token))
+	mux.HandleFunc("/userinfo", s.route(RouteUserinfo, s.userinfo))
+	mux.HandleFunc("/jwks", s.route(RouteJWKS, s.jwks))
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddUser registers an account. The first user added consents on the
+// authorize page until SetConsentingUser picks another.
+func (s *Server) AddUser(user User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.Subject] = user
+	if s.consenting == "" {
+		s.consenting = user.Subject
+	}
+}
+
+// SetConsentingUser chooses the account that approves the next authorize
+// request
+func (s *Server) SetConsentingUser(subject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consenting = subject
+}
+
+// IssueCode returns an authorization code for subject, skipping the
+// authorize redirect. Its ID token carries no nonce, so clients that check
+// one reject it.
+func (s *Server) IssueCode(subject, redirectURI string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := randomString()
+	s.codes[code] = grant{subject: subject, redirectURI: redirectURI}
+	return code
+}
+
+// IssueTokenPair returns a valid access token and refresh token for
+// subject, as a completed authorization would
+func (s *Server) IssueTokenPair(subject string) (access, refresh string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issue(subject)
+}
+
+// TamperIDToken changes the claims of the next ID token issued, before it
+// is signed
+func (s *Server) TamperIDToken(tamper func(claims map[string]interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tamper = append(s.tamper, tamper)
+}
+
+// ForgeIDToken signs the next ID token issued with a key the issuer does
+// not publish
+func (s *Server) ForgeIDToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forge++
+}
+
+// ExpireToken invalidates an access token, leaving refresh tokens usable
+func (s *Server) ExpireToken(access string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.accessTokens, access)
+}
+
+// Fail queues failure statuses for route; each request to route consumes
+// one
+func (s *Server) Fail(route string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[route] = append(s.failures[route], statuses...)
+}
+
+// Requests returns how many requests route has received
+func (s *Server) Requests(route string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[route]
+}
+
+func (s *Server) issue(subject string) (access, refresh string) {
+	access, refresh = randomString(), randomString()
+	s.accessTokens[access] = subject
+	s.refreshTokens[refresh] = subject
+	return access, refresh
+}
+
+// route counts requests, enforces the method and serves queued failures
+func (s *Server) route(name string, next http.HandlerFunc) http.HandlerFunc {
+	method, _, _ := strings.Cut(name, " ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, "invalid_request")
+			return
+		}
+
+		s.mu.Lock()
+		s.requests[name]++
+		status := 0
+		if queued := s.failures[name]; len(queued) > 0 {
+			status = queued[0]
+			s.failures[name] = queued[1:]
+		}
+		s.mu.Unlock()
+
+		if status != 0 {
+			writeError(w, status, "server_error")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"userinfo_endpoint":      s.URL + "/userinfo",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	s.mu.Lock()
+	subject := s.consenting
+	code := randomString()
+	s.codes[code] = grant{
+		subject:     subject,
+		redirectURI: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+	}
+	s.mu.Unlock()
+
+	params := redirect.Query()
+	if subject == "" {
+		params.Set("error", "access_denied")
+	} else {
+		params.Set("code", code)
+	}
+	if state := query.Get("state"); state != "" {
+		params.Set("state", state)
+	}
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("client_id") != s.ClientID || r.PostForm.Get("client_secret") != s.ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subject string
+	var g grant
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		var ok bool
+		g, ok = s.codes[r.PostForm.Get("code")]
+		if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") || !verifies(g.challenge, r.PostForm.Get("code_verifier")) {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(s.codes, r.PostForm.Get("code"))
+		subject = g.subject
+	case "refresh_token":
+		owner, ok := s.refreshTokens[r.PostForm.Get("refresh_token")]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(s.refreshTokens, r.PostForm.Get("refresh_token"))
+		subject = owner
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	access, refresh := s.issue(subject)
+	writeJSON(w, map[string]interface{}{
+		"access_token":  access,
+		"token_type":    "Bearer",
+		"expires_in":    tokenLifetime,
+		"refresh_token": refresh,
+		"scope":         "openid profile email",
+		"id_token":      s.idToken(subject, g.nonce),
+	})
+}
+
+// idToken signs an ID token for subject. The caller holds s.mu.
+func (s *Server) idToken(subject, nonce string) string {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": s.URL,
+		"sub": subject,
+		"aud": s.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(tokenLifetime * time.Second).Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if len(s.tamper) > 0 {
+		s.tamper[0](claims)
+		s.tamper = s.tamper[1:]
+	}
+	key := s.key
+	if s.forge > 0 {
+		key = generateKey()
+		s.forge--
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (s *Server) userinfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	user, found := s.users[s.accessTokens[token]]
+	s.mu.Unlock()
+
+	if !ok || !found {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	writeJSON(w, user)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// verifies reports whether verifier matches an S256 PKCE challenge. Codes
+// issued without a challenge need no verifier.
+func verifies(challenge, verifier string) bool {
+	if challenge == "" {
+		return true
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+var (
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+)
+
+// signingKey returns the key every fake issuer signs with, generated once
+// to keep tests fast
+func signingKey() *rsa.PrivateKey {
+	keyOnce.Do(func() { key = generateKey() })
+	return key
+}
+
+func generateKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,231 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord/discordtest"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+func TestAccountPreferences(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedUser(nelly)
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: token})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var account handlers.Account
+	resp.JSON(t, &account)
+	want := handlers.Preferences{DefaultPrivate: false, Timezone: "UTC", Language: "en"}
+	if account.ID != nelly.ID || account.Preferences != want {
+		t.Errorf("account = %+v", account)
+	}
+
+	private, timezone, language := true, "Europe/Berlin", "pt-BR"
+	resp = h.Do(apptest.Request{Method: http.MethodPatch, Path: "/api/v1/me", Bearer: token, Body: handlers.PatchAccountRequest{
+		Preferences: handlers.PreferencesPatch{DefaultPrivate: &private, Timezone: &timezone, Language: &language},
+	}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &account)
+	want = handlers.Preferences{DefaultPrivate: true, Timezone: timezone, Language: language}
+	if account.Preferences != want {
+		t.Errorf("patched preferences = %+v, want %+v", account.Preferences, want)
+	}
+
+	// Summaries created without a visibility follow the preference
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: token, Body: handlers.CreateSummaryRequest{
+		Content: "Defaults apply.", ServerID: serverID,
+	}})
+	var created handlers.CreateSummaryResponse
+	resp.JSON(t, &created)
+	public := false
+	h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: token, Body: handlers.CreateSummaryRequest{
+		Content: "Explicitly public.", ServerID: serverID, IsPrivate: &public,
+	}})
+
+	summaries, err := h.Summaries.GetSummaries(context.Background(), nelly.ID)
+	if err != nil || len(summaries) != 2 {
+		t.Fatalf("summaries = %+v, %v", summaries, err)
+	}
+	for _, summary := range summaries {
+		if wantPrivate := summary.ID == created.SummaryID; summary.IsPrivate != wantPrivate {
+			t.Errorf("summary %q: is_private = %t, want %t", summary.Content, summary.IsPrivate, wantPrivate)
+		}
+	}
+}
+
+func TestAccountErrors(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedUser(nelly)
+	h.Discord.AddUser(otto)
+	stranger := h.Discord.IssueToken(otto.ID)
+
+	badTimezone, badLanguage := "Mars/Olympus_Mons", "not a language"
+	tests := []struct {
+		name   string
+		req    apptest.Request
+		status int
+		want   apperror.Code
+	}{
+		{"no token", apptest.Request{Method: http.MethodGet, Path: "/api/v1/me"}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"never logged in", apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: stranger}, http.StatusNotFound, apperror.CodeUserNotFound},
+		{"export never logged in", apptest.Request{Method: http.MethodGet, Path: "/api/v1/me/export", Bearer: stranger}, http.StatusNotFound, apperror.CodeUserNotFound},
+		{"delete never logged in", apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me", Bearer: stranger}, http.StatusNotFound, apperror.CodeUserNotFound},
+		{"empty patch", apptest.Request{Method: http.MethodPatch, Path: "/api/v1/me", Bearer: token, Body: `{"preferences": {}}`}, http.StatusBadRequest, apperror.CodeBadRequest},
+		{"unknown time zone", apptest.Request{Method: http.MethodPatch, Path: "/api/v1/me", Bearer: token, Body: handlers.PatchAccountRequest{
+			Preferences: handlers.PreferencesPatch{Timezone: &badTimezone},
+		}}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"malformed language", apptest.Request{Method: http.MethodPatch, Path: "/api/v1/me", Bearer: token, Body: handlers.PatchAccountRequest{
+			Preferences: handlers.PreferencesPatch{Language: &badLanguage},
+		}}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectProblem(t, h.Do(tt.req), tt.status, tt.want)
+		})
+	}
+}
+
+func TestAccountExport(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedUser(nelly)
+	summaryID := h.SeedSummary(nelly.ID, serverID, true, "Exported.")
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me/export", Bearer: token})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("export returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if got := resp.Header.Get("Content-Disposition"); !strings.HasPrefix(got, "attachment") {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if strings.Contains(string(resp.Body), token) {
+		t.Error("the export contains the access token")
+	}
+
+	var export handlers.AccountExport
+	resp.JSON(t, &export)
+	if export.Account.ID != nelly.ID || export.Token.TokenType != "Bearer" || export.ExportedAt == "" {
+		t.Errorf("export = %+v", export)
+	}
+	if len(export.Summaries) != 1 || export.Summaries[0].SummaryID != summaryID {
+		t.Errorf("exported summaries = %+v", export.Summaries)
+	}
+	if export.AuditEvents == nil {
+		t.Error("audit_events is null, want an empty array")
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	h := apptest.New(t)
+	ctx := context.Background()
+
+	// Log in for real so that both an access and a refresh token are stored
+	h.Discord.AddUser(nelly)
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + discordCallback(t, h)}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback returned %d", resp.StatusCode)
+	}
+	user, err := h.Users.FindUserByID(ctx, nelly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SeedSummary(nelly.ID, serverID, true, "Also mine.")
+	key := createKey(t, h, user.Token.AccessToken, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesWrite}})
+	if resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: withKey(key.Key), Body: handlers.CreateSummaryRequest{
+		ServerID: serverID, Content: "Mine.",
+	}}); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	ottoBearer := h.SeedUser(otto)
+	if resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: ottoBearer, Body: handlers.CreateSummaryRequest{
+		ServerID: serverID, Content: "Not mine.",
+	}}); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Otto's create returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	resp := h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me", Bearer: user.Token.AccessToken})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	if h.Discord.Valid(user.Token.AccessToken) || h.Discord.Valid(user.Token.RefreshToken) {
+		t.Error("the Discord grant was not revoked")
+	}
+	if _, err := h.Users.FindUserByID(ctx, nelly.ID); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("user lookup after deletion: err = %v", err)
+	}
+	if summaries, _ := h.Summaries.GetSummaries(ctx, nelly.ID); len(summaries) != 0 {
+		t.Errorf("summaries left behind: %+v", summaries)
+	}
+	if summaries, _ := h.Summaries.GetSummaries(ctx, otto.ID); len(summaries) != 1 {
+		t.Errorf("another user's summaries were touched: %+v", summaries)
+	}
+
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Target: models.AuditUser(user.UUID), Action: models.AuditAccountDeleted})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("audit events = %+v, %v", events, err)
+	}
+	event := events[0]
+	if event.Action != models.AuditAccountDeleted || event.Details["summaries_deleted"] != "2" || event.Details["discord_revoked"] != "true" {
+		t.Errorf("audit event = %+v", event)
+	}
+	if strings.Contains(strings.Join([]string{event.Actor, event.Target}, " "), nelly.ID) {
+		t.Errorf("the audit event identifies the user by Discord ID: %+v", event)
+	}
+	if event.IP == "" {
+		t.Errorf("the deletion event lost the client that asked for it: %+v", event)
+	}
+
+	// Everything else the user or their key did is kept without its client
+	for _, actor := range []string{models.AuditUser(user.UUID), models.AuditAPIKey(key.KeyID)} {
+		events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Actor: actor})
+		if err != nil || len(events) == 0 {
+			t.Fatalf("events by %s = %+v, %v", actor, events, err)
+		}
+		for _, event := range events {
+			if event.Action != models.AuditAccountDeleted && (event.IP != "" || event.UserAgent != "") {
+				t.Errorf("event by %s kept its client: %+v", actor, event)
+			}
+		}
+	}
+	ottoUser, err := h.Users.FindUserByID(ctx, otto.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Actor: models.AuditUser(ottoUser.UUID)}); err != nil || len(events) != 1 || events[0].IP == "" {
+		t.Errorf("Otto's events = %+v, %v", events, err)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: user.Token.AccessToken})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+}
+
+func TestAccountDeletionWhenDiscordIsDown(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedUser(nelly)
+	h.Discord.Fail(discordtest.RouteRevoke, discordtest.Failure{Status: http.StatusInternalServerError})
+
+	resp := h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me", Bearer: token})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if _, err := h.Users.FindUserByID(context.Background(), nelly.ID); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("user lookup after deletion: err = %v", err)
+	}
+
+	events, err := h.Audit.ListEvents(context.Background(), models.AuditFilter{})
+	if err != nil || len(events) != 1 || events[0].Details["discord_revoked"] != "false" {
+		t.Errorf("audit events = %+v, %v", events, err)
+	}
+}
@@ -0,0 +1,272 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord/discordtest"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/models"
+)
+
+func TestAdminSearchesUsersAndTheirSummaries(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{AdminIDs: []string{otto.ID}})
+	h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+	h.SeedSummary(nelly.ID, serverID, false, "Public.")
+	h.SeedSummary(nelly.ID, serverID, true, "Private.")
+	h.SeedSummary(otto.ID, otherServerID, false, "Elsewhere.")
+
+	var users []handlers.AdminUser
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users?q=NEL", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("search returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &users)
+	if len(users) != 1 || users[0].ID != nelly.ID || users[0].Disabled || !users[0].LoggedIn {
+		t.Errorf("searching NEL found %+v", users)
+	}
+	h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users?q=" + otto.ID, Bearer: ottoBearer}).JSON(t, &users)
+	if len(users) != 1 || users[0].Username != otto.Username {
+		t.Errorf("searching otto's ID found %+v", users)
+	}
+	h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users?limit=1", Bearer: ottoBearer}).JSON(t, &users)
+	if len(users) != 1 || users[0].ID != nelly.ID {
+		t.Errorf("the first user by username is %+v, want nelly", users)
+	}
+
+	var summaries []handlers.Summary
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users/" + nelly.ID + "/summaries", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("summaries returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &summaries)
+	private := 0
+	for _, summary := range summaries {
+		if summary.IsPrivate {
+			private++
+		}
+	}
+	if len(summaries) != 2 || private != 1 {
+		t.Errorf("nelly's summaries = %+v, want both including the private one", summaries)
+	}
+
+	var stats []handlers.ServerStats
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/servers", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("server stats returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &stats)
+	if len(stats) != 2 {
+		t.Fatalf("server stats = %+v", stats)
+	}
+	if s := stats[0]; s.ServerID != serverID || s.Summaries != 2 || s.Private != 1 || s.Authors != 1 || s.LastUpdatedAt == "" {
+		t.Errorf("stats of %s = %+v", serverID, s)
+	}
+	if s := stats[1]; s.ServerID != otherServerID || s.Summaries != 1 || s.Private != 0 || s.Authors != 1 {
+		t.Errorf("stats of %s = %+v", otherServerID, s)
+	}
+}
+
+func TestDisabledUserIsRejected(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{AdminIDs: []string{otto.ID}})
+	nellyBearer := h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+	key := createKey(t, h, nellyBearer, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesRead}})
+	ctx := context.Background()
+
+	var user handlers.AdminUser
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/users/" + nelly.ID + "/disable", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("disable returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &user)
+	if !user.Disabled {
+		t.Errorf("disabled user = %+v", user)
+	}
+
+	rejected := []apptest.Request{
+		{Method: http.MethodGet, Path: "/api/v1/me", Bearer: nellyBearer},
+		{Method: http.MethodGet, Path: "/api/v1/auth/status", Bearer: nellyBearer},
+		{Method: http.MethodGet, Path: "/api/v1/summaries", Bearer: nellyBearer},
+		{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(key.Key)},
+		{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: nellyBearer, Body: handlers.CreateSummaryRequest{ServerID: serverID, Content: "Blocked."}},
+	}
+	for _, req := range rejected {
+		expectProblem(t, h.Do(req), http.StatusForbidden, apperror.CodeAccountDisabled)
+	}
+
+	// Logging in again is refused, and the grant it obtained is revoked
+	// rather than stored
+	revokes := h.Discord.Requests(discordtest.RouteRevoke)
+	h.Discord.SetConsentingUser(nelly.ID)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + discordCallback(t, h)}), http.StatusForbidden, apperror.CodeAccountDisabled)
+	if h.Discord.Requests(discordtest.RouteRevoke) == revokes {
+		t.Error("the refused login's grant was not revoked")
+	}
+	if stored, err := h.Users.FindUserByID(ctx, nelly.ID); err != nil || stored.Token.AccessToken != nellyBearer {
+		t.Errorf("stored token after a refused login = %+v, %v", stored, err)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/users/" + nelly.ID + "/enable", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("enable returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &user)
+	if user.Disabled {
+		t.Errorf("enabled user = %+v", user)
+	}
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: nellyBearer}); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /me after enabling returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	admin, err := h.Users.FindUserByID(ctx, otto.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Actor: models.AuditUser(admin.UUID)})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("audit events = %+v, %v", events, err)
+	}
+	if events[0].Action != models.AuditUserEnabled || events[1].Action != models.AuditUserDisabled || events[1].Target != models.AuditUser(user.UUID) {
+		t.Errorf("audit events = %+v", events)
+	}
+}
+
+func TestAdminRevokesTokens(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{AdminIDs: []string{otto.ID}})
+	token := h.SeedLinkedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+	key := createKey(t, h, token.AccessToken, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesRead}})
+	ctx := context.Background()
+	path := "/api/v1/admin/users/" + nelly.ID + "/revoke-tokens"
+
+	// A failed Discord revocation keeps the stored token to retry with, but
+	// the API keys are gone already
+	down := discordtest.Failure{Status: http.StatusInternalServerError}
+	h.Discord.Fail(discordtest.RouteRevoke, down, down)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPost, Path: path, Bearer: ottoBearer}), http.StatusBadGateway, apperror.CodeUpstream)
+	if stored, err := h.Users.FindUserByID(ctx, nelly.ID); err != nil || stored.Token != token {
+		t.Fatalf("stored token after a failed revocation = %+v, %v", stored, err)
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(key.Key)}), http.StatusUnauthorized, apperror.CodeUnauthorized)
+
+	var revoked handlers.RevokedTokens
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: path, Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("revoke returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &revoked)
+	if revoked != (handlers.RevokedTokens{DiscordRevoked: true}) {
+		t.Errorf("retried revocation = %+v", revoked)
+	}
+	if h.Discord.Valid(token.AccessToken) || h.Discord.Valid(token.RefreshToken) {
+		t.Error("the Discord grant was not revoked")
+	}
+	if stored, err := h.Users.FindUserByID(ctx, nelly.ID); err != nil || stored.Token != (models.Token{}) {
+		t.Errorf("stored token after revocation = %+v, %v", stored, err)
+	}
+
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Action: models.AuditTokensRevoked})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("audit events = %+v, %v", events, err)
+	}
+	if failed := events[1]; failed.Details["api_keys"] != "1" || failed.Details["discord"] != "false" {
+		t.Errorf("event of the failed revocation = %+v", failed)
+	}
+	if retried := events[0]; retried.Details["api_keys"] != "0" || retried.Details["discord"] != "true" {
+		t.Errorf("event of the retried revocation = %+v", retried)
+	}
+}
+
+func TestAdminRunsMaintenance(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{AdminIDs: []string{otto.ID}})
+	ottoBearer := h.SeedUser(otto)
+	h.SeedSummary(otto.ID, serverID, false, "Kept.")
+	ctx := context.Background()
+
+	// Left behind by a user deleted by hand, well before the purge
+	orphan := &models.Summary{
+		ID:        uuid.New().String(),
+		UserID:    nelly.ID,
+		ServerID:  serverID,
+		Content:   "Orphaned.",
+		CreatedAt: time.Now().Add(-time.Hour),
+	}
+	if err := h.Summaries.AddSummary(ctx, orphan); err != nil {
+		t.Fatal(err)
+	}
+
+	var summaries []handlers.Summary
+	h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users/" + nelly.ID + "/summaries", Bearer: ottoBearer}).JSON(t, &summaries)
+	if len(summaries) != 1 || summaries[0].SummaryID != orphan.ID {
+		t.Errorf("summaries of the deleted user = %+v", summaries)
+	}
+
+	var result handlers.MaintenanceResult
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/maintenance/purge-orphans", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("purge returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &result)
+	if result.Task != "purge-orphans" || result.Purged == nil || *result.Purged != (models.Orphans{Summaries: 1}) {
+		t.Errorf("purge result = %+v", result)
+	}
+	if list, err := h.Summaries.GetSummaries(ctx, otto.ID); err != nil || len(list) != 1 {
+		t.Errorf("otto's summaries after the purge = %+v, %v", list, err)
+	}
+
+	result = handlers.MaintenanceResult{}
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/maintenance/reindex", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("reindex returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp.JSON(t, &result)
+	if result.Task != "reindex" || result.Purged != nil {
+		t.Errorf("reindex result = %+v", result)
+	}
+
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Target: models.AuditMaintenance("purge-orphans")})
+	if err != nil || len(events) != 1 || events[0].Action != models.AuditMaintenanceRun || events[0].Details["summaries"] != "1" {
+		t.Errorf("audit events = %+v, %v", events, err)
+	}
+}
+
+func TestAdminRoutesAreRestricted(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{AdminIDs: []string{otto.ID}})
+	nellyBearer := h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+	stranger := "80351110224678999"
+
+	tests := []struct {
+		name   string
+		req    apptest.Request
+		status int
+		code   apperror.Code
+	}{
+		{"search without a token", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users"}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"search as a user", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"summaries as a user", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users/" + nelly.ID + "/summaries", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"disable as a user", apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/users/" + otto.ID + "/disable", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"enable as a user", apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/users/" + nelly.ID + "/enable", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"revoke as a user", apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/users/" + otto.ID + "/revoke-tokens", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"server stats as a user", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/servers", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"maintenance as a user", apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/maintenance/reindex", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"disable an admin", apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/users/" + otto.ID + "/disable", Bearer: ottoBearer}, http.StatusBadRequest, apperror.CodeBadRequest},
+		{"disable an unknown user", apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/users/" + stranger + "/disable", Bearer: ottoBearer}, http.StatusNotFound, apperror.CodeUserNotFound},
+		{"revoke for an unknown user", apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/users/" + stranger + "/revoke-tokens", Bearer: ottoBearer}, http.StatusNotFound, apperror.CodeUserNotFound},
+		{"malformed user ID", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users/nelly/summaries", Bearer: ottoBearer}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"limit too high", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/users?limit=5000", Bearer: ottoBearer}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"unknown task", apptest.Request{Method: http.MethodPost, Path: "/api/v1/admin/maintenance/vacuum", Bearer: ottoBearer}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectProblem(t, h.Do(tt.req), tt.status, tt.code)
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/apikey"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+const otherServerID = "613425648685547541"
+
+// createKey creates an API key through the API and returns it
+func createKey(t *testing.T, h *apptest.Harness, bearer string, body handlers.CreateAPIKeyRequest) handlers.CreatedAPIKey {
+	t.Helper()
+
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/me/api-keys", Bearer: bearer, Body: body})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating a key returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var created handlers.CreatedAPIKey
+	resp.JSON(t, &created)
+	return created
+}
+
+func withKey(key string) map[string]string {
+	return map[string]string{handlers.APIKeyHeader: key}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	h.SeedUser(otto)
+	ctx := context.Background()
+
+	created := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{
+		Name:   "summary bot",
+		Scopes: []string{models.ScopeSummariesWrite, models.ScopeSummariesRead, models.ScopeSummariesRead},
+	})
+	if prefix, ok := apikey.Prefix(created.Key); !ok || prefix != created.Prefix {
+		t.Errorf("key %q does not carry its prefix %q", created.Key, created.Prefix)
+	}
+	if created.Kind != models.APIKeyUser || len(created.Scopes) != 2 || len(created.ServerIDs) != 0 || created.LastUsedAt != nil {
+		t.Errorf("created key = %+v", created.APIKey)
+	}
+	stored, err := h.APIKeys.FindAPIKeyByPrefix(ctx, created.Prefix)
+	if err != nil || stored.Hash == created.Key || !apikey.Verify(created.Key, stored.Hash) {
+		t.Errorf("stored key = %+v, %v", stored, err)
+	}
+
+	// A user key acts as its creator without naming them
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: withKey(created.Key), Body: handlers.CreateSummaryRequest{
+		Content:  "Shipped on Friday.",
+		ServerID: serverID,
+	}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("creating a summary with the key returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(created.Key)})
+	var list []handlers.Summary
+	resp.JSON(t, &list)
+	if resp.StatusCode != http.StatusOK || len(list) != 1 || list[0].UserID != nelly.ID {
+		t.Errorf("list with the key = %d %+v", resp.StatusCode, list)
+	}
+
+	// ...and only as them
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", UserID: otto.ID, Headers: withKey(created.Key)})
+	expectProblem(t, resp, http.StatusForbidden, apperror.CodeForbidden)
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: withKey(created.Key), Body: handlers.CreateSummaryRequest{
+		Content: "Not Nelly's.", ServerID: serverID, UserID: otto.ID,
+	}})
+	expectProblem(t, resp, http.StatusForbidden, apperror.CodeForbidden)
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me/api-keys", Bearer: bearer})
+	var keys []handlers.APIKey
+	resp.JSON(t, &keys)
+	if resp.StatusCode != http.StatusOK || len(keys) != 1 || keys[0].KeyID != created.KeyID || keys[0].LastUsedAt == nil {
+		t.Fatalf("keys = %d %+v", resp.StatusCode, keys)
+	}
+	if strings.Contains(string(resp.Body), created.Key) {
+		t.Error("the listing reveals the key")
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me/api-keys/" + created.KeyID, Bearer: bearer})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("revoking returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(created.Key)})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+	resp = h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me/api-keys/" + created.KeyID, Bearer: bearer})
+	expectProblem(t, resp, http.StatusNotFound, apperror.CodeAPIKeyNotFound)
+
+	account, err := h.Users.FindUserByID(ctx, nelly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Target: models.AuditAPIKey(created.KeyID)})
+	if err != nil || len(events) != 2 || events[0].Action != models.AuditAPIKeyRevoked || events[1].Action != models.AuditAPIKeyCreated || events[1].Actor != models.AuditUser(account.UUID) {
+		t.Errorf("audit events = %+v, %v", events, err)
+	}
+}
+
+func TestAPIKeyScopesAndServers(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	here := h.SeedSummary(nelly.ID, serverID, false, "Here.")
+	elsewhere := h.SeedSummary(nelly.ID, otherServerID, false, "Elsewhere.")
+
+	reader := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{
+		Name:      "reader",
+		Scopes:    []string{models.ScopeSummariesRead},
+		ServerIDs: []string{serverID},
+	})
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(reader.Key)})
+	var list []handlers.Summary
+	resp.JSON(t, &list)
+	if resp.StatusCode != http.StatusOK || len(list) != 1 || list[0].SummaryID != here {
+		t.Errorf("list = %d %+v, want only the summary in the key's server", resp.StatusCode, list)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries/" + elsewhere, Headers: withKey(reader.Key)})
+	expectProblem(t, resp, http.StatusNotFound, apperror.CodeSummaryNotFound)
+
+	// Reading does not allow writing
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: withKey(reader.Key), Body: handlers.CreateSummaryRequest{
+		Content: "Nope.", ServerID: serverID,
+	}})
+	expectProblem(t, resp, http.StatusForbidden, apperror.CodeForbidden)
+	resp = h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/summaries/" + here, Headers: withKey(reader.Key)})
+	expectProblem(t, resp, http.StatusForbidden, apperror.CodeForbidden)
+
+	writer := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{
+		Name:      "writer",
+		Scopes:    []string{models.ScopeSummariesWrite},
+		ServerIDs: []string{serverID},
+	})
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: withKey(writer.Key), Body: handlers.CreateSummaryRequest{
+		Content: "Elsewhere.", ServerID: otherServerID,
+	}})
+	expectProblem(t, resp, http.StatusForbidden, apperror.CodeForbidden)
+	moved := otherServerID
+	resp = h.Do(apptest.Request{Method: http.MethodPatch, Path: "/api/v1/summaries/" + here, Headers: withKey(writer.Key), Body: handlers.PatchSummaryRequest{
+		ServerID: &moved,
+	}})
+	expectProblem(t, resp, http.StatusForbidden, apperror.CodeForbidden)
+	resp = h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/summaries/" + elsewhere, Headers: withKey(writer.Key)})
+	expectProblem(t, resp, http.StatusNotFound, apperror.CodeSummaryNotFound)
+	if resp := h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/summaries/" + here, Headers: withKey(writer.Key)}); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("deleting in the key's server returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	// Malformed, unknown and tampered keys are all rejected alike
+	for _, key := range []string{"not a key", "ucb_000000000000_secret", writer.Key + "x"} {
+		resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(key)})
+		expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/me/api-keys", Bearer: bearer, Body: handlers.CreateAPIKeyRequest{
+		Name: "bad", Kind: "bot", Scopes: []string{"summaries:admin"},
+	}})
+	expectProblem(t, resp, http.StatusUnprocessableEntity, apperror.CodeValidation)
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	h.Discord.AddUser(nelly,
+		discord.Guild{ID: serverID, Name: "Managed", Permissions: "32"},
+		discord.Guild{ID: otherServerID, Name: "Joined", Permissions: "1024"},
+	)
+	h.SeedUser(otto)
+	h.SeedSummary(otto.ID, serverID, false, "Otto here.")
+	h.SeedSummary(otto.ID, otherServerID, false, "Otto elsewhere.")
+
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/me/api-keys", Bearer: bearer, Body: handlers.CreateAPIKeyRequest{
+		Name: "server bot", Kind: models.APIKeyServer, Scopes: []string{models.ScopeSummariesRead},
+	}})
+	expectProblem(t, resp, http.StatusUnprocessableEntity, apperror.CodeValidation)
+
+	// Nelly is only a member of the other server
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/me/api-keys", Bearer: bearer, Body: handlers.CreateAPIKeyRequest{
+		Name: "server bot", Kind: models.APIKeyServer, Scopes: []string{models.ScopeSummariesRead}, ServerIDs: []string{serverID, otherServerID},
+	}})
+	expectProblem(t, resp, http.StatusForbidden, apperror.CodeForbidden)
+
+	key := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{
+		Name:      "server bot",
+		Kind:      models.APIKeyServer,
+		Scopes:    []string{models.ScopeSummariesRead, models.ScopeSummariesWrite},
+		ServerIDs: []string{serverID},
+	})
+
+	// A server key acts for whoever it names, in its servers only
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", UserID: otto.ID, Headers: withKey(key.Key)})
+	var list []handlers.Summary
+	resp.JSON(t, &list)
+	if resp.StatusCode != http.StatusOK || len(list) != 1 || list[0].ServerID != serverID {
+		t.Errorf("Otto's summaries through the server key = %d %+v", resp.StatusCode, list)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: withKey(key.Key), Body: handlers.CreateSummaryRequest{
+		Content: "For Otto.", ServerID: serverID, UserID: otto.ID,
+	}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("creating for Otto returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: withKey(key.Key), Body: handlers.CreateSummaryRequest{
+		Content: "For Otto.", ServerID: otherServerID, UserID: otto.ID,
+	}})
+	expectProblem(t, resp, http.StatusForbidden, apperror.CodeForbidden)
+
+	// It has no user of its own to fall back on
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(key.Key)})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: withKey(key.Key), Body: handlers.CreateSummaryRequest{
+		Content: "For nobody.", ServerID: serverID,
+	}})
+	expectProblem(t, resp, http.StatusUnprocessableEntity, apperror.CodeValidation)
+}
+
+func TestServerAPIKeysSkipOthersPrivateSummaries(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	h.Discord.AddUser(nelly, discord.Guild{ID: serverID, Name: "Managed", Permissions: "32"})
+	h.SeedUser(otto)
+	public := h.SeedSummary(otto.ID, serverID, false, "Otto's public notes.")
+	private := h.SeedSummary(otto.ID, serverID, true, "Otto's private notes.")
+	own := h.SeedSummary(nelly.ID, serverID, true, "Nelly's private notes.")
+	key := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{
+		Name:      "server bot",
+		Kind:      models.APIKeyServer,
+		Scopes:    []string{models.ScopeSummariesRead, models.ScopeSummariesWrite},
+		ServerIDs: []string{serverID},
+	})
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", UserID: otto.ID, Headers: withKey(key.Key)})
+	var list []handlers.Summary
+	resp.JSON(t, &list)
+	if resp.StatusCode != http.StatusOK || len(list) != 1 || list[0].SummaryID != public {
+		t.Errorf("Otto's summaries through the server key = %d %+v", resp.StatusCode, list)
+	}
+	path := "/api/v1/summaries/" + private
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: path, UserID: otto.ID, Headers: withKey(key.Key)}), http.StatusNotFound, apperror.CodeSummaryNotFound)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPatch, Path: path, UserID: otto.ID, Headers: withKey(key.Key), Body: `{"is_private": false}`}), http.StatusNotFound, apperror.CodeSummaryNotFound)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodDelete, Path: path, UserID: otto.ID, Headers: withKey(key.Key)}), http.StatusNotFound, apperror.CodeSummaryNotFound)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPut, Path: "/update-summary", UserID: otto.ID, Headers: withKey(key.Key), Body: handlers.UpdateSummaryRequest{
+		SummaryID: private, ServerID: serverID, IsPrivate: true, Content: "Overwritten.",
+	}}), http.StatusNotFound, apperror.CodeSummaryNotFound)
+	if summary, err := h.Summaries.GetSummary(context.Background(), otto.ID, private); err != nil || summary.Content != "Otto's private notes." || !summary.IsPrivate {
+		t.Errorf("Otto's private summary = %+v, %v", summary, err)
+	}
+
+	// The key's creator still reaches their own
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries/" + own, UserID: nelly.ID, Headers: withKey(key.Key)})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Nelly's private summary through their server key returned %d: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestRequiredAPIKeys(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{RequireAPIKeys: true})
+	bearer := h.SeedUser(nelly)
+	h.SeedSummary(nelly.ID, serverID, false, "Mine.")
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", UserID: nelly.ID})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/summarizer", UserID: nelly.ID})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+
+	key := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesRead}})
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/summarizer", UserID: nelly.ID, Headers: withKey(key.Key)})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("legacy list with a key returned %d: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestAccountDeletionRevokesAPIKeys(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	key := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesRead}})
+	account, err := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me/export", Bearer: bearer})
+	var export handlers.AccountExport
+	resp.JSON(t, &export)
+	if len(export.APIKeys) != 1 || export.APIKeys[0].KeyID != key.KeyID {
+		t.Errorf("exported keys = %+v", export.APIKeys)
+	}
+
+	if resp := h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me", Bearer: bearer}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if _, err := h.APIKeys.FindAPIKeyByPrefix(context.Background(), key.Prefix); !errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		t.Errorf("key lookup after deletion: err = %v", err)
+	}
+	events, err := h.Audit.ListEvents(context.Background(), models.AuditFilter{Target: models.AuditUser(account.UUID)})
+	if err != nil || len(events) != 1 || events[0].Details["api_keys_revoked"] != "1" {
+		t.Errorf("audit events = %+v, %v", events, err)
+	}
+}
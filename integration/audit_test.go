@@ -0,0 +1,337 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+const botAgent = "ultra-chat-bot/2.1"
+
+func TestSummaryChangesAreAudited(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{TrustedProxies: loopbackProxy()})
+	nellyBearer := h.SeedUser(nelly)
+	ctx := context.Background()
+	user, err := h.Users.FindUserByID(ctx, nelly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := map[string]string{"User-Agent": botAgent, "X-Forwarded-For": "203.0.113.7"}
+
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: nellyBearer, Headers: agent, Body: handlers.CreateSummaryRequest{
+		ServerID: serverID, Content: "First draft.",
+	}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var created handlers.CreateSummaryResponse
+	resp.JSON(t, &created)
+
+	content := "Second draft."
+	resp = h.Do(apptest.Request{Method: http.MethodPatch, Path: "/api/v1/summaries/" + created.SummaryID, Bearer: nellyBearer, Headers: agent, Body: handlers.PatchSummaryRequest{Content: &content}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodPut, Path: "/update-summary", Bearer: nellyBearer, Headers: agent, Body: handlers.UpdateSummaryRequest{
+		SummaryID: created.SummaryID, ServerID: serverID, Content: "Final draft.",
+	}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	// An update that changes nothing leaves no event
+	resp = h.Do(apptest.Request{Method: http.MethodPut, Path: "/update-summary", Bearer: nellyBearer, Body: handlers.UpdateSummaryRequest{
+		SummaryID: created.SummaryID, ServerID: serverID, IsPrivate: true, Content: "Nothing to update.",
+	}})
+	expectProblem(t, resp, http.StatusNotFound, apperror.CodeSummaryNotFound)
+	resp = h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/summaries/" + created.SummaryID, Bearer: nellyBearer, Headers: agent})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Target: models.AuditSummary(created.SummaryID)})
+	if err != nil || len(events) != 4 {
+		t.Fatalf("audit events = %+v, %v", events, err)
+	}
+	// Oldest first, each change starts from the state the last one left
+	want := []string{models.AuditSummaryCreated, models.AuditSummaryUpdated, models.AuditSummaryUpdated, models.AuditSummaryDeleted}
+	previous := ""
+	for i, action := range want {
+		event := events[len(events)-1-i]
+		if event.Action != action || event.Actor != models.AuditUser(user.UUID) || event.Details["server_id"] != serverID {
+			t.Errorf("event %d = %+v, want %s by the user", i, event, action)
+		}
+		if event.IP != "203.0.113.7" || event.UserAgent != botAgent {
+			t.Errorf("event %d came from %q with %q", i, event.IP, event.UserAgent)
+		}
+		if event.BeforeHash != previous {
+			t.Errorf("event %d before hash = %q, want the last after hash %q", i, event.BeforeHash, previous)
+		}
+		if (event.AfterHash == "") != (action == models.AuditSummaryDeleted) || event.AfterHash == event.BeforeHash {
+			t.Errorf("event %d after hash = %q", i, event.AfterHash)
+		}
+		previous = event.AfterHash
+	}
+}
+
+// failingAudit is an audit log that cannot record anything
+type failingAudit struct {
+	repositories.AuditRepository
+}
+
+func (failingAudit) RecordEvent(context.Context, *models.AuditEvent) error {
+	return errors.New("audit log unavailable")
+}
+
+func TestAuditFailuresDoNotSplitChangesFromEvents(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{
+		WrapAudit: func(audit repositories.AuditRepository) repositories.AuditRepository { return failingAudit{audit} },
+	})
+	bearer := h.SeedUser(nelly)
+	id := h.SeedSummary(nelly.ID, serverID, false, "Unchanged.")
+	path := "/api/v1/summaries/" + id
+
+	// Summary changes are recorded before they are made, so they are
+	// refused while the audit log is down
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: bearer, Body: handlers.CreateSummaryRequest{
+		ServerID: serverID, Content: "Unaudited.",
+	}}), http.StatusInternalServerError, apperror.CodeInternal)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPatch, Path: path, Bearer: bearer, Body: `{"content": "Unaudited."}`}),
+		http.StatusInternalServerError, apperror.CodeInternal)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodDelete, Path: path, Bearer: bearer}),
+		http.StatusInternalServerError, apperror.CodeInternal)
+	summaries, err := h.Summaries.GetSummaries(context.Background(), nelly.ID)
+	if err != nil || len(summaries) != 1 || summaries[0].Content != "Unchanged." {
+		t.Errorf("summaries after refused changes = %+v, %v", summaries, err)
+	}
+
+	// Changes recorded once made are not reported as failed
+	key := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesRead}})
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(key.Key)}); resp.StatusCode != http.StatusOK {
+		t.Errorf("the new key returned %d: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestAPIKeySummaryChangesAreAudited(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	ctx := context.Background()
+	user, err := h.Users.FindUserByID(ctx, nelly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := createKey(t, h, bearer, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesWrite}})
+	// No proxy is trusted, so the forwarding headers are not believed
+	headers := withKey(key.Key)
+	headers["X-Forwarded-For"] = "203.0.113.7"
+	headers["X-Real-IP"] = "203.0.113.7"
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Headers: headers, Body: handlers.CreateSummaryRequest{
+		ServerID: serverID, Content: "Written by a bot.",
+	}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Action: models.AuditSummaryCreated})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("audit events = %+v, %v", events, err)
+	}
+	if event := events[0]; event.Actor != models.AuditAPIKey(key.KeyID) || event.Details["user"] != models.AuditUser(user.UUID) {
+		t.Errorf("audit event = %+v, want the key as actor and the user in the details", event)
+	}
+	if event := events[0]; event.IP != "127.0.0.1" {
+		t.Errorf("audit event came from %q, want the connecting address", event.IP)
+	}
+}
+
+func TestOrphanedSummaryChangesAreAudited(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{TrustNamedUser: true})
+	// The author was removed from the database, leaving the summary behind
+	id := h.SeedSummary(otto.ID, serverID, false, "Left behind.")
+
+	content := "Tidied up."
+	resp := h.Do(apptest.Request{Method: http.MethodPatch, Path: "/api/v1/summaries/" + id, UserID: otto.ID, Body: handlers.PatchSummaryRequest{Content: &content}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/summaries/" + id, UserID: otto.ID})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	events, err := h.Audit.ListEvents(context.Background(), models.AuditFilter{Target: models.AuditSummary(id)})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("audit events = %+v, %v", events, err)
+	}
+	for _, event := range events {
+		if event.Actor != models.AuditDiscordUser(otto.ID) || event.Details["user_id"] != otto.ID {
+			t.Errorf("audit event = %+v, want the author by Discord ID", event)
+		}
+	}
+}
+
+func TestLoginAndLogoutAreAudited(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(nelly)
+	ctx := context.Background()
+
+	var login handlers.LoginResponse
+	h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login"}).JSON(t, &login)
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + h.Authorize(login.URL), Headers: map[string]string{"User-Agent": botAgent}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	user, err := h.Users.FindUserByID(ctx, nelly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/auth/logout", Bearer: user.Token.AccessToken})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("logout returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Actor: models.AuditUser(user.UUID)})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("audit events = %+v, %v", events, err)
+	}
+	loggedOut, loggedIn := events[0], events[1]
+	if loggedIn.Action != models.AuditLogin || loggedIn.Details["provider"] != "discord" || loggedIn.Details["account_created"] != "true" || loggedIn.UserAgent != botAgent {
+		t.Errorf("login event = %+v", loggedIn)
+	}
+	if loggedOut.Action != models.AuditLogout || loggedOut.Target != models.AuditUser(user.UUID) || loggedOut.IP == "" {
+		t.Errorf("logout event = %+v", loggedOut)
+	}
+}
+
+func TestAdminAuditLog(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{AdminIDs: []string{otto.ID}})
+	nellyBearer := h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+	for _, content := range []string{"One.", "Two."} {
+		resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: nellyBearer, Body: handlers.CreateSummaryRequest{ServerID: serverID, Content: content}})
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create returned %d: %s", resp.StatusCode, resp.Body)
+		}
+	}
+	createKey(t, h, nellyBearer, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesRead}})
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events?action=summary.created&limit=1", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("listing returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var list []handlers.AuditEvent
+	resp.JSON(t, &list)
+	if len(list) != 1 || list[0].Action != models.AuditSummaryCreated || list[0].AfterHash == "" {
+		t.Errorf("listed %+v", list)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events?since=2000-01-01T00:00:00Z", Bearer: ottoBearer})
+	resp.JSON(t, &list)
+	if len(list) != 3 {
+		t.Errorf("listed %d events since 2000, want 3", len(list))
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events?until=2000-01-01T00:00:00Z", Bearer: ottoBearer})
+	resp.JSON(t, &list)
+	if len(list) != 0 {
+		t.Errorf("listed %d events until 2000, want none", len(list))
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events/export?action=summary.created", Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("export returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if got := resp.Header.Get("Content-Type"); got != handlers.NDJSONContentType {
+		t.Errorf("Content-Type = %q", got)
+	}
+	var lines int
+	scanner := bufio.NewScanner(bytes.NewReader(resp.Body))
+	for scanner.Scan() {
+		var event handlers.AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Action != models.AuditSummaryCreated {
+			t.Errorf("line %d = %s, %v", lines, scanner.Bytes(), err)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("exported %d lines, want 2", lines)
+	}
+
+	exports, err := h.Audit.ListEvents(context.Background(), models.AuditFilter{Action: models.AuditLogExported})
+	if err != nil || len(exports) != 1 || exports[0].Target != models.AuditLog || exports[0].Details["events"] != "2" || exports[0].Details["action"] != models.AuditSummaryCreated {
+		t.Errorf("export events = %+v, %v", exports, err)
+	}
+
+	tests := []struct {
+		name   string
+		req    apptest.Request
+		status int
+		code   apperror.Code
+	}{
+		{"list without a token", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events"}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"list as a user", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"export as a user", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events/export", Bearer: nellyBearer}, http.StatusForbidden, apperror.CodeForbidden},
+		{"malformed time", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events?since=yesterday", Bearer: ottoBearer}, http.StatusBadRequest, apperror.CodeBadRequest},
+		{"limit too high", apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events?limit=5000", Bearer: ottoBearer}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectProblem(t, h.Do(tt.req), tt.status, tt.code)
+		})
+	}
+}
+
+func TestAuditExportReadsPages(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{AdminIDs: []string{otto.ID}})
+	ottoBearer := h.SeedUser(otto)
+
+	// More events than an export reads at a time, most of them recorded in
+	// the same instant so that pages break ties by ID
+	ctx := context.Background()
+	at := time.Now().Add(-time.Hour)
+	for i := 0; i < 1234; i++ {
+		event := &models.AuditEvent{ID: uuid.New().String(), Time: at.Add(time.Duration(i/100) * time.Second), Actor: models.AuditUser("seeded"), Action: "test.seeded", Target: models.AuditLog}
+		if err := h.Audit.RecordEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	export := func(query string) map[string]bool {
+		resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/admin/audit-events/export?" + query, Bearer: ottoBearer})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("export returned %d: %s", resp.StatusCode, resp.Body)
+		}
+		seen := map[string]bool{}
+		scanner := bufio.NewScanner(bytes.NewReader(resp.Body))
+		for scanner.Scan() {
+			var event handlers.AuditEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || seen[event.EventID] {
+				t.Fatalf("line %d = %s, %v", len(seen), scanner.Bytes(), err)
+			}
+			seen[event.EventID] = true
+		}
+		return seen
+	}
+	if got := export("action=test.seeded"); len(got) != 1234 {
+		t.Errorf("exported %d events, want 1234", len(got))
+	}
+	if got := export("action=test.seeded&limit=678"); len(got) != 678 {
+		t.Errorf("exported %d events with a limit of 678", len(got))
+	}
+
+	exports, err := h.Audit.ListEvents(ctx, models.AuditFilter{Action: models.AuditLogExported})
+	if err != nil || len(exports) != 2 || exports[0].Details["events"] != "678" || exports[1].Details["events"] != "1234" {
+		t.Errorf("export events = %+v, %v", exports, err)
+	}
+}
@@ -0,0 +1,352 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/discord/discordtest"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/models"
+)
+
+// discordLogin starts a Discord login and returns its authorize URL
+func discordLogin(t *testing.T, h *apptest.Harness) string {
+	t.Helper()
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var login handlers.LoginResponse
+	resp.JSON(t, &login)
+	return login.URL
+}
+
+// discordCallback logs in with Discord as the consenting user and returns
+// the query Discord redirects back to the callback with
+func discordCallback(t *testing.T, h *apptest.Harness) string {
+	t.Helper()
+	return h.Authorize(discordLogin(t, h))
+}
+
+func TestLoginCallbackProfile(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(nelly, discord.Guild{ID: "290926798626357999", Name: "Ultra Chat", Owner: true, Permissions: "8"})
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login returned %d", resp.StatusCode)
+	}
+	var login handlers.LoginResponse
+	resp.JSON(t, &login)
+
+	callback := h.Authorize(login.URL)
+	if !strings.Contains(callback, "code=") {
+		t.Fatal("authorize did not issue a code")
+	}
+
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + callback}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	stored, err := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if err != nil {
+		t.Fatalf("callback did not store the user: %v", err)
+	}
+	if stored.Username != nelly.Username || stored.UUID == "" {
+		t.Errorf("stored user = %+v", stored)
+	}
+	accessToken := stored.Token.AccessToken
+	if accessToken == "" {
+		t.Fatalf("stored user has no access token: %+v", stored.Token)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: accessToken})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("profile returned %d", resp.StatusCode)
+	}
+	var profile handlers.Account
+	resp.JSON(t, &profile)
+	if profile.ID != nelly.ID || profile.UUID != stored.UUID || profile.Username != nelly.Username {
+		t.Errorf("profile = %+v", profile)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/status", Bearer: accessToken})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("auth status returned %d", resp.StatusCode)
+	}
+	var status handlers.AuthStatusResponse
+	resp.JSON(t, &status)
+	if status.UserID != nelly.ID {
+		t.Errorf("auth status = %+v", status)
+	}
+
+	// A second login updates the stored token instead of duplicating the user
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login"})
+	resp.JSON(t, &login)
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + h.Authorize(login.URL)}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("second callback returned %d", resp.StatusCode)
+	}
+	updated, _ := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if updated.UUID != stored.UUID || updated.Token.AccessToken == accessToken || !updated.CreatedAt.Equal(stored.CreatedAt) || updated.UpdatedAt.Before(stored.UpdatedAt) {
+		t.Errorf("second login did not update the existing user: %+v", updated)
+	}
+}
+
+func TestCallbackErrors(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(nelly)
+
+	tests := []struct {
+		name   string
+		script func()
+		code   string
+		status int
+		want   apperror.Code
+	}{
+		{"missing code", func() {}, "", http.StatusBadRequest, apperror.CodeBadRequest},
+		{"unknown code", func() {}, "not-a-code", http.StatusBadRequest, apperror.CodeBadRequest},
+		{"token endpoint down", func() {
+			h.Discord.Fail(discordtest.RouteToken, discordtest.Failure{Status: http.StatusInternalServerError, Body: `{"message": "boom"}`})
+		}, h.Discord.IssueCode(nelly.ID, apptest.RedirectURI), http.StatusBadGateway, apperror.CodeUpstream},
+		{"user lookup down", func() {
+			h.Discord.Fail(discordtest.RouteMe, discordtest.Failure{Status: http.StatusServiceUnavailable})
+		}, h.Discord.IssueCode(nelly.ID, apptest.RedirectURI), http.StatusBadGateway, apperror.CodeUpstream},
+		{"rate limited for too long", func() {
+			h.Discord.RateLimit(discordtest.RouteToken, 3600, false)
+		}, h.Discord.IssueCode(nelly.ID, apptest.RedirectURI), http.StatusServiceUnavailable, apperror.CodeUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.script()
+
+			callback := apptest.Callback(discordLogin(t, h), tt.code)
+			resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + callback})
+			expectProblem(t, resp, tt.status, tt.want)
+		})
+	}
+}
+
+func TestCallbackNeedsTheLoginStartedByTheClient(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(otto)
+
+	loginURL := discordLogin(t, h)
+	authorize, _ := url.Parse(loginURL)
+	if query := authorize.Query(); query.Get("state") == "" || query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("login URL %s carries no state or PKCE challenge", loginURL)
+	}
+
+	// Otto authorizes and plants the callback in someone else's client,
+	// which never started a login or started one of its own
+	planted := h.Authorize(loginURL)
+	h.ClearCookies()
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + planted}),
+		http.StatusBadRequest, apperror.CodeInvalidState)
+	discordLogin(t, h)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + planted}),
+		http.StatusBadRequest, apperror.CodeInvalidState)
+	if _, err := h.Users.FindUserByID(context.Background(), otto.ID); err == nil {
+		t.Error("a planted callback logged in")
+	}
+
+	// A login is finished once
+	callback := discordCallback(t, h)
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + callback}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + callback}),
+		http.StatusBadRequest, apperror.CodeInvalidState)
+}
+
+func TestShortRateLimitIsRetried(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(nelly)
+	h.Discord.RateLimit(discordtest.RouteToken, 0.01, false)
+
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/callback?" + discordCallback(t, h)}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback returned %d", resp.StatusCode)
+	}
+	if got := h.Discord.Requests(discordtest.RouteToken); got != 2 {
+		t.Errorf("token endpoint called %d times, want 2", got)
+	}
+}
+
+func TestRevokedTokenIsRejected(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedUser(nelly)
+
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: token}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("profile returned %d", resp.StatusCode)
+	}
+
+	// The client caches /users/@me, so use a fresh token to observe revocation
+	other := h.Discord.IssueToken(nelly.ID)
+	h.Discord.RevokeUser(nelly.ID)
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: other})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me"})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+}
+
+func TestRefreshTokenGrant(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(nelly, discord.Guild{ID: "290926798626357999", Name: "Ultra Chat"})
+
+	client := discord.NewClient(discord.Config{BaseURL: h.Discord.URL, ClientID: apptest.ClientID, ClientSecret: apptest.ClientSecret, RedirectURI: apptest.RedirectURI})
+	ctx := context.Background()
+
+	first, err := client.ExchangeCode(ctx, h.Discord.IssueCode(nelly.ID, apptest.RedirectURI), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := client.RefreshToken(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.AccessToken == first.AccessToken {
+		t.Error("refresh returned the same access token")
+	}
+
+	if _, err := client.RefreshToken(ctx, first.RefreshToken); discord.StatusCode(err) != http.StatusBadRequest {
+		t.Errorf("reusing a refresh token: err = %v, want a 400", err)
+	}
+
+	guilds, err := client.CurrentUserGuilds(ctx, second.AccessToken)
+	if err != nil || len(guilds) != 1 || guilds[0].Name != "Ultra Chat" {
+		t.Errorf("guilds = %+v, %v", guilds, err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedLinkedUser(nelly)
+	h.SeedSummary(nelly.ID, serverID, false, "Kept after logout.")
+
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/auth/logout", Bearer: token.AccessToken})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("logout returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	if h.Discord.Valid(token.AccessToken) || h.Discord.Valid(token.RefreshToken) {
+		t.Error("the Discord grant was not revoked")
+	}
+	stored, err := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if err != nil {
+		t.Fatalf("logout deleted the user: %v", err)
+	}
+	if stored.Token != (models.Token{}) {
+		t.Errorf("stored token after logout = %+v", stored.Token)
+	}
+	if summaries, _ := h.Summaries.GetSummaries(context.Background(), nelly.ID); len(summaries) != 1 {
+		t.Errorf("summaries after logout = %+v", summaries)
+	}
+
+	// The session is over, cached lookups included
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/status", Bearer: token.AccessToken})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/auth/logout", Bearer: token.AccessToken})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+}
+
+func TestLogoutWithoutStoredUser(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(otto)
+	token := h.Discord.IssueToken(otto.ID)
+
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/auth/logout", Bearer: token})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("logout returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if h.Discord.Valid(token) {
+		t.Error("the bearer token was not revoked")
+	}
+}
+
+func TestLogoutWhenRevocationFails(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedLinkedUser(nelly)
+	down := discordtest.Failure{Status: http.StatusInternalServerError}
+	h.Discord.Fail(discordtest.RouteRevoke, down, down)
+
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/auth/logout", Bearer: token.AccessToken})
+	expectProblem(t, resp, http.StatusBadGateway, apperror.CodeUpstream)
+
+	// The stored token is kept so that the logout can be retried
+	stored, err := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if err != nil || stored.Token != token {
+		t.Fatalf("stored token after a failed logout = %+v, %v", stored, err)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/auth/logout", Bearer: token.AccessToken})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("retried logout returned %d: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestLogoutRevokesTheGrantWithEitherToken(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedLinkedUser(nelly)
+	h.Discord.Fail(discordtest.RouteRevoke, discordtest.Failure{Status: http.StatusInternalServerError})
+
+	// Revoking the refresh token fails, but revoking the access token still
+	// ends the grant
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/auth/logout", Bearer: token.AccessToken})
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("logout returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if h.Discord.Valid(token.RefreshToken) {
+		t.Error("the refresh token is still valid")
+	}
+	if got := h.Discord.Requests(discordtest.RouteRevoke); got != 2 {
+		t.Errorf("revocation endpoint called %d times, want 2", got)
+	}
+}
+
+func TestDiscordDeauthorization(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedLinkedUser(nelly)
+
+	// The user removes the app in their Discord settings
+	h.Discord.RevokeUser(nelly.ID)
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/status", Bearer: token.AccessToken})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+	var problem apperror.Problem
+	resp.JSON(t, &problem)
+	if !strings.Contains(problem.Detail, "revoked") {
+		t.Errorf("detail = %q, want it to say the authorization was revoked", problem.Detail)
+	}
+
+	stored, err := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if err != nil || stored.Token != (models.Token{}) {
+		t.Errorf("stored user after deauthorization = %+v, %v", stored, err)
+	}
+}
+
+func TestExpiredTokenIsRenewed(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedLinkedUser(nelly)
+	h.Discord.ExpireToken(token.AccessToken)
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: token.AccessToken})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+
+	// An expired token is not a revoked grant: the stored one is renewed
+	stored, err := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if err != nil || stored.Token.AccessToken == "" || stored.Token.AccessToken == token.AccessToken {
+		t.Fatalf("stored token after expiry = %+v, %v", stored, err)
+	}
+	if !h.Discord.Valid(stored.Token.AccessToken) {
+		t.Error("the renewed token is not valid")
+	}
+}
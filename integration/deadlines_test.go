@@ -0,0 +1,51 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord/discordtest"
+)
+
+func TestSlowDiscordTimesOut(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{DiscordTimeout: 50 * time.Millisecond})
+	token := h.SeedUser(nelly)
+	h.Discord.Fail(discordtest.RouteMe, discordtest.Failure{Delay: 5 * time.Second})
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: token})
+	expectProblem(t, resp, http.StatusGatewayTimeout, apperror.CodeTimeout)
+}
+
+func TestClientDisconnectCancelsWork(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedUser(nelly)
+	h.Discord.Fail(discordtest.RouteMe, discordtest.Failure{Delay: 5 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.Server.URL+"/api/v1/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	started := time.Now()
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("request finished with %d despite the stalled Discord call", resp.StatusCode)
+	}
+
+	// The server notices the disconnect and abandons the Discord call rather
+	// than waiting out its delay
+	const canceled = `http_requests_total{method="GET",route="/api/v1/me",status="499"} 1`
+	for !strings.Contains(string(h.Do(apptest.Request{Method: http.MethodGet, Path: "/metrics"}).Body), canceled) {
+		if time.Since(started) > 2*time.Second {
+			t.Fatal("the abandoned request was never recorded as canceled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
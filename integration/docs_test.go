@@ -0,0 +1,43 @@
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/openapi"
+)
+
+func TestAPIDocs(t *testing.T) {
+	h := apptest.New(t)
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/openapi.json"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("spec returned %d", resp.StatusCode)
+	}
+	var doc openapi.Document
+	resp.JSON(t, &doc)
+	if !doc.Has(http.MethodGet, "/api/v1/summaries/:id") {
+		t.Error("served spec is missing GET /api/v1/summaries/{id}")
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/docs"})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(resp.Body), "/openapi.json") {
+		t.Errorf("docs returned %d", resp.StatusCode)
+	}
+	if strings.Contains(string(resp.Body), "https://") {
+		t.Errorf("docs load assets from another origin: %s", resp.Body)
+	}
+
+	// swagger-ui is served by the app itself
+	for path, contentType := range map[string]string{
+		"/docs/assets/swagger-ui-bundle.js": "text/javascript",
+		"/docs/assets/swagger-ui.css":       "text/css",
+	} {
+		resp := h.Do(apptest.Request{Method: http.MethodGet, Path: path})
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), contentType) || len(resp.Body) == 0 {
+			t.Errorf("%s returned %d, %q", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+}
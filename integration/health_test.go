@@ -0,0 +1,66 @@
+package integration
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/discord/discordtest"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/health"
+)
+
+func TestHealthProbes(t *testing.T) {
+	var indexes health.Startup
+	var discordProbe func(context.Context) error
+
+	h := apptest.NewWithOptions(t, apptest.Options{Health: health.NewChecker(
+		health.Check{Name: "indexes", Probe: indexes.Probe},
+		health.Check{Name: "discord", Probe: func(ctx context.Context) error { return discordProbe(ctx) }, Optional: true},
+	)})
+	discordProbe = discord.NewClient(discord.Config{BaseURL: h.Discord.URL, MaxRetries: -1}).Ping
+
+	readiness := func(want int) health.Report {
+		t.Helper()
+		resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/readyz"})
+		if resp.StatusCode != want {
+			t.Fatalf("readyz = %d, want %d: %s", resp.StatusCode, want, resp.Body)
+		}
+		var report health.Report
+		resp.JSON(t, &report)
+		return report
+	}
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/healthz"})
+	var live handlers.LivenessResponse
+	resp.JSON(t, &live)
+	if resp.StatusCode != http.StatusOK || live.Status != health.StatusOK {
+		t.Errorf("healthz = %d %+v", resp.StatusCode, live)
+	}
+
+	// Unready until startup finishes, with the latest failure as detail
+	report := readiness(http.StatusServiceUnavailable)
+	if report.Status != health.StatusUnavailable || report.Checks["indexes"].Error != health.ErrStarting.Error() {
+		t.Errorf("report while starting = %+v", report)
+	}
+	indexes.Failed(errors.New("mongo is down"))
+	if report := readiness(http.StatusServiceUnavailable); report.Checks["indexes"].Error != "mongo is down" {
+		t.Errorf("report after failed attempt = %+v", report)
+	}
+
+	indexes.Done()
+	report = readiness(http.StatusOK)
+	if report.Status != health.StatusOK || report.Checks["discord"].Status != health.StatusOK {
+		t.Errorf("report when ready = %+v", report)
+	}
+
+	// Discord being down degrades the instance but keeps it in rotation
+	h.Discord.Fail(discordtest.RouteGateway, discordtest.Failure{Status: http.StatusBadGateway})
+	report = readiness(http.StatusOK)
+	if report.Status != health.StatusDegraded || report.Checks["discord"].Status != health.StatusDegraded {
+		t.Errorf("report with Discord down = %+v", report)
+	}
+}
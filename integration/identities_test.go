@@ -0,0 +1,254 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/identity/oidctest"
+	"ultra-chat-backend/models"
+)
+
+var nellyAtAcme = oidctest.User{Subject: "acme|4711", PreferredUsername: "nelly.acme", Name: "Nelly", Email: "nelly@acme.example"}
+
+// oidcLogin starts a login with the OIDC provider and returns its
+// authorize URL
+func oidcLogin(t *testing.T, h *apptest.Harness) string {
+	t.Helper()
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/login"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var login handlers.LoginResponse
+	resp.JSON(t, &login)
+	return login.URL
+}
+
+// oidcCallback logs in with the OIDC provider and returns the query the
+// issuer redirects back to the callback with
+func oidcCallback(t *testing.T, h *apptest.Harness) string {
+	t.Helper()
+	return h.Authorize(oidcLogin(t, h))
+}
+
+func TestLinkAndLogInWithOIDC(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	h.OIDC.AddUser(nellyAtAcme)
+
+	// Without a linked identity there is no account to log in to
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h)})
+	expectProblem(t, resp, http.StatusNotFound, apperror.CodeIdentityNotFound)
+
+	// Logged in with Discord, the same callback links the identity
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h), Bearer: bearer})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("linking returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var linked handlers.Identity
+	resp.JSON(t, &linked)
+	if linked.Provider != apptest.OIDCProvider || linked.Subject != nellyAtAcme.Subject || linked.Username != nellyAtAcme.PreferredUsername || linked.Email != nellyAtAcme.Email {
+		t.Errorf("linked identity = %+v", linked)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me/identities", Bearer: bearer})
+	var identities []handlers.Identity
+	resp.JSON(t, &identities)
+	if len(identities) != 2 || identities[0].Provider != "discord" || identities[0].Subject != nelly.ID || identities[1] != linked {
+		t.Errorf("identities = %+v", identities)
+	}
+
+	// Now the identity logs in to Nelly's account on its own
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h)})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var session handlers.ProviderSession
+	resp.JSON(t, &session)
+	account, err := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Provider != apptest.OIDCProvider || session.AccessToken == "" || session.UserUUID != account.UUID {
+		t.Errorf("session = %+v", session)
+	}
+
+	resp = h.Do(apptest.Request{
+		Method: http.MethodGet, Path: "/api/v1/me", Bearer: session.AccessToken,
+		Headers: map[string]string{handlers.AuthProviderHeader: apptest.OIDCProvider},
+	})
+	var me handlers.Account
+	resp.JSON(t, &me)
+	if resp.StatusCode != http.StatusOK || me.ID != nelly.ID {
+		t.Errorf("GET /me with the OIDC session = %d %+v", resp.StatusCode, me)
+	}
+
+	// The session is the provider's, so Discord does not know it
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: session.AccessToken})
+	expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+
+	// Linked, then logged in with
+	events, err := h.Audit.ListEvents(context.Background(), models.AuditFilter{Target: models.AuditUser(account.UUID)})
+	if err != nil || len(events) != 2 || events[1].Action != models.AuditIdentityLinked || events[0].Action != models.AuditLogin {
+		t.Fatalf("audit events = %+v, %v", events, err)
+	}
+	for _, event := range events {
+		if event.Details["provider"] != apptest.OIDCProvider {
+			t.Errorf("audit event = %+v", event)
+		}
+	}
+}
+
+func TestIdentityConflictsAndUnlinking(t *testing.T) {
+	h := apptest.New(t)
+	nellyBearer := h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+	h.OIDC.AddUser(nellyAtAcme)
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h), Bearer: nellyBearer})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("linking returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	// Linking again refreshes the identity
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h), Bearer: nellyBearer})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("relinking returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	// Nobody else can take it
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h), Bearer: ottoBearer})
+	expectProblem(t, resp, http.StatusConflict, apperror.CodeIdentityLinked)
+
+	// And Nelly cannot link a second one at the same provider
+	h.OIDC.AddUser(oidctest.User{Subject: "acme|4712", PreferredUsername: "nelly.alt"})
+	h.OIDC.SetConsentingUser("acme|4712")
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h), Bearer: nellyBearer})
+	expectProblem(t, resp, http.StatusConflict, apperror.CodeIdentityLinked)
+
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me/identities/discord", Bearer: nellyBearer}),
+		http.StatusBadRequest, apperror.CodeBadRequest)
+
+	if resp := h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me/identities/oidc", Bearer: nellyBearer}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unlinking returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me/identities/oidc", Bearer: nellyBearer}),
+		http.StatusNotFound, apperror.CodeIdentityNotFound)
+
+	// Unlinked, the identity is free for Otto
+	h.OIDC.SetConsentingUser(nellyAtAcme.Subject)
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h), Bearer: ottoBearer})
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("linking to Otto returned %d: %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestProviderRoutes(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(nelly)
+
+	// Discord is a provider like any other
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/discord/login"})
+	var login handlers.LoginResponse
+	resp.JSON(t, &login)
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/discord/callback?" + h.Authorize(login.URL)}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Discord callback returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if _, err := h.Users.FindUserByID(context.Background(), nelly.ID); err != nil {
+		t.Errorf("the Discord callback did not store the user: %v", err)
+	}
+
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/github/login"}),
+		http.StatusNotFound, apperror.CodeProviderNotFound)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?code=bogus"}),
+		http.StatusBadRequest, apperror.CodeInvalidState)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback"}),
+		http.StatusBadRequest, apperror.CodeBadRequest)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + apptest.Callback(oidcLogin(t, h), "bogus")}),
+		http.StatusBadRequest, apperror.CodeBadRequest)
+
+	h.OIDC.Fail(oidctest.RouteToken, http.StatusInternalServerError)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + apptest.Callback(oidcLogin(t, h), "bogus")}),
+		http.StatusBadGateway, apperror.CodeUpstream)
+}
+
+func TestPlantedCallbackDoesNotLinkAnIdentity(t *testing.T) {
+	h := apptest.New(t)
+	nellyBearer := h.SeedUser(nelly)
+	h.OIDC.AddUser(oidctest.User{Subject: "acme|666", PreferredUsername: "mallory"})
+
+	// Mallory authorizes with her own identity and gets Nelly's client to
+	// call the callback while logged in
+	planted := oidcCallback(t, h)
+	h.ClearCookies()
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + planted, Bearer: nellyBearer})
+	expectProblem(t, resp, http.StatusBadRequest, apperror.CodeInvalidState)
+
+	if _, err := h.Identities.FindIdentity(context.Background(), apptest.OIDCProvider, "acme|666"); err == nil {
+		t.Error("Mallory's identity was linked to Nelly's account")
+	}
+}
+
+func TestAccountDeletionUnlinksIdentities(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	h.OIDC.AddUser(nellyAtAcme)
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h), Bearer: bearer})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("linking returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	account, _ := h.Users.FindUserByID(context.Background(), nelly.ID)
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me/export", Bearer: bearer})
+	var export handlers.AccountExport
+	resp.JSON(t, &export)
+	if len(export.Identities) != 2 || export.Identities[1].Subject != nellyAtAcme.Subject {
+		t.Errorf("exported identities = %+v", export.Identities)
+	}
+
+	if resp := h.Do(apptest.Request{Method: http.MethodDelete, Path: "/api/v1/me", Bearer: bearer}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("deletion returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if identities, err := h.Identities.ListIdentities(context.Background(), account.UUID); err != nil || len(identities) != 0 {
+		t.Errorf("identities left after deletion = %+v, %v", identities, err)
+	}
+
+	// The identity no longer logs in to anything
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h)})
+	expectProblem(t, resp, http.StatusNotFound, apperror.CodeIdentityNotFound)
+}
+
+func TestIdentityOfARemovedUserDoesNotLogIn(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	h.OIDC.AddUser(nellyAtAcme)
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h), Bearer: bearer})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("linking returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	ctx := context.Background()
+	account, _ := h.Users.FindUserByID(ctx, nelly.ID)
+	linked, err := h.Identities.FindIdentity(ctx, apptest.OIDCProvider, nellyAtAcme.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removed from the database directly, the user leaves the identity behind
+	if err := h.Users.DeleteUser(ctx, nelly.ID); err != nil {
+		t.Fatal(err)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/oidc/callback?" + oidcCallback(t, h)})
+	expectProblem(t, resp, http.StatusNotFound, apperror.CodeIdentityNotFound)
+
+	if got, err := h.Identities.FindIdentity(ctx, apptest.OIDCProvider, nellyAtAcme.Subject); err != nil || got.Token.AccessToken != linked.Token.AccessToken {
+		t.Errorf("identity after the refused login = %+v, %v", got, err)
+	}
+	if events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Action: models.AuditLogin, Actor: models.AuditUser(account.UUID)}); err != nil || len(events) != 0 {
+		t.Errorf("login events = %+v, %v", events, err)
+	}
+}
@@ -0,0 +1,163 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/models"
+)
+
+func expectDeprecated(t *testing.T, resp *apptest.Response) {
+	t.Helper()
+	if resp.Header.Get("Deprecation") == "" || resp.Header.Get("Sunset") == "" || resp.Header.Get("Link") == "" {
+		t.Errorf("missing deprecation headers: %v", resp.Header)
+	}
+}
+
+func TestLegacyAuthRoutes(t *testing.T) {
+	h := apptest.New(t)
+	h.Discord.AddUser(nelly)
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/login"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login returned %d", resp.StatusCode)
+	}
+	expectDeprecated(t, resp)
+	var login handlers.LoginResponse
+	resp.JSON(t, &login)
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/callback?" + h.Authorize(login.URL)})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	expectDeprecated(t, resp)
+
+	user, err := h.Users.FindUserByID(context.Background(), nelly.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := user.Token.AccessToken
+
+	for _, path := range []string{"/profile", "/is_authenticated"} {
+		resp := h.Do(apptest.Request{Method: http.MethodGet, Path: path, Bearer: token})
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s returned %d", path, resp.StatusCode)
+		}
+		expectDeprecated(t, resp)
+		if path == "/profile" {
+			// The successor the link names returns the same document
+			successor := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me/discord", Bearer: token})
+			if successor.StatusCode != http.StatusOK || string(successor.Body) != string(resp.Body) {
+				t.Errorf("/api/v1/me/discord = %d %s, /profile = %s", successor.StatusCode, successor.Body, resp.Body)
+			}
+			if link := resp.Header.Get("Link"); !strings.Contains(link, "/api/v1/me/discord") {
+				t.Errorf("/profile links to %s", link)
+			}
+		}
+
+		resp = h.Do(apptest.Request{Method: http.MethodGet, Path: path, Bearer: "not-a-token"})
+		expectProblem(t, resp, http.StatusUnauthorized, apperror.CodeUnauthorized)
+		expectDeprecated(t, resp)
+	}
+
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/callback"}), http.StatusBadRequest, apperror.CodeBadRequest)
+}
+
+func TestLegacySummaryRoutes(t *testing.T) {
+	h := apptest.New(t)
+	nellyBearer := h.SeedUser(nelly)
+
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/create-summary", Bearer: nellyBearer, Body: handlers.CreateSummaryRequest{
+		Content:  "Old clients still work.",
+		ServerID: serverID,
+		UserID:   nelly.ID,
+	}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	expectDeprecated(t, resp)
+	var created handlers.CreateSummaryResponse
+	resp.JSON(t, &created)
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/summarizer", Bearer: nellyBearer})
+	var list []handlers.Summary
+	resp.JSON(t, &list)
+	if resp.StatusCode != http.StatusOK || len(list) != 1 {
+		t.Fatalf("list = %d %+v", resp.StatusCode, list)
+	}
+	expectDeprecated(t, resp)
+
+	resp = h.Do(apptest.Request{Method: http.MethodPut, Path: "/update-summary", Bearer: nellyBearer, Body: handlers.UpdateSummaryRequest{
+		SummaryID: created.SummaryID,
+		ServerID:  serverID,
+		Content:   "Old clients can still update.",
+	}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	expectDeprecated(t, resp)
+
+	summary, err := h.Summaries.GetSummary(context.Background(), nelly.ID, created.SummaryID)
+	if err != nil || summary.Content != "Old clients can still update." {
+		t.Errorf("summary after update = %v, %v", summary, err)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodDelete, Path: "/delete-summary", Bearer: nellyBearer, Body: handlers.DeleteSummaryRequest{SummaryID: created.SummaryID}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	expectDeprecated(t, resp)
+
+	tests := []struct {
+		name   string
+		req    apptest.Request
+		status int
+		code   apperror.Code
+	}{
+		{"create with missing fields", apptest.Request{Method: http.MethodPost, Path: "/create-summary", Body: `{}`}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"list without ID header", apptest.Request{Method: http.MethodGet, Path: "/summarizer"}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"update missing", apptest.Request{Method: http.MethodPut, Path: "/update-summary", Bearer: nellyBearer, Body: handlers.UpdateSummaryRequest{SummaryID: created.SummaryID, ServerID: serverID, IsPrivate: true, Content: "x"}}, http.StatusNotFound, apperror.CodeSummaryNotFound},
+		{"update with bad summary_id", apptest.Request{Method: http.MethodPut, Path: "/update-summary", Bearer: nellyBearer, Body: handlers.UpdateSummaryRequest{SummaryID: "42", ServerID: serverID}}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"delete already deleted", apptest.Request{Method: http.MethodDelete, Path: "/delete-summary", Bearer: nellyBearer, Body: handlers.DeleteSummaryRequest{SummaryID: created.SummaryID}}, http.StatusNotFound, apperror.CodeSummaryNotFound},
+		{"delete without ID header", apptest.Request{Method: http.MethodDelete, Path: "/delete-summary", Body: handlers.DeleteSummaryRequest{SummaryID: created.SummaryID}}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := h.Do(tt.req)
+			expectProblem(t, resp, tt.status, tt.code)
+			expectDeprecated(t, resp)
+		})
+	}
+}
+
+func TestLegacyUpdateChangesTheNamedSummary(t *testing.T) {
+	h := apptest.New(t)
+	bearer := h.SeedUser(nelly)
+	first := h.SeedSummary(nelly.ID, serverID, false, "First.")
+	second := h.SeedSummary(nelly.ID, serverID, false, "Second.")
+
+	resp := h.Do(apptest.Request{Method: http.MethodPut, Path: "/update-summary", Bearer: bearer, Body: handlers.UpdateSummaryRequest{
+		SummaryID: second, ServerID: serverID, Content: "Second, revised.",
+	}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	ctx := context.Background()
+	if summary, err := h.Summaries.GetSummary(ctx, nelly.ID, second); err != nil || summary.Content != "Second, revised." {
+		t.Errorf("named summary = %+v, %v", summary, err)
+	}
+	if summary, err := h.Summaries.GetSummary(ctx, nelly.ID, first); err != nil || summary.Content != "First." {
+		t.Errorf("other summary = %+v, %v", summary, err)
+	}
+	events, err := h.Audit.ListEvents(ctx, models.AuditFilter{Action: models.AuditSummaryUpdated})
+	if err != nil || len(events) != 1 || events[0].Target != models.AuditSummary(second) {
+		t.Errorf("audit events = %+v, %v", events, err)
+	}
+}
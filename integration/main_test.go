@@ -0,0 +1,41 @@
+// Package integration drives the HTTP API end to end through apptest: the
+// real app, a fake Discord and either MongoDB or in-memory storage.
+package integration
+
+import (
+	"testing"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord"
+)
+
+var nelly = discord.User{
+	ID:            "80351110224678912",
+	Username:      "nelly",
+	Discriminator: "1337",
+	Email:         "nelly@example.com",
+	Verified:      true,
+}
+
+func TestMain(m *testing.M) {
+	apptest.Main(m)
+}
+
+// expectProblem fails unless resp is a problem document with status and code
+func expectProblem(t *testing.T, resp *apptest.Response, status int, code apperror.Code) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Errorf("status = %d, want %d (body %s)", resp.StatusCode, status, resp.Body)
+		return
+	}
+	if got := resp.Header.Get("Content-Type"); got != apperror.ContentType {
+		t.Errorf("Content-Type = %q, want %q", got, apperror.ContentType)
+	}
+	var problem apperror.Problem
+	resp.JSON(t, &problem)
+	if problem.Code != code {
+		t.Errorf("code = %q, want %q", problem.Code, code)
+	}
+}
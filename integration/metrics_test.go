@@ -0,0 +1,50 @@
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord/discordtest"
+	"ultra-chat-backend/handlers"
+)
+
+func TestMetrics(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedUser(nelly)
+	h.SeedUser(otto)
+
+	h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: token, Body: handlers.CreateSummaryRequest{Content: "x", ServerID: serverID}})
+	h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries/00000000-0000-4000-8000-000000000000", Bearer: token})
+	h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: token})
+	h.Discord.RateLimit(discordtest.RouteMe, 3600, true)
+	h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: h.Discord.IssueToken(otto.ID)})
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/metrics"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("metrics returned %d", resp.StatusCode)
+	}
+	body := string(resp.Body)
+
+	for _, line := range []string{
+		`http_requests_total{method="POST",route="/api/v1/summaries",status="201"} 1`,
+		`http_requests_total{method="GET",route="/api/v1/summaries/:id",status="404"} 1`,
+		`db_operation_duration_seconds_count{repository="summaries",method="AddSummary"} 1`,
+		`db_operation_duration_seconds_count{repository="summaries",method="GetSummary"} 1`,
+		`discord_requests_total{route="GET /users/@me",status="200"} 1`,
+		`discord_requests_total{route="GET /users/@me",status="429"} 1`,
+		`discord_rate_limited_total{route="GET /users/@me",scope="global"} 1`,
+		`summaries_created_total 1`,
+		`users 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics are missing %s", line)
+		}
+	}
+
+	// A missing summary is an expected outcome, not a database error
+	if strings.Contains(body, `db_operation_errors_total{repository="summaries",method="GetSummary"}`) {
+		t.Error("not-found was counted as a database error")
+	}
+}
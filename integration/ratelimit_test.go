@@ -0,0 +1,90 @@
+package integration
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/ratelimit"
+	"ultra-chat-backend/routes"
+)
+
+func TestRateLimitsCountCallersSharingAnIP(t *testing.T) {
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		routes.GroupAuth:      {Requests: 1, Per: time.Hour},
+		routes.GroupSummaries: {Requests: 1, Per: time.Hour},
+	}, ratelimit.KeyByCallerOrIP(handlers.CallerKey))
+	h := apptest.NewWithOptions(t, apptest.Options{Limiter: limiter})
+	nellyBearer := h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+
+	// Every request comes from the same address; each user has a bucket
+	key := createKey(t, h, ottoBearer, handlers.CreateAPIKeyRequest{Name: "bot", Scopes: []string{models.ScopeSummariesRead}})
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: nellyBearer}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Nelly's first request returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: nellyBearer}),
+		http.StatusTooManyRequests, apperror.CodeRateLimited)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: ottoBearer}),
+		http.StatusTooManyRequests, apperror.CodeRateLimited)
+
+	// Anonymous requests share the address's bucket, and so do tokens
+	// nobody has stored, however many are made up
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login"}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("the first anonymous request returned %d", resp.StatusCode)
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: "made-up"}),
+		http.StatusTooManyRequests, apperror.CodeRateLimited)
+
+	// An API key has a bucket of its own
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(key.Key)}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("the key's first request returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Bearer: nellyBearer}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Nelly's summary request returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Headers: withKey(key.Key)}),
+		http.StatusTooManyRequests, apperror.CodeRateLimited)
+}
+
+// loopbackProxy trusts the test client as a reverse proxy, so the
+// forwarding headers it sends are believed
+func loopbackProxy() []*net.IPNet {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	return []*net.IPNet{loopback}
+}
+
+func TestForwardingHeadersOnlyCountFromTrustedProxies(t *testing.T) {
+	policies := map[string]ratelimit.Limit{routes.GroupAuth: {Requests: 1, Per: time.Hour}}
+	spoofed := func(ip string) map[string]string {
+		return map[string]string{"X-Forwarded-For": ip, "X-Real-IP": ip}
+	}
+
+	// Straight from a client, made up addresses do not buy fresh buckets
+	h := apptest.NewWithOptions(t, apptest.Options{
+		Limiter: ratelimit.NewLimiter(ratelimit.NewMemoryStore(), policies, ratelimit.KeyByCallerOrIP(handlers.CallerKey)),
+	})
+	if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login", Headers: spoofed("203.0.113.1")}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("the first request returned %d", resp.StatusCode)
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login", Headers: spoofed("203.0.113.2")}),
+		http.StatusTooManyRequests, apperror.CodeRateLimited)
+
+	// Behind a trusted proxy, the client it forwards for is counted
+	h = apptest.NewWithOptions(t, apptest.Options{
+		Limiter:        ratelimit.NewLimiter(ratelimit.NewMemoryStore(), policies, ratelimit.KeyByCallerOrIP(handlers.CallerKey)),
+		TrustedProxies: loopbackProxy(),
+	})
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+		if resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login", Headers: spoofed(ip)}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("the first request for %s returned %d", ip, resp.StatusCode)
+		}
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/auth/login", Headers: spoofed("203.0.113.1")}),
+		http.StatusTooManyRequests, apperror.CodeRateLimited)
+}
@@ -0,0 +1,215 @@
+package integration
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/handlers"
+)
+
+const serverID = "290926798626357999"
+
+var otto = discord.User{ID: "80351110224678913", Username: "otto", Discriminator: "0001"}
+
+func TestSummaryLifecycle(t *testing.T) {
+	h := apptest.New(t)
+	nellyBearer := h.SeedUser(nelly)
+
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: nellyBearer, Body: handlers.CreateSummaryRequest{
+		Content:  "We agreed to ship on Friday.",
+		ServerID: serverID,
+	}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	var created handlers.CreateSummaryResponse
+	resp.JSON(t, &created)
+	path := "/api/v1/summaries/" + created.SummaryID
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Bearer: nellyBearer})
+	var list []handlers.Summary
+	resp.JSON(t, &list)
+	if resp.StatusCode != http.StatusOK || len(list) != 1 || list[0].SummaryID != created.SummaryID {
+		t.Fatalf("list = %d %+v", resp.StatusCode, list)
+	}
+
+	content := "We agreed to ship on Monday."
+	isPrivate := true
+	resp = h.Do(apptest.Request{Method: http.MethodPatch, Path: path, Bearer: nellyBearer, Body: handlers.PatchSummaryRequest{
+		Content:   &content,
+		IsPrivate: &isPrivate,
+	}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: path, Bearer: nellyBearer})
+	var got handlers.Summary
+	resp.JSON(t, &got)
+	if resp.StatusCode != http.StatusOK || got.Content != content || !got.IsPrivate || got.ServerID != serverID {
+		t.Errorf("get after patch = %d %+v", resp.StatusCode, got)
+	}
+
+	if resp := h.Do(apptest.Request{Method: http.MethodDelete, Path: path, Bearer: nellyBearer}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: path, Bearer: nellyBearer})
+	expectProblem(t, resp, http.StatusNotFound, apperror.CodeSummaryNotFound)
+}
+
+func TestSummaryJSONIsStable(t *testing.T) {
+	h := apptest.New(t)
+	nellyBearer := h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+
+	// An empty list is an array, not null
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Bearer: ottoBearer})
+	if body := strings.TrimSpace(string(resp.Body)); body != "[]" {
+		t.Errorf("empty list = %s", body)
+	}
+
+	// Timestamps keep their format after an update through the legacy route
+	id := h.SeedSummary(nelly.ID, serverID, false, "before")
+	resp = h.Do(apptest.Request{Method: http.MethodPut, Path: "/update-summary", Bearer: nellyBearer, Body: handlers.UpdateSummaryRequest{
+		SummaryID: id,
+		ServerID:  serverID,
+		Content:   "after",
+	}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %d: %s", resp.StatusCode, resp.Body)
+	}
+
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries/" + id, Bearer: nellyBearer})
+	var got map[string]interface{}
+	resp.JSON(t, &got)
+	want := []string{"summary_id", "user_id", "server_id", "is_private", "summary", "created_at", "updated_at"}
+	if len(got) != len(want) {
+		t.Errorf("summary has fields %v, want %v", got, want)
+	}
+	for _, field := range want {
+		if _, ok := got[field]; !ok {
+			t.Errorf("summary has no %s: %v", field, got)
+		}
+	}
+	for _, field := range []string{"created_at", "updated_at"} {
+		value, _ := got[field].(string)
+		if _, err := time.Parse(time.RFC3339, value); err != nil || !strings.HasSuffix(value, "Z") {
+			t.Errorf("%s = %v, want an RFC 3339 UTC timestamp", field, got[field])
+		}
+	}
+}
+
+func TestSummariesAreScopedToTheirOwner(t *testing.T) {
+	h := apptest.New(t)
+	h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+	id := h.SeedSummary(nelly.ID, serverID, false, "nelly's summary")
+
+	resp := h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Bearer: ottoBearer})
+	var list []handlers.Summary
+	resp.JSON(t, &list)
+	if resp.StatusCode != http.StatusOK || len(list) != 0 {
+		t.Errorf("otto's list = %d %+v", resp.StatusCode, list)
+	}
+
+	path := "/api/v1/summaries/" + id
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: path, Bearer: ottoBearer}), http.StatusNotFound, apperror.CodeSummaryNotFound)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPatch, Path: path, Bearer: ottoBearer, Body: `{"content": "hijacked"}`}), http.StatusNotFound, apperror.CodeSummaryNotFound)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodDelete, Path: path, Bearer: ottoBearer}), http.StatusNotFound, apperror.CodeSummaryNotFound)
+
+	// Naming Nelly acts for nobody: a session only acts for its own user,
+	// and without a key or a session the name alone is not believed
+	named := map[string]string{"ID": nelly.ID}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: path, Bearer: ottoBearer, Headers: named}), http.StatusForbidden, apperror.CodeForbidden)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPatch, Path: path, UserID: nelly.ID, Body: `{"content": "hijacked"}`}), http.StatusUnauthorized, apperror.CodeUnauthorized)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodDelete, Path: path, UserID: nelly.ID}), http.StatusUnauthorized, apperror.CodeUnauthorized)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: ottoBearer, Body: handlers.CreateSummaryRequest{
+		Content: "Planted.", ServerID: serverID, UserID: nelly.ID,
+	}}), http.StatusForbidden, apperror.CodeForbidden)
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Body: handlers.CreateSummaryRequest{
+		Content: "Planted.", ServerID: serverID, UserID: nelly.ID,
+	}}), http.StatusUnauthorized, apperror.CodeUnauthorized)
+
+	summary, err := h.Summaries.GetSummary(context.Background(), nelly.ID, id)
+	if err != nil || summary.Content != "nelly's summary" {
+		t.Errorf("nelly's summary changed: %v, %v", summary, err)
+	}
+	if summaries, _ := h.Summaries.GetSummaries(context.Background(), nelly.ID); len(summaries) != 1 {
+		t.Errorf("nelly has %d summaries, want 1", len(summaries))
+	}
+}
+
+func TestNamedUsersCanBeTrustedWhileMigrating(t *testing.T) {
+	h := apptest.NewWithOptions(t, apptest.Options{TrustNamedUser: true})
+	h.SeedUser(nelly)
+	ottoBearer := h.SeedUser(otto)
+
+	// Without a key or a session, the user the request names is believed
+	resp := h.Do(apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Body: handlers.CreateSummaryRequest{
+		Content: "From an old client.", ServerID: serverID, UserID: nelly.ID,
+	}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", resp.StatusCode, resp.Body)
+	}
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", UserID: nelly.ID})
+	var list []handlers.Summary
+	resp.JSON(t, &list)
+	if resp.StatusCode != http.StatusOK || len(list) != 1 {
+		t.Errorf("list = %d %+v", resp.StatusCode, list)
+	}
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries"}), http.StatusUnauthorized, apperror.CodeUnauthorized)
+
+	// A session still only acts for its own user
+	expectProblem(t, h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", Bearer: ottoBearer, Headers: map[string]string{"ID": nelly.ID}}),
+		http.StatusForbidden, apperror.CodeForbidden)
+}
+
+func TestSummaryErrors(t *testing.T) {
+	h := apptest.New(t)
+	nellyBearer := h.SeedUser(nelly)
+	id := h.SeedSummary(nelly.ID, serverID, false, "a summary")
+	path := "/api/v1/summaries/" + id
+	missing := "/api/v1/summaries/00000000-0000-4000-8000-000000000000"
+
+	tests := []struct {
+		name   string
+		req    apptest.Request
+		status int
+		code   apperror.Code
+	}{
+		{"create with an unknown token", apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Bearer: "made-up", Body: handlers.CreateSummaryRequest{Content: "x", ServerID: serverID}}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"create without content", apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Body: handlers.CreateSummaryRequest{ServerID: serverID, UserID: nelly.ID}}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"create with bad snowflake", apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Body: handlers.CreateSummaryRequest{Content: "x", ServerID: "guild", UserID: nelly.ID}}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"create with oversized content", apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Body: handlers.CreateSummaryRequest{Content: strings.Repeat("x", 16001), ServerID: serverID, UserID: nelly.ID}}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"create with unknown field", apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Body: `{"content": "x", "server_id": "` + serverID + `", "user_id": "` + nelly.ID + `", "admin": true}`}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"create with malformed JSON", apptest.Request{Method: http.MethodPost, Path: "/api/v1/summaries", Body: `{"content":`}, http.StatusBadRequest, apperror.CodeInvalidBody},
+		{"list without credentials", apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries"}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"list with bad ID header", apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries", UserID: "nelly"}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"get without credentials", apptest.Request{Method: http.MethodGet, Path: path}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"get with bad id", apptest.Request{Method: http.MethodGet, Path: "/api/v1/summaries/42", Bearer: nellyBearer}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"get missing", apptest.Request{Method: http.MethodGet, Path: missing, Bearer: nellyBearer}, http.StatusNotFound, apperror.CodeSummaryNotFound},
+		{"patch with no fields", apptest.Request{Method: http.MethodPatch, Path: path, Bearer: nellyBearer, Body: `{}`}, http.StatusBadRequest, apperror.CodeBadRequest},
+		{"patch with empty content", apptest.Request{Method: http.MethodPatch, Path: path, Bearer: nellyBearer, Body: `{"content": ""}`}, http.StatusUnprocessableEntity, apperror.CodeValidation},
+		{"patch missing", apptest.Request{Method: http.MethodPatch, Path: missing, Bearer: nellyBearer, Body: `{"is_private": true}`}, http.StatusNotFound, apperror.CodeSummaryNotFound},
+		{"delete without credentials", apptest.Request{Method: http.MethodDelete, Path: path}, http.StatusUnauthorized, apperror.CodeUnauthorized},
+		{"delete missing", apptest.Request{Method: http.MethodDelete, Path: missing, Bearer: nellyBearer}, http.StatusNotFound, apperror.CodeSummaryNotFound},
+		{"unsupported method", apptest.Request{Method: http.MethodPut, Path: path, Bearer: nellyBearer}, http.StatusMethodNotAllowed, apperror.CodeMethodNotAllowed},
+		{"unknown route", apptest.Request{Method: http.MethodGet, Path: "/api/v1/nope"}, http.StatusNotFound, apperror.CodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectProblem(t, h.Do(tt.req), tt.status, tt.code)
+		})
+	}
+
+	if _, err := h.Summaries.GetSummary(context.Background(), nelly.ID, id); err != nil {
+		t.Errorf("failed requests changed the seeded summary: %v", err)
+	}
+}
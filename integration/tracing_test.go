@@ -0,0 +1,96 @@
+package integration
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/handlers"
+)
+
+// Instrumented packages bind their tracers to the first global provider,
+// so the recorder is installed once and shared by the whole test binary
+var spans = tracetest.NewSpanRecorder()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// traceSpans returns the ended spans of traceID by name
+func traceSpans(traceID trace.TraceID) map[string]sdktrace.ReadOnlySpan {
+	out := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			out[span.Name()] = span
+		}
+	}
+	return out
+}
+
+func TestTracing(t *testing.T) {
+	h := apptest.New(t)
+	token := h.SeedUser(nelly)
+
+	// An incoming traceparent is continued
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	resp := h.Do(apptest.Request{
+		Method:  http.MethodPost,
+		Path:    "/api/v1/summaries",
+		Bearer:  token,
+		Body:    handlers.CreateSummaryRequest{Content: "traced", ServerID: serverID},
+		Headers: map[string]string{"traceparent": parent},
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create returned %d", resp.StatusCode)
+	}
+
+	got := traceSpans(traceID)
+	server, ok := got["POST /api/v1/summaries"]
+	if !ok {
+		t.Fatalf("no server span in %v", got)
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span parent = %s, kind = %s", server.Parent().SpanID(), server.SpanKind())
+	}
+	for _, name := range []string{"summaries.CheckUserExists", "summaries.AddSummary"} {
+		span, ok := got[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the request span", name)
+		}
+	}
+
+	// Discord calls are children of the request that made them. Nelly's
+	// token was looked up for the summary, so Otto's fresh one is used.
+	resp = h.Do(apptest.Request{Method: http.MethodGet, Path: "/api/v1/me", Bearer: h.SeedUser(otto)})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("me returned %d", resp.StatusCode)
+	}
+	var me sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		if span.Name() == "GET /api/v1/me" {
+			me = span
+		}
+	}
+	if me == nil {
+		t.Fatal("no server span for /api/v1/me")
+	}
+	discordSpan, ok := traceSpans(me.SpanContext().TraceID())["discord GET /users/@me"]
+	if !ok {
+		t.Fatal("no Discord span")
+	}
+	if discordSpan.Parent().SpanID() != me.SpanContext().SpanID() || discordSpan.SpanKind() != trace.SpanKindClient {
+		t.Errorf("Discord span parent = %s, kind = %s", discordSpan.Parent().SpanID(), discordSpan.SpanKind())
+	}
+}
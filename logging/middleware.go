@@ -0,0 +1,86 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// validRequestID limits the IDs accepted from clients so they cannot
+// inject arbitrary text into logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Middleware assigns every request an ID, taken from a well-formed
+// X-Request-ID header or generated, and echoes it in the response. The
+// request context carries a logger for FromContext tagged with the ID and
+// with the trace and span IDs of any span already started. An access log
+// line is written when the request completes; requests to quietPaths, such
+// as health probes, are logged at debug level.
+func Middleware(logger *slog.Logger, quietPaths ...string) echo.MiddlewareFunc {
+	quiet := make(map[string]bool, len(quietPaths))
+	for _, path := range quietPaths {
+		quiet[path] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			req := c.Request()
+
+			id := req.Header.Get(echo.HeaderXRequestID)
+			if !validRequestID.MatchString(id) {
+				id = newRequestID()
+			}
+			req.Header.Set(echo.HeaderXRequestID, id)
+			c.Response().Header().Set(echo.HeaderXRequestID, id)
+
+			reqLogger := logger.With(slog.String("request_id", id))
+			if span := trace.SpanContextFromContext(req.Context()); span.IsValid() {
+				reqLogger = reqLogger.With(
+					slog.String("trace_id", span.TraceID().String()),
+					slog.String("span_id", span.SpanID().String()),
+				)
+			}
+			c.SetRequest(req.WithContext(WithLogger(req.Context(), reqLogger)))
+
+			err := next(c)
+			if err != nil {
+				// Render the error now so the status it maps to is known
+				c.Error(err)
+			}
+
+			res := c.Response()
+			level := slog.LevelInfo
+			switch {
+			case res.Status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case quiet[c.Path()]:
+				level = slog.LevelDebug
+			}
+
+			reqLogger.LogAttrs(req.Context(), level, "request",
+				slog.String("method", req.Method),
+				slog.String("route", c.Path()),
+				slog.String("path", req.URL.Path),
+				slog.Int("status", res.Status),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.Int64("bytes_out", res.Size),
+				slog.String("remote_ip", c.RealIP()),
+				slog.String("user_agent", req.UserAgent()),
+			)
+			return err
+		}
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,45 +1,215 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+	"ultra-chat-backend/app"
 	"ultra-chat-backend/config"
+	"ultra-chat-backend/discord"
 	"ultra-chat-backend/handlers"
-
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/health"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/logging"
+	"ultra-chat-backend/metrics"
+	"ultra-chat-backend/ratelimit"
+	"ultra-chat-backend/routes"
+	"ultra-chat-backend/tracing"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrateCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "transfer" {
+		os.Exit(transferCommand(os.Args[2:]))
+	}
 
-	db := config.ConnectDB()
-	defer config.DisconnectDB()
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("invalid configuration", err)
+	}
 
-	userRepo := repositories.NewUserRepository(db)
-	summaryRepo, _ := repositories.NewMongoSummaryRepository(db)
+	logger := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	slog.SetDefault(logger)
+	logger.Info("configuration loaded", "config", cfg)
 
-	e := echo.New()
-	e.Use(middleware.Recover())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Auth Routes
-	authHandler := handlers.NewAuthHandler(userRepo)
-	e.GET("/login", authHandler.Login)
-	e.GET("/callback", authHandler.Callback)
-	e.GET("/profile", authHandler.Profile)
+	lc := &app.Lifecycle{}
 
-	summaryHandler := handlers.NewSummaryHandler(summaryRepo)
-	e.POST("/create-summary", summaryHandler.CreateSummary)
-	e.GET("/summarizer", summaryHandler.GetSummaries)
-	e.PUT("/update-summary", summaryHandler.UpdateSummary)
-	e.DELETE("/delete-summary", summaryHandler.DeleteSummary)
-	e.GET("/is_authenticated", summaryHandler.IsAuthenticated)
+	// Registered first so it stops last, flushing spans from the final requests
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, os.Stdout)
+	if err != nil {
+		fatal("setting up tracing failed", err)
+	}
+	lc.OnStop("tracing", shutdownTracing)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5001"
+	store, err := openStorage(ctx, cfg, lc)
+	if err != nil {
+		fatal("opening storage failed", err)
 	}
 
-	log.Fatal(e.Start(":" + port))
+	registry := metrics.NewRegistry()
+
+	discordClient := discord.NewClient(discord.Config{
+		BaseURL:      cfg.Discord.APIBaseURL,
+		ClientID:     cfg.Discord.ClientID,
+		ClientSecret: cfg.Discord.ClientSecret,
+		RedirectURI:  cfg.Discord.RedirectURI,
+		Scope:        cfg.Discord.Scope,
+		Timeout:      cfg.Discord.Timeout,
+		Metrics:      registry,
+	})
+
+	var providers []identity.Provider
+	if cfg.OIDC.Enabled() {
+		providers = append(providers, identity.NewOIDC(identity.OIDCConfig{
+			Name:         cfg.OIDC.Name,
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURI:  cfg.OIDC.RedirectURI,
+			Scope:        cfg.OIDC.Scope,
+			Timeout:      cfg.OIDC.Timeout,
+		}))
+	}
+
+	// Serve straight away but stay unready until the schema is current
+	schemaReady := &health.Startup{}
+	runInBackground(ctx, lc, "migrations", func(ctx context.Context) {
+		migrate(ctx, store.schemas, schemaReady)
+	})
+
+	checks := append(store.checks,
+		health.Check{Name: "migrations", Probe: schemaReady.Probe},
+		health.Check{Name: "discord", Probe: discordClient.Ping, Optional: true, CacheFor: 30 * time.Second, Timeout: 3 * time.Second},
+	)
+	checker := health.NewChecker(checks...)
+
+	limiter := newRateLimiter(cfg.RateLimit, store.mongo)
+	// Validated with the rest of the configuration
+	trustedProxies, _ := cfg.Server.TrustedProxies.Parse()
+
+	e := app.New(app.Dependencies{
+		Users:          store.users,
+		Summaries:      store.summaries,
+		Identities:     store.identities,
+		APIKeys:        store.apiKeys,
+		RequireAPIKeys: cfg.Server.RequireAPIKeys,
+		TrustNamedUser: cfg.Server.TrustNamedUser,
+		Audit:          store.audit,
+		Maintenance:    store.maintenance,
+		AdminIDs:       cfg.Server.AdminIDs,
+		TrustedProxies: trustedProxies,
+		Discord:        discordClient,
+		Providers:      providers,
+		Limiter:        limiter,
+		Health:         checker,
+		Metrics:        registry,
+		MetricsToken:   cfg.Server.MetricsToken,
+		Logger:         logger,
+		MaxBodyBytes:   cfg.Server.MaxBodyBytes,
+	})
+
+	if err := app.Serve(ctx, e, ":"+strconv.Itoa(cfg.Server.Port), lc, cfg.Server.ShutdownTimeout); err != nil {
+		fatal("server stopped with errors", err)
+	}
+	logger.Info("server stopped")
+
+}
+
+// fatal logs err and exits. Deferred calls do not run, so it is only used
+// before anything needs cleaning up or after cleanup has been attempted.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// runInBackground runs fn until ctx is cancelled, and makes shutdown wait
+// for it to return
+func runInBackground(ctx context.Context, lc *app.Lifecycle, name string, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+
+	lc.OnStop(name, func(stopCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+	})
+}
+
+// migrate applies pending migrations to every schema, retrying with
+// backoff until they succeed and recording progress on the readiness gate.
+// Other instances starting at the same time wait for the one migrating.
+func migrate(ctx context.Context, schemas []schema, gate *health.Startup) {
+	backoff := time.Second
+	for {
+		applied, err := upAll(ctx, schemas)
+		if err == nil {
+			gate.Done()
+			slog.Info("database schema is up to date", "applied", applied)
+			return
+		}
+
+		gate.Failed(err)
+		slog.Warn("migrating the database failed, retrying", "retry_in", backoff.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// upAll migrates each schema in turn and returns how many migrations it
+// applied
+func upAll(ctx context.Context, schemas []schema) (int, error) {
+	applied := 0
+	for _, s := range schemas {
+		steps, err := s.up(ctx)
+		applied += len(steps)
+		if err != nil {
+			return applied, fmt.Errorf("%s: %w", s.name, err)
+		}
+	}
+	return applied, nil
+}
+
+// newRateLimiter builds the limiter for the route groups. A mongo store
+// shares counters between instances; db is only used by it.
+func newRateLimiter(cfg config.RateLimit, db *config.DB) *ratelimit.Limiter {
+	policies := map[string]ratelimit.Limit{
+		routes.GroupAuth:      cfg.Auth,
+		routes.GroupSummaries: cfg.Summaries,
+	}
+
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.Store == "mongo" {
+		store = ratelimit.NewMongoStore(db.Database)
+	}
 
+	return ratelimit.NewLimiter(store, policies, ratelimit.KeyByCallerOrIP(handlers.CallerKey))
 }
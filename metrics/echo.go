@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware counts requests and observes their latency per route
+// template (e.g. /api/v1/summaries/:id), method and status. Requests that
+// match no route share the route label "unmatched" to bound cardinality.
+func Middleware(r *Registry) echo.MiddlewareFunc {
+	requests := r.Counter("http_requests_total", "HTTP requests served.", "method", "route", "status")
+	latency := r.Histogram("http_request_duration_seconds", "HTTP request latency in seconds.", nil, "method", "route", "status")
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+
+			err := next(c)
+			if err != nil {
+				// Render the error now so the status it maps to is known
+				c.Error(err)
+			}
+
+			route := c.Path()
+			if route == "" || c.Response().Status == http.StatusNotFound && route == "/*" {
+				route = "unmatched"
+			}
+			status := strconv.Itoa(c.Response().Status)
+			method := c.Request().Method
+
+			requests.With(method, route, status).Inc()
+			latency.With(method, route, status).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// Handler serves the registry in the text format. If token is set the
+// scraper must send it as a bearer token.
+func Handler(r *Registry, token string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if token != "" {
+			got := c.Request().Header.Get(echo.HeaderAuthorization)
+			if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+				return echo.NewHTTPError(http.StatusUnauthorized)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := r.WriteText(&buf); err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, ContentType, buf.Bytes())
+	}
+}
@@ -0,0 +1,412 @@
+// Package metrics keeps counters, gauges and histograms in process and
+// renders them in the Prometheus text exposition format (version 0.0.4),
+// so the service can be scraped without a client library or a running
+// Prometheus. All methods are safe for concurrent use, and on a nil
+// *Registry or a nil metric they do nothing, so instrumentation is optional.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of WriteText's output
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// Registry holds every metric the service exposes
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]collector
+}
+
+// collector is a named metric family
+type collector interface {
+	describe() (kind kind, help string, labels []string)
+	write(w io.Writer, name string) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]collector{}}
+}
+
+// register returns the collector already registered under name, or adds
+// the one built by create. Registering a name twice with a different type
+// or label set is a programming error and panics.
+func (r *Registry) register(name, help string, k kind, labels []string, create func() collector) collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.metrics[name]; ok {
+		existingKind, _, existingLabels := existing.describe()
+		if existingKind != k || strings.Join(existingLabels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s is already registered as a %s with labels %v", name, existingKind, existingLabels))
+		}
+		return existing
+	}
+
+	c := create()
+	r.metrics[name] = c
+	return c
+}
+
+// Counter registers a monotonically increasing counter
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	if r == nil {
+		return nil
+	}
+	return r.register(name, help, kindCounter, labels, func() collector {
+		return &CounterVec{vec: newVec(kindCounter, help, labels)}
+	}).(*CounterVec)
+}
+
+// Gauge registers a value that can go up and down
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	if r == nil {
+		return nil
+	}
+	return r.register(name, help, kindGauge, labels, func() collector {
+		return &GaugeVec{vec: newVec(kindGauge, help, labels)}
+	}).(*GaugeVec)
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at scrape time.
+// If fn fails the gauge is left out of that scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() (float64, error)) {
+	if r == nil {
+		return
+	}
+	r.register(name, help, kindGauge, nil, func() collector {
+		return &gaugeFunc{help: help, fn: fn}
+	})
+}
+
+// Histogram registers a histogram with the given upper bounds, which must
+// be sorted; nil uses DefaultBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if r == nil {
+		return nil
+	}
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return r.register(name, help, kindHistogram, labels, func() collector {
+		return &HistogramVec{vec: newVec(kindHistogram, help, labels), buckets: buckets}
+	}).(*HistogramVec)
+}
+
+// WriteText renders every metric, sorted by name, in the text format
+func (r *Registry) WriteText(w io.Writer) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make(map[string]collector, len(r.metrics))
+	for name, c := range r.metrics {
+		metrics[name] = c
+	}
+	r.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		c := metrics[name]
+		k, help, _ := c.describe()
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, k); err != nil {
+			return err
+		}
+		if err := c.write(w, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vec is the label handling shared by every metric type
+type vec struct {
+	kind   kind
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is one label combination of a metric
+type series struct {
+	labelValues []string
+
+	mu     sync.Mutex
+	value  float64
+	counts []uint64 // histogram buckets, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newVec(k kind, help string, labels []string) vec {
+	return vec{kind: k, help: help, labels: labels, series: map[string]*series{}}
+}
+
+func (v *vec) describe() (kind, string, []string) {
+	return v.kind, v.help, v.labels
+}
+
+func (v *vec) with(values []string, buckets int) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for labels %v", len(values), v.labels))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), values...)}
+		if buckets > 0 {
+			s.counts = make([]uint64, buckets)
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values so output is stable
+func (v *vec) sorted() []*series {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	out := make([]*series, len(keys))
+	for i, key := range keys {
+		out[i] = v.series[key]
+	}
+	return out
+}
+
+func (v *vec) writeValues(w io.Writer, name string) error {
+	for _, s := range v.sorted() {
+		s.mu.Lock()
+		value := s.value
+		s.mu.Unlock()
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", name, labelString(v.labels, s.labelValues, "", ""), formatFloat(value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct{ vec }
+
+// Counter is one series of a CounterVec
+type Counter struct{ s *series }
+
+// With returns the counter for the label values, in registration order
+func (c *CounterVec) With(values ...string) *Counter {
+	if c == nil {
+		return nil
+	}
+	return &Counter{s: c.with(values, 0)}
+}
+
+func (c *CounterVec) write(w io.Writer, name string) error {
+	return c.writeValues(w, name)
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter; negative values are ignored
+func (c *Counter) Add(v float64) {
+	if c == nil || v < 0 {
+		return
+	}
+	c.s.mu.Lock()
+	c.s.value += v
+	c.s.mu.Unlock()
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct{ vec }
+
+// Gauge is one series of a GaugeVec
+type Gauge struct{ s *series }
+
+// With returns the gauge for the label values, in registration order
+func (g *GaugeVec) With(values ...string) *Gauge {
+	if g == nil {
+		return nil
+	}
+	return &Gauge{s: g.with(values, 0)}
+}
+
+func (g *GaugeVec) write(w io.Writer, name string) error {
+	return g.writeValues(w, name)
+}
+
+func (g *Gauge) Set(v float64) {
+	if g == nil {
+		return
+	}
+	g.s.mu.Lock()
+	g.s.value = v
+	g.s.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64) {
+	if g == nil {
+		return
+	}
+	g.s.mu.Lock()
+	g.s.value += v
+	g.s.mu.Unlock()
+}
+
+type gaugeFunc struct {
+	help string
+	fn   func() (float64, error)
+}
+
+func (g *gaugeFunc) describe() (kind, string, []string) {
+	return kindGauge, g.help, nil
+}
+
+func (g *gaugeFunc) write(w io.Writer, name string) error {
+	value, err := g.fn()
+	if err != nil {
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+	return err
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// Histogram is one series of a HistogramVec
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// With returns the histogram for the label values, in registration order
+func (h *HistogramVec) With(values ...string) *Histogram {
+	if h == nil {
+		return nil
+	}
+	return &Histogram{s: h.with(values, len(h.buckets)), buckets: h.buckets}
+}
+
+// Observe records a value, e.g. a latency in seconds
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	if i < len(h.s.counts) {
+		h.s.counts[i]++
+	}
+	h.s.sum += v
+	h.s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) error {
+	for _, s := range h.sorted() {
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		sum, count := s.sum, s.count
+		s.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += counts[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelString(h.labels, s.labelValues, "le", formatFloat(bound)), cumulative); err != nil {
+				return err
+			}
+		}
+		labels := labelString(h.labels, s.labelValues, "", "")
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			name, labelString(h.labels, s.labelValues, "le", "+Inf"), count,
+			name, labels, formatFloat(sum),
+			name, labels, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelString renders {a="x",b="y"}, with an optional extra label last
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, escapeLabel(extraValue))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
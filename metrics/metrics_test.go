@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests.", "route", "status")
+	requests.With("/b", "200").Add(2)
+	requests.With("/a", "500").Inc()
+	requests.With("/a", "200").Inc()
+	requests.With("/a", "200").Add(-5) // ignored
+
+	r.Gauge("temperature", "Line one\nwith a \\ backslash.").With().Set(-1.5)
+	r.GaugeFunc("users", "Users.", func() (float64, error) { return 42, nil })
+	r.GaugeFunc("broken", "Fails at scrape time.", func() (float64, error) { return 0, errors.New("down") })
+
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		latency.With(`say "hi"`).Observe(v)
+	}
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP broken Fails at scrape time.
+# TYPE broken gauge
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="say \"hi\"",le="0.1"} 2
+latency_seconds_bucket{route="say \"hi\"",le="1"} 3
+latency_seconds_bucket{route="say \"hi\"",le="+Inf"} 4
+latency_seconds_sum{route="say \"hi\""} 3.65
+latency_seconds_count{route="say \"hi\""} 4
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a",status="200"} 1
+requests_total{route="/a",status="500"} 1
+requests_total{route="/b",status="200"} 2
+# HELP temperature Line one\nwith a \\ backslash.
+# TYPE temperature gauge
+temperature -1.5
+# HELP users Users.
+# TYPE users gauge
+users 42
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	first := r.Counter("hits_total", "Hits.", "route")
+	if second := r.Counter("hits_total", "Hits.", "route"); second != first {
+		t.Error("re-registering the same counter returned a new one")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a different type under the same name did not panic")
+		}
+	}()
+	r.Gauge("hits_total", "Hits.", "route")
+}
+
+func TestNilRegistry(t *testing.T) {
+	var r *Registry
+	r.Counter("a", "A.").With().Inc()
+	r.Gauge("b", "B.").With().Set(1)
+	r.Histogram("c", "C.", nil).With().Observe(1)
+	r.GaugeFunc("d", "D.", func() (float64, error) { return 1, nil })
+	if err := r.WriteText(&strings.Builder{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	r := NewRegistry()
+	e := echo.New()
+	e.Use(Middleware(r))
+	e.GET("/items/:id", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) })
+	e.GET("/fail", func(c echo.Context) error { return echo.NewHTTPError(http.StatusTeapot) })
+	e.GET("/metrics", Handler(r, "s3cret"))
+
+	for _, path := range []string{"/items/1", "/items/2", "/fail", "/nowhere/at/all"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("scrape without token = %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get(echo.HeaderContentType) != ContentType {
+		t.Fatalf("scrape = %d %q", rec.Code, rec.Header().Get(echo.HeaderContentType))
+	}
+
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/items/:id",status="204"} 2`,
+		`http_requests_total{method="GET",route="/fail",status="418"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_requests_total{method="GET",route="/metrics",status="401"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/items/:id",status="204"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("scrape is missing %s:\n%s", line, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"ultra-chat-backend/app"
+	"ultra-chat-backend/config"
+	"ultra-chat-backend/logging"
+	"ultra-chat-backend/migrations"
+)
+
+const migrateUsage = `usage: ultra-chat-backend migrate <command> [config flags]
+
+Commands:
+  up          apply every pending migration
+  down [n]    revert the n most recent migrations of the storage backend
+              (default 1)
+  status      list migrations and when they were applied
+
+The databases are configured exactly as for the server; see -h. When
+MongoDB only holds rate limit counters for a SQL backend, up and status
+cover both.
+`
+
+// migrateCommand runs "migrate up|down|status" and returns the exit code
+func migrateCommand(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+	command, args := args[0], args[1:]
+
+	steps := 1
+	if command == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n < 1 {
+				fmt.Fprintln(os.Stderr, "down: the number of migrations must be at least 1")
+				return 2
+			}
+			steps, args = n, args[1:]
+		}
+	}
+	if command != "up" && command != "down" && command != "status" {
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n\n%s", command, migrateUsage)
+		return 2
+	}
+
+	cfg, err := config.Load(args, os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return 1
+	}
+	slog.SetDefault(logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	lc := &app.Lifecycle{}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = lc.Stop(closeCtx)
+	}()
+	store, err := openStorage(ctx, cfg, lc)
+	if err != nil {
+		slog.Error("opening storage failed", "error", err)
+		return 1
+	}
+
+	schemas := store.schemas
+	if command == "down" {
+		// The storage backend's history is always last
+		schemas = schemas[len(schemas)-1:]
+	}
+	for _, s := range schemas {
+		if len(store.schemas) > 1 {
+			fmt.Fprintf(os.Stdout, "%s:\n", s.name)
+		}
+		switch command {
+		case "up":
+			applied, err := s.up(ctx)
+			printMigrations(os.Stdout, "applied", applied)
+			if err != nil {
+				slog.Error("migrating failed", "database", s.name, "error", err)
+				return 1
+			}
+		case "down":
+			reverted, err := s.down(ctx, steps)
+			printMigrations(os.Stdout, "reverted", reverted)
+			if err != nil {
+				slog.Error("reverting failed", "database", s.name, "error", err)
+				return 1
+			}
+		case "status":
+			statuses, err := s.status(ctx)
+			if err != nil {
+				slog.Error("reading migration status failed", "database", s.name, "error", err)
+				return 1
+			}
+			printStatus(os.Stdout, statuses)
+		}
+	}
+	return 0
+}
+
+func printMigrations(w io.Writer, verb string, list []step) {
+	if len(list) == 0 {
+		fmt.Fprintf(w, "nothing %s\n", verb)
+		return
+	}
+	for _, m := range list {
+		fmt.Fprintf(w, "%s %04d %s\n", verb, m.Version, m.Description)
+	}
+}
+
+func printStatus(w io.Writer, statuses []migrations.Status) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tAPPLIED\tDESCRIPTION")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		description := s.Description
+		if s.Unknown {
+			description += " (unknown to this version)"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, applied, description)
+	}
+	tw.Flush()
+}
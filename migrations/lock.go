@@ -0,0 +1,154 @@
+package migrations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	lockCollection = "schema_migrations_lock"
+	lockID         = "migrate"
+
+	// lockTTL is how long a lease lasts without renewal, so an instance
+	// that dies mid-migration blocks the others only briefly
+	lockTTL = time.Minute
+	// lockPoll is how often a waiting instance retries
+	lockPoll = time.Second
+)
+
+// lock is a lease held in a single document. Acquiring it either takes
+// over an expired lease or inserts the document; a live lease held by
+// someone else makes the upsert fail on the duplicate _id.
+type lock struct {
+	collection *mongo.Collection
+	owner      string
+	ttl        time.Duration
+	poll       time.Duration
+}
+
+func newLock(db *mongo.Database) *lock {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return &lock{
+		collection: db.Collection(lockCollection),
+		owner:      fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b)),
+		ttl:        lockTTL,
+		poll:       lockPoll,
+	}
+}
+
+// ErrLockLost is the cause of the cancellation of a migration whose
+// instance stopped holding the migration lock, e.g. because another one
+// took over a lease it failed to renew in time
+var ErrLockLost = errors.New("migration lock lost")
+
+// acquire waits until the lease is free or ctx ends. The lease is renewed
+// in the background until the returned release func is called; the
+// returned context is cancelled with ErrLockLost if it cannot be, so that
+// the holder stops before another instance starts migrating too.
+func (l *lock) acquire(ctx context.Context) (context.Context, func(), error) {
+	logged := false
+	for {
+		held, err := l.try(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		if !held {
+			break
+		}
+		if !logged {
+			slog.Info("waiting for another instance to finish migrating")
+			logged = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("acquiring migration lock: %w", ctx.Err())
+		case <-time.After(l.poll):
+		}
+	}
+
+	locked, cancel := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		renewed := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				held, err := l.renew()
+				switch {
+				case err == nil && held:
+					renewed = time.Now()
+				case err == nil:
+					slog.Error("migration lock was taken over")
+					cancel(ErrLockLost)
+					return
+				case time.Since(renewed) >= l.ttl:
+					slog.Error("migration lock expired before it could be renewed", "error", err)
+					cancel(ErrLockLost)
+					return
+				default:
+					slog.Warn("renewing migration lock failed", "error", err)
+				}
+			}
+		}
+	}()
+
+	return locked, func() {
+		close(stop)
+		<-stopped
+		cancel(nil)
+		// Release even if the caller's context has ended
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := l.collection.DeleteOne(releaseCtx, bson.M{"_id": lockID, "owner": l.owner}); err != nil {
+			slog.Warn("releasing migration lock failed", "error", err)
+		}
+	}, nil
+}
+
+// try takes the lease if it is free and reports whether someone else
+// holds it
+func (l *lock) try(ctx context.Context) (heldElsewhere bool, err error) {
+	now := time.Now()
+	_, err = l.collection.UpdateOne(ctx,
+		bson.M{"_id": lockID, "expires_at": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"owner": l.owner, "acquired_at": now, "expires_at": now.Add(l.ttl)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return true, nil
+	}
+	return false, err
+}
+
+// renew extends the lease and reports whether it was still ours to extend
+func (l *lock) renew() (held bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+	defer cancel()
+	result, err := l.collection.UpdateOne(ctx,
+		bson.M{"_id": lockID, "owner": l.owner},
+		bson.M{"$set": bson.M{"expires_at": time.Now().Add(l.ttl)}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
@@ -0,0 +1,320 @@
+// Package migrations applies versioned changes to the MongoDB schema:
+// indexes, and data reshaped between releases. Applied versions are
+// recorded in the schema_migrations collection, and a lease in
+// schema_migrations_lock ensures only one instance migrates at a time while
+// the others wait for it.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection records applied migrations, one document per version
+const Collection = "schema_migrations"
+
+// Migration is one step of the schema history. Versions are applied in
+// ascending order and must never be renumbered once released.
+//
+// Indexes are created after Up and dropped before Down, so a migration that
+// only adds indexes needs neither. Down may be nil for an Up that cannot be
+// undone.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+	Down        func(ctx context.Context, db *mongo.Database) error
+	Indexes     []Index
+}
+
+// Index is an index a migration creates. Declaring it rather than creating
+// it in Up lets Reindex rebuild it.
+type Index struct {
+	Collection string
+	Model      mongo.IndexModel
+}
+
+// name is the index name, which MongoDB derives from the keys unless one
+// is set
+func (i Index) name() string {
+	if i.Model.Options != nil && i.Model.Options.Name != nil {
+		return *i.Model.Options.Name
+	}
+	var parts []string
+	for _, key := range i.Model.Keys.(bson.D) {
+		parts = append(parts, fmt.Sprintf("%s_%v", key.Key, key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func (i Index) unique() bool {
+	return i.Model.Options != nil && i.Model.Options.Unique != nil && *i.Model.Options.Unique
+}
+
+func (i Index) create(ctx context.Context, db *mongo.Database) error {
+	if _, err := db.Collection(i.Collection).Indexes().CreateOne(ctx, i.Model); err != nil {
+		return fmt.Errorf("creating index %s on %s: %w", i.name(), i.Collection, err)
+	}
+	return nil
+}
+
+func (i Index) drop(ctx context.Context, db *mongo.Database) error {
+	if err := dropIndex(ctx, db.Collection(i.Collection), i.name()); err != nil {
+		return fmt.Errorf("dropping index %s on %s: %w", i.name(), i.Collection, err)
+	}
+	return nil
+}
+
+func (m Migration) up(ctx context.Context, db *mongo.Database) error {
+	if m.Up != nil {
+		if err := m.Up(ctx, db); err != nil {
+			return err
+		}
+	}
+	for _, index := range m.Indexes {
+		if err := index.create(ctx, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m Migration) down(ctx context.Context, db *mongo.Database) error {
+	for i := len(m.Indexes) - 1; i >= 0; i-- {
+		if err := m.Indexes[i].drop(ctx, db); err != nil {
+			return err
+		}
+	}
+	if m.Down != nil {
+		return m.Down(ctx, db)
+	}
+	return nil
+}
+
+// Status describes a migration known to this binary or recorded in the
+// database
+type Status struct {
+	Version     int
+	Description string
+	// AppliedAt is nil while the migration is pending
+	AppliedAt *time.Time
+	// Unknown marks a version recorded in the database that this binary
+	// does not have, e.g. one applied by a newer release
+	Unknown bool
+}
+
+// ErrIrreversible is returned by Down for a migration without a Down step
+var ErrIrreversible = errors.New("migration cannot be reverted")
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+	lock       *lock
+}
+
+type record struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// New returns a Migrator for migrations, which must have unique versions
+// and an Up step or indexes each. Use All for the application's schema.
+func New(db *mongo.Database, migrations []Migration) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Up == nil && len(m.Indexes) == 0 {
+			panic(fmt.Sprintf("migrations: version %d has no Up step or indexes", m.Version))
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			panic(fmt.Sprintf("migrations: version %d is defined twice", m.Version))
+		}
+	}
+	return &Migrator{db: db, migrations: sorted, lock: newLock(db)}
+}
+
+// Status lists every migration, oldest first, with when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Description: migration.Description}
+		if rec, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &rec.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, rec := range applied {
+		rec := rec
+		statuses = append(statuses, Status{Version: rec.Version, Description: rec.Description, AppliedAt: &rec.AppliedAt, Unknown: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Pending returns the migrations Up would apply
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in order and returns those it
+// applied. It holds the migration lock throughout, waiting for another
+// instance to finish first, and stops at the first failure, losing the
+// lock among them with ErrLockLost; migrations applied before it stay
+// recorded.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	ctx, release, err := m.lock.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Read pending only once locked, so work done by the previous holder
+	// is not repeated
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range pending {
+		start := time.Now()
+		slog.Info("applying migration", "version", migration.Version, "description", migration.Description)
+		if err := migration.up(ctx, m.db); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, lockErr(ctx, err))
+		}
+		rec := record{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now().UTC()}
+		if _, err := m.db.Collection(Collection).InsertOne(ctx, rec); err != nil {
+			return done, fmt.Errorf("recording migration %d: %w", migration.Version, lockErr(ctx, err))
+		}
+		slog.Info("applied migration", "version", migration.Version, "duration_ms", time.Since(start).Milliseconds())
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down reverts the steps most recently applied migrations, newest first,
+// and returns those it reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	ctx, release, err := m.lock.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if migration.Up != nil && migration.Down == nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, ErrIrreversible)
+		}
+
+		slog.Info("reverting migration", "version", migration.Version, "description", migration.Description)
+		if err := migration.down(ctx, m.db); err != nil {
+			return done, fmt.Errorf("reverting migration %d (%s): %w", migration.Version, migration.Description, lockErr(ctx, err))
+		}
+		if _, err := m.db.Collection(Collection).DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+			return done, fmt.Errorf("unrecording migration %d: %w", migration.Version, lockErr(ctx, err))
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Reindex rebuilds the indexes of the applied migrations by dropping and
+// recreating them one at a time, holding the migration lock throughout.
+// Unlike the reIndex command it works on replica sets; queries fall back to
+// other indexes while one is rebuilt. Unique indexes are left alone: it
+// runs while the service does, and a unique index dropped even briefly
+// would let duplicates in that keep it from being created again.
+func (m *Migrator) Reindex(ctx context.Context) error {
+	ctx, release, err := m.lock.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		for _, index := range migration.Indexes {
+			if index.unique() {
+				slog.Info("not rebuilding unique index", "collection", index.Collection, "index", index.name())
+				continue
+			}
+			if err := index.drop(ctx, m.db); err != nil {
+				return lockErr(ctx, err)
+			}
+			if err := index.create(ctx, m.db); err != nil {
+				return lockErr(ctx, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lockErr blames err on the lost migration lock when that is what ended
+// ctx
+func lockErr(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrLockLost) {
+		return fmt.Errorf("%w: %w", ErrLockLost, err)
+	}
+	return err
+}
+
+// applied returns the recorded migrations by version
+func (m *Migrator) applied(ctx context.Context) (map[int]record, error) {
+	cursor, err := m.db.Collection(Collection).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", Collection, err)
+	}
+	var records []record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", Collection, err)
+	}
+
+	applied := make(map[int]record, len(records))
+	for _, rec := range records {
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
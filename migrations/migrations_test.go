@@ -0,0 +1,255 @@
+package migrations_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/migrations"
+	"ultra-chat-backend/models"
+)
+
+func noop(context.Context, *mongo.Database) error { return nil }
+
+func TestNewRejectsInvalidHistories(t *testing.T) {
+	// New does not touch the database; the client never connects
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database("unused")
+
+	for name, list := range map[string][]migrations.Migration{
+		"duplicate version": {{Version: 1, Up: noop}, {Version: 1, Up: noop}},
+		"missing up":        {{Version: 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("New accepted an invalid history")
+				}
+			}()
+			migrations.New(db, list)
+		})
+	}
+	migrations.New(db, migrations.All)
+}
+
+func TestUpDownStatus(t *testing.T) {
+	db := apptest.MongoDatabase(t)
+	ctx := context.Background()
+
+	var log []string
+	step := func(name string) func(context.Context, *mongo.Database) error {
+		return func(context.Context, *mongo.Database) error {
+			log = append(log, name)
+			return nil
+		}
+	}
+	migrator := migrations.New(db, []migrations.Migration{
+		{Version: 2, Description: "second", Up: step("up 2"), Down: step("down 2")},
+		{Version: 1, Description: "first", Up: step("up 1")},
+		{Version: 3, Description: "third", Up: step("up 3"), Down: step("down 3")},
+	})
+
+	applied, err := migrator.Up(ctx)
+	if err != nil || len(applied) != 3 {
+		t.Fatalf("Up = %d applied, %v", len(applied), err)
+	}
+	if again, err := migrator.Up(ctx); err != nil || len(again) != 0 {
+		t.Fatalf("second Up = %d applied, %v", len(again), err)
+	}
+
+	reverted, err := migrator.Down(ctx, 2)
+	if err != nil || len(reverted) != 2 {
+		t.Fatalf("Down = %d reverted, %v", len(reverted), err)
+	}
+	if _, err := migrator.Down(ctx, 1); !errors.Is(err, migrations.ErrIrreversible) {
+		t.Errorf("reverting an irreversible migration: %v", err)
+	}
+
+	want := []string{"up 1", "up 2", "up 3", "down 3", "down 2"}
+	if len(log) != len(want) {
+		t.Fatalf("ran %v, want %v", log, want)
+	}
+	for i := range want {
+		if log[i] != want[i] {
+			t.Fatalf("ran %v, want %v", log, want)
+		}
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 || statuses[0].AppliedAt == nil || statuses[1].AppliedAt != nil || statuses[2].AppliedAt != nil {
+		t.Errorf("status = %+v, want only version 1 applied", statuses)
+	}
+}
+
+func TestConcurrentUpMigratesOnce(t *testing.T) {
+	db := apptest.MongoDatabase(t)
+
+	var runs int32
+	list := []migrations.Migration{{Version: 1, Up: func(context.Context, *mongo.Database) error {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := migrations.New(db, list).Up(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if runs != 1 {
+		t.Errorf("migration ran %d times, want 1", runs)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	db := apptest.MongoDatabase(t)
+	ctx := context.Background()
+	users := db.Collection("users")
+
+	// The state left by early releases
+	if _, err := users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"id": "80351110224678912", "token": bson.M{"expires_in": 604800.0}, "summaries": bson.A{
+		bson.M{"id": "legacy-1", "content": "first"},
+		bson.M{"id": "legacy-2", "content": "second"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Collection("summaries").InsertOne(ctx, bson.M{
+		"summary_id": "updated-once", "user_id": "80351110224678912", "summary": "third",
+		"created_at": "2024-05-01T11:30:00+02:00", "updated_at": time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := migrations.New(db, migrations.All)
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var mixed models.Summary
+	if err := db.Collection("summaries").FindOne(ctx, bson.M{"summary_id": "updated-once"}).Decode(&mixed); err != nil {
+		t.Fatalf("decoding a summary with mixed timestamps: %v", err)
+	}
+	if !mixed.CreatedAt.Equal(time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)) {
+		t.Errorf("created_at = %v", mixed.CreatedAt)
+	}
+
+	// Users no longer collide on the missing user_id field
+	if _, err := users.InsertOne(ctx, bson.M{"id": "80351110224678913"}); err != nil {
+		t.Errorf("second user rejected: %v", err)
+	}
+	if _, err := users.InsertOne(ctx, bson.M{"id": "80351110224678913"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("duplicate id accepted: %v", err)
+	}
+
+	var moved bson.M
+	if err := db.Collection("summaries").FindOne(ctx, bson.M{"summary_id": "legacy-2"}).Decode(&moved); err != nil {
+		t.Fatalf("embedded summary was not moved: %v", err)
+	}
+	if moved["user_id"] != "80351110224678912" || moved["summary"] != "second" || moved["is_private"] != true {
+		t.Errorf("moved summary = %v", moved)
+	}
+	if _, ok := moved["created_at"].(primitive.DateTime); !ok {
+		t.Errorf("moved summary created_at = %T, want a date", moved["created_at"])
+	}
+
+	// Timestamps are backfilled in the shape the models decode
+	var user models.User
+	if err := users.FindOne(ctx, bson.M{"id": "80351110224678912"}).Decode(&user); err != nil {
+		t.Fatalf("decoding migrated user: %v", err)
+	}
+	if user.CreatedAt.IsZero() || !user.UpdatedAt.Equal(user.CreatedAt) || user.Token.ExpiresIn != 604800 {
+		t.Errorf("migrated user = %+v", user)
+	}
+	if n, _ := users.CountDocuments(ctx, bson.M{"summaries": bson.M{"$exists": true}}); n != 0 {
+		t.Errorf("%d users still embed summaries", n)
+	}
+
+	// Reverting the indexes, the timestamps and the move puts the summaries
+	// back
+	if _, err := migrator.Down(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	var embedding struct {
+		Summaries []bson.M `bson:"summaries"`
+	}
+	if err := users.FindOne(ctx, bson.M{"id": "80351110224678912"}).Decode(&embedding); err != nil || len(embedding.Summaries) != 2 {
+		t.Errorf("after Down user has %d embedded summaries (%v), want 2", len(embedding.Summaries), err)
+	}
+	if n, _ := db.Collection("summaries").CountDocuments(ctx, bson.M{"migrated_from": bson.M{"$exists": true}}); n != 0 {
+		t.Errorf("%d moved summaries left after Down", n)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	db := apptest.MongoDatabase(t)
+	ctx := context.Background()
+
+	migrator := migrations.New(db, migrations.All)
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	indexes := func() map[string][]string {
+		names := map[string][]string{}
+		for _, collection := range []string{"users", "summaries", "rate_limits", "audit_log", "identities", "api_keys"} {
+			specs, err := db.Collection(collection).Indexes().ListSpecifications(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, spec := range specs {
+				names[collection] = append(names[collection], spec.Name)
+			}
+			sort.Strings(names[collection])
+		}
+		return names
+	}
+	before := indexes()
+
+	if err := migrator.Reindex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if after := indexes(); !reflect.DeepEqual(after, before) {
+		t.Errorf("indexes after Reindex = %v, want %v", after, before)
+	}
+
+	// Unique indexes are never dropped, so they enforce uniqueness
+	// throughout
+	summaries := db.Collection("summaries")
+	if _, err := summaries.InsertOne(ctx, bson.M{"summary_id": "once"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := summaries.InsertOne(ctx, bson.M{"summary_id": "once"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("duplicate summary_id accepted after Reindex: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All is the application's schema history. Append new migrations with the
+// next version; never edit or renumber released ones.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "users: unique index on id instead of user_id",
+		Up:          usersIDIndexUp,
+		Down:        usersIDIndexDown,
+		Indexes: []Index{
+			{Collection: "users", Model: mongo.IndexModel{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		},
+	},
+	{
+		Version:     2,
+		Description: "summaries: index on user_id and server_id",
+		Indexes: []Index{
+			{Collection: "summaries", Model: mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "server_id", Value: 1}}}},
+		},
+	},
+	{
+		Version:     3,
+		Description: "rate_limits: expire idle buckets",
+		Indexes: []Index{
+			{Collection: "rate_limits", Model: mongo.IndexModel{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)}},
+		},
+	},
+	{
+		Version:     4,
+		Description: "summaries: move summaries embedded in users to the summaries collection",
+		Up:          moveEmbeddedSummariesUp,
+		Down:        moveEmbeddedSummariesDown,
+	},
+	{
+		Version:     5,
+		Description: "users, summaries: store timestamps as dates",
+		Up:          timestampsUp,
+		Down:        timestampsDown,
+	},
+	{
+		Version:     6,
+		Description: "summaries: unique index on summary_id",
+		Indexes: []Index{
+			{Collection: "summaries", Model: mongo.IndexModel{Keys: bson.D{{Key: "summary_id", Value: 1}}, Options: options.Index().SetUnique(true)}},
+		},
+	},
+	{
+		Version:     7,
+		Description: "audit_log: unique index on event_id and index on time",
+		Indexes: []Index{
+			{Collection: "audit_log", Model: mongo.IndexModel{Keys: bson.D{{Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)}},
+			{Collection: "audit_log", Model: mongo.IndexModel{Keys: bson.D{{Key: "time", Value: -1}}}},
+		},
+	},
+	{
+		Version:     8,
+		Description: "users: index on token.access_token",
+		Indexes: []Index{
+			{Collection: "users", Model: mongo.IndexModel{Keys: bson.D{{Key: "token.access_token", Value: 1}}}},
+		},
+	},
+	{
+		Version:     9,
+		Description: "identities: unique indexes on identity_id, provider and subject, and user_uuid and provider; users: index on uuid",
+		Indexes: []Index{
+			{Collection: "identities", Model: mongo.IndexModel{Keys: bson.D{{Key: "identity_id", Value: 1}}, Options: options.Index().SetUnique(true)}},
+			{Collection: "identities", Model: mongo.IndexModel{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)}},
+			{Collection: "identities", Model: mongo.IndexModel{Keys: bson.D{{Key: "user_uuid", Value: 1}, {Key: "provider", Value: 1}}, Options: options.Index().SetUnique(true)}},
+			{Collection: "users", Model: mongo.IndexModel{Keys: bson.D{{Key: "uuid", Value: 1}}}},
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("identities").Drop(ctx)
+		},
+	},
+	{
+		Version:     10,
+		Description: "api_keys: unique indexes on key_id and prefix, index on user_uuid",
+		Indexes: []Index{
+			{Collection: "api_keys", Model: mongo.IndexModel{Keys: bson.D{{Key: "key_id", Value: 1}}, Options: options.Index().SetUnique(true)}},
+			{Collection: "api_keys", Model: mongo.IndexModel{Keys: bson.D{{Key: "prefix", Value: 1}}, Options: options.Index().SetUnique(true)}},
+			{Collection: "api_keys", Model: mongo.IndexModel{Keys: bson.D{{Key: "user_uuid", Value: 1}, {Key: "created_at", Value: -1}}}},
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return db.Collection("api_keys").Drop(ctx)
+		},
+	},
+	{
+		Version:     11,
+		Description: "audit_log: indexes on actor, target and action by time",
+		Indexes: []Index{
+			{Collection: "audit_log", Model: mongo.IndexModel{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "time", Value: -1}}}},
+			{Collection: "audit_log", Model: mongo.IndexModel{Keys: bson.D{{Key: "target", Value: 1}, {Key: "time", Value: -1}}}},
+			{Collection: "audit_log", Model: mongo.IndexModel{Keys: bson.D{{Key: "action", Value: 1}, {Key: "time", Value: -1}}}},
+		},
+	},
+}
+
+// Early releases created a unique index on users.user_id, a field user
+// documents never had, so every user after the first collided on null.
+// The index on id replacing it is created once it is gone.
+func usersIDIndexUp(ctx context.Context, db *mongo.Database) error {
+	return dropIndex(ctx, db.Collection("users"), "user_id_1")
+}
+
+// usersIDIndexDown does not restore the broken user_id index; the index on
+// id goes with the migration's Indexes
+func usersIDIndexDown(context.Context, *mongo.Database) error {
+	return nil
+}
+
+// migratedFrom marks summaries moved out of user documents so Down can
+// move exactly those back
+const migratedFrom = "users.summaries"
+
+// moveEmbeddedSummariesUp copies each users.summaries entry into the
+// summaries collection, then removes the array. Embedded summaries only
+// carried an id and content; they become private summaries with no server,
+// stamped with the time of the move. Upserting by summary_id makes a rerun
+// after an interruption safe.
+func moveEmbeddedSummariesUp(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("users")
+	summaries := db.Collection("summaries")
+
+	cursor, err := users.Find(ctx, bson.M{"summaries": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"id": 1, "summaries": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for cursor.Next(ctx) {
+		var user struct {
+			ID        string   `bson:"id"`
+			Summaries []bson.M `bson:"summaries"`
+		}
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+
+		for _, embedded := range user.Summaries {
+			id, _ := embedded["id"].(string)
+			if id == "" {
+				id = uuid.New().String()
+			}
+			content, _ := embedded["content"].(string)
+			doc := bson.M{
+				"summary_id":    id,
+				"user_id":       user.ID,
+				"server_id":     "",
+				"is_private":    true,
+				"summary":       content,
+				"created_at":    now,
+				"updated_at":    now,
+				"migrated_from": migratedFrom,
+			}
+			if _, err := summaries.UpdateOne(ctx, bson.M{"summary_id": id}, bson.M{"$setOnInsert": doc}, options.Update().SetUpsert(true)); err != nil {
+				return fmt.Errorf("moving summary %s of user %s: %w", id, user.ID, err)
+			}
+		}
+
+		if _, err := users.UpdateOne(ctx, bson.M{"id": user.ID}, bson.M{"$unset": bson.M{"summaries": ""}}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func moveEmbeddedSummariesDown(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("users")
+	summaries := db.Collection("summaries")
+
+	cursor, err := summaries.Find(ctx, bson.M{"migrated_from": migratedFrom})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var summary struct {
+			SummaryID string `bson:"summary_id"`
+			UserID    string `bson:"user_id"`
+			Summary   string `bson:"summary"`
+		}
+		if err := cursor.Decode(&summary); err != nil {
+			return err
+		}
+
+		embedded := bson.M{"id": summary.SummaryID, "content": summary.Summary}
+		if _, err := users.UpdateOne(ctx, bson.M{"id": summary.UserID}, bson.M{"$push": bson.M{"summaries": embedded}}); err != nil {
+			return err
+		}
+		if _, err := summaries.DeleteOne(ctx, bson.M{"summary_id": summary.SummaryID}); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// timestampsUp backfills the fields models.User and models.Summary expect.
+// Summaries were created with RFC 3339 strings and only became dates once
+// updated; users had no timestamps at all, so they get the creation time
+// encoded in their ObjectID. Tokens decoded from JSON by early releases
+// stored expires_in as a double.
+func timestampsUp(ctx context.Context, db *mongo.Database) error {
+	summaries := db.Collection("summaries")
+	for _, field := range []string{"created_at", "updated_at"} {
+		if _, err := summaries.UpdateMany(ctx,
+			bson.M{field: bson.M{"$type": "string"}},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{field: bson.M{"$toDate": "$" + field}}}}},
+		); err != nil {
+			return fmt.Errorf("converting summaries.%s: %w", field, err)
+		}
+	}
+
+	users := db.Collection("users")
+	if _, err := users.UpdateMany(ctx,
+		bson.M{"created_at": bson.M{"$exists": false}, "_id": bson.M{"$type": "objectId"}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"created_at": bson.M{"$toDate": "$_id"}}}}},
+	); err != nil {
+		return fmt.Errorf("backfilling users.created_at: %w", err)
+	}
+	if _, err := users.UpdateMany(ctx,
+		bson.M{"created_at": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"created_at": "$$NOW"}}}},
+	); err != nil {
+		return fmt.Errorf("backfilling users.created_at: %w", err)
+	}
+	if _, err := users.UpdateMany(ctx,
+		bson.M{"updated_at": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"updated_at": "$created_at"}}}},
+	); err != nil {
+		return fmt.Errorf("backfilling users.updated_at: %w", err)
+	}
+	if _, err := users.UpdateMany(ctx,
+		bson.M{"token.expires_in": bson.M{"$type": "double"}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"token.expires_in": bson.M{"$toInt": "$token.expires_in"}}}}},
+	); err != nil {
+		return fmt.Errorf("converting users.token.expires_in: %w", err)
+	}
+	return nil
+}
+
+// timestampsDown turns summary timestamps back into the strings older
+// releases wrote. User timestamps are left in place; older releases ignore
+// them.
+func timestampsDown(ctx context.Context, db *mongo.Database) error {
+	summaries := db.Collection("summaries")
+	for _, field := range []string{"created_at", "updated_at"} {
+		if _, err := summaries.UpdateMany(ctx,
+			bson.M{field: bson.M{"$type": "date"}},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{field: bson.M{
+				"$dateToString": bson.M{"date": "$" + field, "format": "%Y-%m-%dT%H:%M:%SZ"},
+			}}}}},
+		); err != nil {
+			return fmt.Errorf("converting summaries.%s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// dropIndex drops the named index, treating a missing index or collection
+// as already dropped
+func dropIndex(ctx context.Context, collection *mongo.Collection, name string) error {
+	_, err := collection.Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == codeNamespaceNotFound || cmdErr.Code == codeIndexNotFound) {
+		return nil
+	}
+	return err
+}
+
+// MongoDB server error codes
+const (
+	codeNamespaceNotFound = 26
+	codeIndexNotFound     = 27
+)
@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// APIKey lets a bot or service call the summary API on behalf of users.
+// The key itself is never stored: Prefix finds the record and Hash checks
+// the rest.
+type APIKey struct {
+	ID string `bson:"key_id" json:"key_id"`
+	// Prefix is the public start of the key, unique among keys
+	Prefix string `bson:"prefix" json:"prefix"`
+	// Hash is the hex SHA-256 of the whole key
+	Hash string `bson:"hash" json:"-"`
+	// UserUUID and UserID identify the user who created the key
+	UserUUID string `bson:"user_uuid" json:"user_uuid"`
+	UserID   string `bson:"user_id" json:"user_id"`
+	Name     string `bson:"name" json:"name"`
+	// Kind is APIKeyUser or APIKeyServer
+	Kind   string   `bson:"kind" json:"kind"`
+	Scopes []string `bson:"scopes" json:"scopes"`
+	// ServerIDs are the Discord servers the key may act in; empty means
+	// every server, which only user keys may have
+	ServerIDs []string  `bson:"server_ids" json:"server_ids"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	// LastUsedAt is zero until the key is first used
+	LastUsedAt time.Time `bson:"last_used_at" json:"last_used_at"`
+}
+
+// Kinds of API key
+const (
+	// APIKeyUser keys act as the user who created them
+	APIKeyUser = "user"
+	// APIKeyServer keys act as any user, but only in their servers, which
+	// the creator manages
+	APIKeyServer = "server"
+)
+
+// Scopes an API key can be granted
+const (
+	ScopeSummariesRead  = "summaries:read"
+	ScopeSummariesWrite = "summaries:write"
+)
+
+// Scopes lists every scope, for validation and documentation
+var Scopes = []string{ScopeSummariesRead, ScopeSummariesWrite}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsServer reports whether the key may act in the given server
+func (k *APIKey) AllowsServer(serverID string) bool {
+	if len(k.ServerIDs) == 0 {
+		return k.Kind == APIKeyUser
+	}
+	for _, id := range k.ServerIDs {
+		if id == serverID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsSummary reports whether the key may see the summary. Besides its
+// servers, a server key is kept from private summaries other than its
+// creator's: managing a server does not make someone's private notes
+// theirs to read.
+func (k *APIKey) AllowsSummary(summary *Summary) bool {
+	if !k.AllowsServer(summary.ServerID) {
+		return false
+	}
+	return !summary.IsPrivate || k.Kind == APIKeyUser || k.UserID == summary.UserID
+}
@@ -0,0 +1,125 @@
+package models
+
+import "time"
+
+// AuditEvent records a security-relevant or data-changing action. Events
+// outlive what they describe, so they refer to users by UUID and never
+// copy their data; changed data is only recorded as hashes. The client's
+// IP address and user agent are kept to trace who acted.
+type AuditEvent struct {
+	ID   string    `bson:"event_id" json:"event_id"`
+	Time time.Time `bson:"time" json:"time"`
+	// Actor is who acted, e.g. "user:<uuid>"
+	Actor string `bson:"actor" json:"actor"`
+	// Action is what was done, e.g. AuditAccountDeleted
+	Action string `bson:"action" json:"action"`
+	// Target is what it was done to, in the same form as Actor
+	Target string `bson:"target" json:"target"`
+	// IP and UserAgent identify the client the request came from; empty
+	// for events recorded outside a request
+	IP        string `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent string `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	// BeforeHash and AfterHash fingerprint the target before and after the
+	// action, empty where it did not exist
+	BeforeHash string `bson:"before_hash,omitempty" json:"before_hash,omitempty"`
+	AfterHash  string `bson:"after_hash,omitempty" json:"after_hash,omitempty"`
+	// Details are further facts about the action, such as counts; nil when
+	// there are none
+	Details map[string]string `bson:"details,omitempty" json:"details,omitempty"`
+}
+
+// Audited actions
+const (
+	AuditLogin            = "auth.login"
+	AuditLogout           = "auth.logout"
+	AuditAccountDeleted   = "account.deleted"
+	AuditIdentityLinked   = "identity.linked"
+	AuditIdentityUnlinked = "identity.unlinked"
+	AuditAPIKeyCreated    = "api_key.created"
+	AuditAPIKeyRevoked    = "api_key.revoked"
+	AuditSummaryCreated   = "summary.created"
+	AuditSummaryUpdated   = "summary.updated"
+	AuditSummaryDeleted   = "summary.deleted"
+	AuditLogExported      = "audit_log.exported"
+	AuditUserDisabled     = "user.disabled"
+	AuditUserEnabled      = "user.enabled"
+	AuditTokensRevoked    = "user.tokens_revoked"
+	AuditMaintenanceRun   = "maintenance.run"
+)
+
+// AuditLog is how audit events refer to the audit log itself
+const AuditLog = "audit_log"
+
+// AuditUser is how audit events refer to the user with the given UUID
+func AuditUser(uuid string) string {
+	return "user:" + uuid
+}
+
+// AuditDiscordUser is how audit events refer to a user who has no record
+// and so no UUID, such as the author of a summary left behind by a user
+// removed from the database, by their Discord ID
+func AuditDiscordUser(id string) string {
+	return "discord:" + id
+}
+
+// AuditAPIKey is how audit events refer to the API key with the given ID
+func AuditAPIKey(id string) string {
+	return "api_key:" + id
+}
+
+// AuditSummary is how audit events refer to the summary with the given ID
+func AuditSummary(id string) string {
+	return "summary:" + id
+}
+
+// AuditMaintenance is how audit events refer to the maintenance task
+// with the given name
+func AuditMaintenance(task string) string {
+	return "maintenance:" + task
+}
+
+// AuditFilter selects audit events; empty fields match every event
+type AuditFilter struct {
+	Actor  string
+	Target string
+	Action string
+	// Since and Until bound the event time, inclusive and exclusive
+	Since time.Time
+	Until time.Time
+	// Limit caps how many events are returned; zero means no cap
+	Limit int
+	// After, when set, selects only the events listed after it, for
+	// reading a long log a page at a time
+	After *AuditCursor
+}
+
+// AuditCursor is the position of an event in the newest-first order of
+// the audit log
+type AuditCursor struct {
+	Time time.Time
+	ID   string
+}
+
+// CursorOf returns the position of event
+func CursorOf(event AuditEvent) *AuditCursor {
+	return &AuditCursor{Time: event.Time, ID: event.ID}
+}
+
+// Matches reports whether event is selected by f, ignoring Limit
+func (f AuditFilter) Matches(event AuditEvent) bool {
+	switch {
+	case f.Actor != "" && event.Actor != f.Actor:
+		return false
+	case f.Target != "" && event.Target != f.Target:
+		return false
+	case f.Action != "" && event.Action != f.Action:
+		return false
+	case !f.Since.IsZero() && event.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !event.Time.Before(f.Until):
+		return false
+	case f.After != nil && !event.Time.Before(f.After.Time) && !(event.Time.Equal(f.After.Time) && event.ID < f.After.ID):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Identity is an account at an identity provider other than Discord,
+// linked to a user. A user has at most one identity per provider, and an
+// identity belongs to at most one user.
+type Identity struct {
+	ID       string `bson:"identity_id" json:"identity_id"`
+	Provider string `bson:"provider" json:"provider"`
+	// Subject is the user's stable ID at the provider
+	Subject  string `bson:"subject" json:"subject"`
+	UserUUID string `bson:"user_uuid" json:"user_uuid"`
+	Username string `bson:"username" json:"username"`
+	Email    string `bson:"email" json:"email"`
+	// Token is the provider's token from the identity's last login
+	Token     Token     `bson:"token" json:"-"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
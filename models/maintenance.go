@@ -0,0 +1,9 @@
+package models
+
+// Orphans counts the records a purge found left behind by users who no
+// longer exist, typically because they were deleted by hand
+type Orphans struct {
+	Summaries  int64 `json:"summaries"`
+	Identities int64 `json:"identities"`
+	APIKeys    int64 `json:"api_keys"`
+}
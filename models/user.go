@@ -1,15 +1,126 @@
+// Package models holds the records the repositories store. Field names are
+// the stored names; timestamps are always time.Time, stored as BSON dates.
 package models
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// User is a Discord user who has signed in through the OAuth flow
 type User struct {
-	ID            string                 `bson:"id"`
-	UUID          string                 `bson:"uuid"`
-	Token         map[string]interface{} `bson:"token"`
-	Username      string                 `bson:"username"`
-	Discriminator string                 `bson:"discriminator"`
-	Summaries     []Summary              `bson:"summaries,omitempty"`
+	ID            string      `bson:"id" json:"id"`
+	UUID          string      `bson:"uuid" json:"uuid"`
+	Token         Token       `bson:"token" json:"-"`
+	Username      string      `bson:"username" json:"username"`
+	Discriminator string      `bson:"discriminator" json:"discriminator"`
+	Preferences   Preferences `bson:"preferences" json:"preferences"`
+	// Disabled users are turned away wherever they would authenticate,
+	// until an admin enables them again
+	Disabled  bool      `bson:"disabled" json:"disabled"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Preferences are the settings a user chooses for their account. Empty
+// fields have not been set; see DefaultTimezone and DefaultLanguage.
+type Preferences struct {
+	// DefaultPrivate is the visibility of summaries created without one
+	DefaultPrivate bool `bson:"default_private" json:"default_private"`
+	// Timezone is an IANA time zone name
+	Timezone string `bson:"timezone" json:"timezone"`
+	// Language is the BCP 47 tag of the language summaries are written in
+	Language string `bson:"language" json:"language"`
+}
+
+// Preferences that have never been set read as these
+const (
+	DefaultTimezone = "UTC"
+	DefaultLanguage = "en"
+)
+
+// PreferencesPatch lists the preferences to change; nil fields are left as
+// they are
+type PreferencesPatch struct {
+	DefaultPrivate *bool
+	Timezone       *string
+	Language       *string
+}
+
+// Empty reports whether the patch changes nothing
+func (p PreferencesPatch) Empty() bool {
+	return p.DefaultPrivate == nil && p.Timezone == nil && p.Language == nil
+}
+
+// Token is the OAuth2 token Discord issued for a user, in the shape Discord
+// returned it
+type Token struct {
+	AccessToken  string `bson:"access_token"`
+	TokenType    string `bson:"token_type"`
+	ExpiresIn    int    `bson:"expires_in"`
+	RefreshToken string `bson:"refresh_token"`
+	Scope        string `bson:"scope"`
 }
 
+// Summary is a chat summary written by a user
 type Summary struct {
-	ID      string `bson:"id"`
-	Content string `bson:"content"`
+	ID        string    `bson:"summary_id" json:"summary_id"`
+	UserID    string    `bson:"user_id" json:"user_id"`
+	ServerID  string    `bson:"server_id" json:"server_id"`
+	IsPrivate bool      `bson:"is_private" json:"is_private"`
+	Content   string    `bson:"summary" json:"summary"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ServerStats sums up the summaries written in one Discord server
+type ServerStats struct {
+	ServerID string `bson:"server_id" json:"server_id"`
+	// Summaries counts every summary, Private the private ones among them
+	Summaries int64 `bson:"summaries" json:"summaries"`
+	Private   int64 `bson:"private" json:"private"`
+	// Authors counts the users who wrote them
+	Authors int64 `bson:"authors" json:"authors"`
+	// LastUpdatedAt is when a summary in the server last changed
+	LastUpdatedAt time.Time `bson:"last_updated_at" json:"last_updated_at"`
+}
+
+// AuditHash fingerprints the summary's fields other than its timestamps,
+// for the before and after hashes of audit events
+func (s Summary) AuditHash() string {
+	h := sha256.New()
+	for _, field := range []string{s.ID, s.UserID, s.ServerID, strconv.FormatBool(s.IsPrivate), s.Content} {
+		// Length-prefixed so field boundaries cannot shift
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SummaryPatch lists the summary fields to change; nil fields are left as
+// they are
+type SummaryPatch struct {
+	ServerID  *string
+	IsPrivate *bool
+	Content   *string
+}
+
+// Apply changes the fields of s the patch sets, leaving the timestamps
+func (p SummaryPatch) Apply(s *Summary) {
+	if p.ServerID != nil {
+		s.ServerID = *p.ServerID
+	}
+	if p.IsPrivate != nil {
+		s.IsPrivate = *p.IsPrivate
+	}
+	if p.Content != nil {
+		s.Content = *p.Content
+	}
+}
+
+// Empty reports whether the patch changes nothing
+func (p SummaryPatch) Empty() bool {
+	return p.ServerID == nil && p.IsPrivate == nil && p.Content == nil
 }
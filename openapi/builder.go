@@ -0,0 +1,169 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Route describes a single operation in terms of Go types. Request and
+// response bodies are given as zero values of the types the handler binds
+// and returns, e.g. handlers.CreateSummaryRequest{}.
+type Route struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+	Parameters  []Parameter
+	Security    []SecurityRequirement
+	Request     interface{}
+	Responses   []ResponseSpec
+}
+
+// ResponseSpec describes a response by status code and body type. A nil
+// Body documents a response without content; ContentType defaults to
+// application/json.
+type ResponseSpec struct {
+	Status      int
+	Description string
+	Body        interface{}
+	ContentType string
+	Headers     map[string]*Header
+}
+
+// Builder accumulates routes into a Document
+type Builder struct {
+	doc *Document
+}
+
+// NewBuilder starts an empty document with the given info block
+func NewBuilder(info Info) *Builder {
+	return &Builder{doc: &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}}
+}
+
+// Server adds a server entry to the document
+func (b *Builder) Server(url, description string) *Builder {
+	b.doc.Servers = append(b.doc.Servers, Server{URL: url, Description: description})
+	return b
+}
+
+// Tag declares a tag used to group operations
+func (b *Builder) Tag(name, description string) *Builder {
+	b.doc.Tags = append(b.doc.Tags, Tag{Name: name, Description: description})
+	return b
+}
+
+// SecurityScheme declares a named security scheme operations can require
+func (b *Builder) SecurityScheme(name string, scheme *SecurityScheme) *Builder {
+	if b.doc.Components.SecuritySchemes == nil {
+		b.doc.Components.SecuritySchemes = map[string]*SecurityScheme{}
+	}
+	b.doc.Components.SecuritySchemes[name] = scheme
+	return b
+}
+
+// Add registers a route. Echo-style path parameters (":id") are converted
+// to OpenAPI templates ("{id}") and documented as required path parameters.
+func (b *Builder) Add(route Route) *Builder {
+	path := PathFromEcho(route.Path)
+
+	op := &Operation{
+		OperationID: route.OperationID,
+		Summary:     route.Summary,
+		Description: route.Description,
+		Tags:        route.Tags,
+		Deprecated:  route.Deprecated,
+		Security:    route.Security,
+		Responses:   map[string]*Response{},
+	}
+
+	for _, name := range pathParams(route.Path) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	op.Parameters = append(op.Parameters, route.Parameters...)
+
+	if route.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				"application/json": {Schema: b.schemaFor(reflect.TypeOf(route.Request))},
+			},
+		}
+	}
+
+	for _, spec := range route.Responses {
+		description := spec.Description
+		if description == "" {
+			description = http.StatusText(spec.Status)
+		}
+		response := &Response{Description: description, Headers: spec.Headers}
+		if spec.Body != nil {
+			contentType := spec.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			response.Content = map[string]MediaType{
+				contentType: {Schema: b.schemaFor(reflect.TypeOf(spec.Body))},
+			}
+		}
+		op.Responses[strconv.Itoa(spec.Status)] = response
+	}
+
+	item, ok := b.doc.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		b.doc.Paths[path] = item
+	}
+	item.setOperation(route.Method, op)
+	return b
+}
+
+// Document returns the built document
+func (b *Builder) Document() *Document {
+	return b.doc
+}
+
+// Has reports whether the document describes method on the Echo-style path
+func (d *Document) Has(method, echoPath string) bool {
+	item, ok := d.Paths[PathFromEcho(echoPath)]
+	if !ok {
+		return false
+	}
+	return item.Operation(method) != nil
+}
+
+// PathFromEcho converts an Echo route path such as /summaries/:id into the
+// OpenAPI template /summaries/{id}
+func PathFromEcho(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func pathParams(path string) []string {
+	var params []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			params = append(params, segment[1:])
+		}
+	}
+	return params
+}
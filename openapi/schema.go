@@ -0,0 +1,206 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the schema for t, registering named structs as
+// components and referring to them by $ref.
+func (b *Builder) schemaFor(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schemaFor(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := t.Name()
+		if _, ok := b.doc.Components.Schemas[name]; !ok {
+			// Reserve the name first so recursive types terminate
+			b.doc.Components.Schemas[name] = &Schema{}
+			*b.doc.Components.Schemas[name] = *b.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+// structSchema describes the JSON encoding of a struct type. Fields follow
+// encoding/json rules. Request types declare requiredness and limits with
+// `validate` tags; for other types, fields without omitempty that are not
+// pointers are required. A `doc` tag becomes the property description and
+// `example` its example.
+func (b *Builder) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	validated := hasValidateTags(t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts := parseJSONTag(field.Tag.Get("json"))
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner := b.structSchema(embedded)
+				for key, value := range inner.Properties {
+					schema.Properties[key] = value
+				}
+				schema.Required = append(schema.Required, inner.Required...)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := b.schemaFor(field.Type)
+		if doc := field.Tag.Get("doc"); doc != "" || field.Tag.Get("example") != "" {
+			// $ref siblings are ignored by OpenAPI 3.0, so only annotate inline schemas
+			if property.Ref == "" {
+				property.Description = doc
+				if example := field.Tag.Get("example"); example != "" {
+					property.Example = example
+				}
+			}
+		}
+		if field.Type.Kind() == reflect.Ptr && property.Ref == "" {
+			property.Nullable = true
+		}
+		tag, elementTag, dives := strings.Cut(field.Tag.Get("validate"), "dive")
+		rules := parseValidateTag(strings.TrimSuffix(tag, ","))
+		if property.Ref == "" {
+			applyRules(property, rules)
+		}
+		if dives && property.Items != nil && property.Items.Ref == "" {
+			applyRules(property.Items, parseValidateTag(strings.TrimPrefix(elementTag, ",")))
+		}
+		schema.Properties[name] = property
+
+		required := !opts.contains("omitempty") && field.Type.Kind() != reflect.Ptr
+		if validated {
+			_, required = rules["required"]
+		}
+		if required {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	return schema
+}
+
+type tagOptions string
+
+func (o tagOptions) contains(option string) bool {
+	for _, opt := range strings.Split(string(o), ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+func parseJSONTag(tag string) (string, tagOptions) {
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opts)
+}
+
+// hasValidateTags reports whether any field of t carries a validate tag
+func hasValidateTags(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("validate") != "" {
+			return true
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && hasValidateTags(field.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseValidateTag splits a validator tag such as "required,max=10" into
+// rule names and parameters. The rules of a slice's elements follow "dive"
+// and are parsed separately.
+func parseValidateTag(tag string) map[string]string {
+	rules := map[string]string{}
+	if tag == "" || tag == "-" {
+		return rules
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		rules[name] = param
+	}
+	return rules
+}
+
+// applyRules documents the validator rules the schema language can express
+func applyRules(schema *Schema, rules map[string]string) {
+	switch schema.Type {
+	case "string":
+		if n, err := strconv.Atoi(rules["min"]); err == nil {
+			schema.MinLength = &n
+		}
+		if n, err := strconv.Atoi(rules["max"]); err == nil {
+			schema.MaxLength = &n
+		}
+		if values, ok := rules["oneof"]; ok {
+			schema.Enum = strings.Fields(values)
+		}
+	case "array":
+		if n, err := strconv.Atoi(rules["min"]); err == nil {
+			schema.MinItems = &n
+		}
+		if n, err := strconv.Atoi(rules["max"]); err == nil {
+			schema.MaxItems = &n
+		}
+	}
+	if _, ok := rules["uuid"]; ok {
+		schema.Format = "uuid"
+	}
+	if _, ok := rules["snowflake"]; ok {
+		schema.Pattern = "^[0-9]{17,20}$"
+		if schema.Description == "" {
+			schema.Description = "Discord snowflake ID"
+		}
+	}
+}
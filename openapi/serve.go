@@ -0,0 +1,47 @@
+package openapi
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed docs.html
+var docsPage string
+
+// swaggerUI holds the stylesheet and script of swagger-ui 5.18.2, copied
+// unmodified from its dist directory, so the documentation page loads
+// nothing from third parties
+//
+//go:embed swaggerui
+var swaggerUI embed.FS
+
+// SpecHandler serves the document as JSON
+func SpecHandler(doc *Document) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, doc)
+	}
+}
+
+// DocsHandler serves an interactive documentation page that loads the
+// document from specURL and swagger-ui from assetsURL, where AssetsHandler
+// is mounted
+func DocsHandler(title, specURL, assetsURL string) echo.HandlerFunc {
+	page := strings.NewReplacer("{{title}}", title, "{{spec_url}}", specURL, "{{assets_url}}", assetsURL).Replace(docsPage)
+	return func(c echo.Context) error {
+		return c.HTML(http.StatusOK, page)
+	}
+}
+
+// AssetsHandler serves the swagger-ui files the documentation page uses,
+// mounted under prefix
+func AssetsHandler(prefix string) echo.HandlerFunc {
+	assets, err := fs.Sub(swaggerUI, "swaggerui")
+	if err != nil {
+		panic(err)
+	}
+	return echo.WrapHandler(http.StripPrefix(prefix, http.FileServer(http.FS(assets))))
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/logging"
+
+	"github.com/labstack/echo/v4"
+)
+
+// KeyFunc identifies the client a request is counted against
+type KeyFunc func(c echo.Context) string
+
+// KeyByCallerOrIP counts requests whose caller was recognised against the
+// caller stored in the echo context under callerKey, such as user:<id>,
+// and everything else against the client IP. The caller must be set by
+// middleware running before the limiter.
+func KeyByCallerOrIP(callerKey string) KeyFunc {
+	return func(c echo.Context) string {
+		if caller, ok := c.Get(callerKey).(string); ok && caller != "" {
+			return caller
+		}
+		return "ip:" + c.RealIP()
+	}
+}
+
+// Limiter applies per-group policies backed by a shared Store
+type Limiter struct {
+	store    Store
+	policies map[string]Limit
+	key      KeyFunc
+	now      func() time.Time
+}
+
+// NewLimiter limits each named route group according to policies
+func NewLimiter(store Store, policies map[string]Limit, key KeyFunc) *Limiter {
+	return &Limiter{store: store, policies: policies, key: key, now: time.Now}
+}
+
+// For returns the middleware enforcing group's policy. Groups without a
+// policy, and a nil Limiter, are not limited.
+func (l *Limiter) For(group string) echo.MiddlewareFunc {
+	if l == nil {
+		return passthrough
+	}
+	limit, ok := l.policies[group]
+	if !ok {
+		return passthrough
+	}
+
+	policy := fmt.Sprintf("%d;w=%d;burst=%d", limit.Requests, int(limit.Per.Seconds()), limit.burst())
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := group + ":" + l.key(c)
+
+			res, err := l.store.Take(c.Request().Context(), key, limit, l.now())
+			if err != nil {
+				// Fail open: a broken store must not take the API down
+				logging.FromContext(c.Request().Context()).Warn("rate limiter unavailable, allowing request", "group", group, "error", err)
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set("RateLimit-Policy", policy)
+			header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			header.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+			if !res.Allowed {
+				header.Set("Retry-After", ceilSeconds(res.RetryAfter))
+				return apperror.New(http.StatusTooManyRequests, apperror.CodeRateLimited, "Too many requests, retry later")
+			}
+			return next(c)
+		}
+	}
+}
+
+func passthrough(next echo.HandlerFunc) echo.HandlerFunc {
+	return next
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
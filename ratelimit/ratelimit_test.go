@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ultra-chat-backend/handlers"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := map[string]Limit{
+		"30/m":    {Requests: 30, Per: time.Minute},
+		"5/s:10":  {Requests: 5, Per: time.Second, Burst: 10},
+		"1000/h ": {Requests: 1000, Per: time.Hour},
+	}
+	for input, want := range tests {
+		got, err := ParseLimit(input)
+		if err != nil || got != want {
+			t.Errorf("ParseLimit(%q) = %+v, %v; want %+v", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "30", "0/m", "30/d", "30/m:x", "-1/s"} {
+		if _, err := ParseLimit(input); err == nil {
+			t.Errorf("ParseLimit(%q) succeeded, want error", input)
+		}
+	}
+}
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 60, Per: time.Minute, Burst: 2}
+	now := time.Unix(1700000000, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		res, _ := store.Take(ctx, "k", limit, now)
+		if !res.Allowed {
+			t.Fatalf("take %d denied within burst", i)
+		}
+	}
+
+	res, _ := store.Take(ctx, "k", limit, now)
+	if res.Allowed || res.Remaining != 0 {
+		t.Fatalf("third take = %+v, want denied", res)
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s at 1 token/s", res.RetryAfter)
+	}
+
+	res, _ = store.Take(ctx, "other", limit, now)
+	if !res.Allowed {
+		t.Errorf("buckets are not isolated per key")
+	}
+
+	res, _ = store.Take(ctx, "k", limit, now.Add(time.Second))
+	if !res.Allowed {
+		t.Errorf("bucket did not refill after a second")
+	}
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), map[string]Limit{
+		"auth": {Requests: 1, Per: time.Minute},
+	}, KeyByCallerOrIP("caller"))
+	now := time.Unix(1700000000, 0)
+	limiter.now = func() time.Time { return now }
+
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.GET("/limited", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, limiter.For("auth"))
+	e.GET("/open", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, limiter.For("unknown"))
+
+	do := func(path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do("/limited", "10.0.0.1")
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Remaining") != "0" || rec.Header().Get("RateLimit-Limit") != "1" {
+		t.Fatalf("first request: %d %v", rec.Code, rec.Header())
+	}
+
+	rec = do("/limited", "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("second request: %d %v", rec.Code, rec.Header())
+	}
+
+	if rec = do("/limited", "10.0.0.2"); rec.Code != http.StatusOK {
+		t.Errorf("another IP was limited: %d", rec.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec = do("/open", "10.0.0.1"); rec.Code != http.StatusOK {
+			t.Fatalf("group without policy was limited")
+		}
+	}
+}
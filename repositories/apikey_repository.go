@@ -0,0 +1,131 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"ultra-chat-backend/models"
+)
+
+// APIKeyRepository stores the API keys users have created
+type APIKeyRepository interface {
+	// CreateAPIKey stores a new key, stamping CreatedAt when it is unset
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// FindAPIKeyByPrefix returns the key with the given prefix, or
+	// ErrAPIKeyNotFound
+	FindAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	// ListAPIKeys returns the keys the user with the given UUID created,
+	// newest first
+	ListAPIKeys(ctx context.Context, userUUID string) ([]models.APIKey, error)
+	// TouchAPIKey records that the key with the given ID was used at usedAt
+	TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error
+	// RevokeAPIKey deletes the user's key with the given ID, or fails with
+	// ErrAPIKeyNotFound
+	RevokeAPIKey(ctx context.Context, userUUID, keyID string) error
+	// RevokeAPIKeys deletes every key of the user and returns how many
+	// there were
+	RevokeAPIKeys(ctx context.Context, userUUID string) (int64, error)
+}
+
+type apiKeyRepository struct {
+	collection *mongo.Collection
+	timeouts   Timeouts
+}
+
+// NewAPIKeyRepository stores API keys in the api_keys collection of db,
+// bounding each operation by timeouts
+func NewAPIKeyRepository(db *mongo.Database, timeouts Timeouts) APIKeyRepository {
+	return &apiKeyRepository{
+		collection: db.Collection("api_keys"),
+		timeouts:   timeouts,
+	}
+}
+
+func (r *apiKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "CreateAPIKey")
+	defer cancel()
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now().UTC()
+	}
+	if _, err := r.collection.InsertOne(ctx, key); err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) FindAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "FindAPIKeyByPrefix")
+	defer cancel()
+
+	var key models.APIKey
+	err := r.collection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *apiKeyRepository) ListAPIKeys(ctx context.Context, userUUID string) ([]models.APIKey, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "ListAPIKeys")
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "key_id", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"user_uuid": userUUID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve API keys: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode API keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *apiKeyRepository) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "TouchAPIKey")
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"key_id": keyID}, bson.M{"$set": bson.M{"last_used_at": usedAt.UTC()}})
+	if err != nil {
+		return fmt.Errorf("failed to record API key use: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) RevokeAPIKey(ctx context.Context, userUUID, keyID string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "RevokeAPIKey")
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_uuid": userUUID, "key_id": keyID})
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) RevokeAPIKeys(ctx context.Context, userUUID string) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "RevokeAPIKeys")
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"user_uuid": userUUID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return result.DeletedCount, nil
+}
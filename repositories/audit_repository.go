@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"ultra-chat-backend/models"
+)
+
+// AuditRepository is an append-only log of audit events. Events are never
+// deleted, including when the account they describe is; the only change
+// they see is losing the client details of an account that is erased.
+type AuditRepository interface {
+	// RecordEvent appends event, stamping Time when it is unset
+	RecordEvent(ctx context.Context, event *models.AuditEvent) error
+	// ListEvents returns the events matching filter, newest first
+	ListEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+	// CountEvents returns how many events match filter, ignoring Limit
+	CountEvents(ctx context.Context, filter models.AuditFilter) (int64, error)
+	// RedactClients clears the IP address and user agent of every event
+	// whose actor is one of actors and returns how many events that was
+	RedactClients(ctx context.Context, actors []string) (int64, error)
+}
+
+type auditRepository struct {
+	collection *mongo.Collection
+	timeouts   Timeouts
+}
+
+// NewAuditRepository stores audit events in the audit_log collection of
+// db, bounding each operation by timeouts
+func NewAuditRepository(db *mongo.Database, timeouts Timeouts) AuditRepository {
+	return &auditRepository{
+		collection: db.Collection("audit_log"),
+		timeouts:   timeouts,
+	}
+}
+
+func (r *auditRepository) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	ctx, cancel := r.timeouts.Context(ctx, "audit", "RecordEvent")
+	defer cancel()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if _, err := r.collection.InsertOne(ctx, event); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+func (r *auditRepository) ListEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "audit", "ListEvents")
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: -1}, {Key: "event_id", Value: -1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+
+	cursor, err := r.collection.Find(ctx, auditQuery(filter), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit events: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode audit events: %w", err)
+	}
+	for i := range events {
+		if len(events[i].Details) == 0 {
+			events[i].Details = nil
+		}
+	}
+	return events, nil
+}
+
+func (r *auditRepository) CountEvents(ctx context.Context, filter models.AuditFilter) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "audit", "CountEvents")
+	defer cancel()
+
+	n, err := r.collection.CountDocuments(ctx, auditQuery(filter))
+	if err != nil {
+		return 0, fmt.Errorf("failed to count audit events: %w", err)
+	}
+	return n, nil
+}
+
+// auditQuery selects the events matching filter
+func auditQuery(filter models.AuditFilter) bson.M {
+	query := bson.M{}
+	if filter.Actor != "" {
+		query["actor"] = filter.Actor
+	}
+	if filter.Target != "" {
+		query["target"] = filter.Target
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if !filter.Since.IsZero() || !filter.Until.IsZero() {
+		between := bson.M{}
+		if !filter.Since.IsZero() {
+			between["$gte"] = filter.Since
+		}
+		if !filter.Until.IsZero() {
+			between["$lt"] = filter.Until
+		}
+		query["time"] = between
+	}
+	if filter.After != nil {
+		query["$or"] = bson.A{
+			bson.M{"time": bson.M{"$lt": filter.After.Time}},
+			bson.M{"time": filter.After.Time, "event_id": bson.M{"$lt": filter.After.ID}},
+		}
+	}
+	return query
+}
+
+func (r *auditRepository) RedactClients(ctx context.Context, actors []string) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "audit", "RedactClients")
+	defer cancel()
+
+	if len(actors) == 0 {
+		return 0, nil
+	}
+	result, err := r.collection.UpdateMany(ctx,
+		bson.M{"actor": bson.M{"$in": actors}},
+		bson.M{"$set": bson.M{"ip": "", "user_agent": ""}},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to redact audit events: %w", err)
+	}
+	return result.MatchedCount, nil
+}
@@ -0,0 +1,144 @@
+package repositories
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"ultra-chat-backend/models"
+)
+
+// BulkStore reads and writes a backend's records wholesale, for moving data
+// between backends. Lists are in key order (users by ID, summaries by
+// summary ID, audit events by event ID, identities by identity ID, API
+// keys by key ID) starting after the given key, so a reader can page
+// through with the last key it saw. Puts insert or replace by key, so writing a
+// batch again is harmless.
+type BulkStore interface {
+	ListUsers(ctx context.Context, afterID string, limit int) ([]models.User, error)
+	ListSummaries(ctx context.Context, afterID string, limit int) ([]models.Summary, error)
+	PutUsers(ctx context.Context, users []models.User) error
+	PutSummaries(ctx context.Context, summaries []models.Summary) error
+	ListAuditEvents(ctx context.Context, afterID string, limit int) ([]models.AuditEvent, error)
+	PutAuditEvents(ctx context.Context, events []models.AuditEvent) error
+	ListIdentities(ctx context.Context, afterID string, limit int) ([]models.Identity, error)
+	PutIdentities(ctx context.Context, identities []models.Identity) error
+	ListAPIKeys(ctx context.Context, afterID string, limit int) ([]models.APIKey, error)
+	PutAPIKeys(ctx context.Context, keys []models.APIKey) error
+}
+
+type mongoBulkStore struct {
+	users      *mongo.Collection
+	summaries  *mongo.Collection
+	audit      *mongo.Collection
+	identities *mongo.Collection
+	apiKeys    *mongo.Collection
+}
+
+// NewMongoBulkStore returns a BulkStore for the users, summaries,
+// audit_log, identities and api_keys collections of db, which must be
+// migrated
+func NewMongoBulkStore(db *mongo.Database) BulkStore {
+	return &mongoBulkStore{
+		users:      db.Collection("users"),
+		summaries:  db.Collection("summaries"),
+		audit:      db.Collection("audit_log"),
+		identities: db.Collection("identities"),
+		apiKeys:    db.Collection("api_keys"),
+	}
+}
+
+func (s *mongoBulkStore) ListUsers(ctx context.Context, afterID string, limit int) ([]models.User, error) {
+	users := []models.User{}
+	err := list(ctx, s.users, "id", afterID, limit, &users)
+	return users, err
+}
+
+func (s *mongoBulkStore) ListSummaries(ctx context.Context, afterID string, limit int) ([]models.Summary, error) {
+	summaries := []models.Summary{}
+	err := list(ctx, s.summaries, "summary_id", afterID, limit, &summaries)
+	return summaries, err
+}
+
+func (s *mongoBulkStore) ListAuditEvents(ctx context.Context, afterID string, limit int) ([]models.AuditEvent, error) {
+	events := []models.AuditEvent{}
+	err := list(ctx, s.audit, "event_id", afterID, limit, &events)
+	for i := range events {
+		if len(events[i].Details) == 0 {
+			events[i].Details = nil
+		}
+	}
+	return events, err
+}
+
+func (s *mongoBulkStore) ListIdentities(ctx context.Context, afterID string, limit int) ([]models.Identity, error) {
+	identities := []models.Identity{}
+	err := list(ctx, s.identities, "identity_id", afterID, limit, &identities)
+	return identities, err
+}
+
+func (s *mongoBulkStore) ListAPIKeys(ctx context.Context, afterID string, limit int) ([]models.APIKey, error) {
+	keys := []models.APIKey{}
+	err := list(ctx, s.apiKeys, "key_id", afterID, limit, &keys)
+	return keys, err
+}
+
+func list(ctx context.Context, collection *mongo.Collection, key, after string, limit int, out interface{}) error {
+	cursor, err := collection.Find(ctx,
+		bson.M{key: bson.M{"$gt": after}},
+		options.Find().SetSort(bson.D{{Key: key, Value: 1}}).SetLimit(int64(limit)).SetProjection(bson.M{"_id": 0}),
+	)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, out)
+}
+
+func (s *mongoBulkStore) PutUsers(ctx context.Context, users []models.User) error {
+	writes := make([]mongo.WriteModel, len(users))
+	for i := range users {
+		writes[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"id": users[i].ID}).SetReplacement(&users[i]).SetUpsert(true)
+	}
+	return put(ctx, s.users, writes)
+}
+
+func (s *mongoBulkStore) PutSummaries(ctx context.Context, summaries []models.Summary) error {
+	writes := make([]mongo.WriteModel, len(summaries))
+	for i := range summaries {
+		writes[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"summary_id": summaries[i].ID}).SetReplacement(&summaries[i]).SetUpsert(true)
+	}
+	return put(ctx, s.summaries, writes)
+}
+
+func (s *mongoBulkStore) PutAuditEvents(ctx context.Context, events []models.AuditEvent) error {
+	writes := make([]mongo.WriteModel, len(events))
+	for i := range events {
+		writes[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"event_id": events[i].ID}).SetReplacement(&events[i]).SetUpsert(true)
+	}
+	return put(ctx, s.audit, writes)
+}
+
+func (s *mongoBulkStore) PutIdentities(ctx context.Context, identities []models.Identity) error {
+	writes := make([]mongo.WriteModel, len(identities))
+	for i := range identities {
+		writes[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"identity_id": identities[i].ID}).SetReplacement(&identities[i]).SetUpsert(true)
+	}
+	return put(ctx, s.identities, writes)
+}
+
+func (s *mongoBulkStore) PutAPIKeys(ctx context.Context, keys []models.APIKey) error {
+	writes := make([]mongo.WriteModel, len(keys))
+	for i := range keys {
+		writes[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"key_id": keys[i].ID}).SetReplacement(&keys[i]).SetUpsert(true)
+	}
+	return put(ctx, s.apiKeys, writes)
+}
+
+func put(ctx context.Context, collection *mongo.Collection, writes []mongo.WriteModel) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
@@ -0,0 +1,15 @@
+package repositories
+
+import "errors"
+
+// Domain errors returned by the repositories. Callers should compare with
+// errors.Is; the HTTP layer maps them to statuses.
+var (
+	ErrSummaryNotFound  = errors.New("no matching summary found")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityLinked means the identity belongs to another user, or the
+	// user already has an identity at the same provider
+	ErrIdentityLinked = errors.New("identity already linked")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
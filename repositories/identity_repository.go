@@ -0,0 +1,149 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"ultra-chat-backend/models"
+)
+
+// IdentityRepository stores the identities users have linked from
+// providers other than Discord
+type IdentityRepository interface {
+	// FindIdentity returns the identity with the given subject at provider,
+	// or ErrIdentityNotFound
+	FindIdentity(ctx context.Context, provider, subject string) (*models.Identity, error)
+	// ListIdentities returns the identities of the user with the given UUID,
+	// ordered by provider
+	ListIdentities(ctx context.Context, userUUID string) ([]models.Identity, error)
+	// LinkIdentity stores a new identity, stamping CreatedAt and UpdatedAt
+	// when they are unset. It fails with ErrIdentityLinked if the identity
+	// is already stored or the user has another one at the same provider.
+	LinkIdentity(ctx context.Context, identity *models.Identity) error
+	// UpdateIdentity stores the token and profile of the identity with the
+	// same provider and subject, and stamps UpdatedAt; the other fields
+	// never change
+	UpdateIdentity(ctx context.Context, identity *models.Identity) error
+	// UnlinkIdentity removes the user's identity at provider, or fails with
+	// ErrIdentityNotFound
+	UnlinkIdentity(ctx context.Context, userUUID, provider string) error
+	// UnlinkIdentities removes every identity of the user and returns how
+	// many there were
+	UnlinkIdentities(ctx context.Context, userUUID string) (int64, error)
+}
+
+type identityRepository struct {
+	collection *mongo.Collection
+	timeouts   Timeouts
+}
+
+// NewIdentityRepository stores identities in the identities collection of
+// db, bounding each operation by timeouts. The collection's unique indexes
+// detect conflicting links, so db must be migrated.
+func NewIdentityRepository(db *mongo.Database, timeouts Timeouts) IdentityRepository {
+	return &identityRepository{
+		collection: db.Collection("identities"),
+		timeouts:   timeouts,
+	}
+}
+
+func (r *identityRepository) FindIdentity(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "FindIdentity")
+	defer cancel()
+
+	var identity models.Identity
+	err := r.collection.FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&identity)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *identityRepository) ListIdentities(ctx context.Context, userUUID string) ([]models.Identity, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "ListIdentities")
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, bson.M{"user_uuid": userUUID}, options.Find().SetSort(bson.D{{Key: "provider", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve identities: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	identities := []models.Identity{}
+	if err := cursor.All(ctx, &identities); err != nil {
+		return nil, fmt.Errorf("failed to decode identities: %w", err)
+	}
+	return identities, nil
+}
+
+func (r *identityRepository) LinkIdentity(ctx context.Context, identity *models.Identity) error {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "LinkIdentity")
+	defer cancel()
+
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now().UTC()
+	}
+	if identity.UpdatedAt.IsZero() {
+		identity.UpdatedAt = identity.CreatedAt
+	}
+
+	if _, err := r.collection.InsertOne(ctx, identity); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrIdentityLinked
+		}
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
+
+func (r *identityRepository) UpdateIdentity(ctx context.Context, identity *models.Identity) error {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "UpdateIdentity")
+	defer cancel()
+
+	identity.UpdatedAt = time.Now().UTC()
+	result, err := r.collection.UpdateOne(ctx, bson.M{"provider": identity.Provider, "subject": identity.Subject}, bson.M{"$set": bson.M{
+		"token":      identity.Token,
+		"username":   identity.Username,
+		"email":      identity.Email,
+		"updated_at": identity.UpdatedAt,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to update identity: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (r *identityRepository) UnlinkIdentity(ctx context.Context, userUUID, provider string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "UnlinkIdentity")
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"user_uuid": userUUID, "provider": provider})
+	if err != nil {
+		return fmt.Errorf("failed to unlink identity: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (r *identityRepository) UnlinkIdentities(ctx context.Context, userUUID string) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "UnlinkIdentities")
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"user_uuid": userUUID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to unlink identities: %w", err)
+	}
+	return result.DeletedCount, nil
+}
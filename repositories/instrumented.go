@@ -0,0 +1,403 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"ultra-chat-backend/metrics"
+	"ultra-chat-backend/models"
+)
+
+var tracer = otel.Tracer("ultra-chat-backend/repositories")
+
+// Operation metrics and spans recorded by the instrumented repositories.
+// Not-found results and identity conflicts are expected outcomes and are not
+// counted as errors.
+type operations struct {
+	repository string
+	latency    *metrics.HistogramVec
+	errors     *metrics.CounterVec
+}
+
+func newOperations(reg *metrics.Registry, repository string) operations {
+	return operations{
+		repository: repository,
+		latency:    reg.Histogram("db_operation_duration_seconds", "Database operation latency in seconds per repository method.", nil, "repository", "method"),
+		errors:     reg.Counter("db_operation_errors_total", "Failed database operations per repository method.", "repository", "method"),
+	}
+}
+
+// start opens a span for method, a child of any span in ctx. The returned
+// func ends it and records the operation's latency and error.
+func (o operations) start(ctx context.Context, method string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, o.repository+"."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.collection.name", o.repository),
+			attribute.String("db.operation.name", method),
+		),
+	)
+
+	return ctx, func(err error) {
+		defer span.End()
+		o.latency.With(o.repository, method).Observe(time.Since(start).Seconds())
+		if err != nil && !isExpected(err) {
+			o.errors.With(o.repository, method).Inc()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+}
+
+func isExpected(err error) bool {
+	return errors.Is(err, ErrSummaryNotFound) || errors.Is(err, ErrUserNotFound) ||
+		errors.Is(err, ErrIdentityNotFound) || errors.Is(err, ErrIdentityLinked) ||
+		errors.Is(err, ErrAPIKeyNotFound)
+}
+
+type instrumentedUsers struct {
+	next UserRepository
+	ops  operations
+}
+
+// InstrumentUsers traces every call to repo and records its latency and
+// errors
+func InstrumentUsers(repo UserRepository, reg *metrics.Registry) UserRepository {
+	return &instrumentedUsers{next: repo, ops: newOperations(reg, "users")}
+}
+
+func (r *instrumentedUsers) FindUserByID(ctx context.Context, id string) (*models.User, error) {
+	ctx, done := r.ops.start(ctx, "FindUserByID")
+	user, err := r.next.FindUserByID(ctx, id)
+	done(err)
+	return user, err
+}
+
+func (r *instrumentedUsers) FindUserByUUID(ctx context.Context, uuid string) (*models.User, error) {
+	ctx, done := r.ops.start(ctx, "FindUserByUUID")
+	user, err := r.next.FindUserByUUID(ctx, uuid)
+	done(err)
+	return user, err
+}
+
+func (r *instrumentedUsers) FindUserByAccessToken(ctx context.Context, accessToken string) (*models.User, error) {
+	ctx, done := r.ops.start(ctx, "FindUserByAccessToken")
+	user, err := r.next.FindUserByAccessToken(ctx, accessToken)
+	done(err)
+	return user, err
+}
+
+func (r *instrumentedUsers) CreateUser(ctx context.Context, user *models.User) error {
+	ctx, done := r.ops.start(ctx, "CreateUser")
+	err := r.next.CreateUser(ctx, user)
+	done(err)
+	return err
+}
+
+func (r *instrumentedUsers) UpdateUser(ctx context.Context, user *models.User) error {
+	ctx, done := r.ops.start(ctx, "UpdateUser")
+	err := r.next.UpdateUser(ctx, user)
+	done(err)
+	return err
+}
+
+func (r *instrumentedUsers) UpdatePreferences(ctx context.Context, userID string, patch models.PreferencesPatch) error {
+	ctx, done := r.ops.start(ctx, "UpdatePreferences")
+	err := r.next.UpdatePreferences(ctx, userID, patch)
+	done(err)
+	return err
+}
+
+func (r *instrumentedUsers) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	ctx, done := r.ops.start(ctx, "SetUserDisabled")
+	err := r.next.SetUserDisabled(ctx, userID, disabled)
+	done(err)
+	return err
+}
+
+func (r *instrumentedUsers) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	ctx, done := r.ops.start(ctx, "SearchUsers")
+	users, err := r.next.SearchUsers(ctx, query, limit)
+	done(err)
+	return users, err
+}
+
+func (r *instrumentedUsers) DeleteUser(ctx context.Context, userID string) error {
+	ctx, done := r.ops.start(ctx, "DeleteUser")
+	err := r.next.DeleteUser(ctx, userID)
+	done(err)
+	return err
+}
+
+func (r *instrumentedUsers) IsAuthenticated(ctx context.Context, userID string) (bool, error) {
+	ctx, done := r.ops.start(ctx, "IsAuthenticated")
+	ok, err := r.next.IsAuthenticated(ctx, userID)
+	done(err)
+	return ok, err
+}
+
+func (r *instrumentedUsers) CountUsers(ctx context.Context) (int64, error) {
+	ctx, done := r.ops.start(ctx, "CountUsers")
+	count, err := r.next.CountUsers(ctx)
+	done(err)
+	return count, err
+}
+
+type instrumentedSummaries struct {
+	next    SummaryRepository
+	ops     operations
+	created *metrics.CounterVec
+}
+
+// InstrumentSummaries traces every call to repo, records its latency and
+// errors and counts the summaries created. Per-server counts are left to
+// the admin stats, a label per Discord server would grow without bound
+func InstrumentSummaries(repo SummaryRepository, reg *metrics.Registry) SummaryRepository {
+	return &instrumentedSummaries{
+		next:    repo,
+		ops:     newOperations(reg, "summaries"),
+		created: reg.Counter("summaries_created_total", "Summaries created."),
+	}
+}
+
+func (r *instrumentedSummaries) AddSummary(ctx context.Context, summary *models.Summary) error {
+	ctx, done := r.ops.start(ctx, "AddSummary")
+	err := r.next.AddSummary(ctx, summary)
+	done(err)
+	if err == nil {
+		r.created.With().Inc()
+	}
+	return err
+}
+
+func (r *instrumentedSummaries) GetSummaries(ctx context.Context, userID string) ([]models.Summary, error) {
+	ctx, done := r.ops.start(ctx, "GetSummaries")
+	summaries, err := r.next.GetSummaries(ctx, userID)
+	done(err)
+	return summaries, err
+}
+
+func (r *instrumentedSummaries) GetSummary(ctx context.Context, userID, summaryID string) (*models.Summary, error) {
+	ctx, done := r.ops.start(ctx, "GetSummary")
+	summary, err := r.next.GetSummary(ctx, userID, summaryID)
+	done(err)
+	return summary, err
+}
+
+func (r *instrumentedSummaries) UpdateSummary(ctx context.Context, userID, serverID string, isPrivate bool, content string) error {
+	ctx, done := r.ops.start(ctx, "UpdateSummary")
+	err := r.next.UpdateSummary(ctx, userID, serverID, isPrivate, content)
+	done(err)
+	return err
+}
+
+func (r *instrumentedSummaries) PatchSummary(ctx context.Context, userID, summaryID string, patch models.SummaryPatch) error {
+	ctx, done := r.ops.start(ctx, "PatchSummary")
+	err := r.next.PatchSummary(ctx, userID, summaryID, patch)
+	done(err)
+	return err
+}
+
+func (r *instrumentedSummaries) DeleteSummary(ctx context.Context, userID, summaryID string) error {
+	ctx, done := r.ops.start(ctx, "DeleteSummary")
+	err := r.next.DeleteSummary(ctx, userID, summaryID)
+	done(err)
+	return err
+}
+
+func (r *instrumentedSummaries) ServerStats(ctx context.Context) ([]models.ServerStats, error) {
+	ctx, done := r.ops.start(ctx, "ServerStats")
+	stats, err := r.next.ServerStats(ctx)
+	done(err)
+	return stats, err
+}
+
+func (r *instrumentedSummaries) DeleteSummaries(ctx context.Context, userID string) (int64, error) {
+	ctx, done := r.ops.start(ctx, "DeleteSummaries")
+	n, err := r.next.DeleteSummaries(ctx, userID)
+	done(err)
+	return n, err
+}
+
+func (r *instrumentedSummaries) CheckUserExists(ctx context.Context, userID string) (bool, error) {
+	ctx, done := r.ops.start(ctx, "CheckUserExists")
+	ok, err := r.next.CheckUserExists(ctx, userID)
+	done(err)
+	return ok, err
+}
+
+type instrumentedAudit struct {
+	next AuditRepository
+	ops  operations
+}
+
+// InstrumentAudit traces every call to repo and records its latency and
+// errors
+func InstrumentAudit(repo AuditRepository, reg *metrics.Registry) AuditRepository {
+	return &instrumentedAudit{next: repo, ops: newOperations(reg, "audit")}
+}
+
+func (r *instrumentedAudit) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	ctx, done := r.ops.start(ctx, "RecordEvent")
+	err := r.next.RecordEvent(ctx, event)
+	done(err)
+	return err
+}
+
+func (r *instrumentedAudit) ListEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, done := r.ops.start(ctx, "ListEvents")
+	events, err := r.next.ListEvents(ctx, filter)
+	done(err)
+	return events, err
+}
+
+func (r *instrumentedAudit) CountEvents(ctx context.Context, filter models.AuditFilter) (int64, error) {
+	ctx, done := r.ops.start(ctx, "CountEvents")
+	n, err := r.next.CountEvents(ctx, filter)
+	done(err)
+	return n, err
+}
+
+func (r *instrumentedAudit) RedactClients(ctx context.Context, actors []string) (int64, error) {
+	ctx, done := r.ops.start(ctx, "RedactClients")
+	n, err := r.next.RedactClients(ctx, actors)
+	done(err)
+	return n, err
+}
+
+type instrumentedIdentities struct {
+	next IdentityRepository
+	ops  operations
+}
+
+// InstrumentIdentities traces every call to repo and records its latency
+// and errors
+func InstrumentIdentities(repo IdentityRepository, reg *metrics.Registry) IdentityRepository {
+	return &instrumentedIdentities{next: repo, ops: newOperations(reg, "identities")}
+}
+
+func (r *instrumentedIdentities) FindIdentity(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	ctx, done := r.ops.start(ctx, "FindIdentity")
+	identity, err := r.next.FindIdentity(ctx, provider, subject)
+	done(err)
+	return identity, err
+}
+
+func (r *instrumentedIdentities) ListIdentities(ctx context.Context, userUUID string) ([]models.Identity, error) {
+	ctx, done := r.ops.start(ctx, "ListIdentities")
+	identities, err := r.next.ListIdentities(ctx, userUUID)
+	done(err)
+	return identities, err
+}
+
+func (r *instrumentedIdentities) LinkIdentity(ctx context.Context, identity *models.Identity) error {
+	ctx, done := r.ops.start(ctx, "LinkIdentity")
+	err := r.next.LinkIdentity(ctx, identity)
+	done(err)
+	return err
+}
+
+func (r *instrumentedIdentities) UpdateIdentity(ctx context.Context, identity *models.Identity) error {
+	ctx, done := r.ops.start(ctx, "UpdateIdentity")
+	err := r.next.UpdateIdentity(ctx, identity)
+	done(err)
+	return err
+}
+
+func (r *instrumentedIdentities) UnlinkIdentity(ctx context.Context, userUUID, provider string) error {
+	ctx, done := r.ops.start(ctx, "UnlinkIdentity")
+	err := r.next.UnlinkIdentity(ctx, userUUID, provider)
+	done(err)
+	return err
+}
+
+func (r *instrumentedIdentities) UnlinkIdentities(ctx context.Context, userUUID string) (int64, error) {
+	ctx, done := r.ops.start(ctx, "UnlinkIdentities")
+	n, err := r.next.UnlinkIdentities(ctx, userUUID)
+	done(err)
+	return n, err
+}
+
+type instrumentedAPIKeys struct {
+	next APIKeyRepository
+	ops  operations
+}
+
+// InstrumentAPIKeys traces every call to repo and records its latency and
+// errors
+func InstrumentAPIKeys(repo APIKeyRepository, reg *metrics.Registry) APIKeyRepository {
+	return &instrumentedAPIKeys{next: repo, ops: newOperations(reg, "api_keys")}
+}
+
+func (r *instrumentedAPIKeys) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	ctx, done := r.ops.start(ctx, "CreateAPIKey")
+	err := r.next.CreateAPIKey(ctx, key)
+	done(err)
+	return err
+}
+
+func (r *instrumentedAPIKeys) FindAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	ctx, done := r.ops.start(ctx, "FindAPIKeyByPrefix")
+	key, err := r.next.FindAPIKeyByPrefix(ctx, prefix)
+	done(err)
+	return key, err
+}
+
+func (r *instrumentedAPIKeys) ListAPIKeys(ctx context.Context, userUUID string) ([]models.APIKey, error) {
+	ctx, done := r.ops.start(ctx, "ListAPIKeys")
+	keys, err := r.next.ListAPIKeys(ctx, userUUID)
+	done(err)
+	return keys, err
+}
+
+func (r *instrumentedAPIKeys) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	ctx, done := r.ops.start(ctx, "TouchAPIKey")
+	err := r.next.TouchAPIKey(ctx, keyID, usedAt)
+	done(err)
+	return err
+}
+
+func (r *instrumentedAPIKeys) RevokeAPIKey(ctx context.Context, userUUID, keyID string) error {
+	ctx, done := r.ops.start(ctx, "RevokeAPIKey")
+	err := r.next.RevokeAPIKey(ctx, userUUID, keyID)
+	done(err)
+	return err
+}
+
+func (r *instrumentedAPIKeys) RevokeAPIKeys(ctx context.Context, userUUID string) (int64, error) {
+	ctx, done := r.ops.start(ctx, "RevokeAPIKeys")
+	n, err := r.next.RevokeAPIKeys(ctx, userUUID)
+	done(err)
+	return n, err
+}
+
+type instrumentedMaintenance struct {
+	next Maintenance
+	ops  operations
+}
+
+// InstrumentMaintenance traces every task m runs and records its latency
+// and errors
+func InstrumentMaintenance(m Maintenance, reg *metrics.Registry) Maintenance {
+	return &instrumentedMaintenance{next: m, ops: newOperations(reg, "maintenance")}
+}
+
+func (r *instrumentedMaintenance) Reindex(ctx context.Context) error {
+	ctx, done := r.ops.start(ctx, "Reindex")
+	err := r.next.Reindex(ctx)
+	done(err)
+	return err
+}
+
+func (r *instrumentedMaintenance) PurgeOrphans(ctx context.Context) (models.Orphans, error) {
+	ctx, done := r.ops.start(ctx, "PurgeOrphans")
+	orphans, err := r.next.PurgeOrphans(ctx)
+	done(err)
+	return orphans, err
+}
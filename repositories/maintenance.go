@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"ultra-chat-backend/migrations"
+	"ultra-chat-backend/models"
+)
+
+// Maintenance runs the housekeeping tasks operators start from the admin
+// API, across the collections of every repository of a backend
+type Maintenance interface {
+	// Reindex rebuilds the indexes the schema migrations created
+	Reindex(ctx context.Context) error
+	// PurgeOrphans deletes the summaries, linked identities and API keys
+	// of users who no longer exist and returns how many there were.
+	// Records created while it runs are left alone.
+	PurgeOrphans(ctx context.Context) (models.Orphans, error)
+}
+
+type mongoMaintenance struct {
+	db       *mongo.Database
+	timeouts Timeouts
+}
+
+// NewMongoMaintenance returns the Maintenance of db, bounding each task by
+// timeouts
+func NewMongoMaintenance(db *mongo.Database, timeouts Timeouts) Maintenance {
+	return &mongoMaintenance{db: db, timeouts: timeouts}
+}
+
+// Reindex drops and recreates the non-unique indexes declared by the
+// applied migrations. The reIndex command would be simpler, but since
+// MongoDB 6.0 it is only allowed on a standalone server.
+func (m *mongoMaintenance) Reindex(ctx context.Context) error {
+	ctx, cancel := m.timeouts.Context(ctx, "maintenance", "Reindex")
+	defer cancel()
+
+	if err := migrations.New(m.db, migrations.All).Reindex(ctx); err != nil {
+		return fmt.Errorf("reindexing: %w", err)
+	}
+	return nil
+}
+
+// purgeBatch is how many orphans PurgeOrphans deletes at a time
+const purgeBatch = 1000
+
+// PurgeOrphans deletes the records created before the purge started whose
+// user is missing from the users collection. Orphans are found by joining
+// each collection to users rather than listing every user, which would not
+// fit in a single query on a large database, and deleted in batches.
+func (m *mongoMaintenance) PurgeOrphans(ctx context.Context) (models.Orphans, error) {
+	ctx, cancel := m.timeouts.Context(ctx, "maintenance", "PurgeOrphans")
+	defer cancel()
+
+	started := time.Now().UTC()
+	var orphans models.Orphans
+	purges := []struct {
+		collection, field, userField string
+		deleted                      *int64
+	}{
+		{"summaries", "user_id", "id", &orphans.Summaries},
+		{"identities", "user_uuid", "uuid", &orphans.Identities},
+		{"api_keys", "user_uuid", "uuid", &orphans.APIKeys},
+	}
+	for _, purge := range purges {
+		deleted, err := m.purge(ctx, purge.collection, purge.field, purge.userField, started)
+		*purge.deleted = deleted
+		if err != nil {
+			return orphans, fmt.Errorf("failed to purge %s: %w", purge.collection, err)
+		}
+	}
+	return orphans, nil
+}
+
+// purge deletes the documents of collection created before started whose
+// field matches no user's userField and returns how many it deleted
+func (m *mongoMaintenance) purge(ctx context.Context, collection, field, userField string, started time.Time) (int64, error) {
+	coll := m.db.Collection(collection)
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"created_at": bson.M{"$lt": started}}}},
+		{{Key: "$lookup", Value: bson.M{"from": "users", "localField": field, "foreignField": userField, "as": "user"}}},
+		{{Key: "$match", Value: bson.M{"user": bson.M{"$size": 0}}}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var deleted int64
+	batch := make([]interface{}, 0, purgeBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		result, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": batch}})
+		if err != nil {
+			return err
+		}
+		deleted += result.DeletedCount
+		batch = batch[:0]
+		return nil
+	}
+	for cursor.Next(ctx) {
+		batch = append(batch, cursor.Current.Lookup("_id"))
+		if len(batch) == purgeBatch {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return deleted, err
+	}
+	return deleted, flush()
+}
@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type apiKeyRepository struct {
+	mu   sync.RWMutex
+	keys []models.APIKey
+}
+
+// NewAPIKeyRepository returns an empty in-memory
+// repositories.APIKeyRepository
+func NewAPIKeyRepository() repositories.APIKeyRepository {
+	return &apiKeyRepository{}
+}
+
+func (r *apiKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.keys {
+		if existing.ID == key.ID || existing.Prefix == key.Prefix {
+			return errors.New("API key already exists")
+		}
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = now()
+	}
+	stored := *key
+	stored.Scopes = append([]string(nil), key.Scopes...)
+	stored.ServerIDs = append([]string(nil), key.ServerIDs...)
+	stored.CreatedAt = storedTime(stored.CreatedAt)
+	if !stored.LastUsedAt.IsZero() {
+		stored.LastUsedAt = storedTime(stored.LastUsedAt)
+	}
+	r.keys = append(r.keys, stored)
+	return nil
+}
+
+func (r *apiKeyRepository) FindAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.Prefix == prefix {
+			return &key, nil
+		}
+	}
+	return nil, repositories.ErrAPIKeyNotFound
+}
+
+func (r *apiKeyRepository) ListAPIKeys(ctx context.Context, userUUID string) ([]models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []models.APIKey{}
+	for _, key := range r.keys {
+		if key.UserUUID == userUUID {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID > keys[j].ID
+	})
+	return keys, nil
+}
+
+func (r *apiKeyRepository) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.keys {
+		if r.keys[i].ID == keyID {
+			r.keys[i].LastUsedAt = storedTime(usedAt)
+			return nil
+		}
+	}
+	return repositories.ErrAPIKeyNotFound
+}
+
+func (r *apiKeyRepository) RevokeAPIKey(ctx context.Context, userUUID, keyID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, key := range r.keys {
+		if key.UserUUID == userUUID && key.ID == keyID {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			return nil
+		}
+	}
+	return repositories.ErrAPIKeyNotFound
+}
+
+func (r *apiKeyRepository) RevokeAPIKeys(ctx context.Context, userUUID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.keys[:0]
+	for _, key := range r.keys {
+		if key.UserUUID != userUUID {
+			kept = append(kept, key)
+		}
+	}
+	removed := int64(len(r.keys) - len(kept))
+	r.keys = kept
+	return removed, nil
+}
@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type auditRepository struct {
+	mu     sync.RWMutex
+	events []models.AuditEvent
+}
+
+// NewAuditRepository returns an empty in-memory
+// repositories.AuditRepository
+func NewAuditRepository() repositories.AuditRepository {
+	return &auditRepository{}
+}
+
+func (r *auditRepository) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = now()
+	}
+
+	stored := *event
+	stored.Time = storedTime(stored.Time)
+	stored.Details = nil
+	for key, value := range event.Details {
+		if stored.Details == nil {
+			stored.Details = map[string]string{}
+		}
+		stored.Details[key] = value
+	}
+	r.events = append(r.events, stored)
+	return nil
+}
+
+func (r *auditRepository) ListEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []models.AuditEvent{}
+	for _, event := range r.events {
+		if filter.Matches(event) {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.After(events[j].Time)
+		}
+		return events[i].ID > events[j].ID
+	})
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+func (r *auditRepository) CountEvents(ctx context.Context, filter models.AuditFilter) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var n int64
+	for _, event := range r.events {
+		if filter.Matches(event) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *auditRepository) RedactClients(ctx context.Context, actors []string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var redacted int64
+	for i := range r.events {
+		for _, actor := range actors {
+			if r.events[i].Actor == actor {
+				r.events[i].IP = ""
+				r.events[i].UserAgent = ""
+				redacted++
+				break
+			}
+		}
+	}
+	return redacted, nil
+}
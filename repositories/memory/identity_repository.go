@@ -0,0 +1,142 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type identityRepository struct {
+	mu         sync.RWMutex
+	identities []models.Identity
+}
+
+// NewIdentityRepository returns an empty in-memory
+// repositories.IdentityRepository
+func NewIdentityRepository() repositories.IdentityRepository {
+	return &identityRepository{}
+}
+
+func (r *identityRepository) FindIdentity(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, repositories.ErrIdentityNotFound
+}
+
+func (r *identityRepository) ListIdentities(ctx context.Context, userUUID string) ([]models.Identity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	identities := []models.Identity{}
+	for _, identity := range r.identities {
+		if identity.UserUUID == userUUID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Provider < identities[j].Provider })
+	return identities, nil
+}
+
+func (r *identityRepository) LinkIdentity(ctx context.Context, identity *models.Identity) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, stored := range r.identities {
+		if stored.Provider != identity.Provider {
+			continue
+		}
+		if stored.Subject == identity.Subject || stored.UserUUID == identity.UserUUID {
+			return repositories.ErrIdentityLinked
+		}
+	}
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = now()
+	}
+	if identity.UpdatedAt.IsZero() {
+		identity.UpdatedAt = identity.CreatedAt
+	}
+
+	stored := *identity
+	stored.CreatedAt = storedTime(stored.CreatedAt)
+	stored.UpdatedAt = storedTime(stored.UpdatedAt)
+	r.identities = append(r.identities, stored)
+	return nil
+}
+
+func (r *identityRepository) UpdateIdentity(ctx context.Context, identity *models.Identity) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, stored := range r.identities {
+		if stored.Provider == identity.Provider && stored.Subject == identity.Subject {
+			identity.UpdatedAt = now()
+			r.identities[i].Token = identity.Token
+			r.identities[i].Username = identity.Username
+			r.identities[i].Email = identity.Email
+			r.identities[i].UpdatedAt = identity.UpdatedAt
+			return nil
+		}
+	}
+	return repositories.ErrIdentityNotFound
+}
+
+func (r *identityRepository) UnlinkIdentity(ctx context.Context, userUUID, provider string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, stored := range r.identities {
+		if stored.UserUUID == userUUID && stored.Provider == provider {
+			r.identities = append(r.identities[:i], r.identities[i+1:]...)
+			return nil
+		}
+	}
+	return repositories.ErrIdentityNotFound
+}
+
+func (r *identityRepository) UnlinkIdentities(ctx context.Context, userUUID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.identities[:0]
+	for _, stored := range r.identities {
+		if stored.UserUUID != userUUID {
+			kept = append(kept, stored)
+		}
+	}
+	removed := int64(len(r.identities) - len(kept))
+	r.identities = kept
+	return removed, nil
+}
@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type maintenance struct {
+	users      *userRepository
+	summaries  *summaryRepository
+	identities *identityRepository
+	keys       *apiKeyRepository
+}
+
+// NewMaintenance returns the repositories.Maintenance of the given
+// repositories, which must have been returned by this package
+func NewMaintenance(users repositories.UserRepository, summaries repositories.SummaryRepository, identities repositories.IdentityRepository, keys repositories.APIKeyRepository) repositories.Maintenance {
+	return &maintenance{
+		users:      users.(*userRepository),
+		summaries:  summaries.(*summaryRepository),
+		identities: identities.(*identityRepository),
+		keys:       keys.(*apiKeyRepository),
+	}
+}
+
+// Reindex has nothing to rebuild, as nothing is indexed
+func (m *maintenance) Reindex(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (m *maintenance) PurgeOrphans(ctx context.Context) (models.Orphans, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Orphans{}, err
+	}
+
+	// Holding every lock at once makes the purge atomic, as a single
+	// statement is in SQL
+	m.users.mu.RLock()
+	defer m.users.mu.RUnlock()
+	m.summaries.mu.Lock()
+	defer m.summaries.mu.Unlock()
+	m.identities.mu.Lock()
+	defer m.identities.mu.Unlock()
+	m.keys.mu.Lock()
+	defer m.keys.mu.Unlock()
+
+	uuids := make(map[string]bool, len(m.users.users))
+	for _, user := range m.users.users {
+		uuids[user.UUID] = true
+	}
+	var orphans models.Orphans
+
+	summaries := m.summaries.summaries[:0]
+	for _, summary := range m.summaries.summaries {
+		if _, ok := m.users.users[summary.UserID]; ok {
+			summaries = append(summaries, summary)
+		}
+	}
+	orphans.Summaries = int64(len(m.summaries.summaries) - len(summaries))
+	m.summaries.summaries = summaries
+
+	identities := m.identities.identities[:0]
+	for _, identity := range m.identities.identities {
+		if uuids[identity.UserUUID] {
+			identities = append(identities, identity)
+		}
+	}
+	orphans.Identities = int64(len(m.identities.identities) - len(identities))
+	m.identities.identities = identities
+
+	keys := m.keys.keys[:0]
+	for _, key := range m.keys.keys {
+		if uuids[key.UserUUID] {
+			keys = append(keys, key)
+		}
+	}
+	orphans.APIKeys = int64(len(m.keys.keys) - len(keys))
+	m.keys.keys = keys
+
+	return orphans, nil
+}
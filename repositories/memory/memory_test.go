@@ -0,0 +1,25 @@
+package memory_test
+
+import (
+	"testing"
+
+	"ultra-chat-backend/repositories/memory"
+	"ultra-chat-backend/repositories/repositorytest"
+)
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Repositories {
+		users := memory.NewUserRepository()
+		summaries := memory.NewSummaryRepository(users)
+		identities := memory.NewIdentityRepository()
+		keys := memory.NewAPIKeyRepository()
+		return repositorytest.Repositories{
+			Users:       users,
+			Summaries:   summaries,
+			Audit:       memory.NewAuditRepository(),
+			Identities:  identities,
+			APIKeys:     keys,
+			Maintenance: memory.NewMaintenance(users, summaries, identities, keys),
+		}
+	})
+}
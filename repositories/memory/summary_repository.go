@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type summaryRepository struct {
+	mu        sync.RWMutex
+	summaries []models.Summary
+	users     repositories.UserRepository
+}
+
+// NewSummaryRepository returns an empty in-memory
+// repositories.SummaryRepository. users backs CheckUserExists.
+func NewSummaryRepository(users repositories.UserRepository) repositories.SummaryRepository {
+	return &summaryRepository{users: users}
+}
+
+func (r *summaryRepository) AddSummary(ctx context.Context, summary *models.Summary) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if summary.CreatedAt.IsZero() {
+		summary.CreatedAt = now()
+	}
+	if summary.UpdatedAt.IsZero() {
+		summary.UpdatedAt = summary.CreatedAt
+	}
+
+	stored := *summary
+	stored.CreatedAt = storedTime(stored.CreatedAt)
+	stored.UpdatedAt = storedTime(stored.UpdatedAt)
+	r.summaries = append(r.summaries, stored)
+	return nil
+}
+
+func (r *summaryRepository) GetSummaries(ctx context.Context, userID string) ([]models.Summary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	summaries := []models.Summary{}
+	for _, summary := range r.summaries {
+		if summary.UserID == userID {
+			summaries = append(summaries, summary)
+		}
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].CreatedAt.Before(summaries[j].CreatedAt)
+	})
+	return summaries, nil
+}
+
+func (r *summaryRepository) GetSummary(ctx context.Context, userID, summaryID string) (*models.Summary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if i := r.find(userID, summaryID); i >= 0 {
+		summary := r.summaries[i]
+		return &summary, nil
+	}
+	return nil, repositories.ErrSummaryNotFound
+}
+
+func (r *summaryRepository) UpdateSummary(ctx context.Context, userID, serverID string, isPrivate bool, content string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var oldest *models.Summary
+	for i := range r.summaries {
+		summary := &r.summaries[i]
+		if summary.UserID != userID || summary.ServerID != serverID || summary.IsPrivate != isPrivate {
+			continue
+		}
+		if oldest == nil || summary.CreatedAt.Before(oldest.CreatedAt) ||
+			(summary.CreatedAt.Equal(oldest.CreatedAt) && summary.ID < oldest.ID) {
+			oldest = summary
+		}
+	}
+	if oldest == nil {
+		return repositories.ErrSummaryNotFound
+	}
+	oldest.Content = content
+	oldest.UpdatedAt = now()
+	return nil
+}
+
+func (r *summaryRepository) PatchSummary(ctx context.Context, userID, summaryID string, patch models.SummaryPatch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.find(userID, summaryID)
+	if i < 0 {
+		return repositories.ErrSummaryNotFound
+	}
+	summary := &r.summaries[i]
+	patch.Apply(summary)
+	summary.UpdatedAt = now()
+	return nil
+}
+
+func (r *summaryRepository) DeleteSummary(ctx context.Context, userID, summaryID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.find(userID, summaryID)
+	if i < 0 {
+		return repositories.ErrSummaryNotFound
+	}
+	r.summaries = append(r.summaries[:i], r.summaries[i+1:]...)
+	return nil
+}
+
+func (r *summaryRepository) DeleteSummaries(ctx context.Context, userID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.summaries[:0]
+	for _, summary := range r.summaries {
+		if summary.UserID != userID {
+			kept = append(kept, summary)
+		}
+	}
+	deleted := int64(len(r.summaries) - len(kept))
+	r.summaries = kept
+	return deleted, nil
+}
+
+func (r *summaryRepository) ServerStats(ctx context.Context) ([]models.ServerStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	byServer := map[string]*models.ServerStats{}
+	authors := map[string]map[string]bool{}
+	for _, summary := range r.summaries {
+		stats, ok := byServer[summary.ServerID]
+		if !ok {
+			stats = &models.ServerStats{ServerID: summary.ServerID}
+			byServer[summary.ServerID] = stats
+			authors[summary.ServerID] = map[string]bool{}
+		}
+		stats.Summaries++
+		if summary.IsPrivate {
+			stats.Private++
+		}
+		authors[summary.ServerID][summary.UserID] = true
+		if summary.UpdatedAt.After(stats.LastUpdatedAt) {
+			stats.LastUpdatedAt = summary.UpdatedAt
+		}
+	}
+
+	list := make([]models.ServerStats, 0, len(byServer))
+	for serverID, stats := range byServer {
+		stats.Authors = int64(len(authors[serverID]))
+		list = append(list, *stats)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ServerID < list[j].ServerID })
+	return list, nil
+}
+
+func (r *summaryRepository) CheckUserExists(ctx context.Context, userID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return r.users.IsAuthenticated(ctx, userID)
+}
+
+// find returns the index of the user's summary, or -1. r.mu must be held.
+func (r *summaryRepository) find(userID, summaryID string) int {
+	for i, summary := range r.summaries {
+		if summary.UserID == userID && summary.ID == summaryID {
+			return i
+		}
+	}
+	return -1
+}
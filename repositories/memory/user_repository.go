@@ -0,0 +1,240 @@
+// Package memory implements the repository interfaces in process memory.
+// It backs tests and local development without MongoDB; data is lost when
+// the process exits. Like the MongoDB repositories, every method fails with
+// the context's error once it is cancelled.
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type userRepository struct {
+	mu    sync.RWMutex
+	users map[string]models.User
+}
+
+// NewUserRepository returns an empty in-memory repositories.UserRepository
+func NewUserRepository() repositories.UserRepository {
+	return &userRepository{users: map[string]models.User{}}
+}
+
+func (r *userRepository) FindUserByID(ctx context.Context, id string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repositories.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *userRepository) FindUserByUUID(ctx context.Context, uuid string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.users {
+		if user.UUID == uuid {
+			return &user, nil
+		}
+	}
+	return nil, repositories.ErrUserNotFound
+}
+
+func (r *userRepository) FindUserByAccessToken(ctx context.Context, accessToken string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if accessToken != "" {
+		for _, user := range r.users {
+			if user.Token.AccessToken == accessToken {
+				return &user, nil
+			}
+		}
+	}
+	return nil, repositories.ErrUserNotFound
+}
+
+func (r *userRepository) CreateUser(ctx context.Context, user *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.users[user.ID]; exists {
+		return errors.New("user already exists")
+	}
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now()
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
+
+	stored := *user
+	stored.CreatedAt = storedTime(stored.CreatedAt)
+	stored.UpdatedAt = storedTime(stored.UpdatedAt)
+	r.users[user.ID] = stored
+	return nil
+}
+
+func (r *userRepository) UpdateUser(ctx context.Context, user *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[user.ID]
+	if !ok {
+		return repositories.ErrUserNotFound
+	}
+	user.UpdatedAt = now()
+	stored.Token = user.Token
+	stored.Username = user.Username
+	stored.Discriminator = user.Discriminator
+	stored.UpdatedAt = user.UpdatedAt
+	r.users[user.ID] = stored
+	return nil
+}
+
+func (r *userRepository) UpdatePreferences(ctx context.Context, userID string, patch models.PreferencesPatch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[userID]
+	if !ok {
+		return repositories.ErrUserNotFound
+	}
+	if patch.DefaultPrivate != nil {
+		stored.Preferences.DefaultPrivate = *patch.DefaultPrivate
+	}
+	if patch.Timezone != nil {
+		stored.Preferences.Timezone = *patch.Timezone
+	}
+	if patch.Language != nil {
+		stored.Preferences.Language = *patch.Language
+	}
+	stored.UpdatedAt = now()
+	r.users[userID] = stored
+	return nil
+}
+
+func (r *userRepository) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[userID]
+	if !ok {
+		return repositories.ErrUserNotFound
+	}
+	stored.Disabled = disabled
+	stored.UpdatedAt = now()
+	r.users[userID] = stored
+	return nil
+}
+
+func (r *userRepository) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := []models.User{}
+	for _, user := range r.users {
+		if query == "" || user.ID == query || user.UUID == query || strings.Contains(strings.ToLower(user.Username), strings.ToLower(query)) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Username != users[j].Username {
+			return users[i].Username < users[j].Username
+		}
+		return users[i].ID < users[j].ID
+	})
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (r *userRepository) DeleteUser(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[userID]; !ok {
+		return repositories.ErrUserNotFound
+	}
+	delete(r.users, userID)
+	return nil
+}
+
+func (r *userRepository) IsAuthenticated(ctx context.Context, userID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.users[userID]
+	return ok, nil
+}
+
+func (r *userRepository) CountUsers(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return int64(len(r.users)), nil
+}
+
+func now() time.Time {
+	return storedTime(time.Now())
+}
+
+// storedTime returns t as MongoDB reads it back: UTC, with millisecond
+// precision
+func storedTime(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond).UTC()
+}
@@ -0,0 +1,29 @@
+package repositories_test
+
+import (
+	"context"
+	"testing"
+
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/migrations"
+	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/repositories/repositorytest"
+)
+
+func TestMongoConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Repositories {
+		db := apptest.MongoDatabase(t)
+		if _, err := migrations.New(db, migrations.All).Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return repositorytest.Repositories{
+			Users:       repositories.NewUserRepository(db, repositories.Timeouts{}),
+			Summaries:   repositories.NewMongoSummaryRepository(db, repositories.Timeouts{}),
+			Audit:       repositories.NewAuditRepository(db, repositories.Timeouts{}),
+			Identities:  repositories.NewIdentityRepository(db, repositories.Timeouts{}),
+			APIKeys:     repositories.NewAPIKeyRepository(db, repositories.Timeouts{}),
+			Maintenance: repositories.NewMongoMaintenance(db, repositories.Timeouts{}),
+			Bulk:        repositories.NewMongoBulkStore(db),
+		}
+	})
+}
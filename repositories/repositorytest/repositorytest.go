@@ -0,0 +1,1244 @@
+// Package repositorytest is the conformance suite every storage backend
+// must pass, so the server behaves the same whichever one it runs on. A
+// backend's tests call Run with a function returning fresh, empty
+// repositories.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+// Repositories are one backend's implementations, sharing a store so that
+// CheckUserExists and Maintenance see the users. Bulk is optional;
+// backends without one skip its tests.
+type Repositories struct {
+	Users       repositories.UserRepository
+	Summaries   repositories.SummaryRepository
+	Audit       repositories.AuditRepository
+	Identities  repositories.IdentityRepository
+	APIKeys     repositories.APIKeyRepository
+	Maintenance repositories.Maintenance
+	Bulk        repositories.BulkStore
+}
+
+// Run runs the suite, calling open for empty repositories in each subtest
+func Run(t *testing.T, open func(t *testing.T) Repositories) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r Repositories)
+	}{
+		{"CreateAndFindUser", testCreateAndFindUser},
+		{"CreateDuplicateUser", testCreateDuplicateUser},
+		{"UpdateUser", testUpdateUser},
+		{"CountUsers", testCountUsers},
+		{"UpdatePreferences", testUpdatePreferences},
+		{"DeleteUser", testDeleteUser},
+		{"SetUserDisabled", testSetUserDisabled},
+		{"SearchUsers", testSearchUsers},
+		{"AddAndGetSummary", testAddAndGetSummary},
+		{"GetSummaries", testGetSummaries},
+		{"SummariesAreScopedToTheirOwner", testSummariesAreScoped},
+		{"UpdateSummary", testUpdateSummary},
+		{"PatchSummary", testPatchSummary},
+		{"DeleteSummary", testDeleteSummary},
+		{"DeleteSummaries", testDeleteSummaries},
+		{"CheckUserExists", testCheckUserExists},
+		{"ServerStats", testServerStats},
+		{"AuditLog", testAuditLog},
+		{"LinkIdentities", testLinkIdentities},
+		{"UpdateIdentity", testUpdateIdentity},
+		{"UnlinkIdentities", testUnlinkIdentities},
+		{"APIKeys", testAPIKeys},
+		{"RevokeAPIKeys", testRevokeAPIKeys},
+		{"Reindex", testReindex},
+		{"PurgeOrphans", testPurgeOrphans},
+		{"CancelledContext", testCancelledContext},
+		{"Bulk", testBulk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+var ctx = context.Background()
+
+// at is a fixed timestamp with the millisecond precision every backend keeps
+var at = time.Date(2024, 5, 1, 9, 30, 0, 123e6, time.UTC)
+
+func newUser(id string) *models.User {
+	return &models.User{
+		ID:            id,
+		UUID:          uuid.New().String(),
+		Username:      "user" + id[len(id)-2:],
+		Discriminator: "0001",
+		Token: models.Token{
+			AccessToken:  "access-" + id,
+			TokenType:    "Bearer",
+			ExpiresIn:    604800,
+			RefreshToken: "refresh-" + id,
+			Scope:        "identify email",
+		},
+	}
+}
+
+func newSummary(userID string, createdAt time.Time) *models.Summary {
+	return &models.Summary{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		ServerID:  "290926798626357999",
+		Content:   "summary of " + userID,
+		CreatedAt: createdAt,
+	}
+}
+
+func testCreateAndFindUser(t *testing.T, r Repositories) {
+	user := newUser("80351110224678912")
+	user.CreatedAt = at
+	user.Preferences = models.Preferences{DefaultPrivate: true, Timezone: "Europe/Berlin", Language: "de"}
+	if err := r.Users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Users.FindUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := *user
+	want.UpdatedAt = at
+	if *got != want {
+		t.Errorf("FindUserByID = %+v, want %+v", *got, want)
+	}
+	if got.CreatedAt.Location() != time.UTC {
+		t.Errorf("timestamps are in %v, want UTC", got.CreatedAt.Location())
+	}
+
+	if _, err := r.Users.FindUserByID(ctx, "80351110224678999"); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("finding a missing user: %v, want ErrUserNotFound", err)
+	}
+
+	if got, err := r.Users.FindUserByUUID(ctx, user.UUID); err != nil || *got != want {
+		t.Errorf("FindUserByUUID = %+v, %v, want %+v", got, err, want)
+	}
+	if _, err := r.Users.FindUserByUUID(ctx, uuid.New().String()); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("finding a missing UUID: %v, want ErrUserNotFound", err)
+	}
+}
+
+func testCreateDuplicateUser(t *testing.T, r Repositories) {
+	if err := r.Users.CreateUser(ctx, newUser("80351110224678912")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Users.CreateUser(ctx, newUser("80351110224678912")); err == nil {
+		t.Error("creating a user twice succeeded")
+	}
+	if n, _ := r.Users.CountUsers(ctx); n != 1 {
+		t.Errorf("CountUsers = %d, want 1", n)
+	}
+}
+
+func testUpdateUser(t *testing.T, r Repositories) {
+	user := newUser("80351110224678912")
+	if err := r.Users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if user.CreatedAt.IsZero() || !user.UpdatedAt.Equal(user.CreatedAt) {
+		t.Errorf("CreateUser stamped %v, %v", user.CreatedAt, user.UpdatedAt)
+	}
+	original := *user
+
+	update := *user
+	update.UUID = "not changed"
+	update.Username = "renamed"
+	update.Token.AccessToken = "rotated"
+	if err := r.Users.UpdateUser(ctx, &update); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Users.FindUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "renamed" || got.Token.AccessToken != "rotated" || got.Token.RefreshToken != original.Token.RefreshToken {
+		t.Errorf("after UpdateUser = %+v", got)
+	}
+	if got.UUID != original.UUID || !got.CreatedAt.Equal(original.CreatedAt) {
+		t.Errorf("UpdateUser changed fixed fields: %+v, was %+v", got, original)
+	}
+	if got.UpdatedAt.Before(original.UpdatedAt) || !got.UpdatedAt.Equal(update.UpdatedAt) {
+		t.Errorf("updated_at = %v, UpdateUser reported %v, was %v", got.UpdatedAt, update.UpdatedAt, original.UpdatedAt)
+	}
+
+	if err := r.Users.UpdateUser(ctx, newUser("80351110224678999")); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("updating a missing user: %v, want ErrUserNotFound", err)
+	}
+
+	if got, err := r.Users.FindUserByAccessToken(ctx, "rotated"); err != nil || got.ID != user.ID {
+		t.Errorf("FindUserByAccessToken(rotated) = %+v, %v", got, err)
+	}
+	if _, err := r.Users.FindUserByAccessToken(ctx, original.Token.AccessToken); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("FindUserByAccessToken(replaced token): %v, want ErrUserNotFound", err)
+	}
+
+	// Clearing the token leaves nothing to find it by
+	update.Token = models.Token{}
+	if err := r.Users.UpdateUser(ctx, &update); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Users.FindUserByAccessToken(ctx, ""); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("FindUserByAccessToken(empty): %v, want ErrUserNotFound", err)
+	}
+}
+
+func testCountUsers(t *testing.T, r Repositories) {
+	for i, id := range []string{"80351110224678912", "80351110224678913", "80351110224678914"} {
+		if n, err := r.Users.CountUsers(ctx); err != nil || n != int64(i) {
+			t.Fatalf("CountUsers = %d, %v, want %d", n, err, i)
+		}
+		if err := r.Users.CreateUser(ctx, newUser(id)); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := r.Users.IsAuthenticated(ctx, id); err != nil || !ok {
+			t.Errorf("IsAuthenticated(%s) = %v, %v", id, ok, err)
+		}
+	}
+	if ok, err := r.Users.IsAuthenticated(ctx, "80351110224678999"); err != nil || ok {
+		t.Errorf("IsAuthenticated(missing) = %v, %v", ok, err)
+	}
+}
+
+func testUpdatePreferences(t *testing.T, r Repositories) {
+	user := newUser("80351110224678912")
+	user.CreatedAt = at
+	if err := r.Users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	private, timezone := true, "America/New_York"
+	if err := r.Users.UpdatePreferences(ctx, user.ID, models.PreferencesPatch{DefaultPrivate: &private, Timezone: &timezone}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Users.FindUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.Preferences{DefaultPrivate: true, Timezone: timezone}
+	if got.Preferences != want || !got.UpdatedAt.After(at) {
+		t.Errorf("after UpdatePreferences = %+v", got)
+	}
+
+	language := "fr"
+	if err := r.Users.UpdatePreferences(ctx, user.ID, models.PreferencesPatch{Language: &language}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = r.Users.FindUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Language = language
+	if got.Preferences != want || got.Token != user.Token || got.Username != user.Username {
+		t.Errorf("after patching the language = %+v", got)
+	}
+
+	if err := r.Users.UpdatePreferences(ctx, "80351110224678999", models.PreferencesPatch{Language: &language}); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("updating a missing user's preferences: %v, want ErrUserNotFound", err)
+	}
+}
+
+func testDeleteUser(t *testing.T, r Repositories) {
+	kept, deleted := newUser("80351110224678912"), newUser("80351110224678913")
+	for _, user := range []*models.User{kept, deleted} {
+		if err := r.Users.CreateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Users.DeleteUser(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Users.FindUserByID(ctx, deleted.ID); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("finding a deleted user: %v, want ErrUserNotFound", err)
+	}
+	if err := r.Users.DeleteUser(ctx, deleted.ID); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("deleting twice: %v, want ErrUserNotFound", err)
+	}
+	if n, _ := r.Users.CountUsers(ctx); n != 1 {
+		t.Errorf("CountUsers = %d, want 1", n)
+	}
+	if _, err := r.Users.FindUserByID(ctx, kept.ID); err != nil {
+		t.Errorf("the other user went too: %v", err)
+	}
+}
+
+func testSetUserDisabled(t *testing.T, r Repositories) {
+	user := newUser("80351110224678912")
+	user.CreatedAt = at
+	if err := r.Users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Users.SetUserDisabled(ctx, user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Users.FindUserByID(ctx, user.ID)
+	if err != nil || !got.Disabled || !got.UpdatedAt.After(at) {
+		t.Fatalf("after disabling = %+v, %v", got, err)
+	}
+	// Logins store the token and profile, which leaves the user disabled
+	got.Token.AccessToken = "rotated"
+	got.Disabled = false
+	if err := r.Users.UpdateUser(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Users.FindUserByID(ctx, user.ID); !got.Disabled || got.Token.AccessToken != "rotated" {
+		t.Errorf("UpdateUser enabled the user: %+v", got)
+	}
+
+	if err := r.Users.SetUserDisabled(ctx, user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Users.FindUserByID(ctx, user.ID); got.Disabled || got.Username != user.Username {
+		t.Errorf("after enabling = %+v", got)
+	}
+	if err := r.Users.SetUserDisabled(ctx, "80351110224678999", true); !errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("disabling a missing user: %v, want ErrUserNotFound", err)
+	}
+}
+
+func testSearchUsers(t *testing.T, r Repositories) {
+	if list, err := r.Users.SearchUsers(ctx, "", 10); err != nil || list == nil || len(list) != 0 {
+		t.Fatalf("SearchUsers with no users = %#v, %v, want an empty slice", list, err)
+	}
+	var users []*models.User
+	for i, username := range []string{"nelly", "otto_b", "annelise"} {
+		user := newUser("8035111022467891" + strconv.Itoa(i))
+		user.Username = username
+		if err := r.Users.CreateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+	nelly, otto, annelise := users[0], users[1], users[2]
+
+	for _, tt := range []struct {
+		query string
+		limit int
+		want  []*models.User
+	}{
+		{"", 0, []*models.User{annelise, nelly, otto}},
+		{"", 2, []*models.User{annelise, nelly}},
+		{"NEL", 0, []*models.User{annelise, nelly}},
+		{"_", 0, []*models.User{otto}},
+		{"%", 0, nil},
+		{nelly.ID, 0, []*models.User{nelly}},
+		{otto.UUID, 0, []*models.User{otto}},
+		{"80351110224678", 0, nil},
+	} {
+		got, err := r.Users.SearchUsers(ctx, tt.query, tt.limit)
+		if err != nil {
+			t.Errorf("SearchUsers(%q, %d): %v", tt.query, tt.limit, err)
+			continue
+		}
+		var names, want []string
+		for _, user := range got {
+			names = append(names, user.Username)
+		}
+		for _, user := range tt.want {
+			want = append(want, user.Username)
+		}
+		if !slices.Equal(names, want) {
+			t.Errorf("SearchUsers(%q, %d) = %v, want %v", tt.query, tt.limit, names, want)
+		}
+	}
+}
+
+func testAddAndGetSummary(t *testing.T, r Repositories) {
+	summary := newSummary("80351110224678912", at)
+	summary.IsPrivate = true
+	if err := r.Summaries.AddSummary(ctx, summary); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Summaries.GetSummary(ctx, summary.UserID, summary.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := *summary
+	want.UpdatedAt = at
+	if *got != want {
+		t.Errorf("GetSummary = %+v, want %+v", *got, want)
+	}
+
+	stamped := newSummary("80351110224678912", time.Time{})
+	if err := r.Summaries.AddSummary(ctx, stamped); err != nil {
+		t.Fatal(err)
+	}
+	got, err = r.Summaries.GetSummary(ctx, stamped.UserID, stamped.ID)
+	if err != nil || got.CreatedAt.IsZero() || !got.CreatedAt.Equal(stamped.CreatedAt) || !got.UpdatedAt.Equal(got.CreatedAt) {
+		t.Errorf("AddSummary stamped %v, stored %+v (%v)", stamped.CreatedAt, got, err)
+	}
+
+	if _, err := r.Summaries.GetSummary(ctx, summary.UserID, uuid.New().String()); !errors.Is(err, repositories.ErrSummaryNotFound) {
+		t.Errorf("getting a missing summary: %v, want ErrSummaryNotFound", err)
+	}
+}
+
+func testGetSummaries(t *testing.T, r Repositories) {
+	list, err := r.Summaries.GetSummaries(ctx, "80351110224678912")
+	if err != nil || list == nil || len(list) != 0 {
+		t.Fatalf("GetSummaries with none = %#v, %v, want an empty slice", list, err)
+	}
+
+	// Added out of order; listed oldest first
+	var ids []string
+	for _, offset := range []time.Duration{2, 0, 1} {
+		summary := newSummary("80351110224678912", at.Add(offset*time.Hour))
+		if err := r.Summaries.AddSummary(ctx, summary); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, summary.ID)
+	}
+	want := []string{ids[1], ids[2], ids[0]}
+
+	list, err = r.Summaries.GetSummaries(ctx, "80351110224678912")
+	if err != nil || len(list) != len(want) {
+		t.Fatalf("GetSummaries = %d summaries, %v, want %d", len(list), err, len(want))
+	}
+	for i := range want {
+		if list[i].ID != want[i] {
+			t.Errorf("summary %d = %s, want %s", i, list[i].ID, want[i])
+		}
+	}
+}
+
+func testSummariesAreScoped(t *testing.T, r Repositories) {
+	summary := newSummary("80351110224678912", at)
+	if err := r.Summaries.AddSummary(ctx, summary); err != nil {
+		t.Fatal(err)
+	}
+	other := "80351110224678913"
+	content := "hijacked"
+
+	if list, _ := r.Summaries.GetSummaries(ctx, other); len(list) != 0 {
+		t.Errorf("another user lists %+v", list)
+	}
+	if _, err := r.Summaries.GetSummary(ctx, other, summary.ID); !errors.Is(err, repositories.ErrSummaryNotFound) {
+		t.Errorf("another user gets it: %v", err)
+	}
+	if err := r.Summaries.PatchSummary(ctx, other, summary.ID, models.SummaryPatch{Content: &content}); !errors.Is(err, repositories.ErrSummaryNotFound) {
+		t.Errorf("another user patches it: %v", err)
+	}
+	if err := r.Summaries.UpdateSummary(ctx, other, summary.ServerID, summary.IsPrivate, content); !errors.Is(err, repositories.ErrSummaryNotFound) {
+		t.Errorf("another user updates it: %v", err)
+	}
+	if err := r.Summaries.DeleteSummary(ctx, other, summary.ID); !errors.Is(err, repositories.ErrSummaryNotFound) {
+		t.Errorf("another user deletes it: %v", err)
+	}
+
+	if got, err := r.Summaries.GetSummary(ctx, summary.UserID, summary.ID); err != nil || got.Content != summary.Content {
+		t.Errorf("summary changed: %+v, %v", got, err)
+	}
+}
+
+func testUpdateSummary(t *testing.T, r Repositories) {
+	summary := newSummary("80351110224678912", at)
+	if err := r.Summaries.AddSummary(ctx, summary); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Summaries.UpdateSummary(ctx, summary.UserID, summary.ServerID, true, "wrong visibility"); !errors.Is(err, repositories.ErrSummaryNotFound) {
+		t.Errorf("updating with another visibility: %v, want ErrSummaryNotFound", err)
+	}
+	if err := r.Summaries.UpdateSummary(ctx, summary.UserID, summary.ServerID, false, "updated"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Summaries.GetSummary(ctx, summary.UserID, summary.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content != "updated" || !got.CreatedAt.Equal(at) || !got.UpdatedAt.After(at) {
+		t.Errorf("after UpdateSummary = %+v", got)
+	}
+
+	// Of several matching summaries, every backend updates the oldest,
+	// and the lowest ID of those created at once
+	userID := "80351110224678913"
+	newer := newSummary(userID, at.Add(time.Hour))
+	tiedHigh, tiedLow := newSummary(userID, at), newSummary(userID, at)
+	if tiedHigh.ID < tiedLow.ID {
+		tiedHigh, tiedLow = tiedLow, tiedHigh
+	}
+	for _, s := range []*models.Summary{newer, tiedHigh, tiedLow} {
+		if err := r.Summaries.AddSummary(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Summaries.UpdateSummary(ctx, userID, newer.ServerID, false, "oldest"); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*models.Summary{newer, tiedHigh, tiedLow} {
+		got, err := r.Summaries.GetSummary(ctx, userID, s.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated := got.Content == "oldest"; updated != (s == tiedLow) {
+			t.Errorf("summary created at %v with ID %s updated = %t", s.CreatedAt, s.ID, updated)
+		}
+	}
+}
+
+func testPatchSummary(t *testing.T, r Repositories) {
+	summary := newSummary("80351110224678912", at)
+	if err := r.Summaries.AddSummary(ctx, summary); err != nil {
+		t.Fatal(err)
+	}
+
+	isPrivate := true
+	if err := r.Summaries.PatchSummary(ctx, summary.UserID, summary.ID, models.SummaryPatch{IsPrivate: &isPrivate}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Summaries.GetSummary(ctx, summary.UserID, summary.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsPrivate || got.Content != summary.Content || got.ServerID != summary.ServerID || !got.UpdatedAt.After(at) {
+		t.Errorf("after patching is_private = %+v", got)
+	}
+
+	serverID, content := "290926798626358000", "patched"
+	if err := r.Summaries.PatchSummary(ctx, summary.UserID, summary.ID, models.SummaryPatch{ServerID: &serverID, Content: &content}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = r.Summaries.GetSummary(ctx, summary.UserID, summary.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsPrivate || got.Content != content || got.ServerID != serverID || !got.CreatedAt.Equal(at) {
+		t.Errorf("after patching server and content = %+v", got)
+	}
+
+	if err := r.Summaries.PatchSummary(ctx, summary.UserID, uuid.New().String(), models.SummaryPatch{Content: &content}); !errors.Is(err, repositories.ErrSummaryNotFound) {
+		t.Errorf("patching a missing summary: %v, want ErrSummaryNotFound", err)
+	}
+}
+
+func testDeleteSummary(t *testing.T, r Repositories) {
+	kept := newSummary("80351110224678912", at)
+	deleted := newSummary("80351110224678912", at.Add(time.Hour))
+	for _, s := range []*models.Summary{kept, deleted} {
+		if err := r.Summaries.AddSummary(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Summaries.DeleteSummary(ctx, deleted.UserID, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Summaries.DeleteSummary(ctx, deleted.UserID, deleted.ID); !errors.Is(err, repositories.ErrSummaryNotFound) {
+		t.Errorf("deleting twice: %v, want ErrSummaryNotFound", err)
+	}
+	if list, err := r.Summaries.GetSummaries(ctx, kept.UserID); err != nil || len(list) != 1 || list[0].ID != kept.ID {
+		t.Errorf("after delete = %+v, %v", list, err)
+	}
+}
+
+func testDeleteSummaries(t *testing.T, r Repositories) {
+	owner, other := "80351110224678912", "80351110224678913"
+	for _, userID := range []string{owner, owner, other} {
+		if err := r.Summaries.AddSummary(ctx, newSummary(userID, at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := r.Summaries.DeleteSummaries(ctx, owner); err != nil || n != 2 {
+		t.Fatalf("DeleteSummaries = %d, %v, want 2", n, err)
+	}
+	if list, _ := r.Summaries.GetSummaries(ctx, owner); len(list) != 0 {
+		t.Errorf("%d summaries left", len(list))
+	}
+	if list, _ := r.Summaries.GetSummaries(ctx, other); len(list) != 1 {
+		t.Errorf("another user has %d summaries, want 1", len(list))
+	}
+	if n, err := r.Summaries.DeleteSummaries(ctx, owner); err != nil || n != 0 {
+		t.Errorf("DeleteSummaries with none = %d, %v", n, err)
+	}
+}
+
+func testCheckUserExists(t *testing.T, r Repositories) {
+	user := newUser("80351110224678912")
+	if ok, err := r.Summaries.CheckUserExists(ctx, user.ID); err != nil || ok {
+		t.Errorf("CheckUserExists before signup = %v, %v", ok, err)
+	}
+	if err := r.Users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.Summaries.CheckUserExists(ctx, user.ID); err != nil || !ok {
+		t.Errorf("CheckUserExists after signup = %v, %v", ok, err)
+	}
+}
+
+func testServerStats(t *testing.T, r Repositories) {
+	if list, err := r.Summaries.ServerStats(ctx); err != nil || list == nil || len(list) != 0 {
+		t.Fatalf("ServerStats with no summaries = %#v, %v, want an empty slice", list, err)
+	}
+	alice, bob := "80351110224678912", "80351110224678913"
+	busy, quiet := "290926798626357999", "290926798626357111"
+	for i, s := range []struct {
+		userID, serverID string
+		private          bool
+	}{
+		{alice, busy, false},
+		{alice, busy, true},
+		{bob, busy, false},
+		{alice, quiet, true},
+	} {
+		summary := newSummary(s.userID, at.Add(time.Duration(i)*time.Hour))
+		summary.ServerID, summary.IsPrivate = s.serverID, s.private
+		if err := r.Summaries.AddSummary(ctx, summary); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := r.Summaries.ServerStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.ServerStats{
+		{ServerID: quiet, Summaries: 1, Private: 1, Authors: 1, LastUpdatedAt: at.Add(3 * time.Hour)},
+		{ServerID: busy, Summaries: 3, Private: 1, Authors: 2, LastUpdatedAt: at.Add(2 * time.Hour)},
+	}
+	if !slices.EqualFunc(got, want, func(a, b models.ServerStats) bool {
+		return a.ServerID == b.ServerID && a.Summaries == b.Summaries && a.Private == b.Private && a.Authors == b.Authors && a.LastUpdatedAt.Equal(b.LastUpdatedAt)
+	}) {
+		t.Errorf("ServerStats = %+v, want %+v", got, want)
+	}
+}
+
+func testAuditLog(t *testing.T, r Repositories) {
+	if list, err := r.Audit.ListEvents(ctx, models.AuditFilter{}); err != nil || list == nil || len(list) != 0 {
+		t.Fatalf("ListEvents with none = %#v, %v, want an empty slice", list, err)
+	}
+
+	alice, bob := models.AuditUser(uuid.New().String()), models.AuditUser(uuid.New().String())
+	events := []*models.AuditEvent{
+		{ID: uuid.New().String(), Time: at, Actor: alice, Action: models.AuditAccountDeleted, Target: alice, Details: map[string]string{"summaries": "2"}},
+		{
+			ID: uuid.New().String(), Time: at.Add(time.Hour), Actor: bob, Action: models.AuditSummaryUpdated, Target: models.AuditSummary("s1"),
+			IP: "203.0.113.7", UserAgent: "ultra-chat-bot/1.0", BeforeHash: "before", AfterHash: "after",
+		},
+		{ID: uuid.New().String(), Actor: alice, Action: "test.stamped", Target: bob},
+	}
+	for _, event := range events {
+		if err := r.Audit.RecordEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if events[2].Time.IsZero() {
+		t.Error("RecordEvent did not stamp Time")
+	}
+
+	// Newest first
+	list, err := r.Audit.ListEvents(ctx, models.AuditFilter{})
+	if err != nil || len(list) != 3 {
+		t.Fatalf("ListEvents = %d events, %v", len(list), err)
+	}
+	for i, want := range []*models.AuditEvent{events[2], events[1], events[0]} {
+		if list[i].ID != want.ID {
+			t.Errorf("event %d = %s, want %s", i, list[i].Action, want.Action)
+		}
+	}
+	got := list[2]
+	if !got.Time.Equal(at) || got.Actor != alice || got.Target != alice || got.Action != models.AuditAccountDeleted || len(got.Details) != 1 || got.Details["summaries"] != "2" {
+		t.Errorf("stored %+v, recorded %+v", got, *events[0])
+	}
+	if list[1].Details != nil {
+		t.Errorf("an event without details reads back %#v", list[1].Details)
+	}
+	if got := list[1]; got.IP != "203.0.113.7" || got.UserAgent != "ultra-chat-bot/1.0" || got.BeforeHash != "before" || got.AfterHash != "after" {
+		t.Errorf("stored client and hashes %+v, recorded %+v", got, *events[1])
+	}
+	if got.IP != "" || got.UserAgent != "" || got.BeforeHash != "" || got.AfterHash != "" {
+		t.Errorf("an event without client or hashes reads back %+v", got)
+	}
+
+	if list, _ := r.Audit.ListEvents(ctx, models.AuditFilter{Actor: alice}); len(list) != 2 {
+		t.Errorf("filtering by actor = %d events, want 2", len(list))
+	}
+	if list, _ := r.Audit.ListEvents(ctx, models.AuditFilter{Actor: alice, Target: bob}); len(list) != 1 || list[0].ID != events[2].ID {
+		t.Errorf("filtering by actor and target = %+v", list)
+	}
+	if list, _ := r.Audit.ListEvents(ctx, models.AuditFilter{Limit: 1}); len(list) != 1 || list[0].ID != events[2].ID {
+		t.Errorf("limited to one = %+v", list)
+	}
+	if list, _ := r.Audit.ListEvents(ctx, models.AuditFilter{Action: models.AuditSummaryUpdated}); len(list) != 1 || list[0].ID != events[1].ID {
+		t.Errorf("filtering by action = %+v", list)
+	}
+
+	// Since is inclusive and Until exclusive
+	if list, _ := r.Audit.ListEvents(ctx, models.AuditFilter{Since: at.Add(time.Hour)}); len(list) != 2 || list[1].ID != events[1].ID {
+		t.Errorf("since the second event = %+v", list)
+	}
+	if list, _ := r.Audit.ListEvents(ctx, models.AuditFilter{Until: at.Add(time.Hour)}); len(list) != 1 || list[0].ID != events[0].ID {
+		t.Errorf("until the second event = %+v", list)
+	}
+	if list, _ := r.Audit.ListEvents(ctx, models.AuditFilter{Since: at, Until: at.Add(2 * time.Hour), Actor: alice}); len(list) != 1 || list[0].ID != events[0].ID {
+		t.Errorf("between the first two events by actor = %+v", list)
+	}
+
+	if n, err := r.Audit.CountEvents(ctx, models.AuditFilter{Actor: alice, Limit: 1}); err != nil || n != 2 {
+		t.Errorf("CountEvents by actor = %d, %v, want 2", n, err)
+	}
+	if n, err := r.Audit.CountEvents(ctx, models.AuditFilter{Until: at}); err != nil || n != 0 {
+		t.Errorf("CountEvents before the first event = %d, %v", n, err)
+	}
+
+	// Paging resumes after the last event read, breaking ties in time by ID
+	dave, tiedID := models.AuditUser(uuid.New().String()), uuid.New().String()
+	tied := []*models.AuditEvent{
+		{ID: tiedID + "-1", Time: at.Add(-time.Hour), Actor: dave, Action: "test.tied", Target: bob},
+		{ID: tiedID + "-2", Time: at.Add(-time.Hour), Actor: dave, Action: "test.tied", Target: bob},
+		{ID: tiedID + "-3", Time: at.Add(-time.Hour), Actor: dave, Action: "test.tied", Target: bob},
+	}
+	for _, event := range tied {
+		if err := r.Audit.RecordEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var paged []string
+	filter := models.AuditFilter{Target: bob, Limit: 2}
+	for {
+		page, err := r.Audit.ListEvents(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range page {
+			paged = append(paged, event.ID)
+		}
+		if len(page) < filter.Limit {
+			break
+		}
+		filter.After = models.CursorOf(page[len(page)-1])
+	}
+	if want := []string{events[2].ID, tied[2].ID, tied[1].ID, tied[0].ID}; !slices.Equal(paged, want) {
+		t.Errorf("paged through %v, want %v", paged, want)
+	}
+	if n, err := r.Audit.CountEvents(ctx, models.AuditFilter{Target: bob, After: models.CursorOf(*tied[1])}); err != nil || n != 1 {
+		t.Errorf("CountEvents after a cursor = %d, %v, want 1", n, err)
+	}
+
+	// Redacting clears the client of the actor's events and nothing else
+	carol := models.AuditUser(uuid.New().String())
+	for _, event := range []*models.AuditEvent{
+		{ID: uuid.New().String(), Time: at, Actor: carol, Action: models.AuditLogin, Target: carol, IP: "198.51.100.1", UserAgent: "browser", Details: map[string]string{"provider": "discord"}},
+		{ID: uuid.New().String(), Time: at, Actor: carol, Action: models.AuditSummaryUpdated, Target: models.AuditSummary("s2"), IP: "198.51.100.2", UserAgent: "browser", BeforeHash: "before", AfterHash: "after"},
+	} {
+		if err := r.Audit.RecordEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := r.Audit.RedactClients(ctx, []string{carol, models.AuditAPIKey("gone")}); err != nil || n != 2 {
+		t.Errorf("RedactClients = %d, %v, want 2", n, err)
+	}
+	if n, err := r.Audit.RedactClients(ctx, nil); err != nil || n != 0 {
+		t.Errorf("RedactClients of nobody = %d, %v", n, err)
+	}
+	list, _ = r.Audit.ListEvents(ctx, models.AuditFilter{Actor: carol})
+	for _, event := range list {
+		if event.IP != "" || event.UserAgent != "" || event.Target == "" || event.Action == "" {
+			t.Errorf("redacted event = %+v", event)
+		}
+	}
+	if len(list) != 2 || (list[0].AfterHash != "after" && list[1].AfterHash != "after") {
+		t.Errorf("redacted events lost their hashes: %+v", list)
+	}
+	if list, _ := r.Audit.ListEvents(ctx, models.AuditFilter{Actor: bob}); len(list) != 1 || list[0].IP != "203.0.113.7" {
+		t.Errorf("another actor's event = %+v", list)
+	}
+}
+
+func newIdentity(provider, subject, userUUID string) *models.Identity {
+	return &models.Identity{
+		ID:       uuid.New().String(),
+		Provider: provider,
+		Subject:  subject,
+		UserUUID: userUUID,
+		Username: "user" + subject,
+		Email:    subject + "@example.com",
+		Token: models.Token{
+			AccessToken:  "access-" + subject,
+			TokenType:    "Bearer",
+			ExpiresIn:    3600,
+			RefreshToken: "refresh-" + subject,
+			Scope:        "openid email",
+		},
+	}
+}
+
+func testLinkIdentities(t *testing.T, r Repositories) {
+	alice, bob := uuid.New().String(), uuid.New().String()
+	if list, err := r.Identities.ListIdentities(ctx, alice); err != nil || list == nil || len(list) != 0 {
+		t.Fatalf("ListIdentities with nothing linked = %#v, %v, want an empty slice", list, err)
+	}
+
+	google := newIdentity("google", "1001", alice)
+	google.CreatedAt = at
+	gitlab := newIdentity("gitlab", "2001", alice)
+	for _, identity := range []*models.Identity{google, gitlab} {
+		if err := r.Identities.LinkIdentity(ctx, identity); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !google.UpdatedAt.Equal(at) || gitlab.CreatedAt.IsZero() {
+		t.Errorf("LinkIdentity stamped %v, %v", google.UpdatedAt, gitlab.CreatedAt)
+	}
+
+	got, err := r.Identities.FindIdentity(ctx, "google", "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *google {
+		t.Errorf("FindIdentity = %+v, want %+v", *got, *google)
+	}
+	if got.CreatedAt.Location() != time.UTC {
+		t.Errorf("timestamps are in %v, want UTC", got.CreatedAt.Location())
+	}
+	if _, err := r.Identities.FindIdentity(ctx, "gitlab", "1001"); !errors.Is(err, repositories.ErrIdentityNotFound) {
+		t.Errorf("finding a subject at another provider: %v, want ErrIdentityNotFound", err)
+	}
+
+	list, err := r.Identities.ListIdentities(ctx, alice)
+	if err != nil || len(list) != 2 || list[0].Provider != "gitlab" || list[1].Provider != "google" {
+		t.Fatalf("ListIdentities = %+v, %v, want gitlab then google", list, err)
+	}
+
+	// An identity belongs to one user, and a user has one per provider
+	if err := r.Identities.LinkIdentity(ctx, newIdentity("google", "1001", bob)); !errors.Is(err, repositories.ErrIdentityLinked) {
+		t.Errorf("linking an identity to a second user: %v, want ErrIdentityLinked", err)
+	}
+	if err := r.Identities.LinkIdentity(ctx, newIdentity("google", "1002", alice)); !errors.Is(err, repositories.ErrIdentityLinked) {
+		t.Errorf("linking a second identity at a provider: %v, want ErrIdentityLinked", err)
+	}
+	if err := r.Identities.LinkIdentity(ctx, newIdentity("google", "1002", bob)); err != nil {
+		t.Errorf("linking another user's own identity: %v", err)
+	}
+	if list, _ := r.Identities.ListIdentities(ctx, alice); len(list) != 2 {
+		t.Errorf("rejected links were stored: %+v", list)
+	}
+}
+
+func testUpdateIdentity(t *testing.T, r Repositories) {
+	identity := newIdentity("google", "1001", uuid.New().String())
+	if err := r.Identities.LinkIdentity(ctx, identity); err != nil {
+		t.Fatal(err)
+	}
+	original := *identity
+
+	update := *identity
+	update.UserUUID = "not changed"
+	update.Username = "renamed"
+	update.Email = "renamed@example.com"
+	update.Token.AccessToken = "rotated"
+	if err := r.Identities.UpdateIdentity(ctx, &update); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Identities.FindIdentity(ctx, "google", "1001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "renamed" || got.Email != "renamed@example.com" || got.Token.AccessToken != "rotated" {
+		t.Errorf("after UpdateIdentity = %+v", got)
+	}
+	if got.ID != original.ID || got.UserUUID != original.UserUUID || !got.CreatedAt.Equal(original.CreatedAt) {
+		t.Errorf("UpdateIdentity changed fixed fields: %+v, was %+v", got, original)
+	}
+	if !got.UpdatedAt.Equal(update.UpdatedAt) || got.UpdatedAt.Before(original.UpdatedAt) {
+		t.Errorf("updated_at = %v, UpdateIdentity reported %v, was %v", got.UpdatedAt, update.UpdatedAt, original.UpdatedAt)
+	}
+
+	if err := r.Identities.UpdateIdentity(ctx, newIdentity("google", "1999", original.UserUUID)); !errors.Is(err, repositories.ErrIdentityNotFound) {
+		t.Errorf("updating a missing identity: %v, want ErrIdentityNotFound", err)
+	}
+}
+
+func testUnlinkIdentities(t *testing.T, r Repositories) {
+	alice, bob := uuid.New().String(), uuid.New().String()
+	for _, identity := range []*models.Identity{
+		newIdentity("google", "1001", alice),
+		newIdentity("gitlab", "2001", alice),
+		newIdentity("google", "1002", bob),
+	} {
+		if err := r.Identities.LinkIdentity(ctx, identity); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.Identities.UnlinkIdentity(ctx, alice, "google"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Identities.FindIdentity(ctx, "google", "1001"); !errors.Is(err, repositories.ErrIdentityNotFound) {
+		t.Errorf("unlinked identity is still found: %v", err)
+	}
+	if err := r.Identities.UnlinkIdentity(ctx, alice, "google"); !errors.Is(err, repositories.ErrIdentityNotFound) {
+		t.Errorf("unlinking twice: %v, want ErrIdentityNotFound", err)
+	}
+
+	// Once unlinked, the identity can be linked again
+	if err := r.Identities.LinkIdentity(ctx, newIdentity("google", "1001", alice)); err != nil {
+		t.Errorf("relinking: %v", err)
+	}
+
+	if n, err := r.Identities.UnlinkIdentities(ctx, alice); err != nil || n != 2 {
+		t.Errorf("UnlinkIdentities = %d, %v, want 2", n, err)
+	}
+	if list, _ := r.Identities.ListIdentities(ctx, alice); len(list) != 0 {
+		t.Errorf("identities left after UnlinkIdentities: %+v", list)
+	}
+	if list, _ := r.Identities.ListIdentities(ctx, bob); len(list) != 1 {
+		t.Errorf("another user's identities were touched: %+v", list)
+	}
+	if n, err := r.Identities.UnlinkIdentities(ctx, alice); err != nil || n != 0 {
+		t.Errorf("UnlinkIdentities with nothing linked = %d, %v", n, err)
+	}
+}
+
+func newAPIKey(userUUID, prefix string) *models.APIKey {
+	return &models.APIKey{
+		ID:       uuid.New().String(),
+		Prefix:   prefix,
+		Hash:     "hash-" + prefix,
+		UserUUID: userUUID,
+		UserID:   "80351110224678912",
+		Name:     "bot",
+		Kind:     models.APIKeyUser,
+		Scopes:   []string{models.ScopeSummariesRead, models.ScopeSummariesWrite},
+	}
+}
+
+// sameAPIKey compares keys field by field; backends differ in whether an
+// empty list reads back as nil
+func sameAPIKey(a, b models.APIKey) bool {
+	return a.ID == b.ID && a.Prefix == b.Prefix && a.Hash == b.Hash && a.UserUUID == b.UserUUID &&
+		a.UserID == b.UserID && a.Name == b.Name && a.Kind == b.Kind &&
+		slices.Equal(a.Scopes, b.Scopes) && slices.Equal(a.ServerIDs, b.ServerIDs) &&
+		a.CreatedAt.Equal(b.CreatedAt) && a.LastUsedAt.Equal(b.LastUsedAt)
+}
+
+func testAPIKeys(t *testing.T, r Repositories) {
+	alice := uuid.New().String()
+	if list, err := r.APIKeys.ListAPIKeys(ctx, alice); err != nil || list == nil || len(list) != 0 {
+		t.Fatalf("ListAPIKeys with no keys = %#v, %v, want an empty slice", list, err)
+	}
+
+	older := newAPIKey(alice, "0123456789ab")
+	older.CreatedAt = at
+	newer := newAPIKey(alice, "ba9876543210")
+	newer.Kind = models.APIKeyServer
+	newer.ServerIDs = []string{"290926798626357999", "613425648685547541"}
+	for _, key := range []*models.APIKey{older, newer} {
+		if err := r.APIKeys.CreateAPIKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !older.CreatedAt.Equal(at) || newer.CreatedAt.IsZero() {
+		t.Errorf("CreateAPIKey stamped %v, %v", older.CreatedAt, newer.CreatedAt)
+	}
+
+	got, err := r.APIKeys.FindAPIKeyByPrefix(ctx, "ba9876543210")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameAPIKey(*got, *newer) {
+		t.Errorf("FindAPIKeyByPrefix = %+v, want %+v", *got, *newer)
+	}
+	if !got.LastUsedAt.IsZero() {
+		t.Errorf("an unused key was last used at %v", got.LastUsedAt)
+	}
+	if got.CreatedAt.Location() != time.UTC {
+		t.Errorf("timestamps are in %v, want UTC", got.CreatedAt.Location())
+	}
+	if _, err := r.APIKeys.FindAPIKeyByPrefix(ctx, "ffffffffffff"); !errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		t.Errorf("finding a missing prefix: %v, want ErrAPIKeyNotFound", err)
+	}
+	if err := r.APIKeys.CreateAPIKey(ctx, newAPIKey(uuid.New().String(), "0123456789ab")); err == nil {
+		t.Error("two keys were stored with the same prefix")
+	}
+
+	list, err := r.APIKeys.ListAPIKeys(ctx, alice)
+	if err != nil || len(list) != 2 || list[0].ID != newer.ID || list[1].ID != older.ID {
+		t.Fatalf("ListAPIKeys = %+v, %v, want the newer key first", list, err)
+	}
+
+	used := at.Add(time.Hour)
+	if err := r.APIKeys.TouchAPIKey(ctx, older.ID, used); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.APIKeys.FindAPIKeyByPrefix(ctx, older.Prefix); err != nil || !got.LastUsedAt.Equal(used) {
+		t.Errorf("after TouchAPIKey = %+v, %v, want last used at %v", got, err, used)
+	}
+	if err := r.APIKeys.TouchAPIKey(ctx, uuid.New().String(), used); !errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		t.Errorf("touching a missing key: %v, want ErrAPIKeyNotFound", err)
+	}
+}
+
+func testRevokeAPIKeys(t *testing.T, r Repositories) {
+	alice, bob := uuid.New().String(), uuid.New().String()
+	first, second := newAPIKey(alice, "000000000001"), newAPIKey(alice, "000000000002")
+	other := newAPIKey(bob, "000000000003")
+	for _, key := range []*models.APIKey{first, second, other} {
+		if err := r.APIKeys.CreateAPIKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.APIKeys.RevokeAPIKey(ctx, bob, first.ID); !errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		t.Errorf("revoking another user's key: %v, want ErrAPIKeyNotFound", err)
+	}
+	if err := r.APIKeys.RevokeAPIKey(ctx, alice, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.APIKeys.FindAPIKeyByPrefix(ctx, first.Prefix); !errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		t.Errorf("revoked key is still found: %v", err)
+	}
+	if err := r.APIKeys.RevokeAPIKey(ctx, alice, first.ID); !errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		t.Errorf("revoking twice: %v, want ErrAPIKeyNotFound", err)
+	}
+
+	if n, err := r.APIKeys.RevokeAPIKeys(ctx, alice); err != nil || n != 1 {
+		t.Errorf("RevokeAPIKeys = %d, %v, want 1", n, err)
+	}
+	if list, _ := r.APIKeys.ListAPIKeys(ctx, alice); len(list) != 0 {
+		t.Errorf("keys left after RevokeAPIKeys: %+v", list)
+	}
+	if list, _ := r.APIKeys.ListAPIKeys(ctx, bob); len(list) != 1 {
+		t.Errorf("another user's keys were touched: %+v", list)
+	}
+	if n, err := r.APIKeys.RevokeAPIKeys(ctx, alice); err != nil || n != 0 {
+		t.Errorf("RevokeAPIKeys with no keys = %d, %v", n, err)
+	}
+}
+
+func testReindex(t *testing.T, r Repositories) {
+	user := newUser("80351110224678912")
+	if err := r.Users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Maintenance.Reindex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.Users.FindUserByID(ctx, user.ID); err != nil || got.UUID != user.UUID {
+		t.Errorf("after Reindex = %+v, %v", got, err)
+	}
+}
+
+func testPurgeOrphans(t *testing.T, r Repositories) {
+	user := newUser("80351110224678912")
+	if err := r.Users.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	gone := newUser("80351110224678913")
+
+	// Everything is created well before the purge, as records created while
+	// it runs are spared
+	for _, owner := range []*models.User{user, gone} {
+		if err := r.Summaries.AddSummary(ctx, newSummary(owner.ID, at)); err != nil {
+			t.Fatal(err)
+		}
+		identity := newIdentity("google", owner.ID, owner.UUID)
+		identity.CreatedAt = at
+		if err := r.Identities.LinkIdentity(ctx, identity); err != nil {
+			t.Fatal(err)
+		}
+		key := newAPIKey(owner.UUID, owner.ID[len(owner.ID)-12:])
+		key.CreatedAt = at
+		if err := r.APIKeys.CreateAPIKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orphans, err := r.Maintenance.PurgeOrphans(ctx)
+	if err != nil || orphans != (models.Orphans{Summaries: 1, Identities: 1, APIKeys: 1}) {
+		t.Fatalf("PurgeOrphans = %+v, %v", orphans, err)
+	}
+	if list, _ := r.Summaries.GetSummaries(ctx, gone.ID); len(list) != 0 {
+		t.Errorf("orphaned summaries left: %+v", list)
+	}
+	if list, _ := r.Identities.ListIdentities(ctx, gone.UUID); len(list) != 0 {
+		t.Errorf("orphaned identities left: %+v", list)
+	}
+	if list, _ := r.APIKeys.ListAPIKeys(ctx, gone.UUID); len(list) != 0 {
+		t.Errorf("orphaned API keys left: %+v", list)
+	}
+
+	summaries, _ := r.Summaries.GetSummaries(ctx, user.ID)
+	identities, _ := r.Identities.ListIdentities(ctx, user.UUID)
+	keys, _ := r.APIKeys.ListAPIKeys(ctx, user.UUID)
+	if len(summaries) != 1 || len(identities) != 1 || len(keys) != 1 {
+		t.Errorf("the user kept %d summaries, %d identities and %d API keys, want one of each", len(summaries), len(identities), len(keys))
+	}
+	if orphans, err := r.Maintenance.PurgeOrphans(ctx); err != nil || orphans != (models.Orphans{}) {
+		t.Errorf("purging again = %+v, %v", orphans, err)
+	}
+}
+
+func testCancelledContext(t *testing.T, r Repositories) {
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := r.Users.FindUserByID(cancelled, "80351110224678912"); err == nil || errors.Is(err, repositories.ErrUserNotFound) {
+		t.Errorf("FindUserByID with a cancelled context: %v", err)
+	}
+	if err := r.Users.CreateUser(cancelled, newUser("80351110224678912")); err == nil {
+		t.Error("CreateUser succeeded with a cancelled context")
+	}
+	if _, err := r.Summaries.GetSummaries(cancelled, "80351110224678912"); err == nil {
+		t.Error("GetSummaries succeeded with a cancelled context")
+	}
+	if n, _ := r.Users.CountUsers(ctx); n != 0 {
+		t.Errorf("a cancelled CreateUser stored a user")
+	}
+}
+
+func testBulk(t *testing.T, r Repositories) {
+	if r.Bulk == nil {
+		t.Skip("no bulk store")
+	}
+
+	// Put out of key order; listed in key order
+	ids := []string{"80351110224678914", "80351110224678912", "80351110224678913"}
+	var users []models.User
+	var summaries []models.Summary
+	for i, id := range ids {
+		user := newUser(id)
+		user.CreatedAt = at.Add(time.Duration(i) * time.Hour)
+		user.UpdatedAt = user.CreatedAt.Add(time.Minute)
+		user.Disabled = i == 1
+		users = append(users, *user)
+		summary := newSummary(id, user.CreatedAt)
+		summary.UpdatedAt = user.UpdatedAt
+		summaries = append(summaries, *summary)
+	}
+	if err := r.Bulk.PutUsers(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Bulk.PutSummaries(ctx, summaries); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Bulk.PutUsers(ctx, nil); err != nil {
+		t.Errorf("putting no users: %v", err)
+	}
+
+	first, err := r.Bulk.ListUsers(ctx, "", 2)
+	if err != nil || len(first) != 2 || first[0].ID != ids[1] || first[1].ID != ids[2] {
+		t.Fatalf("first page = %+v, %v", first, err)
+	}
+	if first[0] != users[1] {
+		t.Errorf("listed %+v, put %+v", first[0], users[1])
+	}
+	rest, err := r.Bulk.ListUsers(ctx, first[1].ID, 2)
+	if err != nil || len(rest) != 1 || rest[0].ID != ids[0] {
+		t.Fatalf("second page = %+v, %v", rest, err)
+	}
+	if last, err := r.Bulk.ListUsers(ctx, rest[0].ID, 2); err != nil || len(last) != 0 {
+		t.Errorf("past the end = %+v, %v", last, err)
+	}
+
+	// Stored users are visible to the repositories
+	if got, err := r.Users.FindUserByID(ctx, ids[0]); err != nil || *got != users[0] {
+		t.Errorf("FindUserByID = %+v, %v, want %+v", got, err, users[0])
+	}
+	if got, err := r.Summaries.GetSummary(ctx, summaries[0].UserID, summaries[0].ID); err != nil || *got != summaries[0] {
+		t.Errorf("GetSummary = %+v, %v, want %+v", got, err, summaries[0])
+	}
+
+	// Putting again replaces
+	users[0].Username = "replaced"
+	if err := r.Bulk.PutUsers(ctx, users[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.Users.CountUsers(ctx); n != int64(len(users)) {
+		t.Errorf("CountUsers after putting again = %d, want %d", n, len(users))
+	}
+	if got, err := r.Users.FindUserByID(ctx, ids[0]); err != nil || got.Username != "replaced" {
+		t.Errorf("after putting again = %+v, %v", got, err)
+	}
+
+	event := models.AuditEvent{
+		ID: uuid.New().String(), Time: at, Actor: "user:a", Action: models.AuditAccountDeleted, Target: "user:a",
+		IP: "203.0.113.7", UserAgent: "curl/8.0", BeforeHash: "before", Details: map[string]string{"summaries": "1"},
+	}
+	bare := models.AuditEvent{ID: uuid.New().String(), Time: at, Actor: "user:b", Action: models.AuditAccountDeleted, Target: "user:b"}
+	if err := r.Bulk.PutAuditEvents(ctx, []models.AuditEvent{event, bare}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := r.Bulk.ListAuditEvents(ctx, "", 10)
+	if err != nil || len(stored) != 2 {
+		t.Fatalf("ListAuditEvents = %+v, %v", stored, err)
+	}
+	for _, got := range stored {
+		want := event
+		if got.ID == bare.ID {
+			want = bare
+		}
+		if !got.Time.Equal(want.Time) || got.Actor != want.Actor || got.IP != want.IP || got.UserAgent != want.UserAgent || got.BeforeHash != want.BeforeHash ||
+			len(got.Details) != len(want.Details) || got.Details["summaries"] != want.Details["summaries"] {
+			t.Errorf("listed %+v, put %+v", got, want)
+		}
+	}
+	if stored[0].ID > stored[1].ID {
+		t.Error("audit events out of key order")
+	}
+
+	identities := []models.Identity{*newIdentity("google", "1001", users[0].UUID), *newIdentity("google", "1002", users[1].UUID)}
+	for i := range identities {
+		identities[i].CreatedAt, identities[i].UpdatedAt = at, at.Add(time.Minute)
+	}
+	if err := r.Bulk.PutIdentities(ctx, identities); err != nil {
+		t.Fatal(err)
+	}
+	linked, err := r.Bulk.ListIdentities(ctx, "", 10)
+	if err != nil || len(linked) != 2 || linked[0].ID > linked[1].ID {
+		t.Fatalf("ListIdentities = %+v, %v", linked, err)
+	}
+	if got, err := r.Identities.FindIdentity(ctx, "google", "1001"); err != nil || *got != identities[0] {
+		t.Errorf("FindIdentity = %+v, %v, want %+v", got, err, identities[0])
+	}
+
+	keys := []models.APIKey{*newAPIKey(users[0].UUID, "000000000001"), *newAPIKey(users[1].UUID, "000000000002")}
+	keys[0].CreatedAt = at
+	keys[1].CreatedAt, keys[1].LastUsedAt = at, at.Add(time.Hour)
+	keys[1].Kind, keys[1].ServerIDs = models.APIKeyServer, []string{"290926798626357999"}
+	if err := r.Bulk.PutAPIKeys(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+	storedKeys, err := r.Bulk.ListAPIKeys(ctx, "", 10)
+	if err != nil || len(storedKeys) != 2 || storedKeys[0].ID > storedKeys[1].ID {
+		t.Fatalf("ListAPIKeys = %+v, %v", storedKeys, err)
+	}
+	for _, key := range keys {
+		if got, err := r.APIKeys.FindAPIKeyByPrefix(ctx, key.Prefix); err != nil || !sameAPIKey(*got, key) {
+			t.Errorf("FindAPIKeyByPrefix = %+v, %v, want %+v", got, err, key)
+		}
+	}
+
+	listed, err := r.Bulk.ListSummaries(ctx, "", 10)
+	if err != nil || len(listed) != len(summaries) {
+		t.Fatalf("ListSummaries = %d summaries, %v", len(listed), err)
+	}
+	for i := 1; i < len(listed); i++ {
+		if listed[i-1].ID >= listed[i].ID {
+			t.Errorf("summaries out of key order: %s before %s", listed[i-1].ID, listed[i].ID)
+		}
+	}
+}
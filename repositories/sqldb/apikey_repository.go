@@ -0,0 +1,177 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type apiKeyRepository struct {
+	db       *DB
+	timeouts repositories.Timeouts
+}
+
+// NewAPIKeyRepository stores API keys in the api_keys table of db,
+// bounding each operation by timeouts
+func NewAPIKeyRepository(db *DB, timeouts repositories.Timeouts) repositories.APIKeyRepository {
+	return &apiKeyRepository{db: db, timeouts: timeouts}
+}
+
+const apiKeyColumns = `key_id, prefix, hash, user_uuid, user_id, name, kind, scopes, server_ids, created_at, last_used_at`
+
+func (r *apiKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "CreateAPIKey")
+	defer cancel()
+
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = now()
+	}
+	args, err := apiKeyValues(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.exec(ctx, `INSERT INTO api_keys (`+apiKeyColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) FindAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "FindAPIKeyByPrefix")
+	defer cancel()
+
+	key, err := scanAPIKey(r.db.queryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = ?`, prefix))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repositories.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve API key: %w", err)
+	}
+	return key, nil
+}
+
+func (r *apiKeyRepository) ListAPIKeys(ctx context.Context, userUUID string) ([]models.APIKey, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "ListAPIKeys")
+	defer cancel()
+
+	rows, err := r.db.query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_uuid = ? ORDER BY created_at DESC, key_id DESC`, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode API keys: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *apiKeyRepository) TouchAPIKey(ctx context.Context, keyID string, usedAt time.Time) error {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "TouchAPIKey")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `UPDATE api_keys SET last_used_at = ? WHERE key_id = ?`, storedTime(usedAt), keyID)
+	if err != nil {
+		return fmt.Errorf("failed to record API key use: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) RevokeAPIKey(ctx context.Context, userUUID, keyID string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "RevokeAPIKey")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `DELETE FROM api_keys WHERE user_uuid = ? AND key_id = ?`, userUUID, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) RevokeAPIKeys(ctx context.Context, userUUID string) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "api_keys", "RevokeAPIKeys")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `DELETE FROM api_keys WHERE user_uuid = ?`, userUUID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke API keys: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// apiKeyValues returns the values of apiKeyColumns for key. Lists are
+// stored as JSON arrays and a key never used has a NULL last_used_at.
+func apiKeyValues(key *models.APIKey) ([]interface{}, error) {
+	scopes, err := encodeList(key.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := encodeList(key.ServerIDs)
+	if err != nil {
+		return nil, err
+	}
+	var lastUsed sql.NullTime
+	if !key.LastUsedAt.IsZero() {
+		lastUsed = sql.NullTime{Time: storedTime(key.LastUsedAt), Valid: true}
+	}
+	return []interface{}{
+		key.ID, key.Prefix, key.Hash, key.UserUUID, key.UserID, key.Name, key.Kind,
+		scopes, servers, storedTime(key.CreatedAt), lastUsed,
+	}, nil
+}
+
+func scanAPIKey(row scanner) (*models.APIKey, error) {
+	var k models.APIKey
+	var scopes, servers string
+	var lastUsed sql.NullTime
+	err := row.Scan(
+		&k.ID, &k.Prefix, &k.Hash, &k.UserUUID, &k.UserID, &k.Name, &k.Kind,
+		&scopes, &servers, &k.CreatedAt, &lastUsed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &k.Scopes); err != nil {
+		return nil, fmt.Errorf("API key %s scopes: %w", k.ID, err)
+	}
+	if err := json.Unmarshal([]byte(servers), &k.ServerIDs); err != nil {
+		return nil, fmt.Errorf("API key %s servers: %w", k.ID, err)
+	}
+	k.CreatedAt = k.CreatedAt.UTC()
+	if lastUsed.Valid {
+		k.LastUsedAt = lastUsed.Time.UTC()
+	}
+	return &k, nil
+}
+
+// encodeList stores a list of strings as a JSON array
+func encodeList(list []string) (string, error) {
+	if list == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(list)
+	return string(b), err
+}
@@ -0,0 +1,166 @@
+package sqldb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type auditRepository struct {
+	db       *DB
+	timeouts repositories.Timeouts
+}
+
+// NewAuditRepository stores audit events in the audit_log table of db,
+// bounding each operation by timeouts
+func NewAuditRepository(db *DB, timeouts repositories.Timeouts) repositories.AuditRepository {
+	return &auditRepository{db: db, timeouts: timeouts}
+}
+
+const auditColumns = `event_id, time, actor, action, target, ip, user_agent, before_hash, after_hash, details`
+
+func (r *auditRepository) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	ctx, cancel := r.timeouts.Context(ctx, "audit", "RecordEvent")
+	defer cancel()
+
+	if event.Time.IsZero() {
+		event.Time = now()
+	}
+	details, err := encodeDetails(event.Details)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.exec(ctx, `INSERT INTO audit_log (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		event.ID, storedTime(event.Time), event.Actor, event.Action, event.Target,
+		event.IP, event.UserAgent, event.BeforeHash, event.AfterHash, details,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+func (r *auditRepository) ListEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "audit", "ListEvents")
+	defer cancel()
+
+	where, args := auditWhere(filter)
+	query := `SELECT ` + auditColumns + ` FROM audit_log` + where + ` ORDER BY time DESC, event_id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := r.db.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode audit events: %w", err)
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
+
+func (r *auditRepository) CountEvents(ctx context.Context, filter models.AuditFilter) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "audit", "CountEvents")
+	defer cancel()
+
+	where, args := auditWhere(filter)
+	var n int64
+	if err := r.db.queryRow(ctx, `SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count audit events: %w", err)
+	}
+	return n, nil
+}
+
+// auditWhere is the WHERE clause selecting the events matching filter
+func auditWhere(filter models.AuditFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if filter.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, filter.Target)
+	}
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, storedTime(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "time < ?")
+		args = append(args, storedTime(filter.Until))
+	}
+	if filter.After != nil {
+		after := storedTime(filter.After.Time)
+		where = append(where, "(time < ? OR (time = ? AND event_id < ?))")
+		args = append(args, after, after, filter.After.ID)
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return ` WHERE ` + strings.Join(where, " AND "), args
+}
+
+func (r *auditRepository) RedactClients(ctx context.Context, actors []string) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "audit", "RedactClients")
+	defer cancel()
+
+	if len(actors) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, len(actors))
+	for i, actor := range actors {
+		args[i] = actor
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(actors)), ", ")
+	result, err := r.db.exec(ctx, `UPDATE audit_log SET ip = '', user_agent = '' WHERE actor IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to redact audit events: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func scanAuditEvent(row scanner) (*models.AuditEvent, error) {
+	var e models.AuditEvent
+	var details string
+	err := row.Scan(&e.ID, &e.Time, &e.Actor, &e.Action, &e.Target, &e.IP, &e.UserAgent, &e.BeforeHash, &e.AfterHash, &details)
+	if err != nil {
+		return nil, err
+	}
+	e.Time = e.Time.UTC()
+	if err := json.Unmarshal([]byte(details), &e.Details); err != nil {
+		return nil, fmt.Errorf("event %s details: %w", e.ID, err)
+	}
+	if len(e.Details) == 0 {
+		e.Details = nil
+	}
+	return &e, nil
+}
+
+// encodeDetails stores details as a JSON object
+func encodeDetails(details map[string]string) (string, error) {
+	if details == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(details)
+	return string(b), err
+}
@@ -0,0 +1,205 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type bulkStore struct {
+	db *DB
+}
+
+// NewBulkStore returns a repositories.BulkStore for the users, summaries,
+// audit_log, identities and api_keys tables of db, which must be migrated
+func NewBulkStore(db *DB) repositories.BulkStore {
+	return &bulkStore{db: db}
+}
+
+func (s *bulkStore) ListUsers(ctx context.Context, afterID string, limit int) ([]models.User, error) {
+	rows, err := s.db.query(ctx, `SELECT `+userColumns+` FROM users WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (s *bulkStore) ListSummaries(ctx context.Context, afterID string, limit int) ([]models.Summary, error) {
+	rows, err := s.db.query(ctx, `SELECT `+summaryColumns+` FROM summaries WHERE summary_id > ? ORDER BY summary_id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []models.Summary{}
+	for rows.Next() {
+		summary, err := scanSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, *summary)
+	}
+	return summaries, rows.Err()
+}
+
+func (s *bulkStore) ListAuditEvents(ctx context.Context, afterID string, limit int) ([]models.AuditEvent, error) {
+	rows, err := s.db.query(ctx, `SELECT `+auditColumns+` FROM audit_log WHERE event_id > ? ORDER BY event_id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
+
+func (s *bulkStore) ListIdentities(ctx context.Context, afterID string, limit int) ([]models.Identity, error) {
+	rows, err := s.db.query(ctx, `SELECT `+identityColumns+` FROM identities WHERE identity_id > ? ORDER BY identity_id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.Identity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, *identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s *bulkStore) ListAPIKeys(ctx context.Context, afterID string, limit int) ([]models.APIKey, error) {
+	rows, err := s.db.query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_id > ? ORDER BY key_id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *bulkStore) PutUsers(ctx context.Context, users []models.User) error {
+	return s.put(ctx, "users", userColumns, len(users), func(stmt *sql.Stmt, i int) error {
+		u := users[i]
+		_, err := stmt.ExecContext(ctx,
+			u.ID, u.UUID, u.Username, u.Discriminator,
+			u.Token.AccessToken, u.Token.TokenType, u.Token.ExpiresIn, u.Token.RefreshToken, u.Token.Scope,
+			u.Preferences.DefaultPrivate, u.Preferences.Timezone, u.Preferences.Language,
+			u.Disabled, storedTime(u.CreatedAt), storedTime(u.UpdatedAt),
+		)
+		return err
+	})
+}
+
+func (s *bulkStore) PutSummaries(ctx context.Context, summaries []models.Summary) error {
+	return s.put(ctx, "summaries", summaryColumns, len(summaries), func(stmt *sql.Stmt, i int) error {
+		m := summaries[i]
+		_, err := stmt.ExecContext(ctx,
+			m.ID, m.UserID, m.ServerID, m.IsPrivate, m.Content,
+			storedTime(m.CreatedAt), storedTime(m.UpdatedAt),
+		)
+		return err
+	})
+}
+
+func (s *bulkStore) PutAuditEvents(ctx context.Context, events []models.AuditEvent) error {
+	return s.put(ctx, "audit_log", auditColumns, len(events), func(stmt *sql.Stmt, i int) error {
+		e := events[i]
+		details, err := encodeDetails(e.Details)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, e.ID, storedTime(e.Time), e.Actor, e.Action, e.Target,
+			e.IP, e.UserAgent, e.BeforeHash, e.AfterHash, details)
+		return err
+	})
+}
+
+func (s *bulkStore) PutIdentities(ctx context.Context, identities []models.Identity) error {
+	return s.put(ctx, "identities", identityColumns, len(identities), func(stmt *sql.Stmt, i int) error {
+		m := identities[i]
+		_, err := stmt.ExecContext(ctx,
+			m.ID, m.Provider, m.Subject, m.UserUUID, m.Username, m.Email,
+			m.Token.AccessToken, m.Token.TokenType, m.Token.ExpiresIn, m.Token.RefreshToken, m.Token.Scope,
+			storedTime(m.CreatedAt), storedTime(m.UpdatedAt),
+		)
+		return err
+	})
+}
+
+func (s *bulkStore) PutAPIKeys(ctx context.Context, keys []models.APIKey) error {
+	return s.put(ctx, "api_keys", apiKeyColumns, len(keys), func(stmt *sql.Stmt, i int) error {
+		values, err := apiKeyValues(&keys[i])
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, values...)
+		return err
+	})
+}
+
+// put upserts n rows into table in one transaction, keyed by its first
+// column
+func (s *bulkStore) put(ctx context.Context, table, columns string, n int, exec func(stmt *sql.Stmt, i int) error) error {
+	if n == 0 {
+		return nil
+	}
+
+	names := strings.Split(columns, ", ")
+	updates := make([]string, len(names)-1)
+	for i, name := range names[1:] {
+		updates[i] = name + " = excluded." + name
+	}
+	query := `INSERT INTO ` + table + ` (` + columns + `) VALUES (?` + strings.Repeat(", ?", len(names)-1) + `)
+		ON CONFLICT (` + names[0] + `) DO UPDATE SET ` + strings.Join(updates, ", ")
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.db.rebind(query))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := 0; i < n; i++ {
+		if err := exec(stmt, i); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
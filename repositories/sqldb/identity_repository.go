@@ -0,0 +1,156 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type identityRepository struct {
+	db       *DB
+	timeouts repositories.Timeouts
+}
+
+// NewIdentityRepository stores identities in the identities table of db,
+// bounding each operation by timeouts
+func NewIdentityRepository(db *DB, timeouts repositories.Timeouts) repositories.IdentityRepository {
+	return &identityRepository{db: db, timeouts: timeouts}
+}
+
+const identityColumns = `identity_id, provider, subject, user_uuid, username, email, access_token, token_type, expires_in, refresh_token, scope, created_at, updated_at`
+
+func (r *identityRepository) FindIdentity(ctx context.Context, provider, subject string) (*models.Identity, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "FindIdentity")
+	defer cancel()
+
+	identity, err := scanIdentity(r.db.queryRow(ctx, `SELECT `+identityColumns+` FROM identities WHERE provider = ? AND subject = ?`, provider, subject))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repositories.ErrIdentityNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve identity: %w", err)
+	}
+	return identity, nil
+}
+
+func (r *identityRepository) ListIdentities(ctx context.Context, userUUID string) ([]models.Identity, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "ListIdentities")
+	defer cancel()
+
+	rows, err := r.db.query(ctx, `SELECT `+identityColumns+` FROM identities WHERE user_uuid = ? ORDER BY provider`, userUUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []models.Identity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode identities: %w", err)
+		}
+		identities = append(identities, *identity)
+	}
+	return identities, rows.Err()
+}
+
+func (r *identityRepository) LinkIdentity(ctx context.Context, identity *models.Identity) error {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "LinkIdentity")
+	defer cancel()
+
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = now()
+	}
+	if identity.UpdatedAt.IsZero() {
+		identity.UpdatedAt = identity.CreatedAt
+	}
+
+	// Both dialects skip a row violating any unique constraint, which is
+	// how a conflicting link shows up
+	result, err := r.db.exec(ctx, `INSERT INTO identities (`+identityColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING`,
+		identity.ID, identity.Provider, identity.Subject, identity.UserUUID, identity.Username, identity.Email,
+		identity.Token.AccessToken, identity.Token.TokenType, identity.Token.ExpiresIn, identity.Token.RefreshToken, identity.Token.Scope,
+		storedTime(identity.CreatedAt), storedTime(identity.UpdatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrIdentityLinked
+	}
+	return nil
+}
+
+func (r *identityRepository) UpdateIdentity(ctx context.Context, identity *models.Identity) error {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "UpdateIdentity")
+	defer cancel()
+
+	identity.UpdatedAt = now()
+	result, err := r.db.exec(ctx, `UPDATE identities SET
+		username = ?, email = ?,
+		access_token = ?, token_type = ?, expires_in = ?, refresh_token = ?, scope = ?,
+		updated_at = ?
+		WHERE provider = ? AND subject = ?`,
+		identity.Username, identity.Email,
+		identity.Token.AccessToken, identity.Token.TokenType, identity.Token.ExpiresIn, identity.Token.RefreshToken, identity.Token.Scope,
+		identity.UpdatedAt, identity.Provider, identity.Subject,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update identity: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (r *identityRepository) UnlinkIdentity(ctx context.Context, userUUID, provider string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "UnlinkIdentity")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `DELETE FROM identities WHERE user_uuid = ? AND provider = ?`, userUUID, provider)
+	if err != nil {
+		return fmt.Errorf("failed to unlink identity: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrIdentityNotFound
+	}
+	return nil
+}
+
+func (r *identityRepository) UnlinkIdentities(ctx context.Context, userUUID string) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "identities", "UnlinkIdentities")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `DELETE FROM identities WHERE user_uuid = ?`, userUUID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unlink identities: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func scanIdentity(row scanner) (*models.Identity, error) {
+	var i models.Identity
+	err := row.Scan(
+		&i.ID, &i.Provider, &i.Subject, &i.UserUUID, &i.Username, &i.Email,
+		&i.Token.AccessToken, &i.Token.TokenType, &i.Token.ExpiresIn, &i.Token.RefreshToken, &i.Token.Scope,
+		&i.CreatedAt, &i.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	i.CreatedAt = i.CreatedAt.UTC()
+	i.UpdatedAt = i.UpdatedAt.UTC()
+	return &i, nil
+}
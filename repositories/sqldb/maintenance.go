@@ -0,0 +1,72 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type maintenance struct {
+	db       *DB
+	timeouts repositories.Timeouts
+}
+
+// NewMaintenance returns the repositories.Maintenance of db, bounding each
+// task by timeouts
+func NewMaintenance(db *DB, timeouts repositories.Timeouts) repositories.Maintenance {
+	return &maintenance{db: db, timeouts: timeouts}
+}
+
+// maintainedTables are the tables Reindex rebuilds on PostgreSQL
+var maintainedTables = []string{"users", "summaries", "identities", "api_keys", "audit_log"}
+
+// Reindex rebuilds every index of the database on SQLite, and those of
+// the repositories' tables on PostgreSQL, where only their owner may
+func (m *maintenance) Reindex(ctx context.Context) error {
+	ctx, cancel := m.timeouts.Context(ctx, "maintenance", "Reindex")
+	defer cancel()
+
+	if m.db.Dialect == SQLite {
+		if _, err := m.db.exec(ctx, `REINDEX`); err != nil {
+			return fmt.Errorf("reindexing: %w", err)
+		}
+		return nil
+	}
+	for _, table := range maintainedTables {
+		if _, err := m.db.exec(ctx, `REINDEX TABLE `+table); err != nil {
+			return fmt.Errorf("reindexing %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// PurgeOrphans deletes each kind of orphan in a single statement, which
+// sees the users committed when it starts
+func (m *maintenance) PurgeOrphans(ctx context.Context) (models.Orphans, error) {
+	ctx, cancel := m.timeouts.Context(ctx, "maintenance", "PurgeOrphans")
+	defer cancel()
+
+	var orphans models.Orphans
+	purges := []struct {
+		table, column, userColumn string
+		deleted                   *int64
+	}{
+		{"summaries", "user_id", "id", &orphans.Summaries},
+		{"identities", "user_uuid", "uuid", &orphans.Identities},
+		{"api_keys", "user_uuid", "uuid", &orphans.APIKeys},
+	}
+	for _, purge := range purges {
+		result, err := m.db.exec(ctx, `DELETE FROM `+purge.table+` WHERE NOT EXISTS (
+			SELECT 1 FROM users WHERE users.`+purge.userColumn+` = `+purge.table+`.`+purge.column+`
+		)`)
+		if err != nil {
+			return orphans, fmt.Errorf("failed to purge %s: %w", purge.table, err)
+		}
+		if *purge.deleted, err = result.RowsAffected(); err != nil {
+			return orphans, err
+		}
+	}
+	return orphans, nil
+}
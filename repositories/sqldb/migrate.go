@@ -0,0 +1,503 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"ultra-chat-backend/migrations"
+)
+
+// Migration is one step of the SQL schema history, with the statements
+// for each dialect. Down may be empty for changes that cannot be undone.
+type Migration struct {
+	Version     int
+	Description string
+	Up          Statements
+	Down        Statements
+}
+
+// Statements are the SQL a migration step runs in each dialect, in order
+type Statements map[Dialect][]string
+
+// All is the SQL schema history. Append new migrations with the next
+// version; never edit or renumber released ones.
+var All = []Migration{
+	{
+		Version:     1,
+		Description: "users and summaries tables",
+		Up: Statements{
+			SQLite: {
+				`CREATE TABLE users (
+					id            TEXT PRIMARY KEY,
+					uuid          TEXT NOT NULL,
+					username      TEXT NOT NULL,
+					discriminator TEXT NOT NULL,
+					access_token  TEXT NOT NULL,
+					token_type    TEXT NOT NULL,
+					expires_in    INTEGER NOT NULL,
+					refresh_token TEXT NOT NULL,
+					scope         TEXT NOT NULL,
+					created_at    TIMESTAMP NOT NULL,
+					updated_at    TIMESTAMP NOT NULL
+				)`,
+				`CREATE TABLE summaries (
+					summary_id TEXT PRIMARY KEY,
+					user_id    TEXT NOT NULL,
+					server_id  TEXT NOT NULL,
+					is_private BOOLEAN NOT NULL,
+					summary    TEXT NOT NULL,
+					created_at TIMESTAMP NOT NULL,
+					updated_at TIMESTAMP NOT NULL
+				)`,
+				`CREATE INDEX summaries_user_id_server_id ON summaries (user_id, server_id)`,
+			},
+			Postgres: {
+				`CREATE TABLE users (
+					id            TEXT PRIMARY KEY,
+					uuid          TEXT NOT NULL,
+					username      TEXT NOT NULL,
+					discriminator TEXT NOT NULL,
+					access_token  TEXT NOT NULL,
+					token_type    TEXT NOT NULL,
+					expires_in    INTEGER NOT NULL,
+					refresh_token TEXT NOT NULL,
+					scope         TEXT NOT NULL,
+					created_at    TIMESTAMPTZ NOT NULL,
+					updated_at    TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE TABLE summaries (
+					summary_id TEXT PRIMARY KEY,
+					user_id    TEXT NOT NULL,
+					server_id  TEXT NOT NULL,
+					is_private BOOLEAN NOT NULL,
+					summary    TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX summaries_user_id_server_id ON summaries (user_id, server_id)`,
+			},
+		},
+		Down: Statements{
+			SQLite:   {`DROP TABLE summaries`, `DROP TABLE users`},
+			Postgres: {`DROP TABLE summaries`, `DROP TABLE users`},
+		},
+	},
+	{
+		Version:     2,
+		Description: "user preferences and the audit log",
+		Up: Statements{
+			SQLite: {
+				`ALTER TABLE users ADD COLUMN default_private BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+				`CREATE TABLE audit_log (
+					event_id TEXT PRIMARY KEY,
+					time     TIMESTAMP NOT NULL,
+					actor    TEXT NOT NULL,
+					action   TEXT NOT NULL,
+					target   TEXT NOT NULL,
+					details  TEXT NOT NULL
+				)`,
+				`CREATE INDEX audit_log_time ON audit_log (time)`,
+			},
+			Postgres: {
+				`ALTER TABLE users ADD COLUMN default_private BOOLEAN NOT NULL DEFAULT FALSE`,
+				`ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE users ADD COLUMN language TEXT NOT NULL DEFAULT ''`,
+				`CREATE TABLE audit_log (
+					event_id TEXT PRIMARY KEY,
+					time     TIMESTAMPTZ NOT NULL,
+					actor    TEXT NOT NULL,
+					action   TEXT NOT NULL,
+					target   TEXT NOT NULL,
+					details  TEXT NOT NULL
+				)`,
+				`CREATE INDEX audit_log_time ON audit_log (time)`,
+			},
+		},
+		Down: Statements{
+			SQLite: {
+				`DROP TABLE audit_log`,
+				`ALTER TABLE users DROP COLUMN language`,
+				`ALTER TABLE users DROP COLUMN timezone`,
+				`ALTER TABLE users DROP COLUMN default_private`,
+			},
+			Postgres: {
+				`DROP TABLE audit_log`,
+				`ALTER TABLE users DROP COLUMN language`,
+				`ALTER TABLE users DROP COLUMN timezone`,
+				`ALTER TABLE users DROP COLUMN default_private`,
+			},
+		},
+	},
+	{
+		Version:     3,
+		Description: "index users by access token",
+		Up: Statements{
+			SQLite:   {`CREATE INDEX users_access_token ON users (access_token)`},
+			Postgres: {`CREATE INDEX users_access_token ON users (access_token)`},
+		},
+		Down: Statements{
+			SQLite:   {`DROP INDEX users_access_token`},
+			Postgres: {`DROP INDEX users_access_token`},
+		},
+	},
+	{
+		Version:     4,
+		Description: "identities linked from other providers, and users by UUID",
+		Up: Statements{
+			SQLite: {
+				`CREATE TABLE identities (
+					identity_id   TEXT PRIMARY KEY,
+					provider      TEXT NOT NULL,
+					subject       TEXT NOT NULL,
+					user_uuid     TEXT NOT NULL,
+					username      TEXT NOT NULL,
+					email         TEXT NOT NULL,
+					access_token  TEXT NOT NULL,
+					token_type    TEXT NOT NULL,
+					expires_in    INTEGER NOT NULL,
+					refresh_token TEXT NOT NULL,
+					scope         TEXT NOT NULL,
+					created_at    TIMESTAMP NOT NULL,
+					updated_at    TIMESTAMP NOT NULL,
+					UNIQUE (provider, subject),
+					UNIQUE (user_uuid, provider)
+				)`,
+				`CREATE INDEX users_uuid ON users (uuid)`,
+			},
+			Postgres: {
+				`CREATE TABLE identities (
+					identity_id   TEXT PRIMARY KEY,
+					provider      TEXT NOT NULL,
+					subject       TEXT NOT NULL,
+					user_uuid     TEXT NOT NULL,
+					username      TEXT NOT NULL,
+					email         TEXT NOT NULL,
+					access_token  TEXT NOT NULL,
+					token_type    TEXT NOT NULL,
+					expires_in    INTEGER NOT NULL,
+					refresh_token TEXT NOT NULL,
+					scope         TEXT NOT NULL,
+					created_at    TIMESTAMPTZ NOT NULL,
+					updated_at    TIMESTAMPTZ NOT NULL,
+					UNIQUE (provider, subject),
+					UNIQUE (user_uuid, provider)
+				)`,
+				`CREATE INDEX users_uuid ON users (uuid)`,
+			},
+		},
+		Down: Statements{
+			SQLite:   {`DROP INDEX users_uuid`, `DROP TABLE identities`},
+			Postgres: {`DROP INDEX users_uuid`, `DROP TABLE identities`},
+		},
+	},
+	{
+		Version:     5,
+		Description: "API keys",
+		Up: Statements{
+			SQLite: {
+				`CREATE TABLE api_keys (
+					key_id       TEXT PRIMARY KEY,
+					prefix       TEXT NOT NULL UNIQUE,
+					hash         TEXT NOT NULL,
+					user_uuid    TEXT NOT NULL,
+					user_id      TEXT NOT NULL,
+					name         TEXT NOT NULL,
+					kind         TEXT NOT NULL,
+					scopes       TEXT NOT NULL,
+					server_ids   TEXT NOT NULL,
+					created_at   TIMESTAMP NOT NULL,
+					last_used_at TIMESTAMP
+				)`,
+				`CREATE INDEX api_keys_user_uuid ON api_keys (user_uuid, created_at)`,
+			},
+			Postgres: {
+				`CREATE TABLE api_keys (
+					key_id       TEXT PRIMARY KEY,
+					prefix       TEXT NOT NULL UNIQUE,
+					hash         TEXT NOT NULL,
+					user_uuid    TEXT NOT NULL,
+					user_id      TEXT NOT NULL,
+					name         TEXT NOT NULL,
+					kind         TEXT NOT NULL,
+					scopes       TEXT NOT NULL,
+					server_ids   TEXT NOT NULL,
+					created_at   TIMESTAMPTZ NOT NULL,
+					last_used_at TIMESTAMPTZ
+				)`,
+				`CREATE INDEX api_keys_user_uuid ON api_keys (user_uuid, created_at)`,
+			},
+		},
+		Down: Statements{
+			SQLite:   {`DROP TABLE api_keys`},
+			Postgres: {`DROP TABLE api_keys`},
+		},
+	},
+	{
+		Version:     6,
+		Description: "audit log clients, hashes and filter indexes",
+		Up: Statements{
+			SQLite: {
+				`ALTER TABLE audit_log ADD COLUMN ip TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE audit_log ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE audit_log ADD COLUMN before_hash TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE audit_log ADD COLUMN after_hash TEXT NOT NULL DEFAULT ''`,
+				`CREATE INDEX audit_log_actor ON audit_log (actor, time)`,
+				`CREATE INDEX audit_log_target ON audit_log (target, time)`,
+				`CREATE INDEX audit_log_action ON audit_log (action, time)`,
+			},
+			Postgres: {
+				`ALTER TABLE audit_log ADD COLUMN ip TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE audit_log ADD COLUMN user_agent TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE audit_log ADD COLUMN before_hash TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE audit_log ADD COLUMN after_hash TEXT NOT NULL DEFAULT ''`,
+				`CREATE INDEX audit_log_actor ON audit_log (actor, time)`,
+				`CREATE INDEX audit_log_target ON audit_log (target, time)`,
+				`CREATE INDEX audit_log_action ON audit_log (action, time)`,
+			},
+		},
+		Down: Statements{
+			SQLite: {
+				`DROP INDEX audit_log_action`,
+				`DROP INDEX audit_log_target`,
+				`DROP INDEX audit_log_actor`,
+				`ALTER TABLE audit_log DROP COLUMN after_hash`,
+				`ALTER TABLE audit_log DROP COLUMN before_hash`,
+				`ALTER TABLE audit_log DROP COLUMN user_agent`,
+				`ALTER TABLE audit_log DROP COLUMN ip`,
+			},
+			Postgres: {
+				`DROP INDEX audit_log_action`,
+				`DROP INDEX audit_log_target`,
+				`DROP INDEX audit_log_actor`,
+				`ALTER TABLE audit_log DROP COLUMN after_hash`,
+				`ALTER TABLE audit_log DROP COLUMN before_hash`,
+				`ALTER TABLE audit_log DROP COLUMN user_agent`,
+				`ALTER TABLE audit_log DROP COLUMN ip`,
+			},
+		},
+	},
+	{
+		Version:     7,
+		Description: "disabled users",
+		Up: Statements{
+			SQLite:   {`ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`},
+			Postgres: {`ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`},
+		},
+		Down: Statements{
+			SQLite:   {`ALTER TABLE users DROP COLUMN disabled`},
+			Postgres: {`ALTER TABLE users DROP COLUMN disabled`},
+		},
+	},
+}
+
+// Migrator applies migrations to a DB. Applied versions are recorded in
+// the schema_migrations table. Each migration runs in its own transaction
+// together with its record, so a migration is never half applied, and two
+// instances starting together cannot both apply it: the second blocks on
+// the first's record and then skips the version.
+type Migrator struct {
+	db         *DB
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator for migrations, which must have unique
+// versions and Up statements for db's dialect. Use All for the
+// application's schema.
+func NewMigrator(db *DB, list []Migration) *Migrator {
+	sorted := append([]Migration(nil), list...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if len(m.Up[db.Dialect]) == 0 {
+			panic(fmt.Sprintf("sqldb: version %d has no %s statements", m.Version, db.Dialect))
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			panic(fmt.Sprintf("sqldb: version %d is defined twice", m.Version))
+		}
+	}
+	return &Migrator{db: db, migrations: sorted}
+}
+
+// Status lists every migration, oldest first, with when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]migrations.Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []migrations.Status
+	for _, migration := range m.migrations {
+		status := migrations.Status{Version: migration.Version, Description: migration.Description}
+		if s, ok := applied[migration.Version]; ok {
+			status.AppliedAt = s.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, s := range applied {
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies every pending migration in order and returns those it
+// applied, stopping at the first failure
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if err := m.createTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		start := time.Now()
+		ran, err := m.apply(ctx, migration)
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		if !ran {
+			continue
+		}
+		slog.Info("applied migration", "version", migration.Version, "description", migration.Description, "duration_ms", time.Since(start).Milliseconds())
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// apply runs migration and records it, reporting false if another
+// instance recorded it first
+func (m *Migrator) apply(ctx context.Context, migration Migration) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, m.db.rebind(`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?) ON CONFLICT (version) DO NOTHING`),
+		migration.Version, migration.Description, now())
+	if err != nil {
+		return false, fmt.Errorf("recording: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	for _, statement := range migration.Up[m.db.Dialect] {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// Down reverts the steps most recently applied migrations, newest first,
+// and returns those it reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if err := m.createTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if len(migration.Down[m.db.Dialect]) == 0 {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, migrations.ErrIrreversible)
+		}
+
+		slog.Info("reverting migration", "version", migration.Version, "description", migration.Description)
+		if err := m.revert(ctx, migration); err != nil {
+			return done, fmt.Errorf("reverting migration %d (%s): %w", migration.Version, migration.Description, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+func (m *Migrator) revert(ctx context.Context, migration Migration) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range migration.Down[m.db.Dialect] {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, m.db.rebind(`DELETE FROM schema_migrations WHERE version = ?`), migration.Version); err != nil {
+		return fmt.Errorf("unrecording: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) createTable(ctx context.Context) error {
+	timestamp := "TIMESTAMP"
+	if m.db.Dialect == Postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := m.db.exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at  `+timestamp+` NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// applied returns the recorded migrations by version, marked Unknown
+func (m *Migrator) applied(ctx context.Context) (map[int]migrations.Status, error) {
+	rows, err := m.db.query(ctx, `SELECT version, description, applied_at FROM schema_migrations`)
+	if err != nil {
+		if !m.tableExists(ctx) {
+			return map[int]migrations.Status{}, nil
+		}
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]migrations.Status{}
+	for rows.Next() {
+		var s migrations.Status
+		var appliedAt time.Time
+		if err := rows.Scan(&s.Version, &s.Description, &appliedAt); err != nil {
+			return nil, fmt.Errorf("reading schema_migrations: %w", err)
+		}
+		appliedAt = appliedAt.UTC()
+		s.AppliedAt = &appliedAt
+		s.Unknown = true
+		applied[s.Version] = s
+	}
+	return applied, rows.Err()
+}
+
+// tableExists reports whether schema_migrations has been created, so
+// Status works on an empty database
+func (m *Migrator) tableExists(ctx context.Context) bool {
+	var one int
+	err := m.db.queryRow(ctx, `SELECT 1 FROM schema_migrations LIMIT 1`).Scan(&one)
+	return err == nil || errors.Is(err, sql.ErrNoRows)
+}
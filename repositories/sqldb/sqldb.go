@@ -0,0 +1,157 @@
+// Package sqldb implements the repository interfaces on database/sql, for
+// deployments that would rather not run MongoDB. Two dialects are
+// supported: SQLite through a pure-Go driver, so no C toolchain is needed,
+// and PostgreSQL through pgx. Each dialect has its own schema history,
+// applied by Migrator.
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Dialect is the SQL database flavour behind a DB
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DB is a connection pool and the dialect its queries are written in
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// Open connects to dsn and checks the connection. For SQLite dsn is a file
+// path, optionally with query parameters; the database is created if
+// needed and opened with a busy timeout, so that instances sharing the
+// file wait for each other's writes instead of failing. For PostgreSQL dsn
+// is a postgres:// URL or a key=value connection string.
+func Open(ctx context.Context, dialect Dialect, dsn string) (*DB, error) {
+	var driver string
+	switch dialect {
+	case SQLite:
+		driver = "sqlite"
+		dsn = sqliteDSN(dsn)
+	case Postgres:
+		driver = "pgx"
+	default:
+		return nil, fmt.Errorf("sqldb: unknown dialect %q", dialect)
+	}
+
+	pool, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == SQLite {
+		// SQLite has a single writer; more connections only queue on its lock
+		pool.SetMaxOpenConns(1)
+	}
+	if err := pool.PingContext(ctx); err != nil {
+		_ = pool.Close()
+		return nil, err
+	}
+	return &DB{DB: pool, Dialect: dialect}, nil
+}
+
+// Ping checks the database is reachable, for health checks
+func (db *DB) Ping(ctx context.Context) error {
+	return db.PingContext(ctx)
+}
+
+// Close closes the pool. The context is accepted so DB can be registered
+// as a shutdown hook like the MongoDB client.
+func (db *DB) Close(context.Context) error {
+	return db.DB.Close()
+}
+
+// sqliteDSN adds the pragmas the repositories rely on: a busy timeout,
+// write-ahead logging, and a timestamp format that sorts as text
+func sqliteDSN(dsn string) string {
+	params := "_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + params
+	}
+	return dsn + "?" + params
+}
+
+// rebind rewrites the ? placeholders queries are written with into the
+// dialect's own
+func (db *DB) rebind(query string) string {
+	if db.Dialect != Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (db *DB) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(ctx, db.rebind(query), args...)
+}
+
+func (db *DB) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(ctx, db.rebind(query), args...)
+}
+
+func (db *DB) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.QueryRowContext(ctx, db.rebind(query), args...)
+}
+
+// now returns the current time as the repositories store it: UTC, with the
+// millisecond precision MongoDB keeps, so every backend reads back the same
+// value
+func now() time.Time {
+	return storedTime(time.Now())
+}
+
+func storedTime(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond).UTC()
+}
+
+// sqliteTimeFormat is how the driver writes timestamps with the
+// _time_format=sqlite parameter sqliteDSN sets
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// aggregateTime scans an aggregate such as MAX of a timestamp column.
+// SQLite does not type aggregates by their column, so its driver returns
+// the stored text rather than a time.
+type aggregateTime struct {
+	time.Time
+}
+
+func (t *aggregateTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		t.Time = v.UTC()
+	case string:
+		parsed, err := time.Parse(sqliteTimeFormat, v)
+		if err != nil {
+			return err
+		}
+		t.Time = parsed.UTC()
+	default:
+		return fmt.Errorf("sqldb: cannot scan %T as a time", value)
+	}
+	return nil
+}
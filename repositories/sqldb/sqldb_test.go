@@ -0,0 +1,119 @@
+package sqldb_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/migrations"
+	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/repositories/repositorytest"
+	"ultra-chat-backend/repositories/sqldb"
+)
+
+var dialects = []sqldb.Dialect{sqldb.SQLite, sqldb.Postgres}
+
+func TestConformance(t *testing.T) {
+	for _, dialect := range dialects {
+		t.Run(string(dialect), func(t *testing.T) {
+			repositorytest.Run(t, func(t *testing.T) repositorytest.Repositories {
+				db := apptest.SQLDatabase(t, dialect)
+				if _, err := sqldb.NewMigrator(db, sqldb.All).Up(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				return repositorytest.Repositories{
+					Users:       sqldb.NewUserRepository(db, repositories.Timeouts{}),
+					Summaries:   sqldb.NewSummaryRepository(db, repositories.Timeouts{}),
+					Audit:       sqldb.NewAuditRepository(db, repositories.Timeouts{}),
+					Identities:  sqldb.NewIdentityRepository(db, repositories.Timeouts{}),
+					APIKeys:     sqldb.NewAPIKeyRepository(db, repositories.Timeouts{}),
+					Maintenance: sqldb.NewMaintenance(db, repositories.Timeouts{}),
+					Bulk:        sqldb.NewBulkStore(db),
+				}
+			})
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	for _, dialect := range dialects {
+		t.Run(string(dialect), func(t *testing.T) {
+			db := apptest.SQLDatabase(t, dialect)
+			ctx := context.Background()
+			migrator := sqldb.NewMigrator(db, sqldb.All)
+
+			statuses, err := migrator.Status(ctx)
+			if err != nil || len(statuses) != len(sqldb.All) || statuses[0].AppliedAt != nil {
+				t.Fatalf("status of an empty database = %+v, %v", statuses, err)
+			}
+
+			applied, err := migrator.Up(ctx)
+			if err != nil || len(applied) != len(sqldb.All) {
+				t.Fatalf("Up = %d applied, %v", len(applied), err)
+			}
+			if again, err := migrator.Up(ctx); err != nil || len(again) != 0 {
+				t.Fatalf("second Up = %d applied, %v", len(again), err)
+			}
+			statuses, err = migrator.Status(ctx)
+			if err != nil || statuses[len(statuses)-1].AppliedAt == nil {
+				t.Fatalf("status after Up = %+v, %v", statuses, err)
+			}
+
+			reverted, err := migrator.Down(ctx, len(sqldb.All))
+			if err != nil || len(reverted) != len(sqldb.All) {
+				t.Fatalf("Down = %d reverted, %v", len(reverted), err)
+			}
+			if applied, err := migrator.Up(ctx); err != nil || len(applied) != len(sqldb.All) {
+				t.Fatalf("Up after Down = %d applied, %v", len(applied), err)
+			}
+		})
+	}
+}
+
+func TestConcurrentUpMigratesOnce(t *testing.T) {
+	for _, dialect := range dialects {
+		t.Run(string(dialect), func(t *testing.T) {
+			db := apptest.SQLDatabase(t, dialect)
+
+			var wg sync.WaitGroup
+			results := make([]int, 3)
+			for i := range results {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					applied, err := sqldb.NewMigrator(db, sqldb.All).Up(context.Background())
+					if err != nil {
+						t.Error(err)
+					}
+					results[i] = len(applied)
+				}(i)
+			}
+			wg.Wait()
+
+			total := 0
+			for _, n := range results {
+				total += n
+			}
+			if total != len(sqldb.All) {
+				t.Errorf("migrations applied %d times in total, want %d", total, len(sqldb.All))
+			}
+		})
+	}
+}
+
+func TestIrreversible(t *testing.T) {
+	db := apptest.SQLDatabase(t, sqldb.SQLite)
+	ctx := context.Background()
+	migrator := sqldb.NewMigrator(db, []sqldb.Migration{{
+		Version: 1,
+		Up:      sqldb.Statements{sqldb.SQLite: {`CREATE TABLE t (x INTEGER)`}},
+	}})
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Down(ctx, 1); !errors.Is(err, migrations.ErrIrreversible) {
+		t.Errorf("reverting an irreversible migration: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type summaryRepository struct {
+	db       *DB
+	timeouts repositories.Timeouts
+}
+
+// NewSummaryRepository stores summaries in the summaries table of db,
+// bounding each operation by timeouts
+func NewSummaryRepository(db *DB, timeouts repositories.Timeouts) repositories.SummaryRepository {
+	return &summaryRepository{db: db, timeouts: timeouts}
+}
+
+const summaryColumns = `summary_id, user_id, server_id, is_private, summary, created_at, updated_at`
+
+func (r *summaryRepository) AddSummary(ctx context.Context, summary *models.Summary) error {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "AddSummary")
+	defer cancel()
+
+	if summary.CreatedAt.IsZero() {
+		summary.CreatedAt = now()
+	}
+	if summary.UpdatedAt.IsZero() {
+		summary.UpdatedAt = summary.CreatedAt
+	}
+
+	_, err := r.db.exec(ctx, `INSERT INTO summaries (`+summaryColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		summary.ID, summary.UserID, summary.ServerID, summary.IsPrivate, summary.Content,
+		storedTime(summary.CreatedAt), storedTime(summary.UpdatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add summary: %w", err)
+	}
+	return nil
+}
+
+func (r *summaryRepository) GetSummaries(ctx context.Context, userID string) ([]models.Summary, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "GetSummaries")
+	defer cancel()
+
+	rows, err := r.db.query(ctx, `SELECT `+summaryColumns+` FROM summaries WHERE user_id = ? ORDER BY created_at, summary_id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve summaries: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []models.Summary{}
+	for rows.Next() {
+		summary, err := scanSummary(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode summaries: %w", err)
+		}
+		summaries = append(summaries, *summary)
+	}
+	return summaries, rows.Err()
+}
+
+func (r *summaryRepository) GetSummary(ctx context.Context, userID, summaryID string) (*models.Summary, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "GetSummary")
+	defer cancel()
+
+	summary, err := scanSummary(r.db.queryRow(ctx, `SELECT `+summaryColumns+` FROM summaries WHERE user_id = ? AND summary_id = ?`, userID, summaryID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repositories.ErrSummaryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve summary: %w", err)
+	}
+	return summary, nil
+}
+
+func (r *summaryRepository) UpdateSummary(ctx context.Context, userID, serverID string, isPrivate bool, content string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "UpdateSummary")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `UPDATE summaries SET summary = ?, updated_at = ?
+		WHERE summary_id = (
+			SELECT summary_id FROM summaries
+			WHERE user_id = ? AND server_id = ? AND is_private = ?
+			ORDER BY created_at, summary_id LIMIT 1
+		)`,
+		content, now(), userID, serverID, isPrivate,
+	)
+	return affected(result, err, "failed to update summary")
+}
+
+func (r *summaryRepository) PatchSummary(ctx context.Context, userID, summaryID string, patch models.SummaryPatch) error {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "PatchSummary")
+	defer cancel()
+
+	set := []string{"updated_at = ?"}
+	args := []interface{}{now()}
+	if patch.ServerID != nil {
+		set = append(set, "server_id = ?")
+		args = append(args, *patch.ServerID)
+	}
+	if patch.IsPrivate != nil {
+		set = append(set, "is_private = ?")
+		args = append(args, *patch.IsPrivate)
+	}
+	if patch.Content != nil {
+		set = append(set, "summary = ?")
+		args = append(args, *patch.Content)
+	}
+	args = append(args, userID, summaryID)
+
+	result, err := r.db.exec(ctx, `UPDATE summaries SET `+strings.Join(set, ", ")+` WHERE user_id = ? AND summary_id = ?`, args...)
+	return affected(result, err, "failed to update summary")
+}
+
+func (r *summaryRepository) DeleteSummary(ctx context.Context, userID, summaryID string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "DeleteSummary")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `DELETE FROM summaries WHERE user_id = ? AND summary_id = ?`, userID, summaryID)
+	return affected(result, err, "failed to delete summary")
+}
+
+func (r *summaryRepository) DeleteSummaries(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "DeleteSummaries")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `DELETE FROM summaries WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete summaries: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (r *summaryRepository) ServerStats(ctx context.Context) ([]models.ServerStats, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "ServerStats")
+	defer cancel()
+
+	rows, err := r.db.query(ctx, `SELECT server_id, COUNT(*), SUM(CASE WHEN is_private THEN 1 ELSE 0 END), COUNT(DISTINCT user_id), MAX(updated_at)
+		FROM summaries GROUP BY server_id ORDER BY server_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate summaries: %w", err)
+	}
+	defer rows.Close()
+
+	list := []models.ServerStats{}
+	for rows.Next() {
+		var stats models.ServerStats
+		var lastUpdated aggregateTime
+		if err := rows.Scan(&stats.ServerID, &stats.Summaries, &stats.Private, &stats.Authors, &lastUpdated); err != nil {
+			return nil, fmt.Errorf("failed to decode server stats: %w", err)
+		}
+		stats.LastUpdatedAt = lastUpdated.Time
+		list = append(list, stats)
+	}
+	return list, rows.Err()
+}
+
+func (r *summaryRepository) CheckUserExists(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "CheckUserExists")
+	defer cancel()
+
+	return exists(ctx, r.db, `SELECT 1 FROM users WHERE id = ?`, userID)
+}
+
+// affected maps a statement that changed no summary to ErrSummaryNotFound
+func affected(result sql.Result, err error, msg string) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	if n == 0 {
+		return repositories.ErrSummaryNotFound
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSummary(row scanner) (*models.Summary, error) {
+	var s models.Summary
+	if err := row.Scan(&s.ID, &s.UserID, &s.ServerID, &s.IsPrivate, &s.Content, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return nil, err
+	}
+	s.CreatedAt = s.CreatedAt.UTC()
+	s.UpdatedAt = s.UpdatedAt.UTC()
+	return &s, nil
+}
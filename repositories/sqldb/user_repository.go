@@ -0,0 +1,261 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+type userRepository struct {
+	db       *DB
+	timeouts repositories.Timeouts
+}
+
+// NewUserRepository stores users in the users table of db, bounding each
+// operation by timeouts
+func NewUserRepository(db *DB, timeouts repositories.Timeouts) repositories.UserRepository {
+	return &userRepository{db: db, timeouts: timeouts}
+}
+
+const userColumns = `id, uuid, username, discriminator, access_token, token_type, expires_in, refresh_token, scope, default_private, timezone, language, disabled, created_at, updated_at`
+
+func (r *userRepository) FindUserByID(ctx context.Context, id string) (*models.User, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "FindUserByID")
+	defer cancel()
+
+	user, err := scanUser(r.db.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repositories.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *userRepository) FindUserByUUID(ctx context.Context, uuid string) (*models.User, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "FindUserByUUID")
+	defer cancel()
+
+	user, err := scanUser(r.db.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE uuid = ?`, uuid))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repositories.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *userRepository) FindUserByAccessToken(ctx context.Context, accessToken string) (*models.User, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "FindUserByAccessToken")
+	defer cancel()
+
+	if accessToken == "" {
+		return nil, repositories.ErrUserNotFound
+	}
+
+	user, err := scanUser(r.db.queryRow(ctx, `SELECT `+userColumns+` FROM users WHERE access_token = ?`, accessToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repositories.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve user: %w", err)
+	}
+	return user, nil
+}
+
+func (r *userRepository) CreateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "CreateUser")
+	defer cancel()
+
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now()
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
+
+	_, err := r.db.exec(ctx, `INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, user.UUID, user.Username, user.Discriminator,
+		user.Token.AccessToken, user.Token.TokenType, user.Token.ExpiresIn, user.Token.RefreshToken, user.Token.Scope,
+		user.Preferences.DefaultPrivate, user.Preferences.Timezone, user.Preferences.Language,
+		user.Disabled, storedTime(user.CreatedAt), storedTime(user.UpdatedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return nil
+}
+
+func (r *userRepository) UpdateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "UpdateUser")
+	defer cancel()
+
+	user.UpdatedAt = now()
+	result, err := r.db.exec(ctx, `UPDATE users SET
+		username = ?, discriminator = ?,
+		access_token = ?, token_type = ?, expires_in = ?, refresh_token = ?, scope = ?,
+		updated_at = ?
+		WHERE id = ?`,
+		user.Username, user.Discriminator,
+		user.Token.AccessToken, user.Token.TokenType, user.Token.ExpiresIn, user.Token.RefreshToken, user.Token.Scope,
+		user.UpdatedAt, user.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) UpdatePreferences(ctx context.Context, userID string, patch models.PreferencesPatch) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "UpdatePreferences")
+	defer cancel()
+
+	sets := []string{"updated_at = ?"}
+	args := []interface{}{now()}
+	if patch.DefaultPrivate != nil {
+		sets = append(sets, "default_private = ?")
+		args = append(args, *patch.DefaultPrivate)
+	}
+	if patch.Timezone != nil {
+		sets = append(sets, "timezone = ?")
+		args = append(args, *patch.Timezone)
+	}
+	if patch.Language != nil {
+		sets = append(sets, "language = ?")
+		args = append(args, *patch.Language)
+	}
+
+	result, err := r.db.exec(ctx, `UPDATE users SET `+strings.Join(sets, ", ")+` WHERE id = ?`, append(args, userID)...)
+	if err != nil {
+		return fmt.Errorf("failed to update preferences: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "SetUserDisabled")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `UPDATE users SET disabled = ?, updated_at = ? WHERE id = ?`, disabled, now(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "SearchUsers")
+	defer cancel()
+
+	sqlQuery := `SELECT ` + userColumns + ` FROM users`
+	var args []interface{}
+	if query != "" {
+		sqlQuery += ` WHERE id = ? OR uuid = ? OR LOWER(username) LIKE ? ESCAPE '\'`
+		args = append(args, query, query, "%"+likeEscaper.Replace(strings.ToLower(query))+"%")
+	}
+	sqlQuery += ` ORDER BY username, id`
+	if limit > 0 {
+		sqlQuery += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := r.db.query(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode users: %w", err)
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// likeEscaper escapes the LIKE wildcards in a search term, for patterns
+// declaring backslash their escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (r *userRepository) DeleteUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "DeleteUser")
+	defer cancel()
+
+	result, err := r.db.exec(ctx, `DELETE FROM users WHERE id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return repositories.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) IsAuthenticated(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "IsAuthenticated")
+	defer cancel()
+
+	return exists(ctx, r.db, `SELECT 1 FROM users WHERE id = ?`, userID)
+}
+
+func (r *userRepository) CountUsers(ctx context.Context) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "CountUsers")
+	defer cancel()
+
+	var count int64
+	err := r.db.queryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+// exists reports whether query returns a row
+func exists(ctx context.Context, db *DB, query string, args ...interface{}) (bool, error) {
+	var one int
+	err := db.queryRow(ctx, query, args...).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func scanUser(row scanner) (*models.User, error) {
+	var u models.User
+	err := row.Scan(
+		&u.ID, &u.UUID, &u.Username, &u.Discriminator,
+		&u.Token.AccessToken, &u.Token.TokenType, &u.Token.ExpiresIn, &u.Token.RefreshToken, &u.Token.Scope,
+		&u.Preferences.DefaultPrivate, &u.Preferences.Timezone, &u.Preferences.Language,
+		&u.Disabled, &u.CreatedAt, &u.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	u.CreatedAt = u.CreatedAt.UTC()
+	u.UpdatedAt = u.UpdatedAt.UTC()
+	return &u, nil
+}
@@ -9,53 +9,60 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"ultra-chat-backend/models"
 )
 
+// SummaryRepository stores summaries in their own collection
+type SummaryRepository interface {
+	// AddSummary stores a new summary, stamping CreatedAt and UpdatedAt
+	// when they are unset
+	AddSummary(ctx context.Context, summary *models.Summary) error
+	// GetSummaries returns the user's summaries, oldest first
+	GetSummaries(ctx context.Context, userID string) ([]models.Summary, error)
+	GetSummary(ctx context.Context, userID, summaryID string) (*models.Summary, error)
+	// UpdateSummary changes the content of the user's oldest summary for
+	// the server with the given visibility, breaking ties in creation time
+	// by summary ID
+	UpdateSummary(ctx context.Context, userID, serverID string, isPrivate bool, content string) error
+	PatchSummary(ctx context.Context, userID, summaryID string, patch models.SummaryPatch) error
+	DeleteSummary(ctx context.Context, userID, summaryID string) error
+	// DeleteSummaries removes every summary of the user and returns how
+	// many there were
+	DeleteSummaries(ctx context.Context, userID string) (int64, error)
+	// ServerStats sums up the summaries of every server that has any,
+	// ordered by server ID
+	ServerStats(ctx context.Context) ([]models.ServerStats, error)
+	CheckUserExists(ctx context.Context, userID string) (bool, error)
+}
+
 // MongoSummaryRepository handles operations related to summaries and users
 type MongoSummaryRepository struct {
 	collection     *mongo.Collection
 	userCollection *mongo.Collection
+	timeouts       Timeouts
 }
 
-// NewMongoSummaryRepository initializes the repository with MongoDB collections
-func NewMongoSummaryRepository(db *mongo.Database) (*MongoSummaryRepository, error) {
-	usersCollection := db.Collection("users")
-	summariesCollection := db.Collection("summaries")
-
-	// Create unique index on user_id in the users collection
-	if _, err := usersCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
-		Keys:    bson.D{{Key: "user_id", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	}); err != nil {
-		return nil, errors.New("failed to create index on users collection: " + err.Error())
-	}
-
-	// Create index on user_id and server_id in the summaries collection
-	if _, err := summariesCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
-		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "server_id", Value: 1}},
-	}); err != nil {
-		return nil, errors.New("failed to create index on summaries collection: " + err.Error())
-	}
-
+// NewMongoSummaryRepository initializes the repository with MongoDB
+// collections, bounding each operation by timeouts. The indexes it relies
+// on are created by the migrations package.
+func NewMongoSummaryRepository(db *mongo.Database, timeouts Timeouts) *MongoSummaryRepository {
 	return &MongoSummaryRepository{
-		collection:     summariesCollection,
-		userCollection: usersCollection,
-	}, nil
+		collection:     db.Collection("summaries"),
+		userCollection: db.Collection("users"),
+		timeouts:       timeouts,
+	}
 }
 
 // AddSummary inserts a new summary into the summaries collection
-func (r *MongoSummaryRepository) AddSummary(summaryID, userID, serverID string, isPrivate bool, summaryContent, createdAt string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoSummaryRepository) AddSummary(ctx context.Context, summary *models.Summary) error {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "AddSummary")
 	defer cancel()
 
-	summary := bson.M{
-		"summary_id": summaryID,
-		"user_id":    userID,
-		"server_id":  serverID,
-		"is_private": isPrivate,
-		"summary":    summaryContent,
-		"created_at": createdAt,
-		"updated_at": createdAt,
+	if summary.CreatedAt.IsZero() {
+		summary.CreatedAt = time.Now().UTC()
+	}
+	if summary.UpdatedAt.IsZero() {
+		summary.UpdatedAt = summary.CreatedAt
 	}
 
 	if _, err := r.collection.InsertOne(ctx, summary); err != nil {
@@ -64,49 +71,102 @@ func (r *MongoSummaryRepository) AddSummary(summaryID, userID, serverID string,
 	return nil
 }
 
-// GetSummaries retrieves summaries matching the provided filter
-func (r *MongoSummaryRepository) GetSummaries(filter bson.M) ([]bson.M, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// GetSummaries retrieves the user's summaries, oldest first
+func (r *MongoSummaryRepository) GetSummaries(ctx context.Context, userID string) ([]models.Summary, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "GetSummaries")
 	defer cancel()
 
-	cursor, err := r.collection.Find(ctx, filter)
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
 	if err != nil {
 		return nil, errors.New("failed to retrieve summaries: " + err.Error())
 	}
 	defer cursor.Close(ctx)
 
-	var summaries []bson.M
+	summaries := []models.Summary{}
 	if err := cursor.All(ctx, &summaries); err != nil {
 		return nil, errors.New("failed to decode summaries: " + err.Error())
 	}
 	return summaries, nil
 }
 
-// UpdateSummary modifies the summary content for the given filter
-func (r *MongoSummaryRepository) UpdateSummary(userID, serverID string, isPrivate bool, content string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// UpdateSummary modifies the content of the oldest summary matching the
+// user, server and visibility
+func (r *MongoSummaryRepository) UpdateSummary(ctx context.Context, userID, serverID string, isPrivate bool, content string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "UpdateSummary")
 	defer cancel()
 
 	filter := bson.M{"user_id": userID, "server_id": serverID, "is_private": isPrivate}
 	update := bson.M{
 		"$set": bson.M{
 			"summary":    content,
-			"updated_at": time.Now(),
+			"updated_at": time.Now().UTC(),
 		},
 	}
 
-	result, err := r.collection.UpdateOne(ctx, filter, update)
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "summary_id", Value: 1}})
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Err()
+	if err == mongo.ErrNoDocuments {
+		return ErrSummaryNotFound
+	}
 	if err != nil {
 		return errors.New("failed to update summary: " + err.Error())
 	}
+	return nil
+}
+
+// GetSummary retrieves a single summary owned by the given user
+func (r *MongoSummaryRepository) GetSummary(ctx context.Context, userID, summaryID string) (*models.Summary, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "GetSummary")
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"summary_id": summaryID,
+	}
+
+	var summary models.Summary
+	if err := r.collection.FindOne(ctx, filter).Decode(&summary); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSummaryNotFound
+		}
+		return nil, fmt.Errorf("failed to retrieve summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// PatchSummary applies a partial update to a single summary owned by the given user
+func (r *MongoSummaryRepository) PatchSummary(ctx context.Context, userID, summaryID string, patch models.SummaryPatch) error {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "PatchSummary")
+	defer cancel()
+
+	filter := bson.M{
+		"user_id":    userID,
+		"summary_id": summaryID,
+	}
+
+	set := bson.M{"updated_at": time.Now().UTC()}
+	if patch.ServerID != nil {
+		set["server_id"] = *patch.ServerID
+	}
+	if patch.IsPrivate != nil {
+		set["is_private"] = *patch.IsPrivate
+	}
+	if patch.Content != nil {
+		set["summary"] = *patch.Content
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return fmt.Errorf("failed to update summary: %w", err)
+	}
 	if result.MatchedCount == 0 {
-		return errors.New("no matching summary found")
+		return ErrSummaryNotFound
 	}
 	return nil
 }
 
-func (r *MongoSummaryRepository) DeleteSummary(userID, summaryID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoSummaryRepository) DeleteSummary(ctx context.Context, userID, summaryID string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "DeleteSummary")
 	defer cancel()
 
 	filter := bson.M{
@@ -120,15 +180,69 @@ func (r *MongoSummaryRepository) DeleteSummary(userID, summaryID string) error {
 	}
 
 	if result.DeletedCount == 0 {
-		return errors.New("no matching summary found")
+		return ErrSummaryNotFound
 	}
 	return nil
 }
 
-func (r *MongoSummaryRepository) CheckUserExists(userID string) (bool, error) {
+// DeleteSummaries removes all of the user's summaries
+func (r *MongoSummaryRepository) DeleteSummaries(ctx context.Context, userID string) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "DeleteSummaries")
+	defer cancel()
+
+	result, err := r.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete summaries: %w", err)
+	}
+	return result.DeletedCount, nil
+}
+
+// ServerStats groups the summaries by server
+func (r *MongoSummaryRepository) ServerStats(ctx context.Context) ([]models.ServerStats, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "ServerStats")
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":             "$server_id",
+			"summaries":       bson.M{"$sum": 1},
+			"private":         bson.M{"$sum": bson.M{"$cond": bson.A{"$is_private", 1, 0}}},
+			"authors":         bson.M{"$addToSet": "$user_id"},
+			"last_updated_at": bson.M{"$max": "$updated_at"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":             0,
+			"server_id":       "$_id",
+			"summaries":       1,
+			"private":         1,
+			"authors":         bson.M{"$size": "$authors"},
+			"last_updated_at": 1,
+		}}},
+		{{Key: "$sort", Value: bson.M{"server_id": 1}}},
+	}
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate summaries: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	stats := []models.ServerStats{}
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, fmt.Errorf("failed to decode server stats: %w", err)
+	}
+	for i := range stats {
+		stats[i].LastUpdatedAt = stats[i].LastUpdatedAt.UTC()
+	}
+	return stats, nil
+}
+
+func (r *MongoSummaryRepository) CheckUserExists(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "summaries", "CheckUserExists")
+	defer cancel()
+
 	filter := bson.M{"id": userID} // Use "id" field instead of "_id"
 	var result bson.M
-	err := r.userCollection.FindOne(context.Background(), filter).Decode(&result)
+	err := r.userCollection.FindOne(ctx, filter).Decode(&result)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return false, nil // User not found
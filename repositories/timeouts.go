@@ -0,0 +1,125 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a database operation with no more specific deadline
+const DefaultTimeout = 10 * time.Second
+
+// Timeouts bounds how long each database repository operation may take. The
+// deadline is applied on top of the caller's context, so a request that is
+// cancelled or has a sooner deadline stops its database work too.
+type Timeouts struct {
+	// Default applies to every operation without an override; zero uses
+	// DefaultTimeout
+	Default time.Duration
+	// Methods overrides Default per operation, keyed like the operation's
+	// trace span, e.g. "summaries.GetSummaries" or "users.FindUserByID"
+	Methods map[string]time.Duration
+}
+
+// For returns the deadline of the given repository ("users" or
+// "summaries") method
+func (t Timeouts) For(repository, method string) time.Duration {
+	if d, ok := t.Methods[repository+"."+method]; ok {
+		return d
+	}
+	if t.Default > 0 {
+		return t.Default
+	}
+	return DefaultTimeout
+}
+
+// Context derives the context a single operation runs under
+func (t Timeouts) Context(ctx context.Context, repository, method string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, t.For(repository, method))
+}
+
+// String renders t the way ParseTimeouts reads it
+func (t Timeouts) String() string {
+	def := t.Default
+	if def <= 0 {
+		def = DefaultTimeout
+	}
+	parts := []string{def.String()}
+
+	keys := make([]string, 0, len(t.Methods))
+	for key := range t.Methods {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts = append(parts, key+"="+t.Methods[key].String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t Timeouts) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler using ParseTimeouts
+func (t *Timeouts) UnmarshalText(text []byte) error {
+	timeouts, err := ParseTimeouts(string(text))
+	if err != nil {
+		return err
+	}
+	*t = timeouts
+	return nil
+}
+
+// repositoryTypes are the interfaces whose methods can be given their own
+// deadline, by the name used in metrics and traces. Maintenance tasks can
+// take far longer than requests and are listed so they can be given room.
+var repositoryTypes = map[string]reflect.Type{
+	"users":       reflect.TypeOf((*UserRepository)(nil)).Elem(),
+	"summaries":   reflect.TypeOf((*SummaryRepository)(nil)).Elem(),
+	"maintenance": reflect.TypeOf((*Maintenance)(nil)).Elem(),
+}
+
+// ParseTimeouts parses "<default>[,<repository>.<method>=<duration>...]",
+// e.g. "10s,summaries.GetSummaries=3s". The default may be omitted.
+func ParseTimeouts(s string) (Timeouts, error) {
+	var t Timeouts
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, value, override := strings.Cut(part, "=")
+		if !override {
+			value = key
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d <= 0 {
+			return Timeouts{}, fmt.Errorf("invalid timeout %q: expected a positive duration such as 5s", part)
+		}
+		if !override {
+			t.Default = d
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		repository, method, _ := strings.Cut(key, ".")
+		typ, ok := repositoryTypes[repository]
+		if !ok {
+			return Timeouts{}, fmt.Errorf("invalid timeout %q: repository must be users, summaries or maintenance", part)
+		}
+		if _, ok := typ.MethodByName(method); !ok {
+			return Timeouts{}, fmt.Errorf("invalid timeout %q: %s has no method %q", part, repository, method)
+		}
+		if t.Methods == nil {
+			t.Methods = map[string]time.Duration{}
+		}
+		t.Methods[key] = d
+	}
+	return t, nil
+}
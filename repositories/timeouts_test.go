@@ -0,0 +1,50 @@
+package repositories
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	got, err := ParseTimeouts("3s, summaries.GetSummaries=500ms,users.FindUserByID=1m,maintenance.Reindex=10m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Timeouts{Default: 3 * time.Second, Methods: map[string]time.Duration{
+		"summaries.GetSummaries": 500 * time.Millisecond,
+		"users.FindUserByID":     time.Minute,
+		"maintenance.Reindex":    10 * time.Minute,
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if s := got.String(); s != "3s,maintenance.Reindex=10m0s,summaries.GetSummaries=500ms,users.FindUserByID=1m0s" {
+		t.Errorf("String() = %q", s)
+	}
+
+	for _, tt := range []struct {
+		repository, method string
+		want               time.Duration
+	}{
+		{"summaries", "GetSummaries", 500 * time.Millisecond},
+		{"users", "GetSummaries", 3 * time.Second},
+		{"users", "FindUserByID", time.Minute},
+		{"maintenance", "Reindex", 10 * time.Minute},
+	} {
+		if d := got.For(tt.repository, tt.method); d != tt.want {
+			t.Errorf("For(%s, %s) = %s, want %s", tt.repository, tt.method, d, tt.want)
+		}
+	}
+	if d := (Timeouts{}).For("users", "CreateUser"); d != DefaultTimeout {
+		t.Errorf("zero Timeouts gave %s, want DefaultTimeout", d)
+	}
+}
+
+func TestParseTimeoutsRejectsUnknownMethods(t *testing.T) {
+	for _, s := range []string{"soon", "0s", "-1s", "orders.Find=1s", "users.Fly=1s", "users.FindUserByID=later"} {
+		if _, err := ParseTimeouts(s); err == nil {
+			t.Errorf("ParseTimeouts(%q) succeeded", s)
+		}
+	}
+}
@@ -2,110 +2,237 @@ package repositories
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"ultra-chat-backend/models"
 )
 
 type UserRepository interface {
-	FindUserByID(id string) (*models.User, error)
-	CreateUser(user *models.User) error
-	UpdateUser(id string, update bson.M) error
-	AddSummary(userID string, summary bson.M) error
-	GetSummaries(userID string) ([]bson.M, error)
-	UpdateSummary(userID, summaryID, content string) error
-	DeleteSummary(userID, summaryID string) error
-	IsAuthenticated(userID string) (bool, error)
+	FindUserByID(ctx context.Context, id string) (*models.User, error)
+	// FindUserByUUID returns the user with the given account UUID, or
+	// ErrUserNotFound
+	FindUserByUUID(ctx context.Context, uuid string) (*models.User, error)
+	// FindUserByAccessToken returns the user whose stored token has the
+	// given access token, or ErrUserNotFound; an empty token matches no one
+	FindUserByAccessToken(ctx context.Context, accessToken string) (*models.User, error)
+	// CreateUser stores a new user, stamping CreatedAt and UpdatedAt when
+	// they are unset
+	CreateUser(ctx context.Context, user *models.User) error
+	// UpdateUser stores the user's token and Discord profile and stamps
+	// UpdatedAt; the other fields never change
+	UpdateUser(ctx context.Context, user *models.User) error
+	// UpdatePreferences applies patch to the user's preferences and stamps
+	// UpdatedAt
+	UpdatePreferences(ctx context.Context, userID string, patch models.PreferencesPatch) error
+	// SetUserDisabled disables or enables the user and stamps UpdatedAt
+	SetUserDisabled(ctx context.Context, userID string, disabled bool) error
+	// SearchUsers returns the users whose Discord ID or UUID is query or
+	// whose username contains it, ignoring case, ordered by username and
+	// then ID. An empty query matches everyone; a zero limit returns all.
+	SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error)
+	// DeleteUser removes the user; their summaries are left to the caller
+	DeleteUser(ctx context.Context, userID string) error
+	IsAuthenticated(ctx context.Context, userID string) (bool, error)
+	CountUsers(ctx context.Context) (int64, error)
 }
 
 type userRepository struct {
 	collection *mongo.Collection
+	timeouts   Timeouts
 }
 
-func NewUserRepository(db *mongo.Database) UserRepository {
+// NewUserRepository stores users in the users collection of db, bounding
+// each operation by timeouts
+func NewUserRepository(db *mongo.Database, timeouts Timeouts) UserRepository {
 	return &userRepository{
 		collection: db.Collection("users"),
+		timeouts:   timeouts,
 	}
 }
 
-func (r *userRepository) FindUserByID(id string) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *userRepository) FindUserByID(ctx context.Context, id string) (*models.User, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "FindUserByID")
 	defer cancel()
 
 	var user models.User
 	err := r.collection.FindOne(ctx, bson.M{"id": id}).Decode(&user)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *userRepository) CreateUser(user *models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *userRepository) FindUserByUUID(ctx context.Context, uuid string) (*models.User, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "FindUserByUUID")
 	defer cancel()
 
-	_, err := r.collection.InsertOne(ctx, user)
-	return err
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"uuid": uuid}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
 }
 
-func (r *userRepository) UpdateUser(id string, update bson.M) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *userRepository) FindUserByAccessToken(ctx context.Context, accessToken string) (*models.User, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "FindUserByAccessToken")
 	defer cancel()
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": update})
-	return err
+	if accessToken == "" {
+		return nil, ErrUserNotFound
+	}
+
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"token.access_token": accessToken}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
 }
 
-func (r *userRepository) AddSummary(userID string, summary bson.M) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *userRepository) CreateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "CreateUser")
 	defer cancel()
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"id": userID}, bson.M{"$push": bson.M{"summaries": summary}})
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now().UTC()
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = user.CreatedAt
+	}
+
+	_, err := r.collection.InsertOne(ctx, user)
 	return err
 }
 
-func (r *userRepository) GetSummaries(userID string) ([]bson.M, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *userRepository) UpdateUser(ctx context.Context, user *models.User) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "UpdateUser")
 	defer cancel()
 
-	var user struct {
-		Summaries []bson.M `bson:"summaries"`
-	}
-	err := r.collection.FindOne(ctx, bson.M{"id": userID}).Decode(&user)
+	user.UpdatedAt = time.Now().UTC()
+	result, err := r.collection.UpdateOne(ctx, bson.M{"id": user.ID}, bson.M{"$set": bson.M{
+		"token":         user.Token,
+		"username":      user.Username,
+		"discriminator": user.Discriminator,
+		"updated_at":    user.UpdatedAt,
+	}})
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
 	}
-	return user.Summaries, nil
+	return nil
 }
 
-func (r *userRepository) UpdateSummary(userID, summaryID, content string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *userRepository) UpdatePreferences(ctx context.Context, userID string, patch models.PreferencesPatch) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "UpdatePreferences")
 	defer cancel()
 
-	filter := bson.M{"id": userID, "summaries.id": summaryID}
-	update := bson.M{"$set": bson.M{"summaries.$.content": content}}
+	set := bson.M{"updated_at": time.Now().UTC()}
+	if patch.DefaultPrivate != nil {
+		set["preferences.default_private"] = *patch.DefaultPrivate
+	}
+	if patch.Timezone != nil {
+		set["preferences.timezone"] = *patch.Timezone
+	}
+	if patch.Language != nil {
+		set["preferences.language"] = *patch.Language
+	}
 
-	_, err := r.collection.UpdateOne(ctx, filter, update)
-	return err
+	result, err := r.collection.UpdateOne(ctx, bson.M{"id": userID}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
-func (r *userRepository) DeleteSummary(userID, summaryID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *userRepository) SetUserDisabled(ctx context.Context, userID string, disabled bool) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "SetUserDisabled")
 	defer cancel()
 
-	filter := bson.M{"id": userID}
-	update := bson.M{"$pull": bson.M{"summaries": bson.M{"id": summaryID}}}
+	result, err := r.collection.UpdateOne(ctx, bson.M{"id": userID}, bson.M{"$set": bson.M{
+		"disabled":   disabled,
+		"updated_at": time.Now().UTC(),
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
 
-	_, err := r.collection.UpdateOne(ctx, filter, update)
-	return err
+func (r *userRepository) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "SearchUsers")
+	defer cancel()
+
+	filter := bson.M{}
+	if query != "" {
+		filter = bson.M{"$or": bson.A{
+			bson.M{"id": query},
+			bson.M{"uuid": query},
+			bson.M{"username": primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}},
+		}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}, {Key: "id", Value: 1}}).SetLimit(int64(limit))
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, fmt.Errorf("failed to decode users: %w", err)
+	}
+	return users, nil
 }
 
-func (r *userRepository) IsAuthenticated(userID string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *userRepository) DeleteUser(ctx context.Context, userID string) error {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "DeleteUser")
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *userRepository) IsAuthenticated(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "IsAuthenticated")
 	defer cancel()
 
 	count, err := r.collection.CountDocuments(ctx, bson.M{"id": userID})
 	return count > 0, err
 }
+
+func (r *userRepository) CountUsers(ctx context.Context) (int64, error) {
+	ctx, cancel := r.timeouts.Context(ctx, "users", "CountUsers")
+	defer cancel()
+
+	return r.collection.EstimatedDocumentCount(ctx)
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// LegacyDeprecatedAt is when the verb-named routes were superseded by /api/v1
+	LegacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	// LegacySunsetAt is when the verb-named routes will stop being served
+	LegacySunsetAt = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// Deprecated marks a route as deprecated (RFC 9745) with a sunset date
+// (RFC 8594) and links to the route that replaces it.
+func Deprecated(successor string) echo.MiddlewareFunc {
+	deprecation := fmt.Sprintf("@%d", LegacyDeprecatedAt.Unix())
+	sunset := LegacySunsetAt.Format(http.TimeFormat)
+	link := fmt.Sprintf("<%s>; rel=\"successor-version\"", successor)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Response().Header()
+			header.Set("Deprecation", deprecation)
+			header.Set("Sunset", sunset)
+			header.Add("Link", link)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,678 @@
+package routes
+
+import (
+	"net/http"
+
+	"ultra-chat-backend/apperror"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/health"
+	"ultra-chat-backend/metrics"
+	"ultra-chat-backend/openapi"
+)
+
+const (
+	specPath      = "/openapi.json"
+	docsPath      = "/docs"
+	docsAssets    = "/docs/assets"
+	livenessPath  = "/healthz"
+	readinessPath = "/readyz"
+	metricsPath   = "/metrics"
+)
+
+var (
+	bearerAuth = []openapi.SecurityRequirement{{"bearerAuth": {}}}
+
+	// summaryAuth is an API key or the user's bearer token; only a key
+	// is accepted when the server requires API keys
+	summaryAuth = []openapi.SecurityRequirement{{"apiKeyAuth": {}}, {"bearerAuth": {}}}
+
+	userIDHeader = openapi.Parameter{
+		Name:        "ID",
+		In:          "header",
+		Description: "Discord ID of the user the request acts on; may be left out with a user API key, which acts as its creator, or a bearer token, which only acts as its own user",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+
+	authProviderHeader = openapi.Parameter{
+		Name:        handlers.AuthProviderHeader,
+		In:          "header",
+		Description: "Linked provider that issued the bearer token; omit for Discord tokens",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+
+	stateParameter = openapi.Parameter{
+		Name:        "state",
+		In:          "query",
+		Required:    true,
+		Description: "State the provider redirected back with; must match the login cookie",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+
+	// auditQueryParams select audit events on the admin routes
+	auditQueryParams = []openapi.Parameter{
+		authProviderHeader,
+		{Name: "actor", In: "query", Description: "Only events by this actor, e.g. user:<uuid> or api_key:<id>", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "target", In: "query", Description: "Only events on this target, e.g. user:<uuid> or summary:<id>", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "action", In: "query", Description: "Only events of this action, e.g. summary.deleted", Schema: &openapi.Schema{Type: "string"}},
+		{Name: "since", In: "query", Description: "Only events at or after this RFC 3339 time", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+		{Name: "until", In: "query", Description: "Only events before this RFC 3339 time", Schema: &openapi.Schema{Type: "string", Format: "date-time"}},
+	}
+
+	deprecationHeaders = map[string]*openapi.Header{
+		"Deprecation": {Description: "When the route was deprecated (RFC 9745)", Schema: &openapi.Schema{Type: "string"}},
+		"Sunset":      {Description: "When the route will be removed (RFC 8594)", Schema: &openapi.Schema{Type: "string"}},
+		"Link":        {Description: "The successor-version route", Schema: &openapi.Schema{Type: "string"}},
+	}
+)
+
+const (
+	loginDescription    = "Also sets the HttpOnly login_<provider> cookie binding the login to this client for 10 minutes; the callback must be called with it."
+	callbackDescription = "Must be called by the client that started the login, with its login cookie and the state the provider redirected back with; each login can be finished once."
+)
+
+func errorResponse(status int, description string) openapi.ResponseSpec {
+	return openapi.ResponseSpec{
+		Status:      status,
+		Description: description,
+		Body:        apperror.Problem{},
+		ContentType: apperror.ContentType,
+	}
+}
+
+// accountDisabled documents the rejection of a user an admin disabled
+func accountDisabled() openapi.ResponseSpec {
+	return errorResponse(http.StatusForbidden, "The account is disabled")
+}
+
+// rateLimited documents the response of ratelimit.Limiter; every API route is limited
+func rateLimited() openapi.ResponseSpec {
+	response := errorResponse(http.StatusTooManyRequests, "Rate limit exceeded")
+	response.Headers = map[string]*openapi.Header{
+		"Retry-After":         {Description: "Seconds until a request will be accepted", Schema: &openapi.Schema{Type: "integer"}},
+		"RateLimit-Limit":     {Description: "Bucket capacity", Schema: &openapi.Schema{Type: "integer"}},
+		"RateLimit-Remaining": {Description: "Requests left in the bucket", Schema: &openapi.Schema{Type: "integer"}},
+		"RateLimit-Reset":     {Description: "Seconds until the bucket is full", Schema: &openapi.Schema{Type: "integer"}},
+	}
+	return response
+}
+
+// Spec describes every route mounted by Register. Adding a route without
+// documenting it here fails TestSpecCoversRegisteredRoutes.
+func Spec() *openapi.Document {
+	b := openapi.NewBuilder(openapi.Info{
+		Title:       "Ultra Chat API",
+		Description: "Backend for the ultra-chat Discord bot: Discord OAuth2 login and chat summaries.",
+		Version:     "1.0.0",
+	}).
+		Tag("auth", "Login with Discord and linked identity providers").
+		Tag("users", "The authenticated user").
+		Tag("summaries", "Chat summaries").
+		Tag("admin", "Operator tools, for the configured admin Discord IDs only").
+		Tag("legacy", "Deprecated verb-named routes, kept until the sunset date").
+		Tag("health", "Probes for the orchestrator").
+		SecurityScheme("bearerAuth", &openapi.SecurityScheme{
+			Type:        "http",
+			Scheme:      "bearer",
+			Description: "Discord OAuth2 access token, or an access token of the linked provider named in the X-Auth-Provider header",
+		}).
+		SecurityScheme("apiKeyAuth", &openapi.SecurityScheme{
+			Type:        "apiKey",
+			In:          "header",
+			Name:        handlers.APIKeyHeader,
+			Description: "API key created under /api/v1/me/api-keys. It only does what its scopes allow, in its servers. A server key does not see private summaries other than its creator's.",
+		})
+
+	for _, route := range healthRoutes() {
+		b.Add(route)
+	}
+	for _, route := range v1Routes() {
+		route.Path = "/api/v1" + route.Path
+		route.Responses = append(route.Responses, rateLimited())
+		b.Add(route)
+	}
+	for _, route := range legacyRoutes() {
+		route.Tags = []string{"legacy"}
+		route.Deprecated = true
+		for i := range route.Responses {
+			route.Responses[i].Headers = deprecationHeaders
+		}
+		route.Responses = append(route.Responses, rateLimited())
+		b.Add(route)
+	}
+
+	return b.Document()
+}
+
+func healthRoutes() []openapi.Route {
+	return []openapi.Route{
+		{
+			Method: http.MethodGet, Path: livenessPath, OperationID: "liveness", Tags: []string{"health"},
+			Summary:     "Check that the process is alive",
+			Description: "Checks no dependencies; restart the instance if this fails.",
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.LivenessResponse{}},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: readinessPath, OperationID: "readiness", Tags: []string{"health"},
+			Summary:     "Check that the instance can serve traffic",
+			Description: "Probes MongoDB, startup tasks such as index creation and Discord. Discord being unreachable only degrades the report.",
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: health.Report{}, Description: "Ready, possibly degraded"},
+				{Status: http.StatusServiceUnavailable, Body: health.Report{}, Description: "A required check failed"},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: metricsPath, OperationID: "metrics", Tags: []string{"health"},
+			Summary:     "Prometheus metrics",
+			Description: "Text exposition format 0.0.4. Requires the METRICS_TOKEN as a bearer token when one is configured.",
+			Security:    bearerAuth,
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: "", ContentType: metrics.ContentType},
+				errorResponse(http.StatusUnauthorized, "Missing or wrong metrics token"),
+			},
+		},
+	}
+}
+
+func v1Routes() []openapi.Route {
+	return []openapi.Route{
+		{
+			Method: http.MethodGet, Path: "/auth/login", OperationID: "login", Tags: []string{"auth"},
+			Summary:     "Get the Discord OAuth2 authorize URL",
+			Description: loginDescription,
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.LoginResponse{}},
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/auth/callback", OperationID: "callback", Tags: []string{"auth"},
+			Summary:     "Exchange the OAuth2 code and store the user",
+			Description: callbackDescription,
+			Parameters: []openapi.Parameter{
+				{Name: "code", In: "query", Required: true, Description: "Authorization code issued by Discord", Schema: &openapi.Schema{Type: "string"}},
+				stateParameter,
+			},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: ""},
+				errorResponse(http.StatusBadRequest, "Missing, invalid or expired code, or a state that does not match the login started from this client"),
+				accountDisabled(),
+				errorResponse(http.StatusBadGateway, "Discord request failed"),
+				errorResponse(http.StatusInternalServerError, "Failed to store the user"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/auth/status", OperationID: "authStatus", Tags: []string{"auth"},
+			Summary:  "Check that a bearer token is valid",
+			Security: bearerAuth,
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.AuthStatusResponse{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusBadGateway, "Discord request failed"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/auth/logout", OperationID: "logout", Tags: []string{"auth"},
+			Summary:     "Log out and unlink Discord",
+			Description: "Revokes the Discord authorization behind the bearer token and the stored tokens, then clears the stored token. The account and its summaries are kept; logging in again links Discord anew. The stored token is only cleared once Discord has revoked it, so a failed logout can be retried.",
+			Security:    bearerAuth,
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusNoContent, Description: "Logged out"},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token, or the Discord authorization was already revoked"),
+				errorResponse(http.StatusBadGateway, "Discord could not revoke the authorization"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/auth/:provider/login", OperationID: "providerLogin", Tags: []string{"auth"},
+			Summary:     "Get the authorize URL of an identity provider",
+			Description: "The provider is discord or a configured OpenID Connect provider. " + loginDescription,
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.LoginResponse{}},
+				errorResponse(http.StatusNotFound, "Unknown provider"),
+				errorResponse(http.StatusBadGateway, "The provider could not be reached"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/auth/:provider/callback", OperationID: "providerCallback", Tags: []string{"auth"},
+			Summary:     "Exchange a provider's OAuth2 code",
+			Description: "For discord this is the same as GET /auth/callback. For any other provider, a request with a bearer token links the identity to the caller's account, and one without logs in with an identity linked before, returning the provider's token as the session. " + callbackDescription,
+			Security:    bearerAuth,
+			Parameters: []openapi.Parameter{
+				{Name: "code", In: "query", Required: true, Description: "Authorization code issued by the provider", Schema: &openapi.Schema{Type: "string"}},
+				stateParameter,
+				authProviderHeader,
+			},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.ProviderSession{}, Description: "Logged in, or the linked identity was refreshed"},
+				{Status: http.StatusCreated, Body: handlers.Identity{}, Description: "Identity linked"},
+				errorResponse(http.StatusBadRequest, "Missing, invalid or expired code, or a state that does not match the login started from this client"),
+				errorResponse(http.StatusUnauthorized, "Invalid bearer token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "Unknown provider, or the identity is not linked to an account"),
+				errorResponse(http.StatusConflict, "The identity is linked to another account, or the account already has one at this provider"),
+				errorResponse(http.StatusBadGateway, "The provider request failed"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/me", OperationID: "getMe", Tags: []string{"users"},
+			Summary:    "Get the authenticated user's account and preferences",
+			Security:   bearerAuth,
+			Parameters: []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.Account{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "The user has not logged in through the OAuth flow"),
+				errorResponse(http.StatusBadGateway, "Discord request failed"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/me/discord", OperationID: "getMyDiscordUser", Tags: []string{"users"},
+			Summary:  "Get the Discord user the bearer token belongs to, as Discord reports it",
+			Security: bearerAuth,
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.DiscordUser{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusBadGateway, "Discord request failed"),
+			},
+		},
+		{
+			Method: http.MethodPatch, Path: "/me", OperationID: "patchMe", Tags: []string{"users"},
+			Summary:    "Update the authenticated user's preferences",
+			Security:   bearerAuth,
+			Parameters: []openapi.Parameter{authProviderHeader},
+			Request:    handlers.PatchAccountRequest{},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.Account{}},
+				errorResponse(http.StatusBadRequest, "Invalid body or no fields to update"),
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "The user has not logged in through the OAuth flow"),
+				errorResponse(http.StatusUnprocessableEntity, "Unknown time zone or malformed language tag"),
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/me", OperationID: "deleteMe", Tags: []string{"users"},
+			Summary:     "Delete the authenticated user's account",
+			Description: "Revokes the user's Discord tokens, deletes their summaries and account, and records an audit event that refers to them only by UUID. Revocation is best effort: the data is deleted even if Discord cannot be reached.",
+			Security:    bearerAuth,
+			Parameters:  []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusNoContent, Description: "Account deleted"},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "The user has not logged in through the OAuth flow"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/me/export", OperationID: "exportMe", Tags: []string{"users"},
+			Summary:     "Download everything stored about the authenticated user",
+			Description: "Served as a JSON attachment. Token values are never included.",
+			Security:    bearerAuth,
+			Parameters:  []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.AccountExport{}, Headers: map[string]*openapi.Header{
+					"Content-Disposition": {Description: "attachment; filename=\"ultra-chat-export.json\"", Schema: &openapi.Schema{Type: "string"}},
+				}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "The user has not logged in through the OAuth flow"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/me/identities", OperationID: "listMyIdentities", Tags: []string{"users"},
+			Summary:    "List the identities the authenticated user can log in with",
+			Security:   bearerAuth,
+			Parameters: []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: []handlers.Identity{}, Description: "Discord first, then the linked identities by provider"},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "The user has not logged in through the OAuth flow"),
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/me/identities/:provider", OperationID: "unlinkMyIdentity", Tags: []string{"users"},
+			Summary:    "Unlink an identity from the authenticated user's account",
+			Security:   bearerAuth,
+			Parameters: []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusNoContent, Description: "Identity unlinked"},
+				errorResponse(http.StatusBadRequest, "Discord cannot be unlinked"),
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "No identity at the provider is linked"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/me/api-keys", OperationID: "listMyAPIKeys", Tags: []string{"users"},
+			Summary:    "List the authenticated user's API keys",
+			Security:   bearerAuth,
+			Parameters: []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: []handlers.APIKey{}, Description: "Newest first"},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "The user has not logged in through the OAuth flow"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/me/api-keys", OperationID: "createMyAPIKey", Tags: []string{"users"},
+			Summary:     "Create an API key",
+			Description: "The key is only shown in this response; only its prefix and a hash are stored. Server keys need the Discord guilds scope, to check that the user owns or has the Manage Server permission in each server.",
+			Security:    bearerAuth,
+			Parameters:  []openapi.Parameter{authProviderHeader},
+			Request:     handlers.CreateAPIKeyRequest{},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusCreated, Body: handlers.CreatedAPIKey{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user does not manage one of the servers, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "The user has not logged in through the OAuth flow"),
+				errorResponse(http.StatusUnprocessableEntity, "Unknown kind or scope, or a server key without servers"),
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/me/api-keys/:id", OperationID: "revokeMyAPIKey", Tags: []string{"users"},
+			Summary:    "Revoke an API key",
+			Security:   bearerAuth,
+			Parameters: []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusNoContent, Description: "API key revoked"},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+				errorResponse(http.StatusNotFound, "The user has no API key with this ID"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/summaries", OperationID: "listSummaries", Tags: []string{"summaries"},
+			Summary:    "List the user's summaries",
+			Security:   summaryAuth,
+			Parameters: []openapi.Parameter{userIDHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: []handlers.Summary{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid API key or bearer token, or a server API key without the ID header"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+				errorResponse(http.StatusInternalServerError, "Failed to retrieve summaries"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/summaries", OperationID: "createSummary", Tags: []string{"summaries"},
+			Summary:  "Create a summary",
+			Security: summaryAuth,
+			Request:  handlers.CreateSummaryRequest{},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusCreated, Body: handlers.CreateSummaryResponse{}},
+				errorResponse(http.StatusBadRequest, "Invalid body or missing fields"),
+				errorResponse(http.StatusUnauthorized, "User not found, or a missing or invalid API key or bearer token"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+				errorResponse(http.StatusInternalServerError, "Failed to create summary"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/summaries/:id", OperationID: "getSummary", Tags: []string{"summaries"},
+			Summary:    "Get a summary",
+			Security:   summaryAuth,
+			Parameters: []openapi.Parameter{userIDHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.Summary{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid API key or bearer token, or a server API key without the ID header"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "Summary not found"),
+			},
+		},
+		{
+			Method: http.MethodPatch, Path: "/summaries/:id", OperationID: "patchSummary", Tags: []string{"summaries"},
+			Summary:    "Update some fields of a summary",
+			Security:   summaryAuth,
+			Parameters: []openapi.Parameter{userIDHeader},
+			Request:    handlers.PatchSummaryRequest{},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.MessageResponse{}},
+				errorResponse(http.StatusBadRequest, "Invalid body or no fields to update"),
+				errorResponse(http.StatusUnauthorized, "Missing or invalid API key or bearer token, or a server API key without the ID header"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "Summary not found"),
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/summaries/:id", OperationID: "deleteSummary", Tags: []string{"summaries"},
+			Summary:    "Delete a summary",
+			Security:   summaryAuth,
+			Parameters: []openapi.Parameter{userIDHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusNoContent, Description: "Summary deleted"},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid API key or bearer token, or a server API key without the ID header"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "Summary not found"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/admin/audit-events", OperationID: "listAuditEvents", Tags: []string{"admin"},
+			Summary:     "Search the audit log",
+			Description: "The log records logins, logouts, changes to accounts, identities and API keys, and every change to a summary, with the client's IP address and user agent. Changes carry SHA-256 hashes of the target before and after.",
+			Security:    bearerAuth,
+			Parameters: append(auditQueryParams, openapi.Parameter{
+				Name: "limit", In: "query", Description: "Most events to return, up to 1000; 100 when unset", Schema: &openapi.Schema{Type: "integer"},
+			}),
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: []handlers.AuditEvent{}, Description: "Newest first"},
+				errorResponse(http.StatusBadRequest, "Malformed query parameter"),
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+				errorResponse(http.StatusUnprocessableEntity, "Limit out of range"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/admin/audit-events/export", OperationID: "exportAuditEvents", Tags: []string{"admin"},
+			Summary:     "Download the audit log as NDJSON",
+			Description: "One audit event per line, newest first, selected as in GET /admin/audit-events but without a default limit. The export is itself recorded in the log.",
+			Security:    bearerAuth,
+			Parameters: append(auditQueryParams, openapi.Parameter{
+				Name: "limit", In: "query", Description: "Most events to return, up to 1000; all when unset", Schema: &openapi.Schema{Type: "integer"},
+			}),
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.AuditEvent{}, ContentType: handlers.NDJSONContentType, Description: "One event per line", Headers: map[string]*openapi.Header{
+					"Content-Disposition": {Description: "attachment; filename=\"ultra-chat-audit.ndjson\"", Schema: &openapi.Schema{Type: "string"}},
+				}},
+				errorResponse(http.StatusBadRequest, "Malformed query parameter"),
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+				errorResponse(http.StatusUnprocessableEntity, "Limit out of range"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/admin/users", OperationID: "searchUsers", Tags: []string{"admin"},
+			Summary:     "Search users",
+			Description: "Matches users whose Discord ID or UUID is the query, or whose username contains it regardless of case.",
+			Security:    bearerAuth,
+			Parameters: []openapi.Parameter{
+				authProviderHeader,
+				{Name: "q", In: "query", Description: "Discord ID, UUID or part of a username; every user when unset", Schema: &openapi.Schema{Type: "string"}},
+				{Name: "limit", In: "query", Description: "Most users to return, up to 1000; 100 when unset", Schema: &openapi.Schema{Type: "integer"}},
+			},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: []handlers.AdminUser{}, Description: "Ordered by username"},
+				errorResponse(http.StatusBadRequest, "Malformed query parameter"),
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+				errorResponse(http.StatusUnprocessableEntity, "Limit out of range"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/admin/users/:user_id/summaries", OperationID: "getUserSummaries", Tags: []string{"admin"},
+			Summary:     "List a user's summaries",
+			Description: "Private summaries included. Summaries left behind by a user who no longer exists are listed too.",
+			Security:    bearerAuth,
+			Parameters:  []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: []handlers.Summary{}, Description: "Oldest first"},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+				errorResponse(http.StatusUnprocessableEntity, "The user ID is not a Discord snowflake"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/admin/users/:user_id/disable", OperationID: "disableUser", Tags: []string{"admin"},
+			Summary:     "Disable a user",
+			Description: "A disabled user cannot log in or call the API, and API keys they created stop working, until the user is enabled again. Their data and tokens are kept; revoke the tokens to log them out of Discord too.",
+			Security:    bearerAuth,
+			Parameters:  []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.AdminUser{}},
+				errorResponse(http.StatusBadRequest, "Admins cannot be disabled"),
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "User not found"),
+				errorResponse(http.StatusUnprocessableEntity, "The user ID is not a Discord snowflake"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/admin/users/:user_id/enable", OperationID: "enableUser", Tags: []string{"admin"},
+			Summary:    "Enable a disabled user",
+			Security:   bearerAuth,
+			Parameters: []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.AdminUser{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "User not found"),
+				errorResponse(http.StatusUnprocessableEntity, "The user ID is not a Discord snowflake"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/admin/users/:user_id/revoke-tokens", OperationID: "revokeUserTokens", Tags: []string{"admin"},
+			Summary:     "Revoke a user's tokens",
+			Description: "Revokes every API key of the user and the Discord authorization stored for them, then clears the stored token. The keys are revoked even if Discord fails, in which case the request can be retried.",
+			Security:    bearerAuth,
+			Parameters:  []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.RevokedTokens{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "User not found"),
+				errorResponse(http.StatusUnprocessableEntity, "The user ID is not a Discord snowflake"),
+				errorResponse(http.StatusBadGateway, "Discord could not revoke the authorization"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/admin/servers", OperationID: "listServerStats", Tags: []string{"admin"},
+			Summary:    "Summary statistics per Discord server",
+			Security:   bearerAuth,
+			Parameters: []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: []handlers.ServerStats{}, Description: "Servers with any summaries, by server ID"},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/admin/maintenance/:task", OperationID: "runMaintenance", Tags: []string{"admin"},
+			Summary:     "Run a maintenance task",
+			Description: "reindex rebuilds the indexes of the storage backend; on MongoDB each index except the unique ones is dropped and recreated in turn. purge-orphans deletes the summaries, linked identities and API keys of users who no longer exist, such as users removed from the database by hand. The task runs to completion before the response.",
+			Security:    bearerAuth,
+			Parameters:  []openapi.Parameter{authProviderHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.MaintenanceResult{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				errorResponse(http.StatusForbidden, "The user is not an admin, or the account is disabled"),
+				errorResponse(http.StatusUnprocessableEntity, "Unknown task"),
+			},
+		},
+	}
+}
+
+func legacyRoutes() []openapi.Route {
+	return []openapi.Route{
+		{
+			Method: http.MethodGet, Path: "/login", OperationID: "legacyLogin",
+			Summary:     "Deprecated: use GET /api/v1/auth/login",
+			Description: loginDescription,
+			Responses:   []openapi.ResponseSpec{{Status: http.StatusOK, Body: handlers.LoginResponse{}}},
+		},
+		{
+			Method: http.MethodGet, Path: "/callback", OperationID: "legacyCallback",
+			Summary: "Deprecated: use GET /api/v1/auth/callback",
+			Parameters: []openapi.Parameter{
+				{Name: "code", In: "query", Required: true, Schema: &openapi.Schema{Type: "string"}},
+				stateParameter,
+			},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: ""},
+				errorResponse(http.StatusBadRequest, "No code provided, or a state that does not match the login started from this client"),
+				accountDisabled(),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/profile", OperationID: "legacyProfile",
+			Summary:  "Deprecated: use GET /api/v1/me/discord",
+			Security: bearerAuth,
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.DiscordUser{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/create-summary", OperationID: "legacyCreateSummary",
+			Summary:  "Deprecated: use POST /api/v1/summaries",
+			Security: summaryAuth,
+			Request:  handlers.CreateSummaryRequest{},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusCreated, Body: handlers.CreateSummaryResponse{}},
+				errorResponse(http.StatusBadRequest, "Invalid body or missing fields"),
+				errorResponse(http.StatusUnauthorized, "User not found, or a missing or invalid API key or bearer token"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/summarizer", OperationID: "legacyListSummaries",
+			Summary:    "Deprecated: use GET /api/v1/summaries",
+			Security:   summaryAuth,
+			Parameters: []openapi.Parameter{userIDHeader},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: []handlers.Summary{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid API key or bearer token, or a server API key without the ID header"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+			},
+		},
+		{
+			Method: http.MethodPut, Path: "/update-summary", OperationID: "legacyUpdateSummary",
+			Summary:    "Deprecated: use PATCH /api/v1/summaries/{id}",
+			Security:   summaryAuth,
+			Parameters: []openapi.Parameter{userIDHeader},
+			Request:    handlers.UpdateSummaryRequest{},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.MessageResponse{}},
+				errorResponse(http.StatusBadRequest, "Invalid body or missing fields"),
+				errorResponse(http.StatusUnauthorized, "User not found, or a missing or invalid API key or bearer token"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "Summary not found"),
+			},
+		},
+		{
+			Method: http.MethodDelete, Path: "/delete-summary", OperationID: "legacyDeleteSummary",
+			Summary:    "Deprecated: use DELETE /api/v1/summaries/{id}",
+			Security:   summaryAuth,
+			Parameters: []openapi.Parameter{userIDHeader},
+			Request:    handlers.DeleteSummaryRequest{},
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.MessageResponse{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid API key or bearer token, or a server API key without the ID header"),
+				errorResponse(http.StatusForbidden, "The API key lacks the scope or cannot act for the user or in the server, the bearer token belongs to another user, or the account is disabled"),
+				errorResponse(http.StatusNotFound, "Summary not found"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/is_authenticated", OperationID: "legacyAuthStatus",
+			Summary:  "Deprecated: use GET /api/v1/auth/status",
+			Security: bearerAuth,
+			Responses: []openapi.ResponseSpec{
+				{Status: http.StatusOK, Body: handlers.AuthStatusResponse{}},
+				errorResponse(http.StatusUnauthorized, "Missing or invalid token"),
+				accountDisabled(),
+			},
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/openapi"
+	"ultra-chat-backend/ratelimit"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Rate limit groups. Routes that call Discord share the stricter auth policy.
+const (
+	GroupAuth      = "auth"
+	GroupSummaries = "summaries"
+)
+
+// ProbePaths are polled by the orchestrator and Prometheus rather than
+// called by clients
+var ProbePaths = []string{livenessPath, readinessPath, metricsPath}
+
+// Handlers are the handlers Register mounts
+type Handlers struct {
+	Auth      *handlers.AuthHandler
+	Account   *handlers.AccountHandler
+	Summaries *handlers.SummaryHandler
+	Admin     *handlers.AdminHandler
+	Health    *handlers.HealthHandler
+	Metrics   echo.HandlerFunc
+	// Identify recognises the caller of a request so the rate limiter can
+	// count it against them rather than their IP
+	Identify echo.MiddlewareFunc
+}
+
+// Register mounts the health and metrics endpoints, every API version and
+// the deprecated legacy routes on e.
+// A future /api/v2 gets its own registerV2 next to registerV1 so that both
+// versions can be served side by side while clients migrate. A nil limiter
+// disables rate limiting.
+func Register(e *echo.Echo, h Handlers, limiter *ratelimit.Limiter) {
+	// Probes and scrapes are not rate limited so the orchestrator and
+	// Prometheus are never throttled
+	e.GET(livenessPath, h.Health.Liveness)
+	e.GET(readinessPath, h.Health.Readiness)
+	e.GET(metricsPath, h.Metrics)
+
+	api := e.Group("/api")
+	registerV1(api.Group("/v1"), h, limiter)
+
+	registerLegacy(e, h, limiter)
+
+	// API description
+	e.GET(specPath, openapi.SpecHandler(Spec()))
+	e.GET(docsPath, openapi.DocsHandler("Ultra Chat API", specPath, docsAssets))
+	e.GET(docsAssets+"/*", openapi.AssetsHandler(docsAssets))
+}
+
+// registerV1 mounts the resource-oriented v1 API
+func registerV1(g *echo.Group, h Handlers, limiter *ratelimit.Limiter) {
+	authHandler, accountHandler, summaryHandler, adminHandler := h.Auth, h.Account, h.Summaries, h.Admin
+	authLimit := limit(limiter, GroupAuth, h.Identify)
+	summaryLimit := limit(limiter, GroupSummaries, h.Identify)
+
+	// Auth Routes
+	g.GET("/auth/login", authHandler.Login, authLimit)
+	g.GET("/auth/callback", authHandler.Callback, authLimit)
+	g.GET("/auth/status", summaryHandler.IsAuthenticated, authLimit)
+	g.POST("/auth/logout", authHandler.Logout, authLimit)
+	g.GET("/auth/:provider/login", authHandler.ProviderLogin, authLimit)
+	g.GET("/auth/:provider/callback", authHandler.ProviderCallback, authLimit)
+
+	// Account Routes
+	g.GET("/me", accountHandler.GetAccount, authLimit)
+	g.GET("/me/discord", authHandler.Profile, authLimit)
+	g.PATCH("/me", accountHandler.PatchAccount, authLimit)
+	g.DELETE("/me", accountHandler.DeleteAccount, authLimit)
+	g.GET("/me/export", accountHandler.ExportAccount, authLimit)
+	g.GET("/me/identities", accountHandler.ListIdentities, authLimit)
+	g.DELETE("/me/identities/:provider", accountHandler.UnlinkIdentity, authLimit)
+	g.GET("/me/api-keys", accountHandler.ListAPIKeys, authLimit)
+	g.POST("/me/api-keys", accountHandler.CreateAPIKey, authLimit)
+	g.DELETE("/me/api-keys/:id", accountHandler.RevokeAPIKey, authLimit)
+
+	// Summary Routes
+	g.GET("/summaries", summaryHandler.GetSummaries, summaryLimit)
+	g.POST("/summaries", summaryHandler.CreateSummary, summaryLimit)
+	g.GET("/summaries/:id", summaryHandler.GetSummary, summaryLimit)
+	g.PATCH("/summaries/:id", summaryHandler.PatchSummary, summaryLimit)
+	g.DELETE("/summaries/:id", summaryHandler.DeleteSummaryByID, summaryLimit)
+
+	// Admin Routes, all behind the one admin check
+	admin := g.Group("/admin", authLimit, adminHandler.RequireAdmin)
+	admin.GET("/audit-events", adminHandler.ListAuditEvents)
+	admin.GET("/audit-events/export", adminHandler.ExportAuditEvents)
+	admin.GET("/users", adminHandler.SearchUsers)
+	admin.GET("/users/:user_id/summaries", adminHandler.GetUserSummaries)
+	admin.POST("/users/:user_id/disable", adminHandler.DisableUser)
+	admin.POST("/users/:user_id/enable", adminHandler.EnableUser)
+	admin.POST("/users/:user_id/revoke-tokens", adminHandler.RevokeTokens)
+	admin.GET("/servers", adminHandler.ServerStats)
+	admin.POST("/maintenance/:task", adminHandler.RunMaintenance)
+}
+
+// registerLegacy keeps the original verb-named routes alive as deprecated
+// aliases. Each one advertises its v1 successor and the sunset date.
+func registerLegacy(e *echo.Echo, h Handlers, limiter *ratelimit.Limiter) {
+	authHandler, summaryHandler := h.Auth, h.Summaries
+	authLimit := limit(limiter, GroupAuth, h.Identify)
+	summaryLimit := limit(limiter, GroupSummaries, h.Identify)
+
+	e.GET("/login", authHandler.Login, Deprecated("/api/v1/auth/login"), authLimit)
+	e.GET("/callback", authHandler.Callback, Deprecated("/api/v1/auth/callback"), authLimit)
+	e.GET("/profile", authHandler.Profile, Deprecated("/api/v1/me/discord"), authLimit)
+
+	e.POST("/create-summary", summaryHandler.CreateSummary, Deprecated("/api/v1/summaries"), summaryLimit)
+	e.GET("/summarizer", summaryHandler.GetSummaries, Deprecated("/api/v1/summaries"), summaryLimit)
+	e.PUT("/update-summary", summaryHandler.UpdateSummary, Deprecated("/api/v1/summaries/:id"), summaryLimit)
+	e.DELETE("/delete-summary", summaryHandler.DeleteSummary, Deprecated("/api/v1/summaries/:id"), summaryLimit)
+	e.GET("/is_authenticated", summaryHandler.IsAuthenticated, Deprecated("/api/v1/auth/status"), authLimit)
+}
+
+// limit returns the middleware enforcing group's policy, with identify
+// running first so the caller is known when the request is counted
+func limit(limiter *ratelimit.Limiter, group string, identify echo.MiddlewareFunc) echo.MiddlewareFunc {
+	limited := limiter.For(group)
+	if limiter == nil || identify == nil {
+		return limited
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return identify(limited(next))
+	}
+}
@@ -0,0 +1,153 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/discord"
+	"ultra-chat-backend/handlers"
+	"ultra-chat-backend/identity"
+	"ultra-chat-backend/metrics"
+	"ultra-chat-backend/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+// undocumented routes serve the API description itself
+var undocumented = map[string]bool{
+	specPath:          true,
+	docsPath:          true,
+	docsAssets + "/*": true,
+}
+
+func newTestServer() *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = handlers.HTTPErrorHandler
+	e.Binder = validation.NewBinder(validation.DefaultMaxBodyBytes)
+	e.Validator = validation.New()
+	client := discord.NewClient(discord.Config{})
+	providers := identity.NewRegistry(identity.Discord(client))
+	Register(e, Handlers{
+		Auth:      handlers.NewAuthHandler(nil, nil, nil, client, providers),
+		Account:   handlers.NewAccountHandler(nil, nil, nil, nil, nil, client, providers),
+		Summaries: handlers.NewSummaryHandler(nil, nil, nil, nil, nil, client, nil, false, false),
+		Admin:     handlers.NewAdminHandler(nil, nil, nil, nil, nil, nil, client, providers, nil),
+		Health:    handlers.NewHealthHandler(nil),
+		Metrics:   metrics.Handler(metrics.NewRegistry(), ""),
+	}, nil)
+	return e
+}
+
+func TestSpecCoversRegisteredRoutes(t *testing.T) {
+	e := newTestServer()
+	spec := Spec()
+
+	for _, route := range e.Routes() {
+		// Groups with middleware register these to answer 404 themselves
+		if undocumented[route.Path] || route.Method == echo.RouteNotFound {
+			continue
+		}
+		if !spec.Has(route.Method, route.Path) {
+			t.Errorf("%s %s is registered but missing from the OpenAPI spec", route.Method, route.Path)
+		}
+	}
+}
+
+func TestSpecHasNoStaleRoutes(t *testing.T) {
+	e := newTestServer()
+
+	registered := map[string]bool{}
+	for _, route := range e.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+
+	for _, route := range append(v1Routes(), legacyRoutes()...) {
+		path := route.Path
+		if !registered[route.Method+" "+path] && !registered[route.Method+" /api/v1"+path] {
+			t.Errorf("%s %s is documented but not registered", route.Method, path)
+		}
+	}
+}
+
+func TestSpecEndpoint(t *testing.T) {
+	e := newTestServer()
+
+	req := httptest.NewRequest(http.MethodGet, specPath, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s returned %d", specPath, rec.Code)
+	}
+
+	var doc struct {
+		OpenAPI    string                     `json:"openapi"`
+		Paths      map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+	if doc.OpenAPI == "" || len(doc.Paths) == 0 {
+		t.Fatalf("spec is missing openapi version or paths: %s", rec.Body.String())
+	}
+	if _, ok := doc.Components.Schemas["CreateSummaryRequest"]; !ok {
+		t.Errorf("spec does not define the CreateSummaryRequest schema")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, docsPath, nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s returned %d", docsPath, rec.Code)
+	}
+}
+
+func TestLegacyRoutesAreDeprecated(t *testing.T) {
+	e := newTestServer()
+
+	req := httptest.NewRequest(http.MethodGet, "/summarizer", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Deprecation") == "" || rec.Header().Get("Sunset") == "" {
+		t.Errorf("legacy route is missing Deprecation/Sunset headers: %v", rec.Header())
+	}
+	if got := rec.Header().Get("Link"); got != `</api/v1/summaries>; rel="successor-version"` {
+		t.Errorf("unexpected Link header %q", got)
+	}
+}
+
+func TestRequestsAreValidatedBeforeReachingRepositories(t *testing.T) {
+	e := newTestServer()
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{http.MethodPatch, "/api/v1/summaries/not-a-uuid", `{"content":"x"}`, http.StatusUnprocessableEntity},
+		{http.MethodDelete, "/delete-summary", `{"summary_id":"42"}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/api/v1/summaries", `{"content":"x","server_id":"1","user_id":"80351110224678912"}`, http.StatusUnprocessableEntity},
+		{http.MethodPost, "/api/v1/summaries", `{"content":"x","unknown":1}`, http.StatusUnprocessableEntity},
+		{http.MethodGet, "/api/v1/summaries", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if tt.path != "/api/v1/summaries" || tt.method != http.MethodGet {
+			req.Header.Set("ID", "80351110224678912")
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body.String())
+		}
+	}
+}
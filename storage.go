@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"ultra-chat-backend/app"
+	"ultra-chat-backend/config"
+	"ultra-chat-backend/health"
+	"ultra-chat-backend/migrations"
+	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/repositories/sqldb"
+)
+
+// storage holds the databases the configuration uses: the storage backend
+// for users, their linked identities and API keys, summaries and the audit
+// log, and MongoDB whenever it is used at all, since it may only hold the
+// shared rate limit counters
+type storage struct {
+	users      repositories.UserRepository
+	identities repositories.IdentityRepository
+	apiKeys    repositories.APIKeyRepository
+	summaries  repositories.SummaryRepository
+	audit      repositories.AuditRepository
+	// maintenance runs the admin maintenance tasks on the storage backend
+	maintenance repositories.Maintenance
+	mongo       *config.DB
+	// checks probe each database for readiness
+	checks []health.Check
+	// schemas are the migration histories to keep current, the storage
+	// backend's last
+	schemas []schema
+}
+
+// openStorage connects to every database cfg uses, registering each with
+// lc to be closed on shutdown
+func openStorage(ctx context.Context, cfg *config.Config, lc *app.Lifecycle) (*storage, error) {
+	s := &storage{}
+
+	if cfg.UsesMongo() {
+		db, err := config.ConnectDB(ctx, cfg.Mongo)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to MongoDB: %w", err)
+		}
+		lc.OnStop("mongo", db.Close)
+		s.mongo = db
+		s.checks = append(s.checks, health.Check{Name: "mongo", Probe: db.Ping})
+		s.schemas = append(s.schemas, mongoSchema(migrations.New(db.Database, migrations.All)))
+	}
+
+	switch cfg.Storage.Backend {
+	case config.BackendMongo:
+		s.users = repositories.NewUserRepository(s.mongo.Database, cfg.Mongo.Timeouts)
+		s.identities = repositories.NewIdentityRepository(s.mongo.Database, cfg.Mongo.Timeouts)
+		s.apiKeys = repositories.NewAPIKeyRepository(s.mongo.Database, cfg.Mongo.Timeouts)
+		s.summaries = repositories.NewMongoSummaryRepository(s.mongo.Database, cfg.Mongo.Timeouts)
+		s.audit = repositories.NewAuditRepository(s.mongo.Database, cfg.Mongo.Timeouts)
+		s.maintenance = repositories.NewMongoMaintenance(s.mongo.Database, cfg.Mongo.Timeouts)
+	default:
+		db, err := config.ConnectSQL(ctx, cfg.Storage.Backend, cfg.SQL)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", cfg.Storage.Backend, err)
+		}
+		lc.OnStop(cfg.Storage.Backend, db.Close)
+		s.users = sqldb.NewUserRepository(db, cfg.SQL.Timeouts)
+		s.identities = sqldb.NewIdentityRepository(db, cfg.SQL.Timeouts)
+		s.apiKeys = sqldb.NewAPIKeyRepository(db, cfg.SQL.Timeouts)
+		s.summaries = sqldb.NewSummaryRepository(db, cfg.SQL.Timeouts)
+		s.audit = sqldb.NewAuditRepository(db, cfg.SQL.Timeouts)
+		s.maintenance = sqldb.NewMaintenance(db, cfg.SQL.Timeouts)
+		s.checks = append(s.checks, health.Check{Name: cfg.Storage.Backend, Probe: db.Ping})
+		s.schemas = append(s.schemas, sqlSchema(cfg.Storage.Backend, sqldb.NewMigrator(db, sqldb.All)))
+	}
+	return s, nil
+}
+
+// schema is one database's migration history, whichever kind it is
+type schema struct {
+	name   string
+	up     func(ctx context.Context) ([]step, error)
+	down   func(ctx context.Context, steps int) ([]step, error)
+	status func(ctx context.Context) ([]migrations.Status, error)
+}
+
+// step is a migration applied or reverted
+type step struct {
+	Version     int
+	Description string
+}
+
+func mongoSchema(m *migrations.Migrator) schema {
+	steps := func(list []migrations.Migration, err error) ([]step, error) {
+		out := make([]step, len(list))
+		for i, migration := range list {
+			out[i] = step{migration.Version, migration.Description}
+		}
+		return out, err
+	}
+	return schema{
+		name:   "mongo",
+		up:     func(ctx context.Context) ([]step, error) { return steps(m.Up(ctx)) },
+		down:   func(ctx context.Context, n int) ([]step, error) { return steps(m.Down(ctx, n)) },
+		status: m.Status,
+	}
+}
+
+func sqlSchema(name string, m *sqldb.Migrator) schema {
+	steps := func(list []sqldb.Migration, err error) ([]step, error) {
+		out := make([]step, len(list))
+		for i, migration := range list {
+			out[i] = step{migration.Version, migration.Description}
+		}
+		return out, err
+	}
+	return schema{
+		name:   name,
+		up:     func(ctx context.Context) ([]step, error) { return steps(m.Up(ctx)) },
+		down:   func(ctx context.Context, n int) ([]step, error) { return steps(m.Down(ctx, n)) },
+		status: m.Status,
+	}
+}
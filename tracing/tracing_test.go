@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"ultra-chat-backend/config"
+)
+
+// The package tracer binds to the first global provider, so the recorder is
+// installed before any test runs
+var spans = tracetest.NewSpanRecorder()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+}
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(Middleware())
+	e.GET("/items/:id", func(c echo.Context) error {
+		if !trace.SpanContextFromContext(c.Request().Context()).IsValid() {
+			t.Error("handler context carries no span")
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.GET("/fail", func(c echo.Context) error { return errors.New("boom") })
+
+	for _, path := range []string{"/items/7", "/fail", "/nowhere"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("got %d spans, want 3", len(ended))
+	}
+	for i, want := range []struct {
+		name   string
+		status codes.Code
+	}{
+		{"GET /items/:id", codes.Unset},
+		{"GET /fail", codes.Error},
+		{"GET", codes.Unset},
+	} {
+		span := ended[i]
+		if span.Name() != want.name || span.Status().Code != want.status || span.SpanKind() != trace.SpanKindServer {
+			t.Errorf("span %d = %q %s %s, want %q %s", i, span.Name(), span.SpanKind(), span.Status().Code, want.name, want.status)
+		}
+	}
+}
+
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var out bytes.Buffer
+	shutdown, err := Setup(context.Background(), config.Tracing{Exporter: ExporterStdout, ServiceName: "test-service", SampleRatio: 1}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "exported")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"Name":"exported"`) || !strings.Contains(out.String(), "test-service") {
+		t.Errorf("stdout exporter wrote %s", out.String())
+	}
+
+	if _, err := Setup(context.Background(), config.Tracing{Exporter: "zipkin"}, &out); err == nil {
+		t.Error("unknown exporter accepted")
+	}
+}
@@ -0,0 +1,359 @@
+// Package transfer copies every user, summary, audit event, linked
+// identity and API key from one storage backend to another. Records are
+// streamed in key order in batches; after each batch the last key copied
+// is saved to a checkpoint file, so an interrupted transfer resumes where
+// it stopped. Writes replace by key, so redoing the batch in flight at the
+// interruption is harmless. At the end both sides are counted and
+// checksummed.
+//
+// Writes to the source during a transfer are not picked up; stop the
+// server, or expect the verification to report the difference.
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+)
+
+// DefaultBatchSize is how many records are read and written at a time
+const DefaultBatchSize = 500
+
+// Options control a transfer
+type Options struct {
+	// BatchSize defaults to DefaultBatchSize
+	BatchSize int
+	// Checkpoint is the file progress is saved to and resumed from; empty
+	// disables resuming
+	Checkpoint string
+	// ID names the transfer in the checkpoint, so that a checkpoint left by
+	// a transfer between other backends is not resumed by mistake
+	ID string
+	// DryRun reads and verifies the source without writing anything
+	DryRun bool
+}
+
+// Report is the outcome of a transfer, one entry per collection
+type Report struct {
+	Collections []Collection
+}
+
+// Collection describes one collection after a transfer
+type Collection struct {
+	Name string
+	// Copied counts the records written by this run, or that would have
+	// been in a dry run
+	Copied int
+	// Resumed is set when copying continued from a checkpoint
+	Resumed bool
+	// Source and Target summarise each side's contents after the copy. In
+	// a dry run Target is left empty.
+	Source, Target Digest
+}
+
+// Digest is a count and a checksum of a collection's records in key order
+type Digest struct {
+	Count    int
+	Checksum string
+}
+
+// Verified reports whether every collection matches on both sides
+func (r *Report) Verified() bool {
+	for _, c := range r.Collections {
+		if c.Source != c.Target {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrMismatch is returned when the target does not match the source after
+// copying
+var ErrMismatch = errors.New("transfer: target does not match source")
+
+// Run copies from into to. The target must already be migrated. It returns
+// ErrMismatch, with the report, when verification fails.
+func Run(ctx context.Context, from, to repositories.BulkStore, opts Options) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	cp, err := loadCheckpoint(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	for _, c := range collections {
+		result, err := c.transfer(ctx, from, to, opts, cp)
+		if result != nil {
+			report.Collections = append(report.Collections, *result)
+		}
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", c.name(), err)
+		}
+	}
+
+	if !opts.DryRun && !report.Verified() {
+		return report, ErrMismatch
+	}
+	if !opts.DryRun && opts.Checkpoint != "" {
+		// A finished transfer has nothing to resume
+		if err := os.Remove(opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// collection is one kind of record to copy; table implements it for each
+// record type
+type collection interface {
+	name() string
+	transfer(ctx context.Context, from, to repositories.BulkStore, opts Options, cp *checkpoint) (*Collection, error)
+}
+
+var collections = []collection{
+	table[models.User]{
+		label: "users",
+		list:  repositories.BulkStore.ListUsers,
+		put:   repositories.BulkStore.PutUsers,
+		key:   func(u models.User) string { return u.ID },
+		write: writeUser,
+	},
+	table[models.Summary]{
+		label: "summaries",
+		list:  repositories.BulkStore.ListSummaries,
+		put:   repositories.BulkStore.PutSummaries,
+		key:   func(s models.Summary) string { return s.ID },
+		write: writeSummary,
+	},
+	table[models.AuditEvent]{
+		label: "audit_log",
+		list:  repositories.BulkStore.ListAuditEvents,
+		put:   repositories.BulkStore.PutAuditEvents,
+		key:   func(e models.AuditEvent) string { return e.ID },
+		write: writeAuditEvent,
+	},
+	table[models.Identity]{
+		label: "identities",
+		list:  repositories.BulkStore.ListIdentities,
+		put:   repositories.BulkStore.PutIdentities,
+		key:   func(i models.Identity) string { return i.ID },
+		write: writeIdentity,
+	},
+	table[models.APIKey]{
+		label: "api_keys",
+		list:  repositories.BulkStore.ListAPIKeys,
+		put:   repositories.BulkStore.PutAPIKeys,
+		key:   func(k models.APIKey) string { return k.ID },
+		write: writeAPIKey,
+	},
+}
+
+type table[T any] struct {
+	label string
+	list  func(s repositories.BulkStore, ctx context.Context, afterID string, limit int) ([]T, error)
+	put   func(s repositories.BulkStore, ctx context.Context, records []T) error
+	key   func(T) string
+	// write feeds a record's fields to a checksum
+	write func(h hash.Hash, record T)
+}
+
+func (t table[T]) name() string { return t.label }
+
+func (t table[T]) transfer(ctx context.Context, from, to repositories.BulkStore, opts Options, cp *checkpoint) (*Collection, error) {
+	result := &Collection{Name: t.label}
+	progress := cp.Collections[t.label]
+	result.Resumed = progress.After != "" || progress.Done
+
+	if !opts.DryRun && !progress.Done {
+		start := time.Now()
+		for {
+			batch, err := t.list(from, ctx, progress.After, opts.BatchSize)
+			if err != nil {
+				return result, fmt.Errorf("reading source: %w", err)
+			}
+			if len(batch) == 0 {
+				break
+			}
+			if err := t.put(to, ctx, batch); err != nil {
+				return result, fmt.Errorf("writing target: %w", err)
+			}
+
+			result.Copied += len(batch)
+			progress.After = t.key(batch[len(batch)-1])
+			progress.Copied += len(batch)
+			cp.Collections[t.label] = progress
+			if err := cp.save(opts.Checkpoint); err != nil {
+				return result, err
+			}
+			slog.Info("copied batch", "collection", t.label, "records", progress.Copied, "last", progress.After)
+		}
+
+		progress.Done = true
+		cp.Collections[t.label] = progress
+		if err := cp.save(opts.Checkpoint); err != nil {
+			return result, err
+		}
+		slog.Info("copied collection", "collection", t.label, "records", progress.Copied, "duration_ms", time.Since(start).Milliseconds())
+	}
+
+	var err error
+	if result.Source, err = t.digest(ctx, from, opts.BatchSize); err != nil {
+		return result, fmt.Errorf("verifying source: %w", err)
+	}
+	if opts.DryRun {
+		result.Copied = result.Source.Count
+		return result, nil
+	}
+	if result.Target, err = t.digest(ctx, to, opts.BatchSize); err != nil {
+		return result, fmt.Errorf("verifying target: %w", err)
+	}
+	return result, nil
+}
+
+// digest counts and checksums every record of the collection in s
+func (t table[T]) digest(ctx context.Context, s repositories.BulkStore, batchSize int) (Digest, error) {
+	h := sha256.New()
+	count := 0
+	after := ""
+	for {
+		batch, err := t.list(s, ctx, after, batchSize)
+		if err != nil {
+			return Digest{}, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, record := range batch {
+			t.write(h, record)
+		}
+		count += len(batch)
+		after = t.key(batch[len(batch)-1])
+	}
+	return Digest{Count: count, Checksum: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// The checksums cover every stored field. Timestamps are written at the
+// millisecond precision all backends keep.
+
+func writeUser(h hash.Hash, u models.User) {
+	writeFields(h, u.ID, u.UUID, u.Username, u.Discriminator,
+		u.Token.AccessToken, u.Token.TokenType, strconv.Itoa(u.Token.ExpiresIn), u.Token.RefreshToken, u.Token.Scope,
+		strconv.FormatBool(u.Preferences.DefaultPrivate), u.Preferences.Timezone, u.Preferences.Language,
+		strconv.FormatBool(u.Disabled), timestamp(u.CreatedAt), timestamp(u.UpdatedAt))
+}
+
+func writeSummary(h hash.Hash, s models.Summary) {
+	writeFields(h, s.ID, s.UserID, s.ServerID, strconv.FormatBool(s.IsPrivate), s.Content,
+		timestamp(s.CreatedAt), timestamp(s.UpdatedAt))
+}
+
+func writeAuditEvent(h hash.Hash, e models.AuditEvent) {
+	writeFields(h, e.ID, timestamp(e.Time), e.Actor, e.Action, e.Target,
+		e.IP, e.UserAgent, e.BeforeHash, e.AfterHash, strconv.Itoa(len(e.Details)))
+	keys := make([]string, 0, len(e.Details))
+	for key := range e.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeFields(h, key, e.Details[key])
+	}
+}
+
+func writeIdentity(h hash.Hash, i models.Identity) {
+	writeFields(h, i.ID, i.Provider, i.Subject, i.UserUUID, i.Username, i.Email,
+		i.Token.AccessToken, i.Token.TokenType, strconv.Itoa(i.Token.ExpiresIn), i.Token.RefreshToken, i.Token.Scope,
+		timestamp(i.CreatedAt), timestamp(i.UpdatedAt))
+}
+
+func writeAPIKey(h hash.Hash, k models.APIKey) {
+	writeFields(h, k.ID, k.Prefix, k.Hash, k.UserUUID, k.UserID, k.Name, k.Kind,
+		strconv.Itoa(len(k.Scopes)), strconv.Itoa(len(k.ServerIDs)))
+	writeFields(h, k.Scopes...)
+	writeFields(h, k.ServerIDs...)
+	writeFields(h, timestamp(k.CreatedAt), timestamp(k.LastUsedAt))
+}
+
+func writeFields(h hash.Hash, fields ...string) {
+	for _, f := range fields {
+		// Length-prefixed so field boundaries cannot shift
+		fmt.Fprintf(h, "%d:%s", len(f), f)
+	}
+}
+
+func timestamp(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// checkpoint is the progress saved between batches
+type checkpoint struct {
+	ID          string              `json:"id"`
+	Collections map[string]progress `json:"collections"`
+}
+
+type progress struct {
+	// After is the last key copied
+	After  string `json:"after,omitempty"`
+	Copied int    `json:"copied"`
+	Done   bool   `json:"done,omitempty"`
+}
+
+func loadCheckpoint(opts Options) (*checkpoint, error) {
+	cp := &checkpoint{ID: opts.ID, Collections: map[string]progress{}}
+	if opts.Checkpoint == "" || opts.DryRun {
+		return cp, nil
+	}
+
+	data, err := os.ReadFile(opts.Checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("reading checkpoint %s: %w", opts.Checkpoint, err)
+	}
+	if cp.ID != opts.ID {
+		return nil, fmt.Errorf("checkpoint %s belongs to another transfer (%s); delete it to start over", opts.Checkpoint, cp.ID)
+	}
+	if cp.Collections == nil {
+		cp.Collections = map[string]progress{}
+	}
+	return cp, nil
+}
+
+// save writes the checkpoint atomically, so a crash mid-write leaves the
+// previous one intact
+func (cp *checkpoint) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("saving checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("saving checkpoint: %w", err)
+	}
+	return nil
+}
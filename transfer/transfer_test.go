@@ -0,0 +1,230 @@
+package transfer_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ultra-chat-backend/apptest"
+	"ultra-chat-backend/models"
+	"ultra-chat-backend/repositories"
+	"ultra-chat-backend/repositories/sqldb"
+	"ultra-chat-backend/transfer"
+)
+
+var ctx = context.Background()
+
+func store(t *testing.T) repositories.BulkStore {
+	t.Helper()
+	db := apptest.SQLDatabase(t, sqldb.SQLite)
+	if _, err := sqldb.NewMigrator(db, sqldb.All).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return sqldb.NewBulkStore(db)
+}
+
+// seeded returns a store holding n users with a summary, an audit event,
+// a linked identity and an API key each
+func seeded(t *testing.T, n int) repositories.BulkStore {
+	t.Helper()
+	s := store(t)
+	at := time.Date(2024, 5, 1, 9, 30, 0, 123e6, time.UTC)
+	var users []models.User
+	var summaries []models.Summary
+	var events []models.AuditEvent
+	var identities []models.Identity
+	var keys []models.APIKey
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("8035111022467%04d", i)
+		users = append(users, models.User{
+			ID: id, UUID: fmt.Sprintf("uuid-%d", i), Username: "user", Discriminator: "0001",
+			Token:       models.Token{AccessToken: "access-" + id, TokenType: "Bearer", ExpiresIn: 604800},
+			Preferences: models.Preferences{DefaultPrivate: i%2 == 0, Timezone: "Europe/Berlin", Language: "de"},
+			CreatedAt:   at, UpdatedAt: at,
+		})
+		summaries = append(summaries, models.Summary{
+			ID: fmt.Sprintf("summary-%04d", i), UserID: id, ServerID: "290926798626357999",
+			Content: "summary of " + id, CreatedAt: at, UpdatedAt: at.Add(time.Duration(i) * time.Millisecond),
+		})
+		events = append(events, models.AuditEvent{
+			ID: fmt.Sprintf("event-%04d", i), Time: at, Actor: models.AuditUser(fmt.Sprintf("uuid-%d", i)),
+			Action: models.AuditAccountDeleted, Target: models.AuditUser(fmt.Sprintf("uuid-%d", i)),
+			IP: "203.0.113.7", UserAgent: "curl/8.0", BeforeHash: fmt.Sprintf("hash-%d", i),
+			Details: map[string]string{"summaries_deleted": fmt.Sprint(i)},
+		})
+		identities = append(identities, models.Identity{
+			ID: fmt.Sprintf("identity-%04d", i), Provider: "acme", Subject: fmt.Sprintf("subject-%d", i),
+			UserUUID: fmt.Sprintf("uuid-%d", i), Username: "user", Email: "user@example.com",
+			Token:     models.Token{AccessToken: "acme-" + id, TokenType: "Bearer", ExpiresIn: 3600},
+			CreatedAt: at, UpdatedAt: at,
+		})
+		key := models.APIKey{
+			ID: fmt.Sprintf("key-%04d", i), Prefix: fmt.Sprintf("%012d", i), Hash: "hash-" + id,
+			UserUUID: fmt.Sprintf("uuid-%d", i), UserID: id, Name: "bot", Kind: models.APIKeyUser,
+			Scopes: []string{models.ScopeSummariesRead}, CreatedAt: at,
+		}
+		if i%2 == 0 {
+			key.Kind, key.ServerIDs, key.LastUsedAt = models.APIKeyServer, []string{"290926798626357999"}, at
+		}
+		keys = append(keys, key)
+	}
+	if err := s.PutUsers(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutSummaries(ctx, summaries); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutAuditEvents(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutIdentities(ctx, identities); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutAPIKeys(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRun(t *testing.T) {
+	from, to := seeded(t, 7), store(t)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	report, err := transfer.Run(ctx, from, to, transfer.Options{BatchSize: 3, Checkpoint: checkpoint, ID: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Collections) != 5 {
+		t.Fatalf("report = %+v", report)
+	}
+	for _, c := range report.Collections {
+		if c.Copied != 7 || c.Source.Count != 7 || c.Source != c.Target || c.Resumed {
+			t.Errorf("%s = %+v", c.Name, c)
+		}
+	}
+	if _, err := os.Stat(checkpoint); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("checkpoint left after a finished transfer: %v", err)
+	}
+
+	// Running again rewrites the same records
+	if report, err := transfer.Run(ctx, from, to, transfer.Options{BatchSize: 3}); err != nil || !report.Verified() {
+		t.Errorf("second run = %+v, %v", report, err)
+	}
+}
+
+// failing fails the nth PutSummaries call, as if interrupted
+type failing struct {
+	repositories.BulkStore
+	n int
+}
+
+func (f *failing) PutSummaries(ctx context.Context, summaries []models.Summary) error {
+	if f.n--; f.n == 0 {
+		return errors.New("interrupted")
+	}
+	return f.BulkStore.PutSummaries(ctx, summaries)
+}
+
+func TestResume(t *testing.T) {
+	from, to := seeded(t, 7), store(t)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+	opts := transfer.Options{BatchSize: 3, Checkpoint: checkpoint, ID: "test"}
+
+	if _, err := transfer.Run(ctx, from, &failing{BulkStore: to, n: 2}, opts); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("interrupted run: %v", err)
+	}
+	if _, err := os.Stat(checkpoint); err != nil {
+		t.Fatalf("no checkpoint after an interrupted run: %v", err)
+	}
+
+	report, err := transfer.Run(ctx, from, to, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, summaries := report.Collections[0], report.Collections[1]
+	if users.Copied != 0 || !users.Resumed {
+		t.Errorf("users were copied again: %+v", users)
+	}
+	if summaries.Copied != 4 || !summaries.Resumed {
+		t.Errorf("summaries resumed with %+v, want the 4 after the first batch", summaries)
+	}
+	if !report.Verified() {
+		t.Errorf("resumed transfer does not verify: %+v", report)
+	}
+}
+
+func TestCheckpointOfAnotherTransfer(t *testing.T) {
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := os.WriteFile(checkpoint, []byte(`{"id": "other", "collections": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transfer.Run(ctx, seeded(t, 1), store(t), transfer.Options{Checkpoint: checkpoint, ID: "test"}); err == nil {
+		t.Error("resumed another transfer's checkpoint")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	from, to := seeded(t, 5), store(t)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	report, err := transfer.Run(ctx, from, to, transfer.Options{BatchSize: 2, Checkpoint: checkpoint, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range report.Collections {
+		if c.Copied != 5 || c.Source.Count != 5 || c.Source.Checksum == "" {
+			t.Errorf("%s = %+v", c.Name, c)
+		}
+	}
+	if users, _ := to.ListUsers(ctx, "", 10); len(users) != 0 {
+		t.Errorf("a dry run wrote %d users", len(users))
+	}
+	if _, err := os.Stat(checkpoint); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a dry run wrote a checkpoint: %v", err)
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	from, to := seeded(t, 3), seeded(t, 4)
+
+	report, err := transfer.Run(ctx, from, to, transfer.Options{})
+	if !errors.Is(err, transfer.ErrMismatch) {
+		t.Fatalf("copying onto a target with extra records: %v, want ErrMismatch", err)
+	}
+	if users := report.Collections[0]; users.Source.Count != 3 || users.Target.Count != 4 {
+		t.Errorf("users = %+v", users)
+	}
+}
+
+func TestChecksumCoversEveryField(t *testing.T) {
+	from, to := seeded(t, 2), seeded(t, 2)
+	users, err := to.ListUsers(ctx, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users[0].Token.Scope = "changed"
+	if err := to.PutUsers(ctx, users); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dry runs leave the target alone, so compare the two sources instead
+	a, err := transfer.Run(ctx, from, nil, transfer.Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := transfer.Run(ctx, to, nil, transfer.Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Collections[0].Source.Checksum == b.Collections[0].Source.Checksum {
+		t.Error("changing a token scope kept the users checksum")
+	}
+	if a.Collections[1].Source != b.Collections[1].Source {
+		t.Error("identical summaries checksum differently")
+	}
+}
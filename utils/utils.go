@@ -1,82 +0,0 @@
-package utils
-
-import (
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"strings"
-)
-
-// FetchEnv retrieves an environment variable or returns a default value.
-func FetchEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
-	}
-	return defaultValue
-}
-
-func ExchangeCodeForTokens(code string) (map[string]interface{}, error) {
-	clientID := FetchEnv("CLIENT_ID", "")
-	clientSecret := FetchEnv("CLIENT_SECRET", "")
-	redirectURI := FetchEnv("REDIRECT_URI", "")
-
-	data := fmt.Sprintf(
-		"client_id=%s&client_secret=%s&grant_type=authorization_code&code=%s&redirect_uri=%s",
-		clientID, clientSecret, code, redirectURI,
-	)
-	req, err := http.NewRequest("POST", "https://discord.com/api/oauth2/token", strings.NewReader(data))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, errors.New(string(body))
-	}
-
-	var tokens map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
-		return nil, err
-	}
-
-	return tokens, nil
-}
-
-// FetchUserInfo fetches user information from Discord using an access token.
-func FetchUserInfo(accessToken string) (map[string]interface{}, error) {
-	req, err := http.NewRequest("GET", "https://discord.com/api/users/@me", nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, errors.New(string(body))
-	}
-
-	var userInfo map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		return nil, err
-	}
-
-	return userInfo, nil
-}
@@ -0,0 +1,123 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"ultra-chat-backend/apperror"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultMaxBodyBytes caps request bodies when the Binder has no limit set
+const DefaultMaxBodyBytes = 64 << 10
+
+// Binder implements echo.Binder. Path and query parameters are bound as
+// Echo does; JSON bodies are decoded strictly: unknown fields, trailing data
+// and bodies above MaxBodyBytes are rejected.
+type Binder struct {
+	MaxBodyBytes int64
+	params       echo.DefaultBinder
+}
+
+// NewBinder returns a Binder limited to maxBodyBytes
+func NewBinder(maxBodyBytes int64) *Binder {
+	return &Binder{MaxBodyBytes: maxBodyBytes}
+}
+
+// Bind populates i from the request
+func (b *Binder) Bind(i interface{}, c echo.Context) error {
+	if err := b.params.BindPathParams(c, i); err != nil {
+		return apperror.BadRequest(apperror.CodeBadRequest, "Invalid path parameter").Wrap(err)
+	}
+
+	req := c.Request()
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		if err := b.params.BindQueryParams(c, i); err != nil {
+			return apperror.BadRequest(apperror.CodeBadRequest, "Invalid query parameter").Wrap(err)
+		}
+	}
+
+	if req.ContentLength == 0 {
+		return nil
+	}
+	return b.bindJSON(c, i)
+}
+
+func (b *Binder) bindJSON(c echo.Context, i interface{}) error {
+	req := c.Request()
+
+	contentType := req.Header.Get(echo.HeaderContentType)
+	if !strings.HasPrefix(contentType, echo.MIMEApplicationJSON) {
+		return apperror.New(http.StatusUnsupportedMediaType, apperror.CodeInvalidBody, "Request body must be application/json")
+	}
+
+	limit := b.MaxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(c.Response(), req.Body, limit))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(i); err != nil {
+		return decodeError(err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return apperror.BadRequest(apperror.CodeInvalidBody, "Request body must contain a single JSON object")
+	}
+	return nil
+}
+
+// decodeError turns encoding/json failures into client-facing errors
+func decodeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return apperror.New(http.StatusRequestEntityTooLarge, apperror.CodePayloadTooLarge, "Request body is too large").Wrap(err)
+	case errors.As(err, &typeErr):
+		return apperror.Validation(apperror.FieldError{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: "must be a " + jsonType(typeErr.Type),
+		}).Wrap(err)
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return apperror.BadRequest(apperror.CodeInvalidBody, "Request body is not valid JSON").Wrap(err)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return apperror.Validation(apperror.FieldError{
+			Field:   field,
+			Code:    "unknown",
+			Message: "is not a recognised field",
+		}).Wrap(err)
+	}
+	return apperror.BadRequest(apperror.CodeInvalidBody, "Invalid request body").Wrap(err)
+}
+
+// jsonType names the JSON type a Go type decodes from
+func jsonType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
@@ -0,0 +1,147 @@
+package validation
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ultra-chat-backend/apperror"
+
+	"github.com/labstack/echo/v4"
+)
+
+type testRequest struct {
+	ID       string  `param:"id" json:"-" validate:"required,uuid"`
+	ServerID string  `json:"server_id" validate:"required,snowflake"`
+	Content  *string `json:"content,omitempty" validate:"omitnil,min=1,max=5"`
+	Private  bool    `json:"is_private"`
+}
+
+func bindRequest(t *testing.T, body string, id string) (*testRequest, error) {
+	t.Helper()
+
+	e := echo.New()
+	e.Binder = NewBinder(64)
+	e.Validator = New()
+
+	req := httptest.NewRequest(http.MethodPatch, "/items/"+id, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+
+	var r testRequest
+	if err := c.Bind(&r); err != nil {
+		return nil, err
+	}
+	return &r, c.Validate(&r)
+}
+
+func appError(t *testing.T, err error) *apperror.Error {
+	t.Helper()
+	var appErr *apperror.Error
+	if !errors.As(err, &appErr) {
+		t.Fatalf("expected *apperror.Error, got %T: %v", err, err)
+	}
+	return appErr
+}
+
+func TestIsSnowflake(t *testing.T) {
+	valid := []string{"80351110224678912", "1234567890123456789", "18446744073709551615"}
+	invalid := []string{"", "1234", "abc45678901234567", "18446744073709551616", "-8035111022467891"}
+
+	for _, s := range valid {
+		if !IsSnowflake(s) {
+			t.Errorf("IsSnowflake(%q) = false, want true", s)
+		}
+	}
+	for _, s := range invalid {
+		if IsSnowflake(s) {
+			t.Errorf("IsSnowflake(%q) = true, want false", s)
+		}
+	}
+}
+
+func TestBindValidRequest(t *testing.T) {
+	r, err := bindRequest(t, `{"server_id":"80351110224678912","content":"hi"}`, "0f8fad5b-d9cb-469f-a165-70867728950e")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.ID != "0f8fad5b-d9cb-469f-a165-70867728950e" || *r.Content != "hi" {
+		t.Errorf("unexpected bind result: %+v", r)
+	}
+}
+
+func TestBindReportsFieldErrors(t *testing.T) {
+	_, err := bindRequest(t, `{"server_id":"42","content":"toolong"}`, "not-a-uuid")
+	appErr := appError(t, err)
+
+	if appErr.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", appErr.Status)
+	}
+
+	got := map[string]string{}
+	for _, field := range appErr.Fields {
+		got[field.Field] = field.Code
+	}
+	want := map[string]string{"id": "uuid", "server_id": "snowflake", "content": "max"}
+	for field, code := range want {
+		if got[field] != code {
+			t.Errorf("field %s: code = %q, want %q (all: %v)", field, got[field], code, got)
+		}
+	}
+}
+
+func TestBindRejectsMalformedBodies(t *testing.T) {
+	id := "0f8fad5b-d9cb-469f-a165-70867728950e"
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"unknown field", `{"server_id":"80351110224678912","admin":true}`, http.StatusUnprocessableEntity, "admin"},
+		{"wrong type", `{"server_id":"80351110224678912","is_private":"yes"}`, http.StatusUnprocessableEntity, "is_private"},
+		{"syntax error", `{"server_id":`, http.StatusBadRequest, ""},
+		{"trailing data", `{"server_id":"80351110224678912"}{}`, http.StatusBadRequest, ""},
+		{"too large", `{"server_id":"80351110224678912","content":"` + strings.Repeat("a", 100) + `"}`, http.StatusRequestEntityTooLarge, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := bindRequest(t, tt.body, id)
+			appErr := appError(t, err)
+			if appErr.Status != tt.status {
+				t.Fatalf("status = %d, want %d (%v)", appErr.Status, tt.status, err)
+			}
+			if tt.field != "" && (len(appErr.Fields) != 1 || appErr.Fields[0].Field != tt.field) {
+				t.Errorf("fields = %+v, want one error for %q", appErr.Fields, tt.field)
+			}
+		})
+	}
+}
+
+func TestListFieldMessages(t *testing.T) {
+	type request struct {
+		Kind    string   `json:"kind" validate:"oneof=user server"`
+		Servers []string `json:"servers" validate:"max=2,dive,snowflake"`
+	}
+	err := New().Validate(&request{Kind: "bot", Servers: []string{"80351110224678912", "42", "80351110224678913"}})
+	appErr := appError(t, err)
+
+	got := map[string]string{}
+	for _, field := range appErr.Fields {
+		got[field.Field] = field.Message
+	}
+	want := map[string]string{
+		"kind":    "must be one of user, server",
+		"servers": "must be at most 2 items",
+	}
+	for field, message := range want {
+		if got[field] != message {
+			t.Errorf("field %s: message = %q, want %q (all: %v)", field, got[field], message, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package validation checks request bodies against their `validate` struct
+// tags and decodes JSON strictly. Both report failures as apperror values so
+// clients get field-level detail.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	// Time zone names are checked against the embedded database so that
+	// validation does not depend on the host's zoneinfo
+	_ "time/tzdata"
+
+	"ultra-chat-backend/apperror"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// MaxSummaryLength is the longest summary, in characters, the API accepts
+const MaxSummaryLength = 16000
+
+// snowflakePattern matches Discord IDs: unsigned 64-bit integers as strings
+var snowflakePattern = regexp.MustCompile(`^[0-9]{17,20}$`)
+
+// Validator implements echo.Validator
+type Validator struct {
+	validate *validator.Validate
+}
+
+// New returns a validator that knows the API's custom tags:
+//
+//	snowflake  a Discord ID
+func New() *Validator {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Report fields by the name clients send, not the Go field name
+	v.RegisterTagNameFunc(fieldName)
+
+	_ = v.RegisterValidation("snowflake", func(fl validator.FieldLevel) bool {
+		return IsSnowflake(fl.Field().String())
+	})
+
+	return &Validator{validate: v}
+}
+
+// IsSnowflake reports whether s is a well-formed Discord ID
+func IsSnowflake(s string) bool {
+	if !snowflakePattern.MatchString(s) {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// Validate checks i against its struct tags
+func (v *Validator) Validate(i interface{}) error {
+	err := v.validate.Struct(i)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return apperror.Internal(err)
+	}
+
+	fields := make([]apperror.FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		fields = append(fields, apperror.FieldError{
+			Field:   fe.Field(),
+			Code:    fe.Tag(),
+			Message: message(fe),
+		})
+	}
+	return apperror.Validation(fields...)
+}
+
+// fieldName returns the json name of a field, falling back to the param
+// (path parameter) name and then the Go name
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "param", "query"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return fmt.Sprintf("must be at most %s %s", fe.Param(), unit(fe))
+	case "min":
+		return fmt.Sprintf("must be at least %s %s", fe.Param(), unit(fe))
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	case "snowflake":
+		return "must be a Discord ID"
+	case "uuid", "uuid4":
+		return "must be a UUID"
+	case "timezone":
+		return "must be an IANA time zone name such as Europe/Berlin"
+	case "bcp47_language_tag":
+		return "must be a BCP 47 language tag such as en or pt-BR"
+	}
+	return fmt.Sprintf("failed the %q check", fe.Tag())
+}
+
+// unit is what min and max count for the field: characters of a string,
+// items of a list
+func unit(fe validator.FieldError) string {
+	switch fe.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return "items"
+	}
+	return "characters"
+}